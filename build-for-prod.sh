@@ -5,8 +5,10 @@ set -e -m
 export GOOS=linux
 export GOARCH=amd64
 
-if [[ "${1}" == "" || "${1}" == "quotanizer" ]]; then
-    docker build --progress plain --platform=linux/amd64 -t initialed85/cameranator-quotanizer:latest -f quotanizer/Dockerfile quotanizer # &
+if [[ "${1}" == "" || "${1}" == "quota-enforcer" ]]; then
+    go build -v -o quota_enforcer ./cmd/quota_enforcer/main.go
+    docker build --progress plain --platform=linux/amd64 -t initialed85/cameranator-quota-enforcer:latest -f docker/quota-enforcer/Dockerfile . # &
+    rm -f quota_enforcer
 fi
 
 if [[ "${1}" == "" || "${1}" == "segment-processor" ]]; then
@@ -27,6 +29,18 @@ if [[ "${1}" == "" || "${1}" == "event-pruner" ]]; then
     rm -f event_pruner
 fi
 
+if [[ "${1}" == "" || "${1}" == "archiver" ]]; then
+    go build -v -o archiver ./cmd/archiver/main.go
+    docker build --progress plain --platform=linux/amd64 -t initialed85/cameranator-archiver:latest -f docker/archiver/Dockerfile . # &
+    rm -f archiver
+fi
+
+if [[ "${1}" == "" || "${1}" == "thumbnail-picker" ]]; then
+    go build -v -o thumbnail_picker ./cmd/thumbnail_picker/main.go
+    docker build --progress plain --platform=linux/amd64 -t initialed85/cameranator-thumbnail-picker:latest -f docker/thumbnail-picker/Dockerfile . # &
+    rm -f thumbnail_picker
+fi
+
 if [[ "${1}" == "" || "${1}" == "front-end" ]]; then
     docker build --progress plain --platform=linux/amd64 -t initialed85/cameranator-front-end:latest -f docker/front-end/Dockerfile . # &
 fi
@@ -45,8 +59,8 @@ fi
 
 wait
 
-if [[ "${1}" == "" || "${1}" == "quotanizer" ]]; then
-    docker push initialed85/cameranator-quotanizer:latest # &
+if [[ "${1}" == "" || "${1}" == "quota-enforcer" ]]; then
+    docker push initialed85/cameranator-quota-enforcer:latest # &
     sleep 1
 fi
 
@@ -65,6 +79,16 @@ if [[ "${1}" == "" || "${1}" == "event-pruner" ]]; then
     sleep 1
 fi
 
+if [[ "${1}" == "" || "${1}" == "archiver" ]]; then
+    docker push initialed85/cameranator-archiver:latest # &
+    sleep 1
+fi
+
+if [[ "${1}" == "" || "${1}" == "thumbnail-picker" ]]; then
+    docker push initialed85/cameranator-thumbnail-picker:latest # &
+    sleep 1
+fi
+
 if [[ "${1}" == "" || "${1}" == "front-end" ]]; then
     docker push initialed85/cameranator-front-end:latest # &
     sleep 1
@@ -86,8 +110,8 @@ fi
 
 # wait
 
-if [[ "${1}" == "" || "${1}" == "quotanizer" ]]; then
-    kubectl --context home -n cameranator rollout restart statefulset/quotanizer
+if [[ "${1}" == "" || "${1}" == "quota-enforcer" ]]; then
+    kubectl --context home -n cameranator rollout restart statefulset/quota-enforcer
 fi
 
 if [[ "${1}" == "" || "${1}" == "segment-processor" ]]; then
@@ -102,6 +126,14 @@ if [[ "${1}" == "" || "${1}" == "event-pruner" ]]; then
     kubectl --context home -n cameranator rollout restart statefulset/pruner
 fi
 
+if [[ "${1}" == "" || "${1}" == "archiver" ]]; then
+    kubectl --context home -n cameranator rollout restart statefulset/archiver
+fi
+
+if [[ "${1}" == "" || "${1}" == "thumbnail-picker" ]]; then
+    kubectl --context home -n cameranator rollout restart statefulset/thumbnail-picker
+fi
+
 if [[ "${1}" == "" || "${1}" == "front-end" ]]; then
     kubectl --context home -n cameranator rollout restart deployment/nginx
 fi
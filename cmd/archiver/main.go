@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/archiver"
+	"github.com/initialed85/cameranator/pkg/utils"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	intervalFlag := flag.Duration("interval", time.Hour, "")
+	runOnceFlag := flag.Bool("runOnce", false, "")
+	sourcePathFlag := flag.String("sourcePath", "", "folder the segments are in (i.e. the segment generator's -destinationPath)")
+	archivePathFlag := flag.String("archivePath", "", "folder to move them to (e.g. on a slower disk); they keep their place relative to -sourcePath")
+	maxAgeFlag := flag.Duration("maxAge", time.Hour*24*7, "archive events that ended longer ago than this")
+	bitrateFlag := flag.String("bitrate", "", "optional video bitrate (e.g. 500k) to re-encode the high-res videos at on the way; needs -allowReencodeOutsideChain and isn't allowed with -chainPath")
+	chainPathFlag := flag.String("chainPath", "", "the segment processor's -chainPath (if it keeps a hash chain), so that videos aren't re-encoded out of it")
+	allowReencodeOutsideChainFlag := flag.Bool("allowReencodeOutsideChain", false, "allow -bitrate; only set this if the segment processor doesn't keep a hash chain (re-encoded videos no longer match their entries in it)")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+	dryRunFlag := flag.Bool("dryRun", false, "log what would be moved without moving anything")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	interval := *intervalFlag
+	sourcePath := *sourcePathFlag
+	archivePath := *archivePathFlag
+	maxAge := *maxAgeFlag
+	bitrate := strings.TrimSpace(*bitrateFlag)
+
+	if url == "" || !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	if interval <= time.Duration(0) {
+		log.Fatal("invalid -interval argument; must be > 0s")
+	}
+
+	for name, path := range map[string]string{"sourcePath": sourcePath, "archivePath": archivePath} {
+		if path == "" {
+			log.Fatalf("invalid -%v argument; may not be empty", name)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			log.Fatalf("invalid -%v argument; %#+v is not a folder", name, path)
+		}
+	}
+
+	if maxAge <= time.Duration(0) {
+		log.Fatal("invalid -maxAge argument; must be > 0s")
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	segmentArchiver, err := archiver.NewArchiver(
+		url,
+		timeout,
+		interval,
+		sourcePath,
+		archivePath,
+		maxAge,
+		bitrate,
+		*chainPathFlag,
+		*allowReencodeOutsideChainFlag,
+		resolver,
+		*dryRunFlag,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *runOnceFlag {
+		log.Printf("Running once...")
+
+		report, err := segmentArchiver.Archive()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%v", report)
+
+		if !report.OK() {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	segmentArchiver.Start()
+	log.Printf("Press Ctrl + C to exit...")
+	utils.WaitForCtrlC()
+	segmentArchiver.Stop()
+}
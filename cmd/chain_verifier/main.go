@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/initialed85/cameranator/pkg/segments/hash_chain"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	chainFlag := flag.String("chain", "", "path to a camera's hash chain (e.g. Driveway.jsonl under the segment processor's -chainPath)")
+	publicKeyFlag := flag.String("publicKey", "", "Ed25519 public key (PEM) the chain was signed with (i.e. the segment processor's -chainKey + .pub)")
+	clipFlag := flag.String("clip", "", "optional path to a segment to verify against the chain")
+
+	flag.Parse()
+
+	chainPath := *chainFlag
+	publicKeyPath := *publicKeyFlag
+	clipPath := *clipFlag
+
+	if chainPath == "" {
+		log.Fatal("invalid -chain argument; may not be empty")
+	}
+
+	if publicKeyPath == "" {
+		log.Fatal("invalid -publicKey argument; may not be empty")
+	}
+
+	publicKey, err := hash_chain.LoadPublicKey(publicKeyPath)
+	if err != nil {
+		log.Fatalf("invalid -publicKey argument; %v", err)
+	}
+
+	entries, err := hash_chain.ReadEntries(chainPath)
+	if err != nil {
+		log.Fatalf("invalid -chain argument; %v", err)
+	}
+
+	ok := true
+
+	result := hash_chain.Verify(entries, publicKey)
+	if result.Break != nil {
+		ok = false
+		log.Printf("first broken link is %v", result.Break)
+	} else {
+		log.Printf("all %v entries hold together", result.Entries)
+	}
+
+	log.Printf("the first %v entries are covered by a signature", result.SignedThrough)
+
+	if clipPath != "" {
+		index, found, err := hash_chain.FindClip(entries, clipPath)
+		if err != nil {
+			log.Fatalf("invalid -clip argument; %v", err)
+		}
+
+		if !found {
+			ok = false
+			log.Printf("%#+v is not in the chain (it's been altered, renamed or is from another camera)", clipPath)
+		} else if index >= result.SignedThrough {
+			ok = false
+			log.Printf("%#+v is entry %v but that isn't covered by a valid signature", clipPath, index+1)
+		} else {
+			entry := entries[index]
+			log.Printf(
+				"%#+v is entry %v (sequence %v, %v to %v) and is covered by a valid signature",
+				clipPath,
+				index+1,
+				entry.Sequence,
+				entry.StartTimestamp,
+				entry.EndTimestamp,
+			)
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
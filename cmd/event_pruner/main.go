@@ -6,7 +6,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/services/event_pruner"
+	"github.com/initialed85/cameranator/pkg/storage"
 	"github.com/initialed85/cameranator/pkg/utils"
 )
 
@@ -17,6 +19,10 @@ func main() {
 	timeoutFlag := flag.Duration("timeout", time.Minute*5, "")
 	intervalFlag := flag.Duration("interval", time.Minute*30, "")
 	runOnceFlag := flag.Bool("runOnce", false, "")
+	retentionFlag := flag.String("retention", "", "optional path to a .yaml / .json retention config (see event_pruner.RetentionConfig); without one, only events whose files are gone are pruned")
+	storageFlag := flag.String("storage", "", "optional storage (a path, file:// or s3://bucket/prefix?endpoint=...) that media may have been offloaded to; the local filesystem is always checked too")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+	dryRunFlag := flag.Bool("dryRun", false, "log what would be deleted (and how much space it'd free) without deleting anything")
 
 	flag.Parse()
 
@@ -36,7 +42,37 @@ func main() {
 		log.Fatal("invalid -interval argument; must be > 0s")
 	}
 
-	eventPruner, err := event_pruner.NewEventPruner(url, timeout, interval)
+	var retention *event_pruner.Retention
+
+	if *retentionFlag != "" {
+		config, err := event_pruner.LoadRetentionConfig(*retentionFlag)
+		if err != nil {
+			log.Fatalf("invalid -retention argument; %v", err)
+		}
+
+		retention, err = event_pruner.NewRetention(config)
+		if err != nil {
+			log.Fatalf("invalid -retention argument; %v", err)
+		}
+	}
+
+	var mediaStorage storage.Storage
+
+	if *storageFlag != "" {
+		var err error
+
+		mediaStorage, err = storage.Open(*storageFlag)
+		if err != nil {
+			log.Fatalf("invalid -storage argument; %v", err)
+		}
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	eventPruner, err := event_pruner.NewEventPruner(url, timeout, interval, retention, mediaStorage, resolver, *dryRunFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
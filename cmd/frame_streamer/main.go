@@ -8,10 +8,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/initialed85/cameranator/pkg/media/display"
 	"github.com/initialed85/cameranator/pkg/media/frame"
+	"github.com/initialed85/cameranator/pkg/persistence/camera_source"
 	"github.com/initialed85/cameranator/pkg/persistence/model"
 	"github.com/initialed85/cameranator/pkg/utils"
 	"github.com/relvacode/iso8601"
@@ -22,40 +24,16 @@ import (
 	"github.com/initialed85/glue/pkg/endpoint"
 )
 
-func main() {
-	rawCameraID := strings.TrimSpace(os.Getenv("CAMERA_ID"))
-	if rawCameraID == "" {
-		log.Fatal("CAMERA_ID env var empty or unset")
-	}
-	cameraID, err := strconv.ParseInt(rawCameraID, 10, 64)
-	if err != nil {
-		log.Fatalf("CAMERA_ID could not be parsed as int: %v", err)
-	}
-	if cameraID <= 0 {
-		log.Fatal("CAMERA_ID less than or equal to 0")
-	}
-
-	cameraName := strings.TrimSpace(os.Getenv("CAMERA_NAME"))
-	if cameraName == "" {
-		log.Fatal("CAMERA_NAME env var empty or unset")
-	}
+const restartDelay = time.Second * 5
 
-	streamURL := strings.TrimSpace(os.Getenv("STREAM_URL"))
-	if streamURL == "" {
-		log.Fatal("STREAM_URL env var empty or unset")
-	}
-
-	endpointManager, err := endpoint.NewManagerSimple()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	endpointManager.Start()
-	defer endpointManager.Stop()
-
-	ctx, cancel := context.WithCancel(context.Background())
+func streamCamera(
+	ctx context.Context,
+	endpointManager *endpoint.Manager,
+	camera model.Camera,
+	mats chan gocv.Mat,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	go utils.WaitForCtrlC(cancel)
 
 	reader, writer := io.Pipe()
 	defer func() {
@@ -70,7 +48,6 @@ func main() {
 	}()
 
 	frames := make(chan frame.Frame, 60*20) // 1 minute at 20 fps
-	mats := make(chan gocv.Mat, 60*20)      // 1 minute at 20 fps
 	errs := make(chan error, 16)
 
 	go func() {
@@ -86,7 +63,7 @@ func main() {
 				}
 
 				err = endpointManager.Publish(
-					fmt.Sprintf("raw_frames/%v", cameraID),
+					fmt.Sprintf("raw_frames/%v", camera.ID),
 					"RawFrame",
 					time.Minute,
 					b,
@@ -99,19 +76,23 @@ func main() {
 	}()
 
 	go func() {
-		ffmpeg := ffmpeg_go.Input(
-			streamURL,
+		// ffmpeg is killed when ctx is done (it'd otherwise hang around for as long as the stream is stalled)
+		ffmpeg := ffmpeg_go.OutputContext(
+			ctx,
+			[]*ffmpeg_go.Stream{
+				ffmpeg_go.Input(
+					camera.StreamURL,
+					ffmpeg_go.KwArgs{
+						"rtsp_transport": "tcp",
+					},
+				),
+			},
+			"pipe:",
 			ffmpeg_go.KwArgs{
-				"rtsp_transport": "tcp",
+				"format":  "rawvideo",
+				"pix_fmt": "rgb24",
 			},
 		).
-			Output(
-				"pipe:",
-				ffmpeg_go.KwArgs{
-					"format":  "rawvideo",
-					"pix_fmt": "rgb24",
-				},
-			).
 			WithOutput(writer)
 
 		if os.Getenv("DEBUG") == "1" {
@@ -122,72 +103,255 @@ func main() {
 		if err != nil {
 			errs <- err
 		}
+
+		// so that the reader sees EOF rather than blocking forever
+		_ = writer.Close()
 	}()
 
-	go func() {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(time.Second * 5):
+	case err := <-errs:
+		return fmt.Errorf("failed to open ffmpeg input stream: %v: %v", camera.StreamURL, err)
+	}
+
+	width := int(1920)  // TODO
+	height := int(1080) // TODO
+	frameSize := width * height * 3
+	buf := make([]byte, frameSize)
+
+	for {
+		n, err := io.ReadFull(reader, buf)
+
 		select {
-		case <-time.After(time.Second * 5):
-		case err := <-errs:
-			log.Fatalf("failed to open ffmpeg input stream: %v: %v", streamURL, err)
+		case <-ctx.Done():
+			return nil
+		default:
 		}
 
-		width := int(1920)  // TODO
-		height := int(1080) // TODO
-		frameSize := width * height * 3
-		buf := make([]byte, frameSize)
+		if n != frameSize || (err != nil && err != io.EOF) {
+			return fmt.Errorf("failed to read %#+v after %v bytes: %v", camera.StreamURL, n, err)
+		}
 
-		for {
-			n, err := io.ReadFull(reader, buf)
-			if n != frameSize || (err != nil && err != io.EOF) {
-				log.Fatalf("failed to read %#+v after %v bytes: %v", streamURL, n, err)
-			}
+		now := time.Now()
 
-			now := time.Now()
+		if n == 0 || err == io.EOF {
+			return fmt.Errorf("stream %#+v stopped sending", camera.StreamURL)
+		}
 
-			if n == 0 || err == io.EOF {
-				log.Fatal("stream stopped sending")
-			}
+		originalImage, err := gocv.NewMatFromBytes(height, width, gocv.MatTypeCV8UC3, buf)
+		if err != nil {
+			return fmt.Errorf("failed to decode %#+v bytes: %v", n, err)
+		}
 
-			originalImage, err := gocv.NewMatFromBytes(height, width, gocv.MatTypeCV8UC3, buf)
-			if err != nil {
-				log.Fatalf("failed to decode %#+v bytes: %v", n, err)
-			}
+		if originalImage.Empty() {
+			return fmt.Errorf("original image was empty")
+		}
 
-			if originalImage.Empty() {
-				log.Fatal("original image was empty")
-			}
+		frame := frame.Frame{
+			Camera:    camera,
+			Timestamp: iso8601.Time{Time: now},
+			Data:      buf,
+		}
 
-			frame := frame.Frame{
-				Camera: model.Camera{
-					ID:        cameraID,
-					Name:      cameraName,
-					StreamURL: streamURL,
-				},
-				Timestamp: iso8601.Time{Time: now},
-				Data:      buf,
-			}
+		log.Printf("buf: %v", len(buf))
+
+		select {
+		case frames <- frame:
+		default:
+			log.Printf("warning: glue publish delays causing dropped frames")
+		}
 
-			log.Printf("buf: %v", len(buf))
+		overlay := gocv.NewMat()
+		gocv.CvtColor(originalImage, &overlay, gocv.ColorBGRToRGBA)
+		originalImage.Close()
 
+		if mats != nil {
 			select {
-			case frames <- frame:
+			case mats <- overlay:
 			default:
-				log.Printf("warning: glue publish delays causing dropped frames")
+				log.Printf("warning: window delays causing dropped frames")
 			}
+		}
+	}
+}
 
-			overlay := gocv.NewMat()
-			gocv.CvtColor(originalImage, &overlay, gocv.ColorBGRToRGBA)
-			originalImage.Close()
+type streamer struct {
+	camera model.Camera
+	cancel context.CancelFunc
+	done   chan struct{}
+}
 
-			if mats != nil {
-				select {
-				case mats <- overlay:
-				default:
-					log.Printf("warning: window delays causing dropped frames")
-				}
+// streamers follows the camera table, running one streamCamera per camera (restarting it if it fails)
+type streamers struct {
+	mu               sync.Mutex
+	ctx              context.Context
+	endpointManager  *endpoint.Manager
+	mats             chan gocv.Mat
+	streamerByCamera map[int64]*streamer
+}
+
+func (s *streamers) start(camera model.Camera) {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	thisStreamer := &streamer{
+		camera: camera,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	s.streamerByCamera[camera.ID] = thisStreamer
+
+	go func() {
+		defer close(thisStreamer.done)
+
+		for {
+			log.Printf("streaming %#+v", camera)
+
+			err := streamCamera(ctx, s.endpointManager, camera, s.mats)
+			if err != nil {
+				log.Printf("warning: streaming %#+v failed because %v; restarting in %v", camera.Name, err, restartDelay)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(restartDelay):
 			}
 		}
 	}()
+}
+
+// stop cancels the streamer for a camera (which kills its ffmpeg) and returns it, so that it can be waited for once
+// s.mu has been released
+func (s *streamers) stop(cameraID int64) *streamer {
+	thisStreamer, ok := s.streamerByCamera[cameraID]
+	if !ok {
+		return nil
+	}
+
+	log.Printf("stopping %#+v", thisStreamer.camera)
+
+	thisStreamer.cancel()
+
+	delete(s.streamerByCamera, cameraID)
+
+	return thisStreamer
+}
+
+func (s *streamers) setCameras(cameras []model.Camera) {
+	stopped := make([]*streamer, 0)
+
+	s.mu.Lock()
+
+	cameraByID := make(map[int64]model.Camera)
+	for _, camera := range cameras {
+		if camera.StreamURL == "" {
+			continue
+		}
+
+		cameraByID[camera.ID] = camera
+	}
+
+	for cameraID, thisStreamer := range s.streamerByCamera {
+		camera, ok := cameraByID[cameraID]
+		if !ok || camera != thisStreamer.camera {
+			stopped = append(stopped, s.stop(cameraID))
+		}
+	}
+
+	for cameraID, camera := range cameraByID {
+		_, ok := s.streamerByCamera[cameraID]
+		if !ok {
+			s.start(camera)
+		}
+	}
+
+	s.mu.Unlock()
+
+	for _, thisStreamer := range stopped {
+		<-thisStreamer.done
+		log.Printf("stopped %#+v", thisStreamer.camera)
+	}
+}
+
+func getCameraFromEnv() model.Camera {
+	rawCameraID := strings.TrimSpace(os.Getenv("CAMERA_ID"))
+	if rawCameraID == "" {
+		log.Fatal("CAMERA_ID env var empty or unset")
+	}
+	cameraID, err := strconv.ParseInt(rawCameraID, 10, 64)
+	if err != nil {
+		log.Fatalf("CAMERA_ID could not be parsed as int: %v", err)
+	}
+	if cameraID <= 0 {
+		log.Fatal("CAMERA_ID less than or equal to 0")
+	}
+
+	cameraName := strings.TrimSpace(os.Getenv("CAMERA_NAME"))
+	if cameraName == "" {
+		log.Fatal("CAMERA_NAME env var empty or unset")
+	}
+
+	streamURL := strings.TrimSpace(os.Getenv("STREAM_URL"))
+	if streamURL == "" {
+		log.Fatal("STREAM_URL env var empty or unset")
+	}
+
+	return model.Camera{
+		ID:        cameraID,
+		Name:      cameraName,
+		StreamURL: streamURL,
+	}
+}
+
+func main() {
+	// if set, cameras come from (and follow changes to) the camera table instead of CAMERA_ID / CAMERA_NAME / STREAM_URL
+	url := strings.TrimSpace(os.Getenv("GRAPHQL_URL"))
+
+	endpointManager, err := endpoint.NewManagerSimple()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	endpointManager.Start()
+	defer endpointManager.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go utils.WaitForCtrlC(cancel)
+
+	mats := make(chan gocv.Mat, 60*20) // 1 minute at 20 fps
+
+	if url != "" {
+		s := &streamers{
+			ctx:              ctx,
+			endpointManager:  endpointManager,
+			mats:             mats,
+			streamerByCamera: make(map[int64]*streamer),
+		}
+
+		cameraSource, err := camera_source.NewCameraSource(url, time.Second*30, s.setCameras)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = cameraSource.Start()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cameraSource.Stop()
+	} else {
+		camera := getCameraFromEnv()
+
+		go func() {
+			err := streamCamera(ctx, endpointManager, camera, mats)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	if os.Getenv("DEBUG") == "1" {
 		log.Printf("debug enabled, showing window...")
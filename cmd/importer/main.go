@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/importer"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	pathFlag := flag.String("path", "", "folder to import segments (and motion-era events) from; searched recursively")
+	timezoneFlag := flag.String("timezone", "", "IANA timezone the segment file names were written in (e.g. Australia/Perth); defaults to the host's")
+	minAgeFlag := flag.Duration("minAge", time.Minute*10, "leave videos modified more recently than this (they may still be being recorded or processed)")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+	dryRunFlag := flag.Bool("dryRun", false, "report what would be imported without changing anything")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	path := *pathFlag
+	minAge := *minAgeFlag
+	dryRun := *dryRunFlag
+
+	if url == "" || !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	if path == "" {
+		log.Fatal("invalid -path argument; may not be empty")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		log.Fatalf("invalid -path argument; %#+v is not a folder", path)
+	}
+
+	location, err := segment_template.LoadLocation(*timezoneFlag)
+	if err != nil {
+		log.Fatalf("invalid -timezone argument; %v", err)
+	}
+
+	if minAge < time.Duration(0) {
+		log.Fatal("invalid -minAge argument; must be >= 0s")
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	segmentImporter, err := importer.NewImporter(url, timeout, location, minAge, resolver, dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := segmentImporter.Import(path, func(result importer.Result) {
+		log.Printf("%v", result)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+
+	log.Printf(
+		"found %v segments; %v %v (%.1f MB), %v were already imported, %v were skipped as invalid, %v failed; %v were too recent and %v files weren't recognised",
+		report.Found,
+		verb,
+		report.Imported,
+		float64(report.Bytes)/1000000,
+		report.AlreadyImported,
+		report.Skipped,
+		report.Failed,
+		report.TooRecent,
+		report.Unrecognised,
+	)
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/services/object_tracker"
 	"gocv.io/x/gocv"
 )
@@ -15,6 +16,7 @@ func main() {
 
 	urlFlag := flag.String("url", "https://cameranator.initialed85.cc/api/v1/graphql", "")
 	timeoutFlag := flag.Duration("timeout", time.Second*300, "")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
 
 	flag.Parse()
 
@@ -29,9 +31,14 @@ func main() {
 		log.Fatal("invalid -timeout argument; must be > 0s")
 	}
 
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
 	mats := make(chan gocv.Mat)
 
-	objectTaskScheduler, err := object_tracker.NewObjectTracker(url, timeout, mats)
+	objectTaskScheduler, err := object_tracker.NewObjectTracker(url, timeout, resolver, mats)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/quota_enforcer"
+	"github.com/initialed85/cameranator/pkg/utils"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	var cameraQuotas utils.FlagSliceString
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	intervalFlag := flag.Duration("interval", time.Minute, "")
+	runOnceFlag := flag.Bool("runOnce", false, "")
+	pathFlag := flag.String("path", "", "folder the segments are in; searched recursively")
+	timezoneFlag := flag.String("timezone", "", "IANA timezone the segment file names were written in (e.g. Australia/Perth); defaults to the host's")
+	volumeQuotaFlag := flag.String("volumeQuota", "0", "most that all of the segments under -path may use (e.g. 2TB); 0 for no quota")
+	flag.Var(&cameraQuotas, "cameraQuota", "most that one camera's segments may use, as name=size (e.g. Driveway=500GB); repeatable")
+	classesFlag := flag.String("classes", "", "comma-separated classes (e.g. person,car) whose detections make a segment worth keeping; empty for any")
+	minScoreFlag := flag.Float64("minScore", 0.5, "lowest score for a detection to make a segment worth keeping")
+	minAgeFlag := flag.Duration("minAge", time.Hour, "leave segments that started more recently than this (they may still be being recorded or processed)")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+	dryRunFlag := flag.Bool("dryRun", false, "log what would be deleted (and how much space it'd free) without deleting anything")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	interval := *intervalFlag
+	path := *pathFlag
+	minScore := *minScoreFlag
+	minAge := *minAgeFlag
+
+	if url == "" || !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	if interval <= time.Duration(0) {
+		log.Fatal("invalid -interval argument; must be > 0s")
+	}
+
+	if path == "" {
+		log.Fatal("invalid -path argument; may not be empty")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		log.Fatalf("invalid -path argument; %#+v is not a folder", path)
+	}
+
+	location, err := segment_template.LoadLocation(*timezoneFlag)
+	if err != nil {
+		log.Fatalf("invalid -timezone argument; %v", err)
+	}
+
+	quotas := quota_enforcer.Quotas{
+		Cameras: make(map[string]int64),
+	}
+
+	quotas.Volume, err = quota_enforcer.ParseBytes(*volumeQuotaFlag)
+	if err != nil {
+		log.Fatalf("invalid -volumeQuota argument; %v", err)
+	}
+
+	for _, cameraQuota := range cameraQuotas {
+		parts := strings.SplitN(cameraQuota, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			log.Fatalf("invalid -cameraQuota argument %#+v; must be name=size", cameraQuota)
+		}
+
+		quotas.Cameras[strings.TrimSpace(parts[0])], err = quota_enforcer.ParseBytes(parts[1])
+		if err != nil {
+			log.Fatalf("invalid -cameraQuota argument %#+v; %v", cameraQuota, err)
+		}
+	}
+
+	if quotas.Volume == 0 && len(quotas.Cameras) == 0 {
+		log.Fatal("invalid -volumeQuota / -cameraQuota arguments; at least one quota must be set")
+	}
+
+	classes := make([]string, 0)
+	for _, class := range strings.Split(*classesFlag, ",") {
+		class = strings.TrimSpace(class)
+		if class != "" {
+			classes = append(classes, class)
+		}
+	}
+
+	if minScore < 0 || minScore > 1 {
+		log.Fatal("invalid -minScore argument; must be between 0 and 1")
+	}
+
+	if minAge < time.Duration(0) {
+		log.Fatal("invalid -minAge argument; must be >= 0s")
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	quotaEnforcer, err := quota_enforcer.NewQuotaEnforcer(
+		url,
+		timeout,
+		interval,
+		path,
+		location,
+		quotas,
+		classes,
+		minScore,
+		minAge,
+		resolver,
+		*dryRunFlag,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *runOnceFlag {
+		log.Printf("Running once...")
+
+		report, err := quotaEnforcer.Enforce()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%v", report)
+
+		if !report.OK() {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	quotaEnforcer.Start()
+	log.Printf("Press Ctrl + C to exit...")
+	utils.WaitForCtrlC()
+	quotaEnforcer.Stop()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/reconciler"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	pathFlag := flag.String("path", "", "folder of segments to reconcile with the database; searched recursively")
+	timezoneFlag := flag.String("timezone", "", "IANA timezone the segment file names were written in (e.g. Australia/Perth); defaults to the host's")
+	minAgeFlag := flag.Duration("minAge", time.Minute*10, "leave files modified more recently than this (they may still be being recorded or processed)")
+	quarantinePathFlag := flag.String("quarantinePath", "", "where quarantined files are moved to (keeping their place under -path); defaults to .quarantine under -path")
+	orphanVideosFlag := flag.String("orphanVideos", "report", "for segments with no video row; report, reingest or quarantine")
+	orphanImagesFlag := flag.String("orphanImages", "report", "for images with no image row; report or quarantine")
+	parentlessLowResFlag := flag.String("parentlessLowRes", "report", "for __lowres.jpg images with no image row or anything they were made from; report or quarantine")
+	missingThumbnailsFlag := flag.String("missingThumbnails", "report", "for events whose thumbnail is missing but whose video isn't; report or regenerate")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	path := *pathFlag
+	minAge := *minAgeFlag
+	quarantinePath := *quarantinePathFlag
+
+	if url == "" || !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	if path == "" {
+		log.Fatal("invalid -path argument; may not be empty")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		log.Fatalf("invalid -path argument; %#+v is not a folder", path)
+	}
+
+	location, err := segment_template.LoadLocation(*timezoneFlag)
+	if err != nil {
+		log.Fatalf("invalid -timezone argument; %v", err)
+	}
+
+	if minAge < time.Duration(0) {
+		log.Fatal("invalid -minAge argument; must be >= 0s")
+	}
+
+	if quarantinePath == "" {
+		quarantinePath = filepath.Join(path, ".quarantine")
+	}
+
+	quarantinePath, err = filepath.Abs(quarantinePath)
+	if err != nil {
+		log.Fatalf("invalid -quarantinePath argument; %v", err)
+	}
+
+	policy := reconciler.Policy{}
+
+	err = policy.Set(reconciler.ClassOrphanVideo, *orphanVideosFlag)
+	if err != nil {
+		log.Fatalf("invalid -orphanVideos argument; %v", err)
+	}
+
+	err = policy.Set(reconciler.ClassOrphanImage, *orphanImagesFlag)
+	if err != nil {
+		log.Fatalf("invalid -orphanImages argument; %v", err)
+	}
+
+	err = policy.Set(reconciler.ClassParentlessLowRes, *parentlessLowResFlag)
+	if err != nil {
+		log.Fatalf("invalid -parentlessLowRes argument; %v", err)
+	}
+
+	err = policy.Set(reconciler.ClassMissingThumbnail, *missingThumbnailsFlag)
+	if err != nil {
+		log.Fatalf("invalid -missingThumbnails argument; %v", err)
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	r, err := reconciler.NewReconciler(url, timeout, location, minAge, policy, quarantinePath, resolver)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := r.Reconcile(path, func(finding reconciler.Finding) {
+		log.Printf("%v", finding)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary := make([]string, 0)
+	for _, class := range []reconciler.Class{
+		reconciler.ClassOrphanVideo,
+		reconciler.ClassOrphanImage,
+		reconciler.ClassParentlessLowRes,
+		reconciler.ClassMissingThumbnail,
+	} {
+		summary = append(summary, fmt.Sprintf("%v %v(s) (%v)", report.Findings[class], class, policy.Get(class)))
+	}
+
+	log.Printf(
+		"found %v; %v action(s) were skipped (as their segments were invalid), %v failed",
+		strings.Join(summary, ", "),
+		report.Skipped,
+		report.Failed,
+	)
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/hasura/go-graphql-client"
 	"github.com/initialed85/cameranator/pkg/objects/object_tracker"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/utils"
 	"gocv.io/x/gocv"
 )
@@ -50,9 +51,10 @@ query LiveEvents {
 }
 
 func main() {
-	segmentsPath := strings.TrimSpace(os.Getenv("SEGMENTS_PATH"))
-	if segmentsPath == "" {
-		log.Fatal("SEGMENTS_PATH env empty or unset")
+	// e.g. /srv/target_dir=/Volumes/cameranator/media/srv (see path_resolver.Parse)
+	resolver, err := path_resolver.Parse(os.Getenv("PATH_MAP"))
+	if err != nil {
+		log.Fatalf("PATH_MAP env invalid: %v", err)
 	}
 
 	if len(os.Args) < 3 {
@@ -87,7 +89,7 @@ func main() {
 	mats := make(chan gocv.Mat) // note: unbuffered
 
 	log.Printf("starting tracker...")
-	objectTracker, err := object_tracker.New(segmentsPath, mats)
+	objectTracker, err := object_tracker.New(resolver, mats)
 	if err != nil {
 		log.Fatal(err)
 	}
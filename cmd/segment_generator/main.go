@@ -2,86 +2,262 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/initialed85/cameranator/pkg/filesystem"
+	"github.com/initialed85/cameranator/pkg/media/segment_recorder"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/persistence/camera_source"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
 	"github.com/initialed85/cameranator/pkg/services/segment_generators"
 	"github.com/initialed85/cameranator/pkg/utils"
 )
 
+func getFeedsFromFlags(
+	destinationPath string,
+	duration int,
+	timezone string,
+	format string,
+	watcherBackend string,
+	netCamURLs utils.FlagSliceString,
+	subStreamURLs utils.FlagSliceString,
+	cameraNames utils.FlagSliceString,
+) []segment_generator.Feed {
+	validateFlags(destinationPath, duration, timezone, format, watcherBackend)
+
+	if len(netCamURLs) == 0 {
+		log.Fatal("invalid -netCamURL argument; need at least 1")
+	}
+
+	if len(cameraNames) == 0 {
+		log.Fatal("invalid -cameraName argument; need at least 1")
+	}
+
+	if len(netCamURLs) != len(cameraNames) {
+		log.Fatal("invalid -netCamURL and -cameraName arguments; must have same amount of both (they're indexed together)")
+	}
+
+	if len(subStreamURLs) > 0 && len(subStreamURLs) != len(netCamURLs) {
+		log.Fatal("invalid -subStreamURL arguments; if any are given, must have one for each -netCamURL (they're indexed together)")
+	}
+
+	feeds := make([]segment_generator.Feed, 0)
+
+	for i, netCamURL := range netCamURLs {
+		cameraName := cameraNames[i]
+
+		subStreamURL := ""
+		if len(subStreamURLs) > 0 {
+			subStreamURL = subStreamURLs[i]
+		}
+
+		feeds = append(feeds, segment_generator.Feed{
+			NetCamURL:       netCamURL,
+			SubStreamURL:    subStreamURL,
+			DestinationPath: destinationPath,
+			CameraName:      cameraName,
+			Duration:        duration,
+			Timezone:        timezone,
+			Format:          format,
+			WatcherBackend:  watcherBackend,
+		})
+	}
+
+	return feeds
+}
+
+func validateFlags(destinationPath string, duration int, timezone string, format string, watcherBackend string) {
+	if destinationPath == "" {
+		log.Fatal("invalid -destinationPath argument; may not be empty")
+	}
+
+	if duration <= 0 {
+		log.Fatal("invalid -duration argument; must be > 0")
+	}
+
+	_, err := segment_template.LoadLocation(timezone)
+	if err != nil {
+		log.Fatalf("invalid -timezone argument; %v", err)
+	}
+
+	_, err = segment_recorder.ParseFormat(format)
+	if err != nil {
+		log.Fatalf("invalid -format argument; %v", err)
+	}
+
+	_, err = filesystem.ParseBackend(watcherBackend)
+	if err != nil {
+		log.Fatalf("invalid -watcherBackend argument; %v", err)
+	}
+}
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
 	netCamURLs := utils.FlagSliceString{}
+	subStreamURLs := utils.FlagSliceString{}
 	cameraNames := utils.FlagSliceString{}
 
+	urlFlag := flag.String("url", "", "HTTP URL for GraphQL instance; if set, cameras come from (and follow changes to) the camera table")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	configFlag := flag.String("config", "", "path to a .yaml / .json camera config (reloaded on change or SIGHUP); replaces the per-camera flags")
 	destinationPathFlag := flag.String("destinationPath", "", "")
 	durationFlag := flag.Int("duration", 0, "")
 	hostFlag := flag.String("host", "localhost", "")
 	portFlag := flag.Int64("port", 6291, "")
+	transportFlag := flag.String("transport", "", "where to publish events (udp://host:port, http(s)://host:port/path or amqp(s)://user:pass@host:port/vhost?queue=name); defaults to udp://-host:-port")
+	outboxPathFlag := flag.String("outboxPath", "", "directory for events that are yet to be acknowledged by the segment processor; defaults to .outbox under -destinationPath (or the destination_path of -config)")
+	formatFlag := flag.String("format", "", "segment container; fmp4 (the default) or mpegts, both of which stay readable if the recorder is killed part way through a segment, or mp4 (which doesn't)")
+	watcherBackendFlag := flag.String("watcherBackend", "", "how to follow -destinationPath; auto (the default; polling on NFS / SMB / FUSE, inotify otherwise), inotify or polling")
+	timezoneFlag := flag.String("timezone", "", "IANA timezone for segment file names (e.g. Australia/Perth); defaults to the host's")
 	flag.Var(&netCamURLs, "netCamURL", "")
+	flag.Var(&subStreamURLs, "subStreamURL", "optional low-res stream to record alongside each -netCamURL (for detection and previews)")
 	flag.Var(&cameraNames, "cameraName", "")
 
 	flag.Parse()
 
-	destinationPath := *destinationPathFlag
-	duration := *durationFlag
+	url := *urlFlag
+	timeout := *timeoutFlag
+	configPath := *configFlag
 	host := *hostFlag
 	port := *portFlag
+	transportURL := *transportFlag
+	outboxPath := *outboxPathFlag
 
-	if destinationPath == "" {
-		log.Fatal("invalid -destinationPath argument; may not be empty")
-	}
+	if transportURL == "" {
+		if host == "" {
+			log.Fatal("invalid -host argument; may not be empty")
+		}
 
-	if duration <= 0 {
-		log.Fatal("invalid -duration argument; must be > 0")
-	}
+		if port <= 0 {
+			log.Fatal("invalid -port argument; must be > 0")
+		}
 
-	if host == "" {
-		log.Fatal("invalid -host argument; may not be empty")
+		transportURL = fmt.Sprintf("udp://%v:%v", host, port)
 	}
 
-	if port <= 0 {
-		log.Fatal("invalid -port argument; must be > 0")
-	}
+	if outboxPath == "" {
+		destinationPath := *destinationPathFlag
 
-	if len(netCamURLs) == 0 {
-		log.Fatal("invalid -netCamURL argument; need at least 1")
-	}
+		// in -config mode the destination path is the config's (which the per-camera flags can't be used with)
+		if destinationPath == "" && configPath != "" {
+			config, err := segment_generators.LoadConfig(configPath)
+			if err != nil {
+				log.Fatalf("invalid -config argument; %v", err)
+			}
 
-	if len(cameraNames) == 0 {
-		log.Fatal("invalid -cameraName argument; need at least 1")
-	}
+			destinationPath = config.DestinationPath
+		}
 
-	if len(netCamURLs) != len(cameraNames) {
-		log.Fatal("invalid -netCamURL and -cameraName arguments; must have same amount of both (they're indexed together)")
+		if destinationPath == "" {
+			log.Fatal("invalid -outboxPath argument; may not be empty (unless -destinationPath is set, or -config has a destination_path)")
+		}
+
+		outboxPath = filepath.Join(destinationPath, ".outbox")
 	}
 
-	feeds := make([]segment_generator.Feed, 0)
+	var segmentGenerator *segment_generators.SegmentGenerators
+	var configWatcher *segment_generators.ConfigWatcher
+	var cameraSource *camera_source.CameraSource
 
-	for i, netCamURL := range netCamURLs {
-		cameraName := cameraNames[i]
+	if url != "" {
+		if configPath != "" || len(netCamURLs) > 0 || len(subStreamURLs) > 0 || len(cameraNames) > 0 {
+			log.Fatal("invalid -url argument; cannot be used with -config / -netCamURL / -subStreamURL / -cameraName")
+		}
 
-		feeds = append(feeds, segment_generator.Feed{
-			NetCamURL:       netCamURL,
-			DestinationPath: destinationPath,
-			CameraName:      cameraName,
-			Duration:        duration,
-		})
-	}
+		if !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+			log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+		}
+
+		if timeout <= time.Duration(0) {
+			log.Fatal("invalid -timeout argument; must be > 0s")
+		}
+
+		destinationPath := *destinationPathFlag
+		duration := *durationFlag
+		timezone := *timezoneFlag
+		format := *formatFlag
+		watcherBackend := *watcherBackendFlag
+
+		validateFlags(destinationPath, duration, timezone, format, watcherBackend)
+
+		segmentGenerator = segment_generators.NewSegmentGenerators(
+			nil,
+			transportURL,
+			outboxPath,
+		)
+
+		var err error
+
+		cameraSource, err = camera_source.NewCameraSource(
+			url,
+			timeout,
+			func(cameras []model.Camera) {
+				err := segmentGenerator.SetFeeds(
+					segment_generators.FeedsFromCameras(cameras, destinationPath, duration, timezone, format, watcherBackend),
+				)
+				if err != nil {
+					log.Printf("warning: failed to apply cameras because %v", err)
+				}
+			},
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	segmentGenerator := segment_generators.NewSegmentGenerators(
-		feeds,
-		host,
-		port,
-	)
+		err = cameraSource.Start()
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if configPath != "" {
+		if len(netCamURLs) > 0 || len(subStreamURLs) > 0 || len(cameraNames) > 0 {
+			log.Fatal("invalid -config argument; cannot be used with -netCamURL / -subStreamURL / -cameraName")
+		}
+
+		segmentGenerator = segment_generators.NewSegmentGenerators(
+			nil,
+			transportURL,
+			outboxPath,
+		)
+
+		configWatcher = segment_generators.NewConfigWatcher(configPath, segmentGenerator)
+
+		err := configWatcher.Reload()
+		if err != nil {
+			log.Fatalf("invalid -config argument; %v", err)
+		}
+	} else {
+		segmentGenerator = segment_generators.NewSegmentGenerators(
+			getFeedsFromFlags(*destinationPathFlag, *durationFlag, *timezoneFlag, *formatFlag, *watcherBackendFlag, netCamURLs, subStreamURLs, cameraNames),
+			transportURL,
+			outboxPath,
+		)
+	}
 
 	err := segmentGenerator.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if configWatcher != nil {
+		configWatcher.Start()
+	}
+
 	log.Printf("Press Ctrl + C to exit...")
 	utils.WaitForCtrlC()
+
+	if configWatcher != nil {
+		configWatcher.Stop()
+	}
+
+	if cameraSource != nil {
+		cameraSource.Stop()
+	}
+
 	segmentGenerator.Stop()
 }
@@ -2,11 +2,18 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/segments/hash_chain"
 	"github.com/initialed85/cameranator/pkg/services/segment_processor"
+	"github.com/initialed85/cameranator/pkg/storage"
 	"github.com/initialed85/cameranator/pkg/utils"
 )
 
@@ -14,17 +21,39 @@ func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
 	portFlag := flag.Int64("port", 6291, "")
+	transportFlag := flag.String("transport", "", "where to consume events from (udp://host:port, http://host:port/path or amqp(s)://user:pass@host:port/vhost?queue=name); defaults to udp://0.0.0.0:-port")
 	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
 	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	chainPathFlag := flag.String("chainPath", "", "directory to keep a tamper-evident hash chain (per camera) of segments in; disabled if empty")
+	chainKeyFlag := flag.String("chainKey", "", "Ed25519 private key (PEM) to sign hash chains with; created (along with a .pub) if it doesn't exist; defaults to chain.key under -chainPath")
+	chainSignIntervalFlag := flag.Duration("chainSignInterval", time.Minute*5, "how much footage may go by between signed hash chain entries")
+	storageFlag := flag.String("storage", "", "optional storage (a path, file:// or s3://bucket/prefix?endpoint=...) to copy each segment's files into once they've been processed; kept local if empty")
+	storageRootFlag := flag.String("storageRoot", "", "folder the segments are written to (i.e. the segment generator's -destinationPath); their keys in -storage are relative to it")
+	keepLocalForFlag := flag.Duration("keepLocalFor", time.Hour*24, "how long to keep the local copies of offloaded files for events that haven't got to done yet (the detector, tracker and nginx read them locally)")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the paths in the events it receives (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+	gapToleranceFlag := flag.Duration("gapTolerance", time.Second*5, "record a recording gap if consecutive segments from a camera are further apart than this")
+	spriteIntervalFlag := flag.Duration("spriteInterval", time.Second*2, "how much of each segment goes by between the tiles of its sprite sheet (for scrubbing in the UI); disabled if 0s")
+	spriteTileWidthFlag := flag.Int("spriteTileWidth", 160, "width of each sprite sheet tile")
+	spriteTileHeightFlag := flag.Int("spriteTileHeight", 90, "height of each sprite sheet tile")
+	spriteColumnsFlag := flag.Int("spriteColumns", 10, "most sprite sheet tiles in a row")
 
 	flag.Parse()
 
 	port := *portFlag
+	transportURL := *transportFlag
 	url := *urlFlag
 	timeout := *timeoutFlag
+	gapTolerance := *gapToleranceFlag
+	chainPath := *chainPathFlag
+	chainKey := *chainKeyFlag
+	chainSignInterval := *chainSignIntervalFlag
 
-	if port <= 0 {
-		log.Fatal("invalid -port argument; must be > 0")
+	if transportURL == "" {
+		if port <= 0 {
+			log.Fatal("invalid -port argument; must be > 0")
+		}
+
+		transportURL = fmt.Sprintf("udp://0.0.0.0:%v", port)
 	}
 
 	if url == "" || !strings.Contains(url, "http://") {
@@ -35,7 +64,87 @@ func main() {
 		log.Fatal("invalid -timeout argument; must be > 0s")
 	}
 
-	segmentProcessor, err := segment_processor.NewSegmentProcessor(port, url, timeout)
+	if gapTolerance < time.Duration(0) {
+		log.Fatal("invalid -gapTolerance argument; must be >= 0s")
+	}
+
+	var chains *hash_chain.Chains
+
+	if chainPath != "" {
+		if chainKey == "" {
+			chainKey = filepath.Join(chainPath, "chain.key")
+		}
+
+		if chainSignInterval < time.Duration(0) {
+			log.Fatal("invalid -chainSignInterval argument; must be >= 0s")
+		}
+
+		err := os.MkdirAll(chainPath, 0755)
+		if err != nil {
+			log.Fatalf("invalid -chainPath argument; %v", err)
+		}
+
+		privateKey, err := hash_chain.LoadOrCreatePrivateKey(chainKey)
+		if err != nil {
+			log.Fatalf("invalid -chainKey argument; %v", err)
+		}
+
+		chains = hash_chain.NewChains(chainPath, privateKey, chainSignInterval)
+	}
+
+	var offloader *segment_processor.Offloader
+
+	if *storageFlag != "" {
+		if *storageRootFlag == "" {
+			log.Fatal("invalid -storageRoot argument; may not be empty if -storage is set")
+		}
+
+		mediaStorage, err := storage.Open(*storageFlag)
+		if err != nil {
+			log.Fatalf("invalid -storage argument; %v", err)
+		}
+
+		if *keepLocalForFlag < time.Duration(0) {
+			log.Fatal("invalid -keepLocalFor argument; must be >= 0s")
+		}
+
+		offloader, err = segment_processor.NewOffloader(mediaStorage, *storageRootFlag, *keepLocalForFlag)
+		if err != nil {
+			log.Fatalf("invalid -storageRoot argument; %v", err)
+		}
+	}
+
+	var spriteSheetMaker *sprite_sheet.Maker
+
+	if *spriteIntervalFlag != time.Duration(0) {
+		var err error
+
+		spriteSheetMaker, err = sprite_sheet.NewMaker(
+			*spriteIntervalFlag,
+			*spriteTileWidthFlag,
+			*spriteTileHeightFlag,
+			*spriteColumnsFlag,
+		)
+		if err != nil {
+			log.Fatalf("invalid -sprite* argument; %v", err)
+		}
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	segmentProcessor, err := segment_processor.NewSegmentProcessor(
+		transportURL,
+		url,
+		timeout,
+		gapTolerance,
+		chains,
+		offloader,
+		spriteSheetMaker,
+		resolver,
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
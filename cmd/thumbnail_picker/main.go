@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/thumbnail_picker"
+	"github.com/initialed85/cameranator/pkg/utils"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	intervalFlag := flag.Duration("interval", time.Second*10, "")
+	runOnceFlag := flag.Bool("runOnce", false, "")
+	classesFlag := flag.String("classes", "person,car,dog,cat", "comma-separated classes in order of priority (e.g. person,car); the best detection of the first one detected picks the thumbnail")
+	minScoreFlag := flag.Float64("minScore", 0.5, "lowest score for a detection to pick the thumbnail")
+	overlayFlag := flag.Bool("overlay", false, "draw the bounding box of the detection on the thumbnail")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	interval := *intervalFlag
+	minScore := *minScoreFlag
+
+	if url == "" || !(strings.Contains(url, "http://") || strings.Contains(url, "https://")) {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	if interval <= time.Duration(0) {
+		log.Fatal("invalid -interval argument; must be > 0s")
+	}
+
+	classes := make([]string, 0)
+	for _, class := range strings.Split(*classesFlag, ",") {
+		class = strings.TrimSpace(class)
+		if class != "" {
+			classes = append(classes, class)
+		}
+	}
+
+	if len(classes) == 0 {
+		log.Fatal("invalid -classes argument; may not be empty")
+	}
+
+	if minScore < 0 || minScore > 1 {
+		log.Fatal("invalid -minScore argument; must be between 0 and 1")
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	thumbnailPicker, err := thumbnail_picker.NewThumbnailPicker(
+		url,
+		timeout,
+		interval,
+		classes,
+		minScore,
+		*overlayFlag,
+		resolver,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *runOnceFlag {
+		log.Printf("Running once...")
+
+		report, err := thumbnailPicker.Pick()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%v", report)
+
+		if !report.OK() {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	thumbnailPicker.Start()
+	log.Printf("Press Ctrl + C to exit...")
+	utils.WaitForCtrlC()
+	thumbnailPicker.Stop()
+}
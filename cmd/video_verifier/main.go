@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/services/video_verifier"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	urlFlag := flag.String("url", "http://localhost:8080/v1/graphql", "")
+	timeoutFlag := flag.Duration("timeout", time.Second*30, "")
+	cameraNameFlag := flag.String("cameraName", "", "only verify videos from this camera; defaults to all of them")
+	pathMapFlag := flag.String("pathMap", "", "comma-separated canonical=local path prefixes for where this host sees the media in the database (e.g. /srv/target_dir=/Volumes/cameranator/media/srv)")
+
+	flag.Parse()
+
+	url := *urlFlag
+	timeout := *timeoutFlag
+	cameraName := *cameraNameFlag
+
+	if url == "" || !strings.Contains(url, "http://") {
+		log.Fatal("invalid -url argument; must be HTTP URL for GraphQL instance")
+	}
+
+	if timeout <= time.Duration(0) {
+		log.Fatal("invalid -timeout argument; must be > 0s")
+	}
+
+	resolver, err := path_resolver.Parse(*pathMapFlag)
+	if err != nil {
+		log.Fatalf("invalid -pathMap argument; %v", err)
+	}
+
+	videoVerifier, err := video_verifier.NewVideoVerifier(url, timeout, resolver)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := videoVerifier.Verify(cameraName, func(problem video_verifier.Problem) {
+		log.Printf("%v", problem)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf(
+		"verified %v videos, %v had no checksum to verify against, %v had problems",
+		report.Verified,
+		report.Unchecked,
+		report.Problems,
+	)
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
@@ -73,6 +73,9 @@ export function Video(props: VideoProps) {
 
     const absoluteTimeMillisecondsRef = Date.parse(props.event.start_timestamp)
 
+    // WebVTT cues that point at tiles of the sprite sheet, for thumbnails while scrubbing
+    const spriteSheet = props.event.sprite_sheets?.[0]
+
     if (error) {
         console.warn(error)
         return (
@@ -123,6 +126,15 @@ export function Video(props: VideoProps) {
                             type: "video/mp4",
                         },
                     ],
+                    tracks: spriteSheet
+                        ? [
+                              {
+                                  kind: "metadata",
+                                  label: "thumbnails",
+                                  src: adjustPath(spriteSheet.vtt_file_path),
+                              },
+                          ]
+                        : [],
                 }}
                 onReady={() => {
                     readyRef.current = true
@@ -61,6 +61,14 @@ export const getEventsQuery = (
                 count
                 weighted_score
             }
+            sprite_sheets(order_by: {id: desc}, limit: 1) {
+                id
+                vtt_file_path
+                image {
+                    id
+                    file_path
+                }
+            }
         }
     }`
 }
@@ -76,6 +84,12 @@ export interface Image {
     file_path: string
 }
 
+export interface SpriteSheet {
+    id: string
+    vtt_file_path: string
+    image: Image
+}
+
 export interface Object {
     class_id: number
     class_name: string
@@ -94,4 +108,5 @@ export interface Event {
     processed_video: Video
     source_camera: Camera
     aggregated_detections: [Detection]
+    sprite_sheets: SpriteSheet[]
 }
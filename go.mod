@@ -4,6 +4,7 @@ go 1.21
 
 require (
 	github.com/alfg/mp4 v0.0.0-20210728035756-55ea58c08aeb
+	github.com/aws/aws-sdk-go v1.51.6
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/google/uuid v1.6.0
 	github.com/hasura/go-graphql-client v0.12.1
@@ -16,7 +17,6 @@ require (
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.51.6 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -33,6 +33,6 @@ require (
 	github.com/u2takey/ffmpeg-go v0.5.0
 	golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
 	golang.org/x/sys v0.18.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 	nhooyr.io/websocket v1.8.10 // indirect
 )
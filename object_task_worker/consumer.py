@@ -21,6 +21,7 @@ from object_task_worker.helpers import (
     get_query_for_repeaters,
     get_repeaters_for_detection,
 )
+from object_task_worker.path_resolver import parse as parse_path_map
 from object_task_worker.object_tracker import (
     DetectionContext,
     ObjectTracker,
@@ -73,7 +74,7 @@ INSERT INTO object (
 _UPDATE_EVENT_QUERY = """
 UPDATE event SET
     processed_video_id = %s,
-    status = 'needs tracking'
+    status = 'needs thumbnail'
 WHERE
     id = %s;
 """
@@ -89,6 +90,9 @@ if not all([_DB_HOST, _DB_PASSWORD, _DB_PORT, _DB_USER]):
         "one or more of DB_HOST, DB_PASSWORD, DB_PORT, DB_USER empty or unset"
     )
 
+# where this worker sees the media in the database (see path_resolver)
+_PATH_RESOLVER = parse_path_map(os.getenv("PATH_MAP") or "")
+
 _DSN = f"dbname={_DB_NAME} user={_DB_USER} host={_DB_HOST} port={_DB_PORT} password={_DB_PASSWORD}"
 
 
@@ -269,10 +273,14 @@ class Consumer(object):
         print(f"received event={repr(event)}")
 
         event_id = event.get("id")
-        file_path = event.get("original_video", {}).get("file_path")
-        camera_id = event.get("original_video", {}).get("camera_id")
-        start_timestamp = event.get("original_video", {}).get("start_timestamp")
-        end_timestamp = event.get("original_video", {}).get("end_timestamp")
+
+        # the sub-stream recording (if there is one) is much cheaper to run detection on
+        video = event.get("processed_video") or event.get("original_video", {})
+
+        file_path = video.get("file_path")
+        camera_id = video.get("camera_id")
+        start_timestamp = video.get("start_timestamp")
+        end_timestamp = video.get("end_timestamp")
 
         if not all([event_id, file_path, camera_id, start_timestamp, end_timestamp]):
             raise ValueError("unexpectedly Falsey field in event={}".format(event))
@@ -308,11 +316,15 @@ class Consumer(object):
         )
         print(f"created {repr(object_tracker)}")
 
-        print(f"processing video for file_path={file_path}...")
+        local_file_path = _PATH_RESOLVER.to_local(file_path)
+
+        print(f"processing video for file_path={file_path} (at {local_file_path})...")
         processed_video: ProcessedVideo = object_tracker(
-            file_path
+            local_file_path
         )  # slow, blocking call to do the processing
 
+        output_file_path = _PATH_RESOLVER.to_canonical(processed_video.output_path)
+
         try:
             size = os.stat(processed_video.output_path).st_size / 1024 / 1024
         except Exception:
@@ -322,16 +334,14 @@ class Consumer(object):
 
         with psycopg2.connect(_DSN) as conn:
             with conn.cursor() as cur:
-                print(
-                    f"insert video row for file_path={repr(processed_video.output_path)}..."
-                )
+                print(f"insert video row for file_path={repr(output_file_path)}...")
                 cur.execute(
                     _INSERT_VIDEO_QUERY,
                     (
                         start_timestamp.isoformat(),
                         end_timestamp.isoformat(),
                         size,
-                        processed_video.output_path,
+                        output_file_path,
                         camera_id,
                     ),
                 )
@@ -0,0 +1,79 @@
+from typing import Dict, List, NamedTuple
+
+
+# the same as pkg/path_resolver, e.g.:
+#
+#   PATH_MAP=/srv/target_dir=/Volumes/cameranator/media/srv
+#
+# (comma-separated for more than one)
+
+
+class Mapping(NamedTuple):
+    canonical: str
+    local: str
+
+
+class Resolver(object):
+    def __init__(self, mappings: List[Mapping]):
+        self._mappings: List[Mapping] = []
+
+        seen_canonical = set()
+        seen_local = set()
+
+        for mapping in mappings:
+            mapping = Mapping(
+                canonical=mapping.canonical.strip().rstrip("/"),
+                local=mapping.local.strip().rstrip("/"),
+            )
+
+            if not mapping.canonical or not mapping.local:
+                raise ValueError(
+                    f"invalid mapping {repr(mapping)}; "
+                    "neither side may be empty (or just /)"
+                )
+
+            if mapping.canonical in seen_canonical:
+                raise ValueError(f"{repr(mapping.canonical)} is mapped more than once")
+
+            if mapping.local in seen_local:
+                raise ValueError(
+                    f"more than one thing is mapped to {repr(mapping.local)}"
+                )
+
+            seen_canonical.add(mapping.canonical)
+            seen_local.add(mapping.local)
+
+            self._mappings.append(mapping)
+
+    @staticmethod
+    def _swap(path: str, prefixes: Dict[str, str]) -> str:
+        for prefix in sorted(prefixes, key=len, reverse=True):
+            if path == prefix or path.startswith(prefix + "/"):
+                return prefixes[prefix] + path[len(prefix) :]
+
+        return path
+
+    def to_local(self, canonical: str) -> str:
+        return self._swap(canonical, {m.canonical: m.local for m in self._mappings})
+
+    def to_canonical(self, local: str) -> str:
+        return self._swap(local, {m.local: m.canonical for m in self._mappings})
+
+
+def parse(raw: str) -> Resolver:
+    mappings = []
+
+    for raw_mapping in (raw or "").split(","):
+        raw_mapping = raw_mapping.strip()
+        if not raw_mapping:
+            continue
+
+        canonical, sep, local = raw_mapping.partition("=")
+        if not sep:
+            raise ValueError(
+                f"invalid mapping {repr(raw_mapping)}; must be canonical=local"
+            )
+
+        mappings.append(Mapping(canonical=canonical, local=local))
+
+    return Resolver(mappings)
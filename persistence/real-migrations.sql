@@ -10,6 +10,12 @@ DROP TABLE IF EXISTS public.detection CASCADE;
 
 DROP TABLE IF EXISTS public.aggregated_detection CASCADE;
 
+DROP TABLE IF EXISTS public.recording_gap CASCADE;
+
+DROP TABLE IF EXISTS public.recorder_recovery CASCADE;
+
+DROP TABLE IF EXISTS public.sprite_sheet CASCADE;
+
 SET
     statement_timeout = 0;
 
@@ -63,7 +69,7 @@ SET
 -- camera
 --
 CREATE TABLE
-    public.camera (id bigint NOT NULL PRIMARY KEY, name text NOT NULL UNIQUE, stream_url text NOT NULL);
+    public.camera (id bigint NOT NULL PRIMARY KEY, name text NOT NULL UNIQUE, stream_url text NOT NULL, sub_stream_url text);
 
 ALTER TABLE public.camera OWNER TO postgres;
 
@@ -95,7 +101,7 @@ CREATE TABLE
         thumbnail_image_id bigint NOT NULL,
         processed_video_id bigint,
         source_camera_id bigint NOT NULL,
-        status text DEFAULT true NOT NULL CHECK (status IN ('needs detection', 'detection underway', 'needs tracking', 'tracking underway', 'done'))
+        status text DEFAULT true NOT NULL CHECK (status IN ('needs detection', 'detection underway', 'needs thumbnail', 'needs tracking', 'tracking underway', 'done'))
     );
 
 ALTER TABLE public.event OWNER TO postgres;
@@ -126,6 +132,7 @@ CREATE TABLE
         duration interval NOT NULL DEFAULT interval '0 seconds',
         "size" double precision NOT NULL DEFAULT 0,
         file_path text NOT NULL,
+        checksum text,
         camera_id bigint NOT NULL,
         event_id bigint NULL
     );
@@ -276,6 +283,101 @@ SET DEFAULT nextval('public.aggregated_detection_id_seq'::regclass);
 SELECT
     pg_catalog.setval ('public.aggregated_detection_id_seq', 1, true);
 
+--
+-- recording_gap
+--
+CREATE TABLE
+    public.recording_gap (
+        id bigint NOT NULL PRIMARY KEY,
+        start_timestamp timestamp with time zone NOT NULL,
+        end_timestamp timestamp with time zone NOT NULL,
+        duration interval GENERATED ALWAYS AS (end_timestamp - start_timestamp) STORED,
+        camera_id bigint NOT NULL
+    );
+
+ALTER TABLE public.recording_gap OWNER TO postgres;
+
+CREATE SEQUENCE public.recording_gap_id_seq AS bigint START
+WITH
+    1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+
+ALTER TABLE public.recording_gap_id_seq OWNER TO postgres;
+
+ALTER SEQUENCE public.recording_gap_id_seq OWNED BY public.recording_gap.id;
+
+ALTER TABLE ONLY public.recording_gap
+ALTER COLUMN id
+SET DEFAULT nextval('public.recording_gap_id_seq'::regclass);
+
+SELECT
+    pg_catalog.setval ('public.recording_gap_id_seq', 1, true);
+
+--
+-- recorder_recovery (each time a segment generator's watchdog restarted a stalled recorder)
+--
+CREATE TABLE
+    public.recorder_recovery (
+        id bigint NOT NULL PRIMARY KEY,
+        timestamp timestamp with time zone NOT NULL,
+        reason text NOT NULL,
+        detail text NOT NULL,
+        file_path text NOT NULL,
+        camera_id bigint NOT NULL
+    );
+
+ALTER TABLE public.recorder_recovery OWNER TO postgres;
+
+CREATE SEQUENCE public.recorder_recovery_id_seq AS bigint START
+WITH
+    1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+
+ALTER TABLE public.recorder_recovery_id_seq OWNER TO postgres;
+
+ALTER SEQUENCE public.recorder_recovery_id_seq OWNED BY public.recorder_recovery.id;
+
+ALTER TABLE ONLY public.recorder_recovery
+ALTER COLUMN id
+SET DEFAULT nextval('public.recorder_recovery_id_seq'::regclass);
+
+SELECT
+    pg_catalog.setval ('public.recorder_recovery_id_seq', 1, true);
+
+--
+-- sprite_sheet (the tiles of a video for scrubbing through it; image is the tiles and vtt_file_path says which is which)
+--
+CREATE TABLE
+    public.sprite_sheet (
+        id bigint NOT NULL PRIMARY KEY,
+        interval_seconds double precision NOT NULL,
+        tile_width integer NOT NULL,
+        tile_height integer NOT NULL,
+        columns integer NOT NULL,
+        rows integer NOT NULL,
+        tiles integer NOT NULL,
+        vtt_file_path text NOT NULL,
+        image_id bigint NOT NULL,
+        video_id bigint NOT NULL,
+        event_id bigint NOT NULL,
+        camera_id bigint NOT NULL
+    );
+
+ALTER TABLE public.sprite_sheet OWNER TO postgres;
+
+CREATE SEQUENCE public.sprite_sheet_id_seq AS bigint START
+WITH
+    1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+
+ALTER TABLE public.sprite_sheet_id_seq OWNER TO postgres;
+
+ALTER SEQUENCE public.sprite_sheet_id_seq OWNED BY public.sprite_sheet.id;
+
+ALTER TABLE ONLY public.sprite_sheet
+ALTER COLUMN id
+SET DEFAULT nextval('public.sprite_sheet_id_seq'::regclass);
+
+SELECT
+    pg_catalog.setval ('public.sprite_sheet_id_seq', 1, true);
+
 --
 -- foreign keys
 --
@@ -321,6 +423,24 @@ ADD CONSTRAINT detection_object_id_fkey FOREIGN KEY (object_id) REFERENCES publi
 ALTER TABLE ONLY public.aggregated_detection
 ADD CONSTRAINT aggregated_detection_event_id_fkey FOREIGN KEY (event_id) REFERENCES public.event (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
 
+ALTER TABLE ONLY public.recording_gap
+ADD CONSTRAINT recording_gap_camera_id_fkey FOREIGN KEY (camera_id) REFERENCES public.camera (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
+ALTER TABLE ONLY public.recorder_recovery
+ADD CONSTRAINT recorder_recovery_camera_id_fkey FOREIGN KEY (camera_id) REFERENCES public.camera (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
+ALTER TABLE ONLY public.sprite_sheet
+ADD CONSTRAINT sprite_sheet_image_id_fkey FOREIGN KEY (image_id) REFERENCES public.image (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
+ALTER TABLE ONLY public.sprite_sheet
+ADD CONSTRAINT sprite_sheet_video_id_fkey FOREIGN KEY (video_id) REFERENCES public.video (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
+ALTER TABLE ONLY public.sprite_sheet
+ADD CONSTRAINT sprite_sheet_event_id_fkey FOREIGN KEY (event_id) REFERENCES public.event (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
+ALTER TABLE ONLY public.sprite_sheet
+ADD CONSTRAINT sprite_sheet_camera_id_fkey FOREIGN KEY (camera_id) REFERENCES public.camera (id) ON UPDATE RESTRICT ON DELETE RESTRICT;
+
 --
 -- because my neanderthal brain cannot switch contexts from the naming schema we use at work
 --
@@ -369,6 +489,33 @@ SELECT
 FROM
     public.object;
 
+DROP VIEW IF EXISTS public.recording_gaps;
+
+CREATE VIEW
+    public.recording_gaps AS
+SELECT
+    *
+FROM
+    public.recording_gap;
+
+DROP VIEW IF EXISTS public.recorder_recoveries;
+
+CREATE VIEW
+    public.recorder_recoveries AS
+SELECT
+    *
+FROM
+    public.recorder_recovery;
+
+DROP VIEW IF EXISTS public.sprite_sheets;
+
+CREATE VIEW
+    public.sprite_sheets AS
+SELECT
+    *
+FROM
+    public.sprite_sheet;
+
 DROP VIEW IF EXISTS public.videos;
 
 CREATE VIEW
@@ -415,13 +562,22 @@ CREATE INDEX IF NOT EXISTS detection_class_id_idx ON public.detection (class_id)
 
 CREATE INDEX IF NOT EXISTS detection_class_name_idx ON public.detection (class_name);
 
+CREATE INDEX IF NOT EXISTS recording_gap_start_timestamp_camera_id_idx ON public.recording_gap (start_timestamp, camera_id);
+
+CREATE INDEX IF NOT EXISTS recording_gap_end_timestamp_camera_id_idx ON public.recording_gap (end_timestamp, camera_id);
+
+-- a recovery is only recorded once, however many times it's delivered
+CREATE UNIQUE INDEX IF NOT EXISTS recorder_recovery_timestamp_camera_id_idx ON public.recorder_recovery (timestamp, camera_id);
+
+CREATE INDEX IF NOT EXISTS sprite_sheet_event_id_idx ON public.sprite_sheet (event_id);
+
 --
 -- aggregations
 --
 CREATE
 OR REPLACE FUNCTION aggregate_detection () RETURNS TRIGGER AS $$
 BEGIN
-    IF NEW.status = 'needs tracking' THEN
+    IF NEW.status = 'needs thumbnail' THEN
         WITH cte1 AS (
             SELECT
                 d.class_id AS class_id,
@@ -462,7 +618,7 @@ $$ LANGUAGE plpgsql;
 CREATE
 OR REPLACE TRIGGER aggregate_detection_trigger
 AFTER
-UPDATE ON event FOR EACH ROW WHEN (NEW.status = 'needs tracking')
+UPDATE ON event FOR EACH ROW WHEN (NEW.status = 'needs thumbnail')
 EXECUTE PROCEDURE aggregate_detection ();
 
 --
@@ -0,0 +1,239 @@
+package filesystem
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollInterval is how often the polling backend lists the folder
+const pollInterval = time.Second
+
+// Backend is how a Watcher finds out about changes to its folder
+type Backend string
+
+const (
+	BackendAuto    Backend = "auto"    // polling on network / FUSE filesystems (where inotify doesn't see remote writes), inotify otherwise
+	BackendInotify Backend = "inotify" // fsnotify (i.e. inotify on Linux)
+	BackendPolling Backend = "polling" // list the folder every pollInterval and compare sizes and modification times
+)
+
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(strings.ToLower(name)) {
+	case "", BackendAuto:
+		return BackendAuto, nil
+	case BackendInotify:
+		return BackendInotify, nil
+	case BackendPolling, "poll":
+		return BackendPolling, nil
+	}
+
+	return "", fmt.Errorf("unsupported watcher backend %#+v; must be auto, inotify or polling", name)
+}
+
+// backend delivers changes to a folder as fsnotify events (only Create and Write matter to the Watcher)
+type backend interface {
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+	close()
+}
+
+// openBackend opens the given kind of backend on path; for BackendAuto it picks one based on the filesystem (and
+// falls back to polling if inotify can't be set up)
+func openBackend(kind Backend, path string) (backend, error) {
+	if kind != BackendAuto {
+		if kind == BackendPolling {
+			return openPollingBackend(path, pollInterval)
+		}
+
+		return openInotifyBackend(path)
+	}
+
+	remote, fsType, err := isRemoteFilesystem(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if remote {
+		log.Printf("%#+v is on %v; polling it every %v", path, fsType, pollInterval)
+		return openPollingBackend(path, pollInterval)
+	}
+
+	b, err := openInotifyBackend(path)
+	if err != nil {
+		log.Printf("warning: failed to watch %#+v with inotify because %v; polling it every %v instead", path, err, pollInterval)
+		return openPollingBackend(path, pollInterval)
+	}
+
+	return b, nil
+}
+
+type inotifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+func openInotifyBackend(path string) (*inotifyBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = watcher.Add(path)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	return &inotifyBackend{watcher: watcher}, nil
+}
+
+func (b *inotifyBackend) events() <-chan fsnotify.Event {
+	return b.watcher.Events
+}
+
+func (b *inotifyBackend) errors() <-chan error {
+	return b.watcher.Errors
+}
+
+func (b *inotifyBackend) close() {
+	_ = b.watcher.Close()
+}
+
+type polledFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// pollingBackend lists the folder every interval; a file it hasn't seen before is a Create and a change in a known
+// file's size or modification time is a Write
+type pollingBackend struct {
+	path      string
+	eventsCh  chan fsnotify.Event
+	errorsCh  chan error
+	done      chan struct{}
+	wg        sync.WaitGroup
+	files     map[string]polledFile
+	lastError string
+}
+
+func openPollingBackend(path string, interval time.Duration) (*pollingBackend, error) {
+	b := pollingBackend{
+		path:     path,
+		eventsCh: make(chan fsnotify.Event, dispatchQueueSize),
+		errorsCh: make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+
+	// what's there already isn't news (the Watcher's scan deals with it)
+	var err error
+	b.files, err = b.list()
+	if err != nil {
+		return nil, err
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+				b.poll()
+			}
+		}
+	}()
+
+	return &b, nil
+}
+
+func (b *pollingBackend) list() (map[string]polledFile, error) {
+	entries, err := os.ReadDir(b.path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]polledFile)
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		files[filepath.Join(b.path, entry.Name())] = polledFile{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+	}
+
+	return files, nil
+}
+
+func (b *pollingBackend) poll() {
+	files, err := b.list()
+	if err != nil {
+		// only the once for each distinct error, rather than every interval
+		if err.Error() != b.lastError {
+			b.lastError = err.Error()
+			b.send(nil, err)
+		}
+
+		return
+	}
+
+	b.lastError = ""
+
+	for name, file := range files {
+		last, ok := b.files[name]
+		if !ok {
+			b.send(&fsnotify.Event{Name: name, Op: fsnotify.Create}, nil)
+			continue
+		}
+
+		if file.size != last.size || !file.modTime.Equal(last.modTime) {
+			b.send(&fsnotify.Event{Name: name, Op: fsnotify.Write}, nil)
+		}
+	}
+
+	b.files = files
+}
+
+func (b *pollingBackend) send(event *fsnotify.Event, err error) {
+	if event != nil {
+		select {
+		case <-b.done:
+		case b.eventsCh <- *event:
+		}
+
+		return
+	}
+
+	select {
+	case <-b.done:
+	case b.errorsCh <- err:
+	}
+}
+
+func (b *pollingBackend) events() <-chan fsnotify.Event {
+	return b.eventsCh
+}
+
+func (b *pollingBackend) errors() <-chan error {
+	return b.errorsCh
+}
+
+func (b *pollingBackend) close() {
+	close(b.done)
+	b.wg.Wait()
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"syscall"
+)
+
+// filesystems that inotify can't (fully) follow; changes made by other hosts (or by the other side of a FUSE / 9p
+// mount) never raise events
+var remoteFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x00c36400: "ceph",
+}
+
+func isRemoteFilesystem(path string) (bool, string, error) {
+	stat := syscall.Statfs_t{}
+
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return false, "", err
+	}
+
+	fsType, ok := remoteFilesystems[uint32(stat.Type)]
+
+	return ok, fsType, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"os"
+)
+
+// isRemoteFilesystem only knows how to tell on Linux; elsewhere everything gets inotify (well, fsnotify) first
+func isRemoteFilesystem(path string) (bool, string, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return false, "", err
+	}
+
+	return false, "", nil
+}
@@ -0,0 +1,40 @@
+package filesystem
+
+const (
+	dispatchQueueSize = 256
+)
+
+// dispatcher runs callbacks one at a time (in the order they were dispatched) on its own goroutine behind a bounded
+// queue, so that a Watcher's callbacks never run concurrently with one another; once the queue is full, dispatch
+// blocks (i.e. the Watcher stops taking events until its callbacks catch up, rather than piling up goroutines)
+type dispatcher struct {
+	jobs chan func()
+	done chan struct{}
+}
+
+func newDispatcher(queueSize int) *dispatcher {
+	d := dispatcher{
+		jobs: make(chan func(), queueSize),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(d.done)
+
+		for job := range d.jobs {
+			job()
+		}
+	}()
+
+	return &d
+}
+
+func (d *dispatcher) dispatch(job func()) {
+	d.jobs <- job
+}
+
+// stop waits for everything already dispatched to finish; nothing may be dispatched after it's called
+func (d *dispatcher) stop() {
+	close(d.jobs)
+	<-d.done
+}
@@ -2,7 +2,11 @@ package filesystem
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -11,19 +15,32 @@ import (
 	"github.com/initialed85/cameranator/pkg/media/metadata"
 )
 
+// scanInterval is how often the folder is listed to catch files that the Backend didn't tell us about
+const scanInterval = time.Second * 30
+
 type File struct {
 	Name string
 	Size float64
 }
 
+// Watcher invokes onFileCreate once for each file that appears in path (and matches matcher, if set) and onFileWrite
+// for each write to one; as well as following its Backend, the folder is scanned when the Watcher starts and every
+// scanInterval after that, and any files that weren't already seen (e.g. they were created while nothing was
+// watching) get a create (in order of modification time) as if they'd just appeared; the callbacks are run one at a
+// time, in order, on a goroutine of the Watcher's own (see dispatcher)
 type Watcher struct {
 	path          string
 	matcher       *regexp.Regexp
 	onFileCreate  func(File)
 	onFileWrite   func(File)
+	backendKind   Backend
 	ticker        *time.Ticker
-	watcher       *fsnotify.Watcher
+	backend       backend
+	dispatcher    *dispatcher
 	blockedWorker *worker.BlockedWorker
+	mu            sync.Mutex
+	seen          map[string]os.FileInfo // by name, the file that a create was last emitted for
+	lastScan      time.Time
 }
 
 func NewWatcher(
@@ -31,12 +48,26 @@ func NewWatcher(
 	matcher *regexp.Regexp,
 	onFileCreate func(File),
 	onFileWrite func(File),
+) *Watcher {
+	return NewWatcherWithBackend(path, matcher, onFileCreate, onFileWrite, BackendAuto)
+}
+
+// NewWatcherWithBackend is NewWatcher with an explicit Backend (e.g. BackendPolling for a filesystem that BackendAuto
+// doesn't recognise as needing it)
+func NewWatcherWithBackend(
+	path string,
+	matcher *regexp.Regexp,
+	onFileCreate func(File),
+	onFileWrite func(File),
+	backendKind Backend,
 ) *Watcher {
 	w := Watcher{
 		path:         path,
 		matcher:      matcher,
 		onFileCreate: onFileCreate,
 		onFileWrite:  onFileWrite,
+		backendKind:  backendKind,
+		seen:         make(map[string]os.FileInfo),
 	}
 
 	w.blockedWorker = worker.NewBlockedWorker(
@@ -52,7 +83,7 @@ func (w *Watcher) onStart() {
 	var err error
 
 	for {
-		w.watcher, err = fsnotify.NewWatcher()
+		w.backend, err = openBackend(w.backendKind, w.path)
 		if err != nil {
 			log.Printf("warning: failed to watch %#+v because %v; will try again...", w.path, err)
 			time.Sleep(time.Second)
@@ -62,18 +93,121 @@ func (w *Watcher) onStart() {
 		break
 	}
 
-	for {
-		err = w.watcher.Add(w.path)
-		if err != nil {
-			log.Printf("warning: failed to watch %#+v because %v; will try again...", w.path, err)
-			time.Sleep(time.Second)
+	w.dispatcher = newDispatcher(dispatchQueueSize)
+
+	w.ticker = time.NewTicker(time.Second)
+
+	// after the watch is in place, so that nothing falls between the two
+	w.scan()
+}
+
+// markSeen records that a create has been emitted for the file at name; it returns false if one already had been for
+// the same file (by identity rather than by name, so that one renamed over it, e.g. a config file being saved
+// atomically, is new)
+func (w *Watcher) markSeen(name string, info os.FileInfo) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seenInfo, ok := w.seen[name]
+	if ok && os.SameFile(seenInfo, info) {
+		return false
+	}
+
+	w.seen[name] = info
+
+	return true
+}
+
+// scan emits a create for each file in the folder that hasn't been seen yet; they're emitted in order (of
+// modification time) as a single dispatched job, so that e.g. consecutive segments are handled in the order they were
+// written
+func (w *Watcher) scan() {
+	w.lastScan = time.Now()
+
+	entries, err := os.ReadDir(w.path)
+	if err != nil {
+		log.Printf("warning: failed to scan %#+v because %v", w.path, err)
+		return
+	}
+
+	type scanned struct {
+		file    File
+		info    os.FileInfo
+		modTime time.Time
+	}
+
+	missed := make([]scanned, 0)
+	present := make(map[string]struct{})
+
+	w.mu.Lock()
+
+	for _, entry := range entries {
+		name := filepath.Join(w.path, entry.Name())
+
+		if w.matcher != nil && !w.matcher.Match([]byte(name)) {
 			continue
 		}
 
-		break
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		present[name] = struct{}{}
+
+		seenInfo, ok := w.seen[name]
+		if ok && os.SameFile(seenInfo, info) {
+			continue
+		}
+
+		missed = append(missed, scanned{
+			file: File{
+				Name: name,
+				Size: float64(info.Size()) / 1000000,
+			},
+			info:    info,
+			modTime: info.ModTime(),
+		})
 	}
 
-	w.ticker = time.NewTicker(time.Second)
+	// forget what's been deleted (so that seen doesn't grow forever); anything created since the listing is kept
+	for name := range w.seen {
+		_, ok := present[name]
+		if ok {
+			continue
+		}
+
+		_, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			delete(w.seen, name)
+		}
+	}
+
+	for _, m := range missed {
+		w.seen[m.file.Name] = m.info
+	}
+
+	w.mu.Unlock()
+
+	if len(missed) == 0 {
+		return
+	}
+
+	sort.SliceStable(missed, func(i, j int) bool {
+		if missed[i].modTime.Equal(missed[j].modTime) {
+			return missed[i].file.Name < missed[j].file.Name
+		}
+
+		return missed[i].modTime.Before(missed[j].modTime)
+	})
+
+	log.Printf("scan; %v file(s) in %#+v were missed", len(missed), w.path)
+
+	w.dispatcher.dispatch(func() {
+		for _, m := range missed {
+			w.onFileCreate(m.file)
+		}
+	})
 }
 
 func (w *Watcher) handleEvent(event fsnotify.Event) {
@@ -108,19 +242,29 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	}
 
 	if event.Op&fsnotify.Create == fsnotify.Create {
-		// TODO: fix unbounded goroutine usage
-		go w.onFileCreate(file)
+		info, err := os.Lstat(name)
+		if err != nil {
+			log.Printf("warning: failed to stat %#+v because %#+v", name, err)
+			return
+		}
+
+		if w.markSeen(name, info) {
+			w.dispatcher.dispatch(func() {
+				w.onFileCreate(file)
+			})
+		}
 	}
 
 	if event.Op&fsnotify.Write == fsnotify.Write {
-		// TODO: fix unbounded goroutine usage
-		go w.onFileWrite(file)
+		w.dispatcher.dispatch(func() {
+			w.onFileWrite(file)
+		})
 	}
 }
 
 func (w *Watcher) work() {
 	select {
-	case event, ok := <-w.watcher.Events:
+	case event, ok := <-w.backend.events():
 		if !ok {
 			log.Printf("warning: event %#+v not ok; retrying...", event)
 			time.Sleep(time.Second)
@@ -134,7 +278,7 @@ func (w *Watcher) work() {
 		}
 
 		w.handleEvent(event)
-	case err, ok := <-w.watcher.Errors:
+	case err, ok := <-w.backend.errors():
 		if !ok {
 			log.Printf("warning: error %#+v not ok; retrying...", err)
 			time.Sleep(time.Second)
@@ -143,13 +287,18 @@ func (w *Watcher) work() {
 
 		log.Printf("warning: watcher threw %#+v", err) // TODO: probably do something with this
 	case <-w.ticker.C:
-		time.Sleep(0) // noop for debounce and so we can exit
+		if time.Since(w.lastScan) >= scanInterval {
+			w.scan()
+		}
 	}
 }
 
 func (w *Watcher) onStop() {
-	_ = w.watcher.Remove(w.path)
-	w.watcher = nil
+	w.backend.close()
+	w.backend = nil
+
+	// lets whatever's queued finish (in the background, as Stop doesn't wait)
+	go w.dispatcher.stop()
 
 	w.ticker.Stop()
 	w.ticker = nil
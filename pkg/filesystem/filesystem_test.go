@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,6 +23,7 @@ func TestNewWatcher(t *testing.T) {
 	matcher, err := regexp.Compile(`.*/file\.txt`)
 	require.NoError(t, err)
 
+	mu := sync.Mutex{}
 	created := make([]File, 0)
 	wrote := make([]File, 0)
 
@@ -29,38 +32,47 @@ func TestNewWatcher(t *testing.T) {
 		matcher,
 		func(file File) {
 			log.Printf("created %#+v", file)
+			mu.Lock()
 			created = append(created, file)
+			mu.Unlock()
 		},
 		func(file File) {
 			log.Printf("wrote %#+v", file)
+			mu.Lock()
 			wrote = append(wrote, file)
+			mu.Unlock()
 		},
 	)
 
+	assertLen := func(createdLen int, wroteLen int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Len(t, created, createdLen)
+		assert.Len(t, wrote, wroteLen)
+	}
+
 	w.Start()
 
 	time.Sleep(time.Millisecond * 100)
-	assert.Len(t, created, 0)
-	assert.Len(t, wrote, 0)
+	assertLen(0, 0)
 
 	f, err := os.Create(fmt.Sprintf("%v/file.txt", dir))
 	require.NoError(t, err)
 	time.Sleep(time.Millisecond * 100)
-	assert.Len(t, created, 1)
-	assert.Len(t, wrote, 0)
+	assertLen(1, 0)
 
 	_, err = f.Write([]byte("Hello, world."))
 	require.NoError(t, err)
 	time.Sleep(time.Millisecond * 100)
-	assert.Len(t, created, 1)
-	assert.Len(t, wrote, 1)
+	assertLen(1, 1)
 
 	_, err = f.Write([]byte("Hello, world."))
 	require.NoError(t, err)
 	time.Sleep(time.Millisecond * 100)
-	assert.Len(t, created, 1)
-	assert.Len(t, wrote, 2)
+	assertLen(1, 2)
 
+	mu.Lock()
 	assert.Equal(
 		t,
 		File{
@@ -69,6 +81,229 @@ func TestNewWatcher(t *testing.T) {
 		},
 		wrote[1],
 	)
+	mu.Unlock()
 
 	w.Stop()
 }
+
+func TestWatcher_Scan(t *testing.T) {
+	dir := t.TempDir()
+
+	matcher, err := regexp.Compile(`.*/file_\d\.txt`)
+	require.NoError(t, err)
+
+	// written while nothing was watching (and out of order, as far as their names go)
+	now := time.Now()
+	for i, name := range []string{"file_2.txt", "file_1.txt", "other.txt"} {
+		path := fmt.Sprintf("%v/%v", dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("Hello, world."), 0644))
+		require.NoError(t, os.Chtimes(path, now, now.Add(time.Second*time.Duration(i-10))))
+	}
+
+	mu := sync.Mutex{}
+	created := make([]string, 0)
+
+	w := NewWatcher(
+		dir,
+		matcher,
+		func(file File) {
+			mu.Lock()
+			created = append(created, filepath.Base(file.Name))
+			mu.Unlock()
+		},
+		func(file File) {},
+	)
+
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(time.Millisecond * 100)
+
+	mu.Lock()
+	assert.Equal(t, []string{"file_2.txt", "file_1.txt"}, created)
+	mu.Unlock()
+
+	// seen by fsnotify and then by a scan, but only created once
+	require.NoError(t, os.WriteFile(fmt.Sprintf("%v/file_3.txt", dir), []byte("Hello, world."), 0644))
+	time.Sleep(time.Millisecond * 100)
+	w.scan()
+	time.Sleep(time.Millisecond * 100)
+
+	mu.Lock()
+	assert.Equal(t, []string{"file_2.txt", "file_1.txt", "file_3.txt"}, created)
+	mu.Unlock()
+
+	// forgotten once deleted
+	require.NoError(t, os.Remove(fmt.Sprintf("%v/file_1.txt", dir)))
+	w.scan()
+
+	w.mu.Lock()
+	assert.Len(t, w.seen, 2)
+	w.mu.Unlock()
+}
+
+func TestWatcher_RenameOverExisting(t *testing.T) {
+	dir := t.TempDir()
+
+	matcher, err := regexp.Compile(`.*/cameras\.yaml`)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "cameras.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("cameras: []"), 0644))
+
+	created := make(chan string, 16)
+
+	w := NewWatcher(
+		dir,
+		matcher,
+		func(file File) {
+			created <- filepath.Base(file.Name)
+		},
+		func(file File) {},
+	)
+
+	w.Start()
+	defer w.Stop()
+
+	select {
+	case name := <-created:
+		assert.Equal(t, "cameras.yaml", name)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for the scan")
+	}
+
+	// saved atomically (as editors and Kubernetes do), so it's a new file with the same name
+	tempPath := filepath.Join(dir, ".cameras.yaml.tmp")
+	require.NoError(t, os.WriteFile(tempPath, []byte("cameras: [{name: Driveway}]"), 0644))
+	require.NoError(t, os.Rename(tempPath, path))
+
+	select {
+	case name := <-created:
+		assert.Equal(t, "cameras.yaml", name)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for the create")
+	}
+
+	// and a scan after that doesn't create it again
+	w.scan()
+
+	select {
+	case name := <-created:
+		require.Fail(t, "unexpected create", name)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestWatcher_Polling(t *testing.T) {
+	dir := t.TempDir()
+
+	matcher, err := regexp.Compile(`.*/file\.txt`)
+	require.NoError(t, err)
+
+	mu := sync.Mutex{}
+	created := make([]File, 0)
+	wrote := make([]File, 0)
+
+	w := NewWatcherWithBackend(
+		dir,
+		matcher,
+		func(file File) {
+			mu.Lock()
+			created = append(created, file)
+			mu.Unlock()
+		},
+		func(file File) {
+			mu.Lock()
+			wrote = append(wrote, file)
+			mu.Unlock()
+		},
+		BackendPolling,
+	)
+
+	w.Start()
+	defer w.Stop()
+
+	time.Sleep(time.Millisecond * 100)
+
+	path := fmt.Sprintf("%v/file.txt", dir)
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	time.Sleep(pollInterval + time.Millisecond*500)
+
+	mu.Lock()
+	assert.Len(t, created, 1)
+	assert.Len(t, wrote, 0)
+	mu.Unlock()
+
+	_, err = f.Write([]byte("Hello, world."))
+	require.NoError(t, err)
+
+	time.Sleep(pollInterval + time.Millisecond*500)
+
+	mu.Lock()
+	assert.Len(t, created, 1)
+	require.Len(t, wrote, 1)
+	assert.Equal(t, File{Name: path, Size: 1.3e-05}, wrote[0])
+	mu.Unlock()
+
+	// nothing changed, nothing to say
+	time.Sleep(pollInterval + time.Millisecond*500)
+
+	mu.Lock()
+	assert.Len(t, wrote, 1)
+	mu.Unlock()
+}
+
+func TestParseBackend(t *testing.T) {
+	for name, expected := range map[string]Backend{
+		"":        BackendAuto,
+		"auto":    BackendAuto,
+		"inotify": BackendInotify,
+		"Polling": BackendPolling,
+		"poll":    BackendPolling,
+	} {
+		backend, err := ParseBackend(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, backend)
+	}
+
+	_, err := ParseBackend("kqueue")
+	assert.Error(t, err)
+}
+
+func TestDispatcher(t *testing.T) {
+	d := newDispatcher(4)
+
+	mu := sync.Mutex{}
+	running := 0
+	overlapped := false
+	order := make([]int, 0)
+
+	for i := 0; i < 16; i++ {
+		i := i
+
+		d.dispatch(func() {
+			mu.Lock()
+			running++
+			overlapped = overlapped || running > 1
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			order = append(order, i)
+			mu.Unlock()
+		})
+	}
+
+	d.stop()
+
+	assert.False(t, overlapped)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, order)
+}
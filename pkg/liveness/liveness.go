@@ -1,6 +1,7 @@
 package liveness
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime"
@@ -11,6 +12,11 @@ type HasLiveness interface {
 	IsLive() bool
 }
 
+// HasStatus is optionally implemented by a HasLiveness to describe itself (as JSON) at /status
+type HasStatus interface {
+	Status() any
+}
+
 type Agent struct {
 	services []HasLiveness
 	serveMux *http.ServeMux
@@ -32,6 +38,11 @@ func Open(
 		a.handle,
 	)
 
+	a.serveMux.HandleFunc(
+		"/status",
+		a.handleStatus,
+	)
+
 	a.server = &http.Server{
 		Addr:    fmt.Sprintf(":%v", port),
 		Handler: a.serveMux,
@@ -84,6 +95,33 @@ func (a *Agent) handle(responseWriter http.ResponseWriter, request *http.Request
 	responseWriter.WriteHeader(http.StatusOK)
 }
 
+func (a *Agent) handleStatus(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	statuses := make([]any, 0)
+
+	for _, server := range a.services {
+		hasStatus, ok := server.(HasStatus)
+		if !ok {
+			continue
+		}
+
+		statuses = append(statuses, hasStatus.Status())
+	}
+
+	b, err := json.Marshal(statuses)
+	if err != nil {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	_, _ = responseWriter.Write(b)
+}
+
 func (a *Agent) Close() {
 	_ = a.server.Close()
 }
@@ -91,6 +91,45 @@ func ConvertVideo(sourcePath, destinationPath string, width, height int) (string
 	)
 }
 
+// ReencodeVideo re-encodes the video at sourcePath (at the same size) to destinationPath with a target video bitrate
+// (e.g. 500k), copying any audio as it is; it's done on the CPU as it's meant for background work (e.g. archiving)
+func ReencodeVideo(sourcePath, destinationPath string, bitrate string) (string, string, error) {
+	var err error
+
+	sourcePath, err = filepath.Abs(sourcePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	destinationPath, err = filepath.Abs(destinationPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	sourcePath = strings.TrimSpace(sourcePath)
+	destinationPath = strings.TrimSpace(destinationPath)
+
+	log.Printf("ReencodeVideo; sourcePath=%#+v, destinationPath=%#+v, bitrate=%#+v", sourcePath, destinationPath, bitrate)
+
+	arguments := []string{
+		"-y",
+		"-i",
+		fmt.Sprintf("%v", sourcePath),
+		"-c:v",
+		"libx264",
+		"-b:v",
+		bitrate,
+		"-c:a",
+		"copy",
+		fmt.Sprintf("%v", destinationPath),
+	}
+
+	return process.RunCommand(
+		"ffmpeg",
+		arguments...,
+	)
+}
+
 func ConvertImage(sourcePath, destinationPath string, width, height int) (string, string, error) {
 	var err error
 
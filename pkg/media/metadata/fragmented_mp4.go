@@ -0,0 +1,406 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// toDuration converts a count of ticks at timescale (per second) without overflowing for long durations
+func toDuration(ticks uint64, timescale uint64) time.Duration {
+	return time.Duration(ticks/timescale)*time.Second + time.Duration(ticks%timescale)*time.Second/time.Duration(timescale)
+}
+
+type box struct {
+	boxType string
+	offset  int64 // of the payload
+	size    int64 // of the payload
+}
+
+type track struct {
+	id          uint32
+	handlerType string
+	timescale   uint32
+}
+
+type fragment struct {
+	trackID  uint32
+	duration uint64
+}
+
+// readBoxes returns the boxes in data (at offset in the file), stopping at the first one that runs past the end (i.e.
+// it's been truncated)
+func readBoxes(data []byte, offset int64) []box {
+	boxes := make([]box, 0)
+
+	for len(data) >= 8 {
+		size := int64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // extends to the end
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			headerSize = 16
+		}
+
+		if size < headerSize {
+			return boxes
+		}
+
+		if size > int64(len(data)) {
+			return boxes
+		}
+
+		boxes = append(boxes, box{
+			boxType: boxType,
+			offset:  offset + headerSize,
+			size:    size - headerSize,
+		})
+
+		data = data[size:]
+		offset += size
+	}
+
+	return boxes
+}
+
+func getPayload(data []byte, b box) []byte {
+	return data[b.offset : b.offset+b.size]
+}
+
+func findBox(boxes []box, boxType string) (box, bool) {
+	for _, b := range boxes {
+		if b.boxType == boxType {
+			return b, true
+		}
+	}
+
+	return box{}, false
+}
+
+func readTrack(data []byte, trak box) (track, error) {
+	t := track{}
+
+	trakBoxes := readBoxes(getPayload(data, trak), trak.offset)
+
+	tkhd, ok := findBox(trakBoxes, "tkhd")
+	if !ok {
+		return track{}, fmt.Errorf("trak has no tkhd")
+	}
+
+	payload := getPayload(data, tkhd)
+	if len(payload) < 4 {
+		return track{}, fmt.Errorf("tkhd too short")
+	}
+
+	// version 1 has 64-bit creation / modification times
+	trackIDOffset := 12
+	if payload[0] == 1 {
+		trackIDOffset = 20
+	}
+
+	if len(payload) < trackIDOffset+4 {
+		return track{}, fmt.Errorf("tkhd too short")
+	}
+
+	t.id = binary.BigEndian.Uint32(payload[trackIDOffset : trackIDOffset+4])
+
+	mdia, ok := findBox(trakBoxes, "mdia")
+	if !ok {
+		return track{}, fmt.Errorf("trak has no mdia")
+	}
+
+	mdiaBoxes := readBoxes(getPayload(data, mdia), mdia.offset)
+
+	mdhd, ok := findBox(mdiaBoxes, "mdhd")
+	if !ok {
+		return track{}, fmt.Errorf("mdia has no mdhd")
+	}
+
+	payload = getPayload(data, mdhd)
+	if len(payload) < 4 {
+		return track{}, fmt.Errorf("mdhd too short")
+	}
+
+	timescaleOffset := 12
+	if payload[0] == 1 {
+		timescaleOffset = 20
+	}
+
+	if len(payload) < timescaleOffset+4 {
+		return track{}, fmt.Errorf("mdhd too short")
+	}
+
+	t.timescale = binary.BigEndian.Uint32(payload[timescaleOffset : timescaleOffset+4])
+
+	hdlr, ok := findBox(mdiaBoxes, "hdlr")
+	if ok {
+		payload = getPayload(data, hdlr)
+		if len(payload) >= 12 {
+			t.handlerType = string(payload[8:12])
+		}
+	}
+
+	return t, nil
+}
+
+func readTrex(payload []byte) (trackID uint32, defaultSampleDuration uint32, err error) {
+	if len(payload) < 16 {
+		return 0, 0, fmt.Errorf("trex too short")
+	}
+
+	return binary.BigEndian.Uint32(payload[4:8]), binary.BigEndian.Uint32(payload[12:16]), nil
+}
+
+func readTraf(data []byte, traf box, defaultSampleDurationByTrackID map[uint32]uint32) (fragment, error) {
+	trafBoxes := readBoxes(getPayload(data, traf), traf.offset)
+
+	tfhd, ok := findBox(trafBoxes, "tfhd")
+	if !ok {
+		return fragment{}, fmt.Errorf("traf has no tfhd")
+	}
+
+	payload := getPayload(data, tfhd)
+	if len(payload) < 8 {
+		return fragment{}, fmt.Errorf("tfhd too short")
+	}
+
+	flags := binary.BigEndian.Uint32(payload[0:4]) & 0xffffff
+	f := fragment{
+		trackID: binary.BigEndian.Uint32(payload[4:8]),
+	}
+
+	defaultSampleDuration := defaultSampleDurationByTrackID[f.trackID]
+
+	position := 8
+	if flags&0x01 != 0 { // base-data-offset
+		position += 8
+	}
+	if flags&0x02 != 0 { // sample-description-index
+		position += 4
+	}
+	if flags&0x08 != 0 { // default-sample-duration
+		if len(payload) < position+4 {
+			return fragment{}, fmt.Errorf("tfhd too short")
+		}
+
+		defaultSampleDuration = binary.BigEndian.Uint32(payload[position : position+4])
+	}
+
+	for _, b := range trafBoxes {
+		if b.boxType != "trun" {
+			continue
+		}
+
+		payload := getPayload(data, b)
+		if len(payload) < 8 {
+			return fragment{}, fmt.Errorf("trun too short")
+		}
+
+		flags := binary.BigEndian.Uint32(payload[0:4]) & 0xffffff
+		sampleCount := binary.BigEndian.Uint32(payload[4:8])
+
+		position := 8
+		if flags&0x01 != 0 { // data-offset
+			position += 4
+		}
+		if flags&0x04 != 0 { // first-sample-flags
+			position += 4
+		}
+
+		if flags&0x100 == 0 {
+			f.duration += uint64(sampleCount) * uint64(defaultSampleDuration)
+			continue
+		}
+
+		sampleSize := 4
+		for _, flag := range []uint32{0x200, 0x400, 0x800} {
+			if flags&flag != 0 {
+				sampleSize += 4
+			}
+		}
+
+		for i := uint32(0); i < sampleCount; i++ {
+			if len(payload) < position+4 {
+				return fragment{}, fmt.Errorf("trun too short for %v samples", sampleCount)
+			}
+
+			f.duration += uint64(binary.BigEndian.Uint32(payload[position : position+4]))
+			position += sampleSize
+		}
+	}
+
+	return f, nil
+}
+
+// HasMoov returns true if there's a moov at the top level of the MP4 at path; a plain MP4 only gets one once it's been
+// written to the end (a fragmented MP4 gets an empty one at the start), so one without is beyond repair
+func HasMoov(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	header := make([]byte, 16)
+	offset := int64(0)
+
+	for {
+		n, err := file.ReadAt(header, offset)
+		if n < 8 {
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+
+			return false, nil
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		if string(header[4:8]) == "moov" {
+			return true, nil
+		}
+
+		switch size {
+		case 0: // extends to the end
+			return false, nil
+		case 1:
+			if n < 16 {
+				return false, nil
+			}
+
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+
+		if size < 8 {
+			return false, nil
+		}
+
+		offset += size
+	}
+}
+
+// getFragmentedMP4Duration adds up the sample durations of each fragment (moof) of the first video track (or the
+// first track, if there's no video); a fragment only counts if its mdat is all there, so a file that was cut off
+// mid-fragment has the duration of what can actually be played
+func getFragmentedMP4Duration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	topLevelBoxes := readBoxes(data, 0)
+
+	moov, ok := findBox(topLevelBoxes, "moov")
+	if !ok {
+		return time.Duration(0), fmt.Errorf("%#+v has no moov", path)
+	}
+
+	moovBoxes := readBoxes(getPayload(data, moov), moov.offset)
+
+	var selectedTrack *track
+
+	for _, b := range moovBoxes {
+		if b.boxType != "trak" {
+			continue
+		}
+
+		t, err := readTrack(data, b)
+		if err != nil {
+			return time.Duration(0), fmt.Errorf("failed to read track from %#+v: %v", path, err)
+		}
+
+		if selectedTrack == nil || (selectedTrack.handlerType != "vide" && t.handlerType == "vide") {
+			selectedTrack = &t
+		}
+	}
+
+	if selectedTrack == nil {
+		return time.Duration(0), fmt.Errorf("%#+v has no tracks", path)
+	}
+
+	if selectedTrack.timescale == 0 {
+		return time.Duration(0), fmt.Errorf("%#+v has a track with no timescale", path)
+	}
+
+	defaultSampleDurationByTrackID := make(map[uint32]uint32)
+
+	mvex, ok := findBox(moovBoxes, "mvex")
+	if ok {
+		mvexBoxes := readBoxes(getPayload(data, mvex), mvex.offset)
+		for _, b := range mvexBoxes {
+			if b.boxType != "trex" {
+				continue
+			}
+
+			trackID, defaultSampleDuration, err := readTrex(getPayload(data, b))
+			if err != nil {
+				return time.Duration(0), fmt.Errorf("failed to read trex from %#+v: %v", path, err)
+			}
+
+			defaultSampleDurationByTrackID[trackID] = defaultSampleDuration
+		}
+	}
+
+	fragments := 0
+	total := uint64(0)
+	pending := uint64(0)
+	hasPending := false
+
+	for _, b := range topLevelBoxes {
+		switch b.boxType {
+		case "moof":
+			pending = 0
+			hasPending = true
+
+			moofBoxes := readBoxes(getPayload(data, b), b.offset)
+			for _, traf := range moofBoxes {
+				if traf.boxType != "traf" {
+					continue
+				}
+
+				f, err := readTraf(data, traf, defaultSampleDurationByTrackID)
+				if err != nil {
+					return time.Duration(0), fmt.Errorf("failed to read traf from %#+v: %v", path, err)
+				}
+
+				if f.trackID == selectedTrack.id {
+					pending += f.duration
+				}
+			}
+		case "mdat":
+			// readBoxes only returns boxes that are all there
+			if hasPending {
+				total += pending
+				fragments++
+				hasPending = false
+			}
+		}
+	}
+
+	if fragments == 0 {
+		return time.Duration(0), fmt.Errorf("%#+v has no complete fragments", path)
+	}
+
+	return toDuration(total, uint64(selectedTrack.timescale)), nil
+}
@@ -0,0 +1,168 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uint32s(values ...uint32) []byte {
+	b := make([]byte, 0)
+	for _, value := range values {
+		b = binary.BigEndian.AppendUint32(b, value)
+	}
+
+	return b
+}
+
+func mp4Box(boxType string, payloads ...[]byte) []byte {
+	payload := make([]byte, 0)
+	for _, p := range payloads {
+		payload = append(payload, p...)
+	}
+
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	b = append(b, []byte(boxType)...)
+
+	return append(b, payload...)
+}
+
+func getFragmentedMP4(truncate bool) []byte {
+	data := mp4Box("ftyp", []byte("isom"), uint32s(512), []byte("isomiso6mp41"))
+
+	data = append(data, mp4Box(
+		"moov",
+		mp4Box("mvhd", uint32s(0, 0, 0, 1000, 0), make([]byte, 80)),
+		mp4Box(
+			"trak",
+			mp4Box("tkhd", uint32s(0, 0, 0, 1, 0, 0), make([]byte, 60)),
+			mp4Box(
+				"mdia",
+				mp4Box("mdhd", uint32s(0, 0, 0, 90000, 0, 0)),
+				mp4Box("hdlr", uint32s(0, 0), []byte("vide"), uint32s(0, 0, 0), []byte("VideoHandler\x00")),
+			),
+		),
+		mp4Box(
+			"mvex",
+			mp4Box("trex", uint32s(0, 1, 1, 3000, 0, 0)),
+		),
+	)...)
+
+	// 30 samples at the trex default duration (1s)
+	data = append(data, mp4Box(
+		"moof",
+		mp4Box("mfhd", uint32s(0, 1)),
+		mp4Box(
+			"traf",
+			mp4Box("tfhd", uint32s(0x020000, 1)),
+			mp4Box("tfdt", uint32s(0, 0)),
+			mp4Box("trun", uint32s(0x000001, 30, 0)),
+		),
+	)...)
+	data = append(data, mp4Box("mdat", make([]byte, 1024))...)
+
+	// 30 samples with their own durations and sizes (2s)
+	samples := make([]uint32, 0)
+	for i := 0; i < 30; i++ {
+		samples = append(samples, 6000, 32)
+	}
+
+	data = append(data, mp4Box(
+		"moof",
+		mp4Box("mfhd", uint32s(0, 2)),
+		mp4Box(
+			"traf",
+			mp4Box("tfhd", uint32s(0x020000, 1)),
+			mp4Box("tfdt", uint32s(0, 90000)),
+			mp4Box("trun", uint32s(0x000301, 30, 0), uint32s(samples...)),
+		),
+	)...)
+	data = append(data, mp4Box("mdat", make([]byte, 1024))...)
+
+	// a fragment at the default duration given by the tfhd (0.5s)
+	data = append(data, mp4Box(
+		"moof",
+		mp4Box("mfhd", uint32s(0, 3)),
+		mp4Box(
+			"traf",
+			mp4Box("tfhd", uint32s(0x020008, 1, 1500)),
+			mp4Box("tfdt", uint32s(0, 270000)),
+			mp4Box("trun", uint32s(0x000001, 30, 0)),
+		),
+	)...)
+
+	mdat := mp4Box("mdat", make([]byte, 1024))
+	if truncate {
+		mdat = mdat[:512]
+	}
+
+	return append(data, mdat...)
+}
+
+func TestGetVideoDuration_FragmentedMP4(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.mp4")
+	err := os.WriteFile(path, getFragmentedMP4(false), 0644)
+	require.NoError(t, err)
+
+	duration, err := GetVideoDuration(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond*3500, duration)
+
+	// the last fragment is only counted if its mdat is all there
+	err = os.WriteFile(path, getFragmentedMP4(true), 0644)
+	require.NoError(t, err)
+
+	duration, err = GetVideoDuration(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second*3, duration)
+}
+
+func TestGetVideoDuration_TruncatedMP4(t *testing.T) {
+	dir := t.TempDir()
+
+	// a plain MP4 that was cut off before its moov was written
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.mp4")
+	data := append(mp4Box("ftyp", []byte("isom"), uint32s(512)), mp4Box("mdat", make([]byte, 1024))[:512]...)
+	err := os.WriteFile(path, data, 0644)
+	require.NoError(t, err)
+
+	_, err = GetVideoDuration(path)
+	assert.Error(t, err)
+}
+
+func TestHasMoov(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.mp4")
+	err := os.WriteFile(path, getFragmentedMP4(true), 0644)
+	require.NoError(t, err)
+
+	hasMoov, err := HasMoov(path)
+	require.NoError(t, err)
+	assert.True(t, hasMoov)
+
+	// a plain MP4 that was cut off before its moov was written
+	data := append(mp4Box("ftyp", []byte("isom"), uint32s(512)), mp4Box("mdat", make([]byte, 1024))[:512]...)
+	err = os.WriteFile(path, data, 0644)
+	require.NoError(t, err)
+
+	hasMoov, err = HasMoov(path)
+	require.NoError(t, err)
+	assert.False(t, hasMoov)
+
+	// and the same, but with the mdat size left unset
+	data = append(mp4Box("ftyp", []byte("isom"), uint32s(512)), append(uint32s(0), []byte("mdat")...)...)
+	err = os.WriteFile(path, append(data, make([]byte, 256)...), 0644)
+	require.NoError(t, err)
+
+	hasMoov, err = HasMoov(path)
+	require.NoError(t, err)
+	assert.False(t, hasMoov)
+}
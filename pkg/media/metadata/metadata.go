@@ -1,15 +1,56 @@
 package metadata
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/alfg/mp4"
 )
 
+// GetVideoDuration returns the duration of an MP4 (from its moov, or by adding up its fragments if it's fragmented)
+// or an MPEG-TS (from the span of its timestamps)
 func GetVideoDuration(path string) (time.Duration, error) {
-	video, err := mp4.Open(path)
+	if strings.ToLower(filepath.Ext(path)) == ".ts" {
+		return getMPEGTSDuration(path)
+	}
+
+	duration, err := getMP4Duration(path)
+	if err == nil {
+		return duration, nil
+	}
+
+	// fragmented MP4s are written with an empty moov (that says nothing about the duration)
+	fragmentedDuration, fragmentedErr := getFragmentedMP4Duration(path)
+	if fragmentedErr == nil {
+		return fragmentedDuration, nil
+	}
+
+	return time.Duration(0), fmt.Errorf("%v (and as a fragmented MP4: %v)", err, fragmentedErr)
+}
+
+func getMP4Duration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	// mp4.Open never closes the file
+	defer func() {
+		_ = file.Close()
+	}()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	video, err := mp4.OpenFromReader(file, fileInfo.Size())
 	if err != nil {
 		return time.Duration(0), err
 	}
@@ -18,7 +59,16 @@ func GetVideoDuration(path string) (time.Duration, error) {
 		return time.Duration(0), fmt.Errorf("can't get duration- video.Moov or video.Moov.Mvhd is nil")
 	}
 
-	return time.Millisecond * time.Duration(video.Moov.Mvhd.Duration), nil
+	if video.Moov.Mvhd.Duration == 0 {
+		return time.Duration(0), fmt.Errorf("can't get duration- video.Moov.Mvhd.Duration is 0")
+	}
+
+	timescale := video.Moov.Mvhd.Timescale
+	if timescale == 0 {
+		timescale = 1000
+	}
+
+	return toDuration(uint64(video.Moov.Mvhd.Duration), uint64(timescale)), nil
 }
 
 func GetFileSize(path string) (float64, error) {
@@ -33,3 +83,24 @@ func GetFileSize(path string) (float64, error) {
 
 	return float64(fileInfo.Size()) / 1000000, nil
 }
+
+// GetFileChecksum returns the hex SHA-256 of the file at path
+func GetFileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -1,6 +1,8 @@
 package metadata
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -28,3 +30,19 @@ func TestGetSize(t *testing.T) {
 		size,
 	)
 }
+
+func TestGetFileChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "some_file")
+
+	err := os.WriteFile(path, []byte("hello world"), 0644)
+	require.NoError(t, err)
+
+	checksum, err := GetFileChecksum(path)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		checksum,
+	)
+}
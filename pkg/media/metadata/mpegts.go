@@ -0,0 +1,175 @@
+package metadata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	ptsTimescale = 90000
+	ptsWrap      = uint64(1) << 33
+)
+
+// readPTS decodes the 33-bit timestamp spread over 5 bytes (with marker bits) in a PES header
+func readPTS(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 |
+		uint64(b[1])<<22 |
+		uint64(b[2]>>1)<<15 |
+		uint64(b[3])<<7 |
+		uint64(b[4]>>1)
+}
+
+// getPESPTS returns the stream id and PTS of the PES packet that starts in payload (if it has a PTS)
+func getPESPTS(payload []byte) (streamID byte, pts uint64, ok bool) {
+	if len(payload) < 14 || payload[0] != 0x00 || payload[1] != 0x00 || payload[2] != 0x01 {
+		return 0, 0, false
+	}
+
+	streamID = payload[3]
+
+	// only audio / video have the optional PES header
+	if streamID < 0xc0 || streamID > 0xef {
+		return 0, 0, false
+	}
+
+	if payload[7]&0x80 == 0 {
+		return 0, 0, false
+	}
+
+	return streamID, readPTS(payload[9:14]), true
+}
+
+type ptsSpan struct {
+	isVideo bool
+	first   uint64
+	min     uint64 // relative to first, allowing for wrap-around
+	max     uint64
+	count   int
+}
+
+func (s *ptsSpan) add(pts uint64) {
+	if s.count == 0 {
+		s.first = pts
+	}
+
+	// PTS wraps every ~26.5 hours and video PTS isn't monotonic (B-frames), so treat anything more than half the
+	// range behind the first as having wrapped
+	relative := (pts + ptsWrap - s.first) % ptsWrap
+	if relative > ptsWrap/2 {
+		relative = 0
+	}
+
+	if s.count == 0 || relative < s.min {
+		s.min = relative
+	}
+
+	if relative > s.max {
+		s.max = relative
+	}
+
+	s.count++
+}
+
+func (s *ptsSpan) duration() time.Duration {
+	if s.count < 2 {
+		return time.Duration(0)
+	}
+
+	span := s.max - s.min
+
+	// the last frame is on screen for a frame's worth of time as well
+	span += span / uint64(s.count-1)
+
+	return toDuration(span, ptsTimescale)
+}
+
+// getMPEGTSDuration returns the span of PTS of the first video stream (or the first stream, if there's no video);
+// a partial packet at the end (i.e. the file was cut off) is ignored
+func getMPEGTSDuration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReaderSize(file, tsPacketSize*1024)
+	packet := make([]byte, tsPacketSize)
+
+	spanByPID := make(map[uint16]*ptsSpan)
+	pids := make([]uint16, 0)
+
+	for {
+		_, err = io.ReadFull(reader, packet[:1])
+		if err != nil {
+			break
+		}
+
+		// lost sync; skip ahead until it's found again
+		if packet[0] != tsSyncByte {
+			continue
+		}
+
+		_, err = io.ReadFull(reader, packet[1:])
+		if err != nil {
+			break
+		}
+
+		payloadUnitStart := packet[1]&0x40 != 0
+		pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+		adaptationFieldControl := packet[3] >> 4 & 0x03
+
+		if !payloadUnitStart || adaptationFieldControl&0x01 == 0 {
+			continue
+		}
+
+		payloadOffset := 4
+		if adaptationFieldControl&0x02 != 0 {
+			payloadOffset += 1 + int(packet[4])
+		}
+
+		if payloadOffset >= tsPacketSize {
+			continue
+		}
+
+		streamID, pts, ok := getPESPTS(packet[payloadOffset:])
+		if !ok {
+			continue
+		}
+
+		span, ok := spanByPID[pid]
+		if !ok {
+			span = &ptsSpan{isVideo: streamID >= 0xe0}
+			spanByPID[pid] = span
+			pids = append(pids, pid)
+		}
+
+		span.add(pts)
+	}
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return time.Duration(0), err
+	}
+
+	var selectedSpan *ptsSpan
+	for _, pid := range pids {
+		span := spanByPID[pid]
+
+		if selectedSpan == nil || (!selectedSpan.isVideo && span.isVideo) {
+			selectedSpan = span
+		}
+	}
+
+	if selectedSpan == nil || selectedSpan.count < 2 {
+		return time.Duration(0), fmt.Errorf("%#+v has no timestamps to get a duration from", path)
+	}
+
+	return selectedSpan.duration(), nil
+}
@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePTS(pts uint64) []byte {
+	return []byte{
+		0x21 | byte(pts>>29&0x0e),
+		byte(pts >> 22),
+		byte(pts>>14) | 0x01,
+		byte(pts >> 7),
+		byte(pts<<1) | 0x01,
+	}
+}
+
+func tsPacket(pid uint16, streamID byte, pts uint64) []byte {
+	packet := make([]byte, tsPacketSize)
+	for i := range packet {
+		packet[i] = 0xff
+	}
+
+	packet[0] = tsSyncByte
+	packet[1] = 0x40 | byte(pid>>8) // payload unit start
+	packet[2] = byte(pid)
+	packet[3] = 0x10 // payload only
+
+	pes := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 0x05}
+	pes = append(pes, writePTS(pts)...)
+	copy(packet[4:], pes)
+
+	return packet
+}
+
+func getMPEGTS(firstPTS uint64) []byte {
+	data := make([]byte, 0)
+
+	for i := uint64(0); i < 60; i++ {
+		data = append(data, tsPacket(0x100, 0xe0, (firstPTS+i*3000)%ptsWrap)...) // 30 fps video for 2s
+
+		if i%2 == 0 {
+			data = append(data, tsPacket(0x101, 0xc0, (firstPTS+i*6000)%ptsWrap)...) // audio that runs for longer
+		}
+	}
+
+	// cut off part way through a packet
+	return append(data, tsPacket(0x100, 0xe0, firstPTS+60*3000)[:100]...)
+}
+
+func TestGetVideoDuration_MPEGTS(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.ts")
+	err := os.WriteFile(path, getMPEGTS(900000), 0644)
+	require.NoError(t, err)
+
+	duration, err := GetVideoDuration(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second*2, duration)
+
+	// PTS wraps around part way through
+	err = os.WriteFile(path, getMPEGTS(ptsWrap-30000), 0644)
+	require.NoError(t, err)
+
+	duration, err = GetVideoDuration(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second*2, duration)
+}
+
+func TestGetVideoDuration_MPEGTS_Empty(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.ts")
+	err := os.WriteFile(path, []byte{}, 0644)
+	require.NoError(t, err)
+
+	_, err = GetVideoDuration(path)
+	assert.Error(t, err)
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
 	"github.com/initialed85/cameranator/pkg/process"
 )
 
@@ -24,9 +26,63 @@ func init() {
 	}
 }
 
-func RecordSegments(netCamURL, destinationPath, cameraName string, duration int) (*process.BackgroundProcess, error) {
-	log.Printf("RecordSegments; recording %v second segments from %v to %v for %v", duration, netCamURL, destinationPath, cameraName)
+// Format is the container segments are written in; plain MP4 is unreadable if ffmpeg is killed before it writes the
+// moov at the end, whereas fragmented MP4 and MPEG-TS are readable up to the point they were cut off
+type Format string
 
+const (
+	FormatMP4           Format = "mp4"
+	FormatFragmentedMP4 Format = "fmp4"
+	FormatMPEGTS        Format = "mpegts"
+)
+
+// ParseFormat returns the Format for name; empty means FormatFragmentedMP4 (as a plain MP4 that was cut off can't be
+// repaired)
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(name))) {
+	case FormatMP4:
+		return FormatMP4, nil
+	case "", FormatFragmentedMP4:
+		return FormatFragmentedMP4, nil
+	case FormatMPEGTS:
+		return FormatMPEGTS, nil
+	}
+
+	return "", fmt.Errorf("unsupported segment format %#+v; must be %v, %v or %v", name, FormatMP4, FormatFragmentedMP4, FormatMPEGTS)
+}
+
+// Extension returns the file extension for segments in this Format
+func (f Format) Extension() string {
+	if f == FormatMPEGTS {
+		return ".ts"
+	}
+
+	return ".mp4"
+}
+
+func (f Format) getMuxerArguments() []string {
+	switch f {
+	case FormatFragmentedMP4:
+		return []string{
+			"-segment_format",
+			"mp4",
+			"-segment_format_options",
+			"movflags=+frag_keyframe+empty_moov+default_base_moof",
+		}
+	case FormatMPEGTS:
+		return []string{
+			"-segment_format",
+			"mpegts",
+		}
+	}
+
+	return []string{
+		"-segment_format",
+		"mp4",
+	}
+}
+
+func getInputArguments(netCamURL string) []string {
 	arguments := make([]string, 0)
 
 	if !enablePassthrough {
@@ -53,6 +109,13 @@ func RecordSegments(netCamURL, destinationPath, cameraName string, duration int)
 		"tcp",
 		"-i",
 		netCamURL,
+	)
+
+	return arguments
+}
+
+func getOutputArguments(destinationPath string, template *segment_template.Template, duration int, format Format) []string {
+	arguments := []string{
 		"-c",
 		"copy",
 		"-map",
@@ -61,8 +124,12 @@ func RecordSegments(netCamURL, destinationPath, cameraName string, duration int)
 		"segment",
 		"-segment_time",
 		fmt.Sprintf("%v", duration),
-		"-segment_format",
-		"mp4",
+	}
+
+	arguments = append(arguments, format.getMuxerArguments()...)
+
+	arguments = append(
+		arguments,
 		"-segment_atclocktime",
 		"1",
 		"-strftime",
@@ -97,10 +164,36 @@ func RecordSegments(netCamURL, destinationPath, cameraName string, duration int)
 
 	arguments = append(
 		arguments,
-		filepath.Join(destinationPath, "Segment_%Y-%m-%dT%H:%M:%S_"+cameraName+".mp4"),
+		filepath.Join(destinationPath, template.Strftime()),
+	)
+
+	return arguments
+}
+
+func getArguments(netCamURL string, destinationPath string, template *segment_template.Template, duration int, format Format) []string {
+	return append(
+		getInputArguments(netCamURL),
+		getOutputArguments(destinationPath, template, duration, format)...,
 	)
+}
+
+// RecordSegments runs (and supervises) ffmpeg to record the stream as a series of segments in the given format (the
+// template's extension should match it); options.Env is extended with the template's timezone
+func RecordSegments(
+	netCamURL, destinationPath string,
+	template *segment_template.Template,
+	duration int,
+	format Format,
+	options process.Options,
+) (*process.BackgroundProcess, error) {
+	log.Printf("RecordSegments; recording %v second %v segments from %v to %v for %v", duration, format, netCamURL, destinationPath, template.CameraName())
+
+	arguments := getArguments(netCamURL, destinationPath, template, duration, format)
+
+	options.Env = append(append([]string{}, options.Env...), template.Env()...)
 
-	return process.RunBackgroundProcess(
+	return process.RunBackgroundProcessWithOptions(
+		options,
 		"ffmpeg",
 		arguments...,
 	)
@@ -7,29 +7,34 @@ import (
 	"testing"
 	"time"
 
-	"github.com/relvacode/iso8601"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/process"
 )
 
 func TestRecordSegments(t *testing.T) {
 	dir, err := os.MkdirTemp("", "cameranator")
 	require.NoError(t, err)
 
-	process, err := RecordSegments("rtsp://host.docker.internal:8554/Streaming/Channels/101", dir, "Driveway", 5)
-	if process == nil {
+	template, err := segment_template.NewTemplate("", "Driveway", time.Local)
+	require.NoError(t, err)
+
+	backgroundProcess, err := RecordSegments("rtsp://host.docker.internal:8554/Streaming/Channels/101", dir, template, 5, FormatMP4, process.Options{})
+	if backgroundProcess == nil {
 		require.Fail(t, "process unexpectedly nil")
 	}
 
 	if err != nil {
-		process.Stop()
+		backgroundProcess.Stop()
 
 		require.NoError(t, err)
 	}
 
 	time.Sleep(time.Second * 10)
 
-	process.Stop()
+	backgroundProcess.Stop()
 
 	fileInfos, err := os.ReadDir(dir)
 	require.NoError(t, err)
@@ -39,12 +44,57 @@ func TestRecordSegments(t *testing.T) {
 	for _, fileInfo := range fileInfos {
 		assert.True(t, strings.HasPrefix(fileInfo.Name(), "Segment_"))
 		assert.True(t, strings.HasSuffix(fileInfo.Name(), "_Driveway.mp4"))
-		timestamp, err := iso8601.ParseString(
-			strings.Split(fileInfo.Name(), "_")[1],
-		)
+		timestamp, err := template.Parse(fileInfo.Name())
 		if err != nil {
 			require.NoError(t, err)
 		}
 		log.Printf("parsed: %#+v", timestamp.String())
 	}
 }
+
+func TestGetArguments(t *testing.T) {
+	template, err := segment_template.NewTemplate(segment_template.SubStreamPattern(""), "Driveway", time.Local)
+	require.NoError(t, err)
+
+	arguments := strings.Join(
+		getArguments(
+			"rtsp://camera/102",
+			"/srv/segments",
+			template,
+			60,
+			FormatFragmentedMP4,
+		),
+		" ",
+	)
+
+	assert.True(t, strings.Index(arguments, "-i rtsp://camera/102") < strings.Index(arguments, "-map 0 "))
+	assert.Contains(t, arguments, "-segment_time 60")
+	assert.Contains(t, arguments, "empty_moov")
+	assert.True(t, strings.HasSuffix(arguments, "/srv/segments/Segment_%Y-%m-%dT%H:%M:%S%z_Driveway__lowres.mp4"))
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, FormatFragmentedMP4, format)
+	assert.Equal(t, ".mp4", format.Extension())
+
+	format, err = ParseFormat("MP4")
+	require.NoError(t, err)
+	assert.Equal(t, FormatMP4, format)
+	assert.NotContains(t, strings.Join(format.getMuxerArguments(), " "), "empty_moov")
+
+	format, err = ParseFormat("FMP4")
+	require.NoError(t, err)
+	assert.Equal(t, FormatFragmentedMP4, format)
+	assert.Equal(t, ".mp4", format.Extension())
+	assert.Contains(t, strings.Join(format.getMuxerArguments(), " "), "empty_moov")
+
+	format, err = ParseFormat("mpegts")
+	require.NoError(t, err)
+	assert.Equal(t, FormatMPEGTS, format)
+	assert.Equal(t, ".ts", format.Extension())
+
+	_, err = ParseFormat("mkv")
+	assert.Error(t, err)
+}
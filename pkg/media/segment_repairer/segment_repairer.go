@@ -0,0 +1,98 @@
+package segment_repairer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/media/segment_validator"
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+// ErrNoMoov is returned by Repair for a plain MP4 that was cut off before its moov was written
+var ErrNoMoov = errors.New("no moov (it was cut off before it was finished); plain MP4 segments can't be repaired, so record fmp4 or mpegts segments")
+
+// Repair remuxes (without re-encoding) a segment whose duration can't be read, e.g. because ffmpeg was killed
+// mid-fragment; the segment is only replaced if the remuxed copy is readable. A plain MP4 that never had its moov
+// written can't be repaired (ffmpeg can't find its tracks), which is why segments are fmp4 by default; ErrNoMoov is
+// returned for those without trying.
+func Repair(path string) error {
+	extension := strings.ToLower(filepath.Ext(path))
+
+	format := "mp4"
+	if extension == ".ts" {
+		format = "mpegts"
+	} else {
+		hasMoov, err := metadata.HasMoov(path)
+		if err != nil {
+			return err
+		}
+
+		if !hasMoov {
+			return fmt.Errorf("%#+v has %w", path, ErrNoMoov)
+		}
+	}
+
+	// hidden (so that it doesn't look like a segment to anything watching the folder) but with the same extension (so
+	// that its duration can be checked)
+	repairedPath := filepath.Join(
+		filepath.Dir(path),
+		fmt.Sprintf(".%v.repairing%v", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), filepath.Ext(path)),
+	)
+
+	defer func() {
+		_ = os.Remove(repairedPath)
+	}()
+
+	stdout, stderr, err := process.RunCommand(
+		"ffmpeg",
+		"-y",
+		"-v",
+		"error",
+		"-err_detect",
+		"ignore_err",
+		"-i",
+		path,
+		"-c",
+		"copy",
+		"-map",
+		"0",
+		"-f",
+		format,
+		repairedPath,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remux %#+v: %v; stdout=%#+v, stderr=%#+v", path, err, stdout, stderr)
+	}
+
+	_, err = metadata.GetVideoDuration(repairedPath)
+	if err != nil {
+		return fmt.Errorf("remuxed %#+v but still couldn't get its duration: %v", path, err)
+	}
+
+	return os.Rename(repairedPath, path)
+}
+
+// ValidateOrRepair validates the segment at path (see segment_validator.Validate), remuxing it with Repair and trying
+// again if need be
+func ValidateOrRepair(path string) error {
+	_, err := segment_validator.Validate(path)
+	if err == nil {
+		return nil
+	}
+
+	repairErr := Repair(path)
+	if repairErr != nil {
+		return fmt.Errorf("%v (and couldn't be repaired: %v)", err, repairErr)
+	}
+
+	_, err = segment_validator.Validate(path)
+	if err != nil {
+		return fmt.Errorf("%v (even after being repaired)", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package segment_repairer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/process"
+	"github.com/initialed85/cameranator/pkg/test_utils"
+)
+
+func remux(t *testing.T, path string, arguments ...string) {
+	arguments = append([]string{"-y", "-i", test_utils.TestVideoPath, "-c", "copy", "-an"}, arguments...)
+
+	stdout, stderr, err := process.RunCommand("ffmpeg", append(arguments, path)...)
+	require.NoError(t, err, "stdout=%#+v, stderr=%#+v", stdout, stderr)
+}
+
+// truncate cuts the file at path off at fraction of its size, as if the recorder had been killed part way through
+func truncate(t *testing.T, path string, fraction float64) {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	err = os.Truncate(path, int64(float64(info.Size())*fraction))
+	require.NoError(t, err)
+}
+
+func TestRepair_TruncatedFragmentedMP4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Segment_2020-12-27T10:25:05+0800_Testing.mp4")
+
+	remux(t, path, "-movflags", "+frag_keyframe+empty_moov+default_base_moof")
+
+	duration, err := metadata.GetVideoDuration(path)
+	require.NoError(t, err)
+
+	truncate(t, path, 0.6)
+
+	err = Repair(path)
+	require.NoError(t, err)
+
+	repairedDuration, err := metadata.GetVideoDuration(path)
+	require.NoError(t, err)
+	assert.Greater(t, int64(repairedDuration), int64(0))
+	assert.Less(t, int64(repairedDuration), int64(duration))
+}
+
+func TestRepair_TruncatedMP4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Segment_2020-12-27T10:25:05+0800_Testing.mp4")
+
+	// the moov goes at the end, as it does when ffmpeg writes segments as plain MP4s
+	remux(t, path)
+
+	truncate(t, path, 0.6)
+
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	err = Repair(path)
+	assert.ErrorIs(t, err, ErrNoMoov)
+
+	// and it's left as it was
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestRepair_NoMoov(t *testing.T) {
+	// the start of a plain MP4 (an ftyp and most of an mdat) that was cut off before its moov was written
+	data := []byte{
+		0x00, 0x00, 0x00, 0x10, 'f', 't', 'y', 'p', 'i', 's', 'o', 'm', 0x00, 0x00, 0x02, 0x00,
+		0x00, 0x00, 0x04, 0x00, 'm', 'd', 'a', 't',
+	}
+	data = append(data, make([]byte, 512)...)
+
+	path := filepath.Join(t.TempDir(), "Segment_2020-12-27T10:25:05+0800_Testing.mp4")
+	err := os.WriteFile(path, data, 0644)
+	require.NoError(t, err)
+
+	err = Repair(path)
+	assert.ErrorIs(t, err, ErrNoMoov)
+}
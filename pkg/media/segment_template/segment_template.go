@@ -0,0 +1,244 @@
+package segment_template
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampToken  = "{timestamp}"
+	OffsetToken     = "{offset}"
+	CameraNameToken = "{camera_name}"
+
+	// DefaultPattern carries the UTC offset (e.g. Segment_2020-12-25T08:45:04+0800_Driveway.mp4) so that the repeated
+	// hour at the end of daylight saving is unambiguous
+	DefaultPattern = "Segment_" + TimestampToken + OffsetToken + "_" + CameraNameToken + ".mp4"
+
+	// LegacyPattern is the naming scheme from before the offset was added (e.g. Segment_2020-12-25T08:45:04_Driveway.mp4);
+	// templates with OffsetToken still match and parse names without it, so that existing files aren't orphaned
+	LegacyPattern = "Segment_" + TimestampToken + "_" + CameraNameToken + ".mp4"
+
+	// SubStreamSuffix goes before the extension for a camera's sub-stream segments, so that they sit alongside (and
+	// don't match the template for) its main stream segments
+	SubStreamSuffix = "__lowres"
+)
+
+const (
+	timestampStrftime   = "%Y-%m-%dT%H:%M:%S"
+	timestampLayout     = "2006-01-02T15:04:05"
+	timestampExpression = `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`
+	offsetStrftime      = "%z"
+	offsetLayout        = "-0700"
+	offsetExpression    = `[+-]\d{4}`
+)
+
+type Template struct {
+	pattern       string
+	legacyPattern string
+	cameraName    string
+	location      *time.Location
+	matcher       *regexp.Regexp
+	primary       *regexp.Regexp
+	legacy        *regexp.Regexp
+}
+
+func LoadLocation(name string) (*time.Location, error) {
+	// time.LoadLocation treats "" as UTC, but an unset timezone has always meant "whatever the host is set to"
+	if strings.TrimSpace(name) == "" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone %#+v: %v", name, err)
+	}
+
+	return location, nil
+}
+
+// WithExtension returns pattern with its extension replaced (e.g. for segments that aren't MP4)
+func WithExtension(pattern string, extension string) string {
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+
+	return strings.TrimSuffix(pattern, filepath.Ext(pattern)) + extension
+}
+
+// SubStreamPattern returns the pattern for the sub-stream segments that pair with those named by pattern
+func SubStreamPattern(pattern string) string {
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+
+	extension := filepath.Ext(pattern)
+
+	return strings.TrimSuffix(pattern, extension) + SubStreamSuffix + extension
+}
+
+func NewTemplate(
+	pattern string,
+	cameraName string,
+	location *time.Location,
+) (*Template, error) {
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+
+	if strings.Count(pattern, TimestampToken) != 1 {
+		return nil, fmt.Errorf("pattern %#+v must contain %v exactly once", pattern, TimestampToken)
+	}
+
+	if strings.Count(pattern, OffsetToken) > 1 {
+		return nil, fmt.Errorf("pattern %#+v may contain %v at most once", pattern, OffsetToken)
+	}
+
+	if strings.ContainsRune(pattern, filepath.Separator) {
+		return nil, fmt.Errorf("pattern %#+v must be a file name, not a path", pattern)
+	}
+
+	if cameraName == "" {
+		return nil, fmt.Errorf("cameraName may not be empty")
+	}
+
+	if location == nil {
+		location = time.Local
+	}
+
+	t := Template{
+		pattern:    pattern,
+		cameraName: cameraName,
+		location:   location,
+	}
+
+	expression := getExpression(pattern, cameraName)
+
+	var err error
+
+	t.primary, err = regexp.Compile("(^|/)" + expression + "$")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile matcher for pattern %#+v: %v", pattern, err)
+	}
+
+	t.matcher = t.primary
+
+	if strings.Contains(pattern, OffsetToken) {
+		t.legacyPattern = strings.Replace(pattern, OffsetToken, "", 1)
+		legacyExpression := getExpression(t.legacyPattern, cameraName)
+
+		t.legacy, err = regexp.Compile("(^|/)" + legacyExpression + "$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile matcher for pattern %#+v: %v", t.legacyPattern, err)
+		}
+
+		t.matcher, err = regexp.Compile("(^|/)(?:" + expression + "|" + legacyExpression + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile matcher for pattern %#+v: %v", pattern, err)
+		}
+	}
+
+	return &t, nil
+}
+
+func getExpression(pattern string, cameraName string) string {
+	expression := regexp.QuoteMeta(strings.ReplaceAll(pattern, CameraNameToken, cameraName))
+	expression = strings.Replace(expression, regexp.QuoteMeta(TimestampToken), "("+timestampExpression+")", 1)
+	expression = strings.Replace(expression, regexp.QuoteMeta(OffsetToken), "("+offsetExpression+")", 1)
+
+	return expression
+}
+
+func (t *Template) CameraName() string {
+	return t.cameraName
+}
+
+func (t *Template) Location() *time.Location {
+	return t.location
+}
+
+// Matcher returns an expression that matches the names this template makes (and, if it has OffsetToken, the names
+// without it that were made before)
+func (t *Template) Matcher() *regexp.Regexp {
+	return t.matcher
+}
+
+// Strftime returns the file name in the form ffmpeg's segment muxer expects when invoked with -strftime 1
+func (t *Template) Strftime() string {
+	name := strings.ReplaceAll(t.pattern, CameraNameToken, t.cameraName)
+	name = strings.Replace(name, TimestampToken, timestampStrftime, 1)
+	name = strings.Replace(name, OffsetToken, offsetStrftime, 1)
+
+	return name
+}
+
+// Env returns the environment ffmpeg needs so that strftime renders in this template's timezone
+func (t *Template) Env() []string {
+	if t.location == time.Local {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("TZ=%v", t.location.String())}
+}
+
+func (t *Template) Format(timestamp time.Time) string {
+	timestamp = timestamp.In(t.location)
+
+	name := strings.ReplaceAll(t.pattern, CameraNameToken, t.cameraName)
+	name = strings.Replace(name, TimestampToken, timestamp.Format(timestampLayout), 1)
+	name = strings.Replace(name, OffsetToken, timestamp.Format(offsetLayout), 1)
+
+	return name
+}
+
+func (t *Template) Match(path string) bool {
+	return t.matcher.MatchString(path)
+}
+
+func (t *Template) Parse(path string) (time.Time, error) {
+	pattern := t.pattern
+
+	submatches := t.primary.FindStringSubmatch(path)
+	if submatches == nil && t.legacy != nil {
+		pattern = t.legacyPattern
+		submatches = t.legacy.FindStringSubmatch(path)
+	}
+
+	if submatches == nil {
+		return time.Time{}, fmt.Errorf("%#+v does not match pattern %#+v for %#+v", path, t.pattern, t.cameraName)
+	}
+
+	rawTimestamp := ""
+	rawOffset := ""
+
+	// the first group is the path separator anchor; the rest are in the order the tokens appear in the pattern
+	groups := submatches[2:]
+	timestampIndex := strings.Index(pattern, TimestampToken)
+	offsetIndex := strings.Index(pattern, OffsetToken)
+
+	if offsetIndex == -1 {
+		rawTimestamp = groups[0]
+	} else if timestampIndex < offsetIndex {
+		rawTimestamp, rawOffset = groups[0], groups[1]
+	} else {
+		rawOffset, rawTimestamp = groups[0], groups[1]
+	}
+
+	if rawOffset != "" {
+		timestamp, err := time.Parse(timestampLayout+offsetLayout, rawTimestamp+rawOffset)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse %#+v from %#+v: %v", rawTimestamp+rawOffset, path, err)
+		}
+
+		return timestamp.In(t.location), nil
+	}
+
+	timestamp, err := time.ParseInLocation(timestampLayout, rawTimestamp, t.location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse %#+v from %#+v: %v", rawTimestamp, path, err)
+	}
+
+	return timestamp, nil
+}
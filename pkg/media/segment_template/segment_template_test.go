@@ -0,0 +1,157 @@
+package segment_template
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate_Default(t *testing.T) {
+	location, err := LoadLocation("Australia/Perth")
+	require.NoError(t, err)
+
+	template, err := NewTemplate("", "Driveway", location)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Segment_%Y-%m-%dT%H:%M:%S%z_Driveway.mp4", template.Strftime())
+	assert.Equal(t, []string{"TZ=Australia/Perth"}, template.Env())
+
+	assert.True(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_Driveway.mp4"))
+	assert.False(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_FrontDoor.mp4"))
+	assert.False(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_Driveway.jpg"))
+	assert.False(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_Driveway.mp4.tmp"))
+
+	timestamp, err := template.Parse("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_Driveway.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, "2020-12-25T08:45:04+08:00", timestamp.Format(time.RFC3339))
+
+	assert.Equal(t, "Segment_2020-12-25T08:45:04+0800_Driveway.mp4", template.Format(timestamp.UTC()))
+}
+
+func TestTemplate_Legacy(t *testing.T) {
+	location, err := LoadLocation("Australia/Perth")
+	require.NoError(t, err)
+
+	template, err := NewTemplate("", "Driveway", location)
+	require.NoError(t, err)
+
+	// names from before the offset was in the default pattern
+	assert.True(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4"))
+	assert.True(t, template.Matcher().MatchString("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4"))
+	assert.False(t, template.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_FrontDoor.mp4"))
+
+	timestamp, err := template.Parse("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, "2020-12-25T08:45:04+08:00", timestamp.Format(time.RFC3339))
+
+	legacyTemplate, err := NewTemplate(LegacyPattern, "Driveway", location)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Segment_%Y-%m-%dT%H:%M:%S_Driveway.mp4", legacyTemplate.Strftime())
+	assert.False(t, legacyTemplate.Match("/srv/target_dir/segments/Segment_2020-12-25T08:45:04+0800_Driveway.mp4"))
+}
+
+func TestTemplate_DaylightSaving(t *testing.T) {
+	location, err := LoadLocation("Australia/Sydney")
+	require.NoError(t, err)
+
+	template, err := NewTemplate("", "Driveway", location)
+	require.NoError(t, err)
+
+	before, err := template.Parse("Segment_2023-09-30T12:00:00_Driveway.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, "2023-09-30T12:00:00+10:00", before.Format(time.RFC3339))
+
+	after, err := template.Parse("Segment_2023-10-01T12:00:00_Driveway.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, "2023-10-01T12:00:00+11:00", after.Format(time.RFC3339))
+}
+
+func TestTemplate_FallBackHour(t *testing.T) {
+	location, err := LoadLocation("Australia/Sydney")
+	require.NoError(t, err)
+
+	template, err := NewTemplate("", "Driveway", location)
+	require.NoError(t, err)
+
+	// 02:30 happens twice as daylight saving ends; once at +1100 and then again an hour later at +1000
+	first := time.Date(2023, 4, 1, 15, 30, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	firstName := template.Format(first)
+	secondName := template.Format(second)
+
+	assert.Equal(t, "Segment_2023-04-02T02:30:00+1100_Driveway.mp4", firstName)
+	assert.Equal(t, "Segment_2023-04-02T02:30:00+1000_Driveway.mp4", secondName)
+
+	parsedFirst, err := template.Parse(firstName)
+	require.NoError(t, err)
+	assert.True(t, first.Equal(parsedFirst))
+
+	parsedSecond, err := template.Parse(secondName)
+	require.NoError(t, err)
+	assert.True(t, second.Equal(parsedSecond))
+}
+
+func TestTemplate_Offset(t *testing.T) {
+	location, err := LoadLocation("Australia/Sydney")
+	require.NoError(t, err)
+
+	template, err := NewTemplate("Segment_{timestamp}{offset}_{camera_name}.ts", "Side.Gate", location)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Segment_%Y-%m-%dT%H:%M:%S%z_Side.Gate.ts", template.Strftime())
+	assert.False(t, template.Match("Segment_2023-04-02T02:30:00+1100_SideXGate.ts"))
+
+	// the repeated hour as daylight saving ends
+	first, err := template.Parse("Segment_2023-04-02T02:30:00+1100_Side.Gate.ts")
+	require.NoError(t, err)
+
+	second, err := template.Parse("Segment_2023-04-02T02:30:00+1000_Side.Gate.ts")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Hour, second.Sub(first))
+	assert.Equal(t, "Segment_2023-04-02T02:30:00+1000_Side.Gate.ts", template.Format(second))
+}
+
+func TestNewTemplate_Invalid(t *testing.T) {
+	_, err := NewTemplate("Segment_{camera_name}.mp4", "Driveway", time.UTC)
+	assert.Error(t, err)
+
+	_, err = NewTemplate("segments/Segment_{timestamp}_{camera_name}.mp4", "Driveway", time.UTC)
+	assert.Error(t, err)
+
+	_, err = NewTemplate("", "", time.UTC)
+	assert.Error(t, err)
+
+	_, err = LoadLocation("Not/A_Timezone")
+	assert.Error(t, err)
+}
+
+func TestSubStreamPattern(t *testing.T) {
+	assert.Equal(t, "Segment_{timestamp}{offset}_{camera_name}__lowres.mp4", SubStreamPattern(""))
+	assert.Equal(t, "Segment_{timestamp}_{camera_name}__lowres.mp4", SubStreamPattern(LegacyPattern))
+
+	template, err := NewTemplate("", "Driveway", time.UTC)
+	require.NoError(t, err)
+
+	subStreamTemplate, err := NewTemplate(SubStreamPattern(""), "Driveway", time.UTC)
+	require.NoError(t, err)
+
+	assert.True(t, template.Match("/srv/Segment_2020-12-25T08:45:04+0000_Driveway.mp4"))
+	assert.False(t, template.Match("/srv/Segment_2020-12-25T08:45:04+0000_Driveway__lowres.mp4"))
+	assert.True(t, subStreamTemplate.Match("/srv/Segment_2020-12-25T08:45:04+0000_Driveway__lowres.mp4"))
+	assert.False(t, subStreamTemplate.Match("/srv/Segment_2020-12-25T08:45:04+0000_Driveway.mp4"))
+
+	// and the same for names from before the offset was in the default pattern
+	assert.False(t, template.Match("/srv/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"))
+	assert.True(t, subStreamTemplate.Match("/srv/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"))
+	assert.False(t, subStreamTemplate.Match("/srv/Segment_2020-12-25T08:45:04_Driveway.mp4"))
+}
+
+func TestWithExtension(t *testing.T) {
+	assert.Equal(t, "Segment_{timestamp}{offset}_{camera_name}.ts", WithExtension("", ".ts"))
+	assert.Equal(t, "Segment_{timestamp}{offset}_{camera_name}__lowres.ts", SubStreamPattern(WithExtension("", ".ts")))
+}
@@ -0,0 +1,198 @@
+package segment_validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+type Stream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+}
+
+type packet struct {
+	PTSTime string `json:"pts_time"`
+	Flags   string `json:"flags"`
+}
+
+// gop is a span of the video to decode; a duration of 0 means through to the end
+type gop struct {
+	start    float64
+	duration float64
+}
+
+func parseStreams(output string) ([]Stream, error) {
+	probe := struct {
+		Streams []Stream `json:"streams"`
+	}{}
+
+	err := json.Unmarshal([]byte(output), &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	return probe.Streams, nil
+}
+
+// parseKeyframes returns the (sorted) timestamps of the keyframe packets; packets without a timestamp are ignored
+func parseKeyframes(output string) ([]float64, error) {
+	probe := struct {
+		Packets []packet `json:"packets"`
+	}{}
+
+	err := json.Unmarshal([]byte(output), &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	keyframes := make([]float64, 0)
+
+	for _, p := range probe.Packets {
+		if !strings.Contains(p.Flags, "K") {
+			continue
+		}
+
+		timestamp, err := strconv.ParseFloat(p.PTSTime, 64)
+		if err != nil {
+			continue
+		}
+
+		keyframes = append(keyframes, timestamp)
+	}
+
+	sort.Float64s(keyframes)
+
+	return keyframes, nil
+}
+
+// getGOPs returns the first GOP (first keyframe up to the next) and the last GOP (last keyframe to the end); if
+// there's only one keyframe, that's the whole video (and there's only the one GOP to decode)
+func getGOPs(keyframes []float64) ([]gop, error) {
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("no keyframes")
+	}
+
+	if len(keyframes) == 1 {
+		return []gop{{start: keyframes[0]}}, nil
+	}
+
+	return []gop{
+		{start: keyframes[0], duration: keyframes[1] - keyframes[0]},
+		{start: keyframes[len(keyframes)-1]},
+	}, nil
+}
+
+func probeStreams(path string) ([]Stream, error) {
+	stdout, stderr, err := process.RunCommand(
+		"ffprobe",
+		"-v",
+		"error",
+		"-show_entries",
+		"stream=index,codec_type,codec_name",
+		"-of",
+		"json",
+		path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe streams: %v; stderr=%#+v", err, stderr)
+	}
+
+	return parseStreams(stdout)
+}
+
+func probeKeyframes(path string) ([]float64, error) {
+	stdout, stderr, err := process.RunCommand(
+		"ffprobe",
+		"-v",
+		"error",
+		"-select_streams",
+		"v:0",
+		"-show_entries",
+		"packet=pts_time,flags",
+		"-of",
+		"json",
+		path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe packets: %v; stderr=%#+v", err, stderr)
+	}
+
+	return parseKeyframes(stdout)
+}
+
+func decode(path string, g gop) error {
+	arguments := []string{
+		"-v",
+		"error",
+		"-xerror",
+		"-ss",
+		fmt.Sprintf("%.6f", g.start),
+		"-i",
+		path,
+	}
+
+	if g.duration > 0 {
+		arguments = append(arguments, "-t", fmt.Sprintf("%.6f", g.duration))
+	}
+
+	arguments = append(arguments, "-map", "0:v:0", "-f", "null", "-")
+
+	_, stderr, err := process.RunCommand("ffmpeg", arguments...)
+	if err != nil {
+		return fmt.Errorf("failed to decode from %.3fs: %v; stderr=%#+v", g.start, err, stderr)
+	}
+
+	// not every decode error is fatal to ffmpeg, but they all get logged
+	if strings.TrimSpace(stderr) != "" {
+		return fmt.Errorf("errors decoding from %.3fs; stderr=%#+v", g.start, stderr)
+	}
+
+	return nil
+}
+
+// Validate checks that a segment is fit to ingest: it has to have a video stream and its first and last GOPs have to
+// decode cleanly; the GOPs in between aren't decoded (that'd cost as much as recording them did) but a segment that
+// was cut off or mangled in transit is almost always broken at one end or the other
+func Validate(path string) ([]Stream, error) {
+	streams, err := probeStreams(path)
+	if err != nil {
+		return nil, fmt.Errorf("%#+v is invalid: %v", path, err)
+	}
+
+	hasVideo := false
+	for _, stream := range streams {
+		if stream.CodecType == "video" && stream.CodecName != "" {
+			hasVideo = true
+			break
+		}
+	}
+
+	if !hasVideo {
+		return nil, fmt.Errorf("%#+v is invalid: no video stream", path)
+	}
+
+	keyframes, err := probeKeyframes(path)
+	if err != nil {
+		return nil, fmt.Errorf("%#+v is invalid: %v", path, err)
+	}
+
+	gops, err := getGOPs(keyframes)
+	if err != nil {
+		return nil, fmt.Errorf("%#+v is invalid: %v", path, err)
+	}
+
+	for _, g := range gops {
+		err = decode(path, g)
+		if err != nil {
+			return nil, fmt.Errorf("%#+v is invalid: %v", path, err)
+		}
+	}
+
+	return streams, nil
+}
@@ -0,0 +1,56 @@
+package segment_validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStreams(t *testing.T) {
+	streams, err := parseStreams(`{
+    "programs": [],
+    "streams": [
+        {"index": 0, "codec_name": "h264", "codec_type": "video"},
+        {"index": 1, "codec_name": "aac", "codec_type": "audio"}
+    ]
+}`)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]Stream{
+			{Index: 0, CodecType: "video", CodecName: "h264"},
+			{Index: 1, CodecType: "audio", CodecName: "aac"},
+		},
+		streams,
+	)
+}
+
+func TestParseKeyframes(t *testing.T) {
+	keyframes, err := parseKeyframes(`{
+    "packets": [
+        {"pts_time": "0.000000", "flags": "K__"},
+        {"pts_time": "0.066667", "flags": "___"},
+        {"pts_time": "N/A", "flags": "K__"},
+        {"pts_time": "2.000000", "flags": "K__"},
+        {"pts_time": "2.066667", "flags": "__D"}
+    ]
+}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []float64{0, 2}, keyframes)
+}
+
+func TestGetGOPs(t *testing.T) {
+	_, err := getGOPs([]float64{})
+	assert.Error(t, err)
+
+	gops, err := getGOPs([]float64{1.5})
+	require.NoError(t, err)
+	assert.Equal(t, []gop{{start: 1.5}}, gops)
+
+	gops, err = getGOPs([]float64{0, 2, 4, 6})
+	require.NoError(t, err)
+	assert.Equal(t, []gop{{start: 0, duration: 2}, {start: 6}}, gops)
+}
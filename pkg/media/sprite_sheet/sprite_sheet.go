@@ -0,0 +1,248 @@
+package sprite_sheet
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+const (
+	// Suffix goes before the extension of a sprite sheet's image and WebVTT file (which are named after the video)
+	Suffix = "__sprite"
+
+	tempPrefix = ".making."
+)
+
+// GetPaths returns where the sprite sheet image and WebVTT file for a video go (next to it, named after it)
+func GetPaths(videoPath string) (imagePath string, vttPath string) {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + Suffix
+
+	return base + ".jpg", base + ".vtt"
+}
+
+// Layout is how the tiles (one every Interval of the video, left to right and then top to bottom) are laid out
+type Layout struct {
+	Interval   time.Duration
+	Duration   time.Duration // of the video that the tiles cover
+	TileWidth  int
+	TileHeight int
+	Columns    int
+	Rows       int
+	Tiles      int
+}
+
+// GetLayout returns the Layout for a video of the given duration; a video shorter than interval (or of unknown
+// duration) gets a single tile
+func GetLayout(duration time.Duration, interval time.Duration, tileWidth int, tileHeight int, maxColumns int) Layout {
+	tiles := 1
+	if duration > interval {
+		tiles = int((duration + interval - 1) / interval)
+	}
+
+	columns := tiles
+	if columns > maxColumns {
+		columns = maxColumns
+	}
+
+	return Layout{
+		Interval:   interval,
+		Duration:   duration,
+		TileWidth:  tileWidth,
+		TileHeight: tileHeight,
+		Columns:    columns,
+		Rows:       (tiles + columns - 1) / columns,
+		Tiles:      tiles,
+	}
+}
+
+func formatTimestamp(d time.Duration) string {
+	milliseconds := d.Milliseconds()
+
+	return fmt.Sprintf(
+		"%02d:%02d:%02d.%03d",
+		milliseconds/3600000,
+		(milliseconds/60000)%60,
+		(milliseconds/1000)%60,
+		milliseconds%1000,
+	)
+}
+
+// GetVTT returns a WebVTT file with a cue for each tile that points at it in imageName (relative to the WebVTT file,
+// so that the two can be moved around together); the last cue runs to the end of the video
+func GetVTT(layout Layout, imageName string) string {
+	vtt := "WEBVTT\n"
+
+	for i := 0; i < layout.Tiles; i++ {
+		start := time.Duration(i) * layout.Interval
+
+		end := start + layout.Interval
+		if i == layout.Tiles-1 || end > layout.Duration {
+			end = layout.Duration
+		}
+
+		if end <= start {
+			end = start + layout.Interval
+		}
+
+		vtt += fmt.Sprintf(
+			"\n%v --> %v\n%v#xywh=%v,%v,%v,%v\n",
+			formatTimestamp(start),
+			formatTimestamp(end),
+			imageName,
+			(i%layout.Columns)*layout.TileWidth,
+			(i/layout.Columns)*layout.TileHeight,
+			layout.TileWidth,
+			layout.TileHeight,
+		)
+	}
+
+	return vtt
+}
+
+func getTempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), tempPrefix+filepath.Base(path))
+}
+
+// writeImage has ffmpeg tile the frames of the video (or just take the first one, for a single tile) into an image
+func writeImage(sourcePath string, imagePath string, layout Layout) error {
+	filter := fmt.Sprintf(
+		"fps=1000/%v,scale=%v:%v,tile=%vx%v",
+		layout.Interval.Milliseconds(),
+		layout.TileWidth,
+		layout.TileHeight,
+		layout.Columns,
+		layout.Rows,
+	)
+
+	if layout.Tiles == 1 {
+		filter = fmt.Sprintf("scale=%v:%v", layout.TileWidth, layout.TileHeight)
+	}
+
+	stdout, stderr, err := process.RunCommand(
+		"ffmpeg",
+		"-y",
+		"-i",
+		sourcePath,
+		"-an",
+		"-vf",
+		filter,
+		"-frames:v",
+		"1",
+		"-q:v",
+		"5",
+		imagePath,
+	)
+	if err != nil {
+		return fmt.Errorf("%v; stdout=%#+v, stderr=%#+v", err, stdout, stderr)
+	}
+
+	return nil
+}
+
+// SpriteSheet is a sprite sheet that's been made
+type SpriteSheet struct {
+	Layout
+	ImagePath string
+	VTTPath   string
+}
+
+type Maker struct {
+	interval   time.Duration
+	tileWidth  int
+	tileHeight int
+	maxColumns int
+}
+
+// NewMaker returns a Maker of sprite sheets with a tileWidth x tileHeight tile every interval, in rows of up to
+// maxColumns
+func NewMaker(interval time.Duration, tileWidth int, tileHeight int, maxColumns int) (*Maker, error) {
+	if interval < time.Millisecond {
+		return nil, fmt.Errorf("interval must be at least 1ms; got %v", interval)
+	}
+
+	if tileWidth <= 0 || tileHeight <= 0 {
+		return nil, fmt.Errorf("tile size must be > 0; got %vx%v", tileWidth, tileHeight)
+	}
+
+	if maxColumns <= 0 {
+		return nil, fmt.Errorf("maxColumns must be > 0; got %v", maxColumns)
+	}
+
+	return &Maker{
+		interval:   interval,
+		tileWidth:  tileWidth,
+		tileHeight: tileHeight,
+		maxColumns: maxColumns,
+	}, nil
+}
+
+// Make makes the sprite sheet for the video at sourcePath (e.g. a sub-stream video, which is much cheaper to decode)
+// with its files named after videoPath (see GetPaths); the tiles cover the video's actual duration, so a segment that's
+// shorter than expectedDuration (which is only used if the video's duration can't be read) just gets fewer of them, and
+// if the frames can't be tiled (e.g. for a segment with fewer frames than it should have) it falls back to one tile of
+// the first frame
+func (m *Maker) Make(sourcePath string, videoPath string, expectedDuration time.Duration) (SpriteSheet, error) {
+	duration, err := metadata.GetVideoDuration(sourcePath)
+	if err != nil || duration <= 0 {
+		log.Printf("warning: couldn't get duration of %#+v (%v); assuming %v", sourcePath, err, expectedDuration)
+		duration = expectedDuration
+	}
+
+	if duration <= 0 {
+		duration = m.interval
+	}
+
+	layout := GetLayout(duration, m.interval, m.tileWidth, m.tileHeight, m.maxColumns)
+
+	imagePath, vttPath := GetPaths(videoPath)
+
+	tempImagePath := getTempPath(imagePath)
+	defer func() {
+		_ = os.Remove(tempImagePath)
+	}()
+
+	err = writeImage(sourcePath, tempImagePath, layout)
+	if err != nil && layout.Tiles > 1 {
+		log.Printf("warning: falling back to a single tile for %#+v because %v", sourcePath, err)
+
+		layout = GetLayout(duration, duration, m.tileWidth, m.tileHeight, 1)
+		err = writeImage(sourcePath, tempImagePath, layout)
+	}
+
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+
+	tempVTTPath := getTempPath(vttPath)
+	defer func() {
+		_ = os.Remove(tempVTTPath)
+	}()
+
+	err = os.WriteFile(tempVTTPath, []byte(GetVTT(layout, filepath.Base(imagePath))), 0644)
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+
+	err = os.Rename(tempImagePath, imagePath)
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+
+	err = os.Rename(tempVTTPath, vttPath)
+	if err != nil {
+		_ = os.Remove(imagePath)
+		return SpriteSheet{}, err
+	}
+
+	return SpriteSheet{
+		Layout:    layout,
+		ImagePath: imagePath,
+		VTTPath:   vttPath,
+	}, nil
+}
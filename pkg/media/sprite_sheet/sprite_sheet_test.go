@@ -0,0 +1,92 @@
+package sprite_sheet
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/test_utils"
+)
+
+func TestGetPaths(t *testing.T) {
+	imagePath, vttPath := GetPaths("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4")
+	assert.Equal(t, "/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.jpg", imagePath)
+	assert.Equal(t, "/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt", vttPath)
+}
+
+func TestGetLayout(t *testing.T) {
+	layout := GetLayout(time.Second*60, time.Second*2, 160, 90, 10)
+	assert.Equal(t, 30, layout.Tiles)
+	assert.Equal(t, 10, layout.Columns)
+	assert.Equal(t, 3, layout.Rows)
+
+	// a partial interval at the end still gets a tile
+	layout = GetLayout(time.Millisecond*5500, time.Second*2, 160, 90, 10)
+	assert.Equal(t, 3, layout.Tiles)
+	assert.Equal(t, 3, layout.Columns)
+	assert.Equal(t, 1, layout.Rows)
+
+	layout = GetLayout(time.Millisecond*1500, time.Second*2, 160, 90, 10)
+	assert.Equal(t, 1, layout.Tiles)
+	assert.Equal(t, 1, layout.Columns)
+	assert.Equal(t, 1, layout.Rows)
+}
+
+func TestGetVTT(t *testing.T) {
+	layout := GetLayout(time.Millisecond*5500, time.Second*2, 160, 90, 2)
+
+	assert.Equal(
+		t,
+		`WEBVTT
+
+00:00:00.000 --> 00:00:02.000
+Segment_2020-12-25T08:45:04_Driveway__sprite.jpg#xywh=0,0,160,90
+
+00:00:02.000 --> 00:00:04.000
+Segment_2020-12-25T08:45:04_Driveway__sprite.jpg#xywh=160,0,160,90
+
+00:00:04.000 --> 00:00:05.500
+Segment_2020-12-25T08:45:04_Driveway__sprite.jpg#xywh=0,90,160,90
+`,
+		GetVTT(layout, "Segment_2020-12-25T08:45:04_Driveway__sprite.jpg"),
+	)
+
+	assert.Contains(t, GetVTT(GetLayout(time.Hour+time.Minute*2+time.Millisecond*3004, time.Hour*2, 1, 1, 1), "a.jpg"), "00:00:00.000 --> 01:02:03.004\n")
+}
+
+func TestMaker_Make(t *testing.T) {
+	dir, err := test_utils.GetTempDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	videoPath := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.mp4")
+
+	maker, err := NewMaker(time.Second*2, 160, 90, 10)
+	require.NoError(t, err)
+
+	spriteSheet, err := maker.Make(test_utils.TestVideoPath, videoPath, time.Minute*5)
+	require.NoError(t, err)
+
+	assert.Greater(t, spriteSheet.Tiles, 1)
+
+	_, err = os.Stat(spriteSheet.ImagePath)
+	require.NoError(t, err)
+
+	vtt, err := os.ReadFile(spriteSheet.VTTPath)
+	require.NoError(t, err)
+	assert.Equal(t, GetVTT(spriteSheet.Layout, "Segment_2020-12-25T08:45:04_Driveway__sprite.jpg"), string(vtt))
+
+	// much longer than the video, so there's just the one tile
+	maker, err = NewMaker(time.Hour, 160, 90, 10)
+	require.NoError(t, err)
+
+	spriteSheet, err = maker.Make(test_utils.TestVideoPath, videoPath, time.Minute*5)
+	require.NoError(t, err)
+	assert.Equal(t, 1, spriteSheet.Tiles)
+}
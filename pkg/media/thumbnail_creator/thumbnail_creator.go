@@ -1,11 +1,19 @@
 package thumbnail_creator
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/initialed85/cameranator/pkg/process"
 )
 
+// BestSuffix goes before the extension of a thumbnail picked from the best frame of a video (which is named after it)
+const BestSuffix = "__best"
+
 func GetThumbnail(videoPath, imagePath string) error {
 	stdout, stderr, err := process.RunCommand(
 		"ffmpeg",
@@ -23,3 +31,101 @@ func GetThumbnail(videoPath, imagePath string) error {
 
 	return nil
 }
+
+// GetBestPaths returns where the thumbnail picked from the best frame of a video and the low-res copy of it go (next to
+// the video, named after it and with the low-res one named the same way as the segment processor's)
+func GetBestPaths(videoPath string) (imagePath string, lowResImagePath string) {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + BestSuffix
+
+	return base + ".jpg", base + "__lowres.jpg"
+}
+
+// Box is a rectangle (in pixels) to draw on a thumbnail
+type Box struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+func getBoxFilter(box Box) string {
+	return fmt.Sprintf("drawbox=x=%v:y=%v:w=%v:h=%v:color=red@0.8:t=4", box.X, box.Y, box.Width, box.Height)
+}
+
+// GetThumbnailAt writes the frame at offset into the video to imagePath, with box drawn on it if it's set
+func GetThumbnailAt(videoPath string, imagePath string, offset time.Duration, box *Box) error {
+	if offset < 0 {
+		offset = 0
+	}
+
+	arguments := []string{
+		"-y",
+		"-ss",
+		fmt.Sprintf("%.3f", offset.Seconds()),
+		"-i",
+		videoPath,
+		"-an",
+		"-frames:v",
+		"1",
+	}
+
+	if box != nil {
+		arguments = append(arguments, "-vf", getBoxFilter(*box))
+	}
+
+	arguments = append(arguments, "-q:v", "2", imagePath)
+
+	stdout, stderr, err := process.RunCommand("ffmpeg", arguments...)
+	if err != nil {
+		return fmt.Errorf("%v; stdout=%#+v, stderr=%#+v", err, stdout, stderr)
+	}
+
+	// ffmpeg is happy to write nothing at all for an offset past the end of the video
+	info, err := os.Stat(imagePath)
+	if err != nil || info.Size() == 0 {
+		return fmt.Errorf("no frame at %v into %#+v", offset, videoPath)
+	}
+
+	return nil
+}
+
+func parseSize(output string) (int, int, error) {
+	probe := struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+	}{}
+
+	err := json.Unmarshal([]byte(output), &probe)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(probe.Streams) == 0 || probe.Streams[0].Width <= 0 || probe.Streams[0].Height <= 0 {
+		return 0, 0, fmt.Errorf("no video stream with a size")
+	}
+
+	return probe.Streams[0].Width, probe.Streams[0].Height, nil
+}
+
+// GetVideoSize returns the width and height of the (first) video stream of a video
+func GetVideoSize(videoPath string) (int, int, error) {
+	stdout, stderr, err := process.RunCommand(
+		"ffprobe",
+		"-v",
+		"error",
+		"-select_streams",
+		"v:0",
+		"-show_entries",
+		"stream=width,height",
+		"-of",
+		"json",
+		videoPath,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to probe size: %v; stderr=%#+v", err, stderr)
+	}
+
+	return parseSize(stdout)
+}
@@ -4,8 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/initialed85/cameranator/pkg/test_utils"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,3 +32,38 @@ func TestCreateThumbnail(t *testing.T) {
 	_, err = os.Stat(path)
 	require.NoError(t, err)
 }
+
+func TestGetBestPaths(t *testing.T) {
+	imagePath, lowResImagePath := GetBestPaths("/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4")
+	assert.Equal(t, "/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway__best.jpg", imagePath)
+	assert.Equal(t, "/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway__best__lowres.jpg", lowResImagePath)
+}
+
+func TestParseSize(t *testing.T) {
+	width, height, err := parseSize(`{"programs": [], "streams": [{"width": 1920, "height": 1080}]}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1920, width)
+	assert.Equal(t, 1080, height)
+
+	_, _, err = parseSize(`{"programs": [], "streams": []}`)
+	assert.Error(t, err)
+}
+
+func TestGetThumbnailAt(t *testing.T) {
+	dir, err := test_utils.GetTempDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	path := filepath.Join(dir, "some_file.jpg")
+
+	err = GetThumbnailAt(test_utils.TestVideoPath, path, time.Second, &Box{X: 10, Y: 10, Width: 100, Height: 50})
+	require.NoError(t, err)
+
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	err = GetThumbnailAt(test_utils.TestVideoPath, filepath.Join(dir, "other_file.jpg"), time.Hour, nil)
+	assert.Error(t, err)
+}
@@ -10,7 +10,6 @@ import (
 	"log"
 	"math"
 	"os"
-	"path"
 	"slices"
 	"strconv"
 	"strings"
@@ -19,6 +18,8 @@ import (
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"gocv.io/x/gocv"
 	"golang.org/x/exp/maps"
+
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 )
 
 const (
@@ -32,21 +33,20 @@ const (
 )
 
 type ObjectTracker struct {
-	segmentsPath string
-	mats         chan gocv.Mat
-	ctx          context.Context
-	cancel       context.CancelFunc
+	resolver *path_resolver.Resolver
+	mats     chan gocv.Mat
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
+// New returns an ObjectTracker that finds the videos of events using resolver (see path_resolver.Resolver)
 func New(
-	segmentsPath string,
+	resolver *path_resolver.Resolver,
 	mats chan gocv.Mat,
 ) (*ObjectTracker, error) {
-	segmentsPath = strings.TrimRight(segmentsPath, "/")
-
 	o := ObjectTracker{
-		segmentsPath: segmentsPath,
-		mats:         mats,
+		resolver: resolver,
+		mats:     mats,
 	}
 	o.ctx, o.cancel = context.WithCancel(context.Background())
 
@@ -61,8 +61,7 @@ func (o *ObjectTracker) Close() error {
 func (o *ObjectTracker) enrichEvent(event *PartialEvent) error {
 	log.Printf("event.ID: %v; preparing original video %#+v...", event.ID, event.OriginalVideo.FilePath)
 
-	filePathParts := strings.Split(strings.TrimRight(event.OriginalVideo.FilePath, "/"), "/")
-	event.OriginalVideo.AdjustedFilePath = path.Join(o.segmentsPath, filePathParts[len(filePathParts)-1])
+	event.OriginalVideo.AdjustedFilePath = o.resolver.ToLocal(event.OriginalVideo.FilePath)
 	log.Printf("event.ID: %v; adjusted filePath for original video is %#+v", event.ID, event.OriginalVideo.AdjustedFilePath)
 
 	stat, err := os.Stat(event.OriginalVideo.AdjustedFilePath)
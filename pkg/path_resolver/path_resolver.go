@@ -0,0 +1,139 @@
+package path_resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+
+e.g. for a service that sees the shared volume at /Volumes/cameranator/media/srv (rather than at /srv/target_dir,
+which is what the paths in the database are relative to):
+
+	-pathMap /srv/target_dir=/Volumes/cameranator/media/srv
+
+or (for more than one) comma-separated, e.g.:
+
+	-pathMap /srv/target_dir/segments=/mnt/fast/segments,/srv/target_dir/archive=/mnt/slow/archive
+
+*/
+
+// Mapping says that media known (e.g. in the database) by a path / URI starting with Canonical is at Local for this
+// service
+type Mapping struct {
+	Canonical string
+	Local     string
+}
+
+// Resolver turns canonical media paths / URIs (as stored in the database) into local paths and back, by swapping the
+// longest matching prefix; a path that no Mapping matches is returned as it is (so a nil or empty Resolver changes
+// nothing)
+type Resolver struct {
+	mappings []Mapping
+}
+
+func New(mappings []Mapping) (*Resolver, error) {
+	r := Resolver{
+		mappings: make([]Mapping, 0, len(mappings)),
+	}
+
+	seenCanonical := make(map[string]struct{})
+	seenLocal := make(map[string]struct{})
+
+	for _, mapping := range mappings {
+		mapping.Canonical = strings.TrimRight(strings.TrimSpace(mapping.Canonical), "/")
+		mapping.Local = strings.TrimRight(strings.TrimSpace(mapping.Local), "/")
+
+		if mapping.Canonical == "" || mapping.Local == "" {
+			return nil, fmt.Errorf("invalid mapping %#+v; neither side may be empty (or just /)", mapping)
+		}
+
+		_, ok := seenCanonical[mapping.Canonical]
+		if ok {
+			return nil, fmt.Errorf("%#+v is mapped more than once", mapping.Canonical)
+		}
+
+		_, ok = seenLocal[mapping.Local]
+		if ok {
+			return nil, fmt.Errorf("more than one thing is mapped to %#+v", mapping.Local)
+		}
+
+		seenCanonical[mapping.Canonical] = struct{}{}
+		seenLocal[mapping.Local] = struct{}{}
+
+		r.mappings = append(r.mappings, mapping)
+	}
+
+	return &r, nil
+}
+
+// Parse returns a Resolver for comma-separated canonical=local pairs (see the example above); empty is a Resolver
+// that changes nothing
+func Parse(raw string) (*Resolver, error) {
+	mappings := make([]Mapping, 0)
+
+	for _, rawMapping := range strings.Split(raw, ",") {
+		rawMapping = strings.TrimSpace(rawMapping)
+		if rawMapping == "" {
+			continue
+		}
+
+		canonical, local, ok := strings.Cut(rawMapping, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping %#+v; must be canonical=local", rawMapping)
+		}
+
+		mappings = append(mappings, Mapping{Canonical: canonical, Local: local})
+	}
+
+	return New(mappings)
+}
+
+// swap replaces the longest of prefixes that path starts with (as a whole path segment) with its replacement
+func swap(path string, prefixes map[string]string) string {
+	keys := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		keys = append(keys, prefix)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+
+	for _, prefix := range keys {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return prefixes[prefix] + strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	return path
+}
+
+// ToLocal returns where this service can find the media known by canonical
+func (r *Resolver) ToLocal(canonical string) string {
+	if r == nil {
+		return canonical
+	}
+
+	prefixes := make(map[string]string)
+	for _, mapping := range r.mappings {
+		prefixes[mapping.Canonical] = mapping.Local
+	}
+
+	return swap(canonical, prefixes)
+}
+
+// ToCanonical is the inverse of ToLocal (i.e. what to record in the database for a local path)
+func (r *Resolver) ToCanonical(local string) string {
+	if r == nil {
+		return local
+	}
+
+	prefixes := make(map[string]string)
+	for _, mapping := range r.mappings {
+		prefixes[mapping.Local] = mapping.Canonical
+	}
+
+	return swap(local, prefixes)
+}
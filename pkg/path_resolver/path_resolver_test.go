@@ -0,0 +1,53 @@
+package path_resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	r, err := Parse("/srv/target_dir=/Volumes/cameranator/media/srv/, /srv/target_dir/archive=/mnt/slow/archive,s3://cameranator/media=/mnt/minio/cameranator/media")
+	require.NoError(t, err)
+
+	for canonical, local := range map[string]string{
+		"/srv/target_dir/segments/Segment_2020-12-25T08:45:04_Driveway.mp4":           "/Volumes/cameranator/media/srv/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+		"/srv/target_dir/archive/2020-12-25/Segment_2020-12-25T08:45:04_Driveway.mp4": "/mnt/slow/archive/2020-12-25/Segment_2020-12-25T08:45:04_Driveway.mp4",
+		"s3://cameranator/media/Segment_2020-12-25T08:45:04_Driveway.mp4":             "/mnt/minio/cameranator/media/Segment_2020-12-25T08:45:04_Driveway.mp4",
+		"/srv/target_dir": "/Volumes/cameranator/media/srv",
+	} {
+		assert.Equal(t, local, r.ToLocal(canonical), canonical)
+		assert.Equal(t, canonical, r.ToCanonical(local), local)
+	}
+
+	// only whole path segments match
+	assert.Equal(t, "/srv/target_dir2/a.mp4", r.ToLocal("/srv/target_dir2/a.mp4"))
+	assert.Equal(t, "/somewhere/else/a.mp4", r.ToLocal("/somewhere/else/a.mp4"))
+	assert.Equal(t, "/somewhere/else/a.mp4", r.ToCanonical("/somewhere/else/a.mp4"))
+}
+
+func TestResolver_Identity(t *testing.T) {
+	r, err := Parse("")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/target_dir/a.mp4", r.ToLocal("/srv/target_dir/a.mp4"))
+	assert.Equal(t, "/srv/target_dir/a.mp4", r.ToCanonical("/srv/target_dir/a.mp4"))
+
+	var nilResolver *Resolver
+	assert.Equal(t, "/srv/target_dir/a.mp4", nilResolver.ToLocal("/srv/target_dir/a.mp4"))
+	assert.Equal(t, "/srv/target_dir/a.mp4", nilResolver.ToCanonical("/srv/target_dir/a.mp4"))
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"/srv/target_dir",
+		"=/mnt",
+		"/srv/target_dir=",
+		"/=/mnt",
+		"/srv/a=/mnt/a,/srv/a=/mnt/b",
+		"/srv/a=/mnt/a,/srv/b=/mnt/a",
+	} {
+		_, err := Parse(raw)
+		assert.Error(t, err, raw)
+	}
+}
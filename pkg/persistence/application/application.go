@@ -53,6 +53,27 @@ func NewApplication(url string, timeout time.Duration) (*Application, error) {
 		return nil, err
 	}
 
+	err = r.Register(
+		registry.NewModel("recording_gap", model.RecordingGap{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Register(
+		registry.NewModel("recorder_recovery", model.RecorderRecovery{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Register(
+		registry.NewModel("sprite_sheet", model.SpriteSheet{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	a := Application{
 		registry: r,
 		client:   graphql.NewClient(url, timeout),
@@ -0,0 +1,185 @@
+package camera_source
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/initialed85/glue/pkg/worker"
+
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+const subscription = `
+subscription LiveCameras {
+	camera(order_by: {id: asc}) {
+		id
+		name
+		stream_url
+		sub_stream_url
+	}
+}
+`
+
+// CameraSource keeps a handler up to date with the full contents of the camera table; Hasura re-sends the whole
+// result set whenever it changes, so inserts, updates and deletes all arrive the same way
+type CameraSource struct {
+	mu                        sync.Mutex
+	scheduledWorker           *worker.BlockedWorker
+	graphqlSubscriptionClient *graphql.SubscriptionClient
+	application               *application.Application
+	url                       string
+	handler                   func([]model.Camera)
+	lastCameras               []model.Camera
+}
+
+func NewCameraSource(
+	url string,
+	timeout time.Duration,
+	handler func([]model.Camera),
+) (*CameraSource, error) {
+	c := CameraSource{
+		url:     url,
+		handler: handler,
+	}
+
+	var err error
+
+	c.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c.scheduledWorker = worker.NewBlockedWorker(
+		c.onStart,
+		func() {
+			time.Sleep(time.Second * 1)
+		},
+		c.onStop,
+	)
+
+	return &c, nil
+}
+
+// GetCameras queries the camera table once (without involving the handler)
+func (c *CameraSource) GetCameras() ([]model.Camera, error) {
+	cameraModelAndClient, err := c.application.GetModelAndClient("camera")
+	if err != nil {
+		return nil, err
+	}
+
+	cameras := make([]model.Camera, 0)
+
+	err = cameraModelAndClient.GetAll(&cameras)
+	if err != nil {
+		return nil, err
+	}
+
+	return cameras, nil
+}
+
+func (c *CameraSource) setCameras(cameras []model.Camera) {
+	c.mu.Lock()
+	if c.lastCameras != nil && reflect.DeepEqual(cameras, c.lastCameras) {
+		c.mu.Unlock()
+		return
+	}
+	c.lastCameras = cameras
+	c.mu.Unlock()
+
+	log.Printf("CameraSource; cameras are now %v", cameras)
+
+	c.handler(cameras)
+}
+
+func (c *CameraSource) subscriptionHandler(message []byte, err error) error {
+	if err != nil {
+		log.Printf("attempt to read message caused %#+v; ignoring", err)
+		return nil
+	}
+
+	payload := struct {
+		Camera []model.Camera `json:"camera"`
+	}{}
+
+	err = json.Unmarshal(message, &payload)
+	if err != nil {
+		log.Printf("attempt to unmarshal message caused %#+v; ignoring", err)
+		return nil
+	}
+
+	if payload.Camera == nil {
+		payload.Camera = make([]model.Camera, 0)
+	}
+
+	c.setCameras(payload.Camera)
+
+	return nil
+}
+
+func (c *CameraSource) onStart() {
+	log.Printf("connecting to %v", c.url)
+	graphqlSubscriptionClient := graphql.NewSubscriptionClient(c.url).
+		WithRetryTimeout(0). // i.e. keep trying forever; the database is the source of truth
+		OnError(func(sc *graphql.SubscriptionClient, err error) error {
+			log.Printf("warning: camera subscription caused %v; will retry...", err)
+			return nil
+		})
+
+	c.mu.Lock()
+	c.graphqlSubscriptionClient = graphqlSubscriptionClient
+	c.mu.Unlock()
+
+	_, err := graphqlSubscriptionClient.Exec(subscription, nil, c.subscriptionHandler)
+	if err != nil {
+		// TODO
+		log.Panicf("attempt to invoke graphqlClient.Exec (for subscription) caused %#+v; cannot recover", err)
+		return
+	}
+
+	log.Printf("running graphql client...")
+	err = graphqlSubscriptionClient.Run()
+	if err != nil {
+		// TODO
+		log.Panicf("attempt to invoke graphqlClient.Run caused %#+v; cannot recover", err)
+		return
+	}
+}
+
+func (c *CameraSource) onStop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.graphqlSubscriptionClient == nil {
+		return
+	}
+
+	_ = c.graphqlSubscriptionClient.Close()
+	c.graphqlSubscriptionClient = nil
+}
+
+// Start loads the cameras once (so that the handler has been called before Start returns) and then follows changes
+func (c *CameraSource) Start() error {
+	cameras, err := c.GetCameras()
+	if err != nil {
+		return fmt.Errorf("failed to load cameras: %v", err)
+	}
+
+	c.setCameras(cameras)
+
+	c.scheduledWorker.Start()
+
+	return nil
+}
+
+func (c *CameraSource) Stop() {
+	c.scheduledWorker.Stop()
+
+	// Run() blocks onStart until the client is closed, so onStop would never get a chance to
+	c.onStop()
+}
@@ -0,0 +1,82 @@
+package camera_source
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func getTestCameraSource() (*CameraSource, *[][]model.Camera) {
+	calls := make([][]model.Camera, 0)
+
+	c := CameraSource{
+		handler: func(cameras []model.Camera) {
+			calls = append(calls, cameras)
+		},
+	}
+
+	return &c, &calls
+}
+
+func TestCameraSource_SubscriptionHandler(t *testing.T) {
+	c, calls := getTestCameraSource()
+
+	driveway := model.Camera{ID: 1, Name: "Driveway", StreamURL: "rtsp://a/101", SubStreamURL: "rtsp://a/102"}
+	frontDoor := model.Camera{ID: 2, Name: "FrontDoor", StreamURL: "rtsp://b/101"}
+
+	err := c.subscriptionHandler([]byte(`{"camera": [{"id": 1, "name": "Driveway", "stream_url": "rtsp://a/101", "sub_stream_url": "rtsp://a/102"}]}`), nil)
+	require.NoError(t, err)
+	require.Len(t, *calls, 1)
+	assert.Equal(t, []model.Camera{driveway}, (*calls)[0])
+
+	// Hasura re-sends the whole result set, even when nothing we care about has changed
+	err = c.subscriptionHandler([]byte(`{"camera": [{"id": 1, "name": "Driveway", "stream_url": "rtsp://a/101", "sub_stream_url": "rtsp://a/102"}]}`), nil)
+	require.NoError(t, err)
+	assert.Len(t, *calls, 1)
+
+	// an insert
+	err = c.subscriptionHandler([]byte(`{"camera": [{"id": 1, "name": "Driveway", "stream_url": "rtsp://a/101", "sub_stream_url": "rtsp://a/102"}, {"id": 2, "name": "FrontDoor", "stream_url": "rtsp://b/101"}]}`), nil)
+	require.NoError(t, err)
+	require.Len(t, *calls, 2)
+	assert.Equal(t, []model.Camera{driveway, frontDoor}, (*calls)[1])
+
+	// an update
+	err = c.subscriptionHandler([]byte(`{"camera": [{"id": 1, "name": "Driveway", "stream_url": "rtsp://a/201"}, {"id": 2, "name": "FrontDoor", "stream_url": "rtsp://b/101"}]}`), nil)
+	require.NoError(t, err)
+	require.Len(t, *calls, 3)
+	assert.Equal(t, []model.Camera{{ID: 1, Name: "Driveway", StreamURL: "rtsp://a/201"}, frontDoor}, (*calls)[2])
+
+	// everything deleted
+	err = c.subscriptionHandler([]byte(`{"camera": null}`), nil)
+	require.NoError(t, err)
+	require.Len(t, *calls, 4)
+	assert.Equal(t, []model.Camera{}, (*calls)[3])
+}
+
+func TestCameraSource_SubscriptionHandler_Ignored(t *testing.T) {
+	c, calls := getTestCameraSource()
+
+	err := c.subscriptionHandler(nil, fmt.Errorf("connection reset"))
+	assert.NoError(t, err)
+
+	err = c.subscriptionHandler([]byte(`not json`), nil)
+	assert.NoError(t, err)
+
+	assert.Len(t, *calls, 0)
+}
+
+func TestCameraSource_SetCameras_FirstEmpty(t *testing.T) {
+	c, calls := getTestCameraSource()
+
+	// an empty camera table still has to reach the handler the first time (so that it knows there's nothing to run)
+	c.setCameras([]model.Camera{})
+	require.Len(t, *calls, 1)
+	assert.Equal(t, []model.Camera{}, (*calls)[0])
+
+	c.setCameras([]model.Camera{})
+	assert.Len(t, *calls, 1)
+}
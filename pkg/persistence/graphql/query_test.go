@@ -23,6 +23,7 @@ func TestGetManyQuery(t *testing.T) {
     id
     name
     stream_url
+    sub_stream_url
   }
 }
 `,
@@ -51,11 +52,29 @@ func TestGetManyQuery_Nested(t *testing.T) {
       end_timestamp
       size
       file_path
+      checksum
       camera_id
       camera {
         id
         name
         stream_url
+        sub_stream_url
+      }
+    }
+    processed_video_id
+    processed_video {
+      id
+      start_timestamp
+      end_timestamp
+      size
+      file_path
+      checksum
+      camera_id
+      camera {
+        id
+        name
+        stream_url
+        sub_stream_url
       }
     }
     thumbnail_image_id
@@ -69,6 +88,7 @@ func TestGetManyQuery_Nested(t *testing.T) {
         id
         name
         stream_url
+        sub_stream_url
       }
     }
     source_camera_id
@@ -76,6 +96,7 @@ func TestGetManyQuery_Nested(t *testing.T) {
       id
       name
       stream_url
+      sub_stream_url
     }
     status
   }
@@ -97,6 +118,7 @@ func TestGetOneQuery(t *testing.T) {
     id
     name
     stream_url
+    sub_stream_url
   }
 }
 `,
@@ -124,6 +146,7 @@ mutation {
     id
     name
     stream_url
+    sub_stream_url
   }
 }
 `,
@@ -177,6 +200,7 @@ mutation {
       id
       name
       stream_url
+      sub_stream_url
     }
   }
 }
@@ -229,6 +253,7 @@ mutation {
       id
       name
       stream_url
+      sub_stream_url
     }
   }
 }
@@ -255,6 +280,7 @@ mutation {
       id
       name
       stream_url
+      sub_stream_url
     }
   }
 }
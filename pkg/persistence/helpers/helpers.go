@@ -1,11 +1,15 @@
 package helpers
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/relvacode/iso8601"
 
 	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
 	"github.com/initialed85/cameranator/pkg/persistence/application"
 	"github.com/initialed85/cameranator/pkg/persistence/model"
 )
@@ -32,6 +36,8 @@ func GetCamera(
 	return cameras[0], nil
 }
 
+// AddEvent adds an event for a segment (with the SHA-256 of each video, for later verification); lowQualityVideoPath
+// (the sub-stream segment) is optional and becomes the event's processed_video
 func AddEvent(
 	application *application.Application,
 	cameraName string,
@@ -39,7 +45,39 @@ func AddEvent(
 	endTimestamp iso8601.Time,
 	highQualityVideoPath string,
 	highQualityImagePath string,
+	lowQualityVideoPath string,
 ) (model.Event, error) {
+	return AddEventWithLocations(
+		application,
+		cameraName,
+		startTimestamp,
+		endTimestamp,
+		highQualityVideoPath,
+		highQualityImagePath,
+		lowQualityVideoPath,
+		nil,
+	)
+}
+
+// AddEventWithLocations is AddEvent for files that are being kept somewhere else (e.g. in a storage.Storage); the
+// sizes and checksums come from the local files but the file paths recorded are what locate returns for each of them
+// (nil records the local paths)
+func AddEventWithLocations(
+	application *application.Application,
+	cameraName string,
+	startTimestamp iso8601.Time,
+	endTimestamp iso8601.Time,
+	highQualityVideoPath string,
+	highQualityImagePath string,
+	lowQualityVideoPath string,
+	locate func(path string) string,
+) (model.Event, error) {
+	if locate == nil {
+		locate = func(path string) string {
+			return path
+		}
+	}
+
 	camera, err := GetCamera(application, cameraName)
 	if err != nil {
 		return model.Event{}, err
@@ -50,6 +88,11 @@ func AddEvent(
 		return model.Event{}, err
 	}
 
+	highQualityVideoChecksum, err := metadata.GetFileChecksum(highQualityVideoPath)
+	if err != nil {
+		return model.Event{}, err
+	}
+
 	highQualityImageSize, err := metadata.GetFileSize(highQualityImagePath)
 	if err != nil {
 		return model.Event{}, err
@@ -59,14 +102,15 @@ func AddEvent(
 		startTimestamp,
 		endTimestamp,
 		highQualityVideoSize,
-		highQualityVideoPath,
+		locate(highQualityVideoPath),
+		highQualityVideoChecksum,
 		camera,
 	)
 
 	highQualityImage := model.NewImage(
 		startTimestamp,
 		highQualityImageSize,
-		highQualityImagePath,
+		locate(highQualityImagePath),
 		camera,
 	)
 
@@ -78,6 +122,27 @@ func AddEvent(
 		camera,
 	)
 
+	if lowQualityVideoPath != "" {
+		lowQualityVideoSize, err := metadata.GetFileSize(lowQualityVideoPath)
+		if err != nil {
+			return model.Event{}, err
+		}
+
+		lowQualityVideoChecksum, err := metadata.GetFileChecksum(lowQualityVideoPath)
+		if err != nil {
+			return model.Event{}, err
+		}
+
+		event.ProcessedVideo = model.NewVideo(
+			startTimestamp,
+			endTimestamp,
+			lowQualityVideoSize,
+			locate(lowQualityVideoPath),
+			lowQualityVideoChecksum,
+			camera,
+		)
+	}
+
 	eventModelAndClient, err := application.GetModelAndClient("event")
 	if err != nil {
 		return model.Event{}, err
@@ -95,3 +160,1140 @@ func AddEvent(
 
 	return events[0], nil
 }
+
+// GetLatestVideoBefore returns the camera's most recent video that started before the given timestamp; ok is false
+// if there isn't one
+func GetLatestVideoBefore(
+	application *application.Application,
+	cameraName string,
+	timestamp iso8601.Time,
+) (video model.Video, ok bool, err error) {
+	videoModelAndClient, err := application.GetModelAndClient("video")
+	if err != nil {
+		return model.Video{}, false, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  video(
+    where: {camera: {name: {_eq: %#v}}, start_timestamp: {_lt: %#v}},
+    order_by: {start_timestamp: desc},
+    limit: 1
+  ) {
+    id
+    start_timestamp
+    end_timestamp
+    size
+    file_path
+    checksum
+    camera_id
+  }
+}
+`, cameraName, timestamp.Format("2006-01-02T15:04:05-0700"))
+
+	videos := make([]model.Video, 0)
+	err = videoModelAndClient.Client().QueryAndExtract(query, "video", &videos)
+	if err != nil {
+		return model.Video{}, false, err
+	}
+
+	if len(videos) == 0 {
+		return model.Video{}, false, nil
+	}
+
+	return videos[0], true, nil
+}
+
+// GetVideosAfter returns up to limit videos (in order of id) with an id greater than afterID, for paging through all
+// of them; cameraName is optional
+func GetVideosAfter(
+	application *application.Application,
+	cameraName string,
+	afterID int64,
+	limit int,
+) ([]model.Video, error) {
+	videoModelAndClient, err := application.GetModelAndClient("video")
+	if err != nil {
+		return nil, err
+	}
+
+	where := fmt.Sprintf("id: {_gt: %v}", afterID)
+	if cameraName != "" {
+		where += fmt.Sprintf(", camera: {name: {_eq: %#v}}", cameraName)
+	}
+
+	query := fmt.Sprintf(`
+{
+  video(
+    where: {%v},
+    order_by: {id: asc},
+    limit: %v
+  ) {
+    id
+    start_timestamp
+    end_timestamp
+    size
+    file_path
+    checksum
+    camera_id
+  }
+}
+`, where, limit)
+
+	videos := make([]model.Video, 0)
+	err = videoModelAndClient.Client().QueryAndExtract(query, "video", &videos)
+	if err != nil {
+		return nil, err
+	}
+
+	return videos, nil
+}
+
+// GetVideosByFilePath returns the videos (if any) with one of the given file paths
+func GetVideosByFilePath(
+	application *application.Application,
+	filePaths []string,
+) ([]model.Video, error) {
+	videoModelAndClient, err := application.GetModelAndClient("video")
+	if err != nil {
+		return nil, err
+	}
+
+	rawFilePaths, err := json.Marshal(filePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  video(
+    where: {file_path: {_in: %v}},
+    order_by: {id: asc}
+  ) {
+    id
+    start_timestamp
+    end_timestamp
+    size
+    file_path
+    checksum
+    camera_id
+  }
+}
+`, string(rawFilePaths))
+
+	videos := make([]model.Video, 0)
+	err = videoModelAndClient.Client().QueryAndExtract(query, "video", &videos)
+	if err != nil {
+		return nil, err
+	}
+
+	return videos, nil
+}
+
+// GetImagesByFilePath returns the images (if any) with one of the given file paths
+func GetImagesByFilePath(
+	application *application.Application,
+	filePaths []string,
+) ([]model.Image, error) {
+	imageModelAndClient, err := application.GetModelAndClient("image")
+	if err != nil {
+		return nil, err
+	}
+
+	rawFilePaths, err := json.Marshal(filePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  image(
+    where: {file_path: {_in: %v}},
+    order_by: {id: asc}
+  ) {
+    id
+    timestamp
+    size
+    file_path
+    camera_id
+  }
+}
+`, string(rawFilePaths))
+
+	images := make([]model.Image, 0)
+	err = imageModelAndClient.Client().QueryAndExtract(query, "image", &images)
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// eventFields are those of an event (and the file paths of its videos and thumbnail) needed to find its files and
+// to delete it (see DeleteEvents)
+const eventFields = `{
+    id
+    start_timestamp
+    end_timestamp
+    original_video_id
+    original_video {
+      id
+      file_path
+    }
+    processed_video_id
+    processed_video {
+      id
+      file_path
+    }
+    thumbnail_image_id
+    thumbnail_image {
+      id
+      file_path
+    }
+    source_camera_id
+    source_camera {
+      id
+      name
+    }
+    status
+  }`
+
+// GetEventsAfter returns up to limit events (in order of id, with the file paths of their videos and thumbnail) with
+// an id greater than afterID, for paging through all of them
+func GetEventsAfter(
+	application *application.Application,
+	afterID int64,
+	limit int,
+) ([]model.Event, error) {
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  event(
+    where: {id: {_gt: %v}},
+    order_by: {id: asc},
+    limit: %v
+  ) %v
+}
+`, afterID, limit, eventFields)
+
+	events := make([]model.Event, 0)
+	err = eventModelAndClient.Client().QueryAndExtract(query, "event", &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetEventsWithStatusAfter is as for GetEventsAfter, but only for events with the given status
+func GetEventsWithStatusAfter(
+	application *application.Application,
+	status string,
+	afterID int64,
+	limit int,
+) ([]model.Event, error) {
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return nil, err
+	}
+
+	rawStatus, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  event(
+    where: {id: {_gt: %v}, status: {_eq: %v}},
+    order_by: {id: asc},
+    limit: %v
+  ) %v
+}
+`, afterID, string(rawStatus), limit, eventFields)
+
+	events := make([]model.Event, 0)
+	err = eventModelAndClient.Client().QueryAndExtract(query, "event", &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// SetEventsStatus moves the events on to status
+func SetEventsStatus(
+	application *application.Application,
+	eventIDs []int64,
+	status string,
+) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return err
+	}
+
+	rawEventIDs, err := json.Marshal(eventIDs)
+	if err != nil {
+		return err
+	}
+
+	rawStatus, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	mutation := fmt.Sprintf(`
+mutation {
+  update_event(where: {id: {_in: %v}}, _set: {status: %v}) {
+    affected_rows
+  }
+}
+`, string(rawEventIDs), string(rawStatus))
+
+	_, err = eventModelAndClient.Client().Mutate(mutation)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetBestDetections returns the highest-scoring detection of each of the given classes (of every class, if classNames
+// is nil) for each of the events, if it scores at least minScore
+func GetBestDetections(
+	application *application.Application,
+	eventIDs []int64,
+	classNames []string,
+	minScore float64,
+) ([]model.Detection, error) {
+	if len(eventIDs) == 0 || (classNames != nil && len(classNames) == 0) {
+		return []model.Detection{}, nil
+	}
+
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return nil, err
+	}
+
+	rawEventIDs, err := json.Marshal(eventIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	where := fmt.Sprintf("event_id: {_in: %v}, score: {_gte: %v}", string(rawEventIDs), minScore)
+
+	if classNames != nil {
+		rawClassNames, err := json.Marshal(classNames)
+		if err != nil {
+			return nil, err
+		}
+
+		where += fmt.Sprintf(", class_name: {_in: %v}", string(rawClassNames))
+	}
+
+	query := fmt.Sprintf(`
+{
+  detection(
+    distinct_on: [event_id, class_name],
+    where: {%v},
+    order_by: [{event_id: asc}, {class_name: asc}, {score: desc}]
+  ) {
+    id
+    timestamp
+    class_id
+    class_name
+    score
+    bounding_box
+    camera_id
+    event_id
+    object_id
+  }
+}
+`, where)
+
+	detections := make([]model.Detection, 0)
+	err = eventModelAndClient.Client().QueryAndExtract(query, "detection", &detections)
+	if err != nil {
+		return nil, err
+	}
+
+	return detections, nil
+}
+
+// GetEventsByVideoFilePath returns the events (if any) whose original video has one of the given file paths
+func GetEventsByVideoFilePath(
+	application *application.Application,
+	filePaths []string,
+) ([]model.Event, error) {
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return nil, err
+	}
+
+	rawFilePaths, err := json.Marshal(filePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  event(
+    where: {original_video: {file_path: {_in: %v}}},
+    order_by: {id: asc}
+  ) %v
+}
+`, string(rawFilePaths), eventFields)
+
+	events := make([]model.Event, 0)
+	err = eventModelAndClient.Client().QueryAndExtract(query, "event", &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeletedCounts is how many rows of each kind went with a batch of events
+type DeletedCounts struct {
+	Detections           int
+	AggregatedDetections int
+	Objects              int
+	SpriteSheets         int
+	Events               int
+	Videos               int
+	Images               int
+}
+
+func (d DeletedCounts) Add(other DeletedCounts) DeletedCounts {
+	return DeletedCounts{
+		Detections:           d.Detections + other.Detections,
+		AggregatedDetections: d.AggregatedDetections + other.AggregatedDetections,
+		Objects:              d.Objects + other.Objects,
+		SpriteSheets:         d.SpriteSheets + other.SpriteSheets,
+		Events:               d.Events + other.Events,
+		Videos:               d.Videos + other.Videos,
+		Images:               d.Images + other.Images,
+	}
+}
+
+// getDeleteEventsMutation returns a mutation that deletes the events (and their sprite sheets, whose images go too)
+// along with everything that refers to them and everything they refer to (bar the camera); the foreign keys are all
+// RESTRICT, so it goes from the leaves inwards (and video / image refer back to event, so those references are cleared
+// before the event goes)
+func getDeleteEventsMutation(events []model.Event, spriteSheets []model.SpriteSheet) (string, error) {
+	eventIDs := make([]int64, 0, len(events))
+	videoIDs := make([]int64, 0, len(events)*2)
+	imageIDs := make([]int64, 0, len(events)+len(spriteSheets))
+
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.ID)
+		videoIDs = append(videoIDs, event.OriginalVideoID)
+
+		if event.ProcessedVideoID != 0 {
+			videoIDs = append(videoIDs, event.ProcessedVideoID)
+		}
+
+		imageIDs = append(imageIDs, event.ThumbnailImageID)
+	}
+
+	for _, spriteSheet := range spriteSheets {
+		imageIDs = append(imageIDs, spriteSheet.ImageID)
+	}
+
+	rawEventIDs, err := json.Marshal(eventIDs)
+	if err != nil {
+		return "", err
+	}
+
+	rawVideoIDs, err := json.Marshal(videoIDs)
+	if err != nil {
+		return "", err
+	}
+
+	rawImageIDs, err := json.Marshal(imageIDs)
+	if err != nil {
+		return "", err
+	}
+
+	mutation := fmt.Sprintf(`
+mutation {
+  delete_detection(where: {_or: [{event_id: {_in: %[1]v}}, {object: {event_id: {_in: %[1]v}}}]}) {
+    affected_rows
+  }
+  delete_aggregated_detection(where: {event_id: {_in: %[1]v}}) {
+    affected_rows
+  }
+  delete_object(where: {event_id: {_in: %[1]v}}) {
+    affected_rows
+  }
+  delete_sprite_sheet(where: {event_id: {_in: %[1]v}}) {
+    affected_rows
+  }
+  update_video(where: {event_id: {_in: %[1]v}}, _set: {event_id: null}) {
+    affected_rows
+  }
+  update_image(where: {event_id: {_in: %[1]v}}, _set: {event_id: null}) {
+    affected_rows
+  }
+  delete_event(where: {id: {_in: %[1]v}}) {
+    affected_rows
+  }
+  delete_video(where: {id: {_in: %[2]v}}) {
+    affected_rows
+  }
+  delete_image(where: {id: {_in: %[3]v}}) {
+    affected_rows
+  }
+}
+`, string(rawEventIDs), string(rawVideoIDs), string(rawImageIDs))
+
+	return mutation, nil
+}
+
+// DeleteEvents deletes the events (which need their ids and those of their videos and thumbnail image) and all that
+// goes with them as one mutation (which Hasura runs as one transaction, so it's all or nothing)
+func DeleteEvents(
+	application *application.Application,
+	events []model.Event,
+) (DeletedCounts, error) {
+	if len(events) == 0 {
+		return DeletedCounts{}, nil
+	}
+
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return DeletedCounts{}, err
+	}
+
+	eventIDs := make([]int64, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.ID)
+	}
+
+	spriteSheets, err := GetSpriteSheets(application, eventIDs)
+	if err != nil {
+		return DeletedCounts{}, err
+	}
+
+	mutation, err := getDeleteEventsMutation(events, spriteSheets)
+	if err != nil {
+		return DeletedCounts{}, err
+	}
+
+	client := eventModelAndClient.Client()
+
+	data, err := client.Mutate(mutation)
+	if err != nil {
+		return DeletedCounts{}, err
+	}
+
+	counts := DeletedCounts{}
+
+	for key, count := range map[string]*int{
+		"delete_detection":            &counts.Detections,
+		"delete_aggregated_detection": &counts.AggregatedDetections,
+		"delete_object":               &counts.Objects,
+		"delete_sprite_sheet":         &counts.SpriteSheets,
+		"delete_event":                &counts.Events,
+		"delete_video":                &counts.Videos,
+		"delete_image":                &counts.Images,
+	} {
+		results := make([]struct {
+			AffectedRows int `json:"affected_rows"`
+		}, 0)
+
+		err = client.Extract(data, key, &results)
+		if err != nil {
+			return DeletedCounts{}, err
+		}
+
+		if len(results) != 1 {
+			return DeletedCounts{}, fmt.Errorf("expected exactly 1 result for %v; got %v", key, len(results))
+		}
+
+		*count = results[0].AffectedRows
+	}
+
+	return counts, nil
+}
+
+// getDropOriginalVideosMutation returns a mutation that points each of the events at its processed (sub-stream) video in
+// place of its original (high-res) one, and then deletes the original one's row
+func getDropOriginalVideosMutation(events []model.Event) (string, error) {
+	fields := make([]string, 0, len(events)*2)
+
+	for i, event := range events {
+		if event.ProcessedVideoID == 0 {
+			return "", fmt.Errorf("event %v has no processed video to fall back on", event.ID)
+		}
+
+		fields = append(fields, fmt.Sprintf(
+			"  event_%v: update_event(where: {id: {_eq: %v}}, _set: {original_video_id: %v, processed_video_id: null}) {\n    affected_rows\n  }",
+			i,
+			event.ID,
+			event.ProcessedVideoID,
+		))
+
+		fields = append(fields, fmt.Sprintf(
+			"  video_%v: delete_video(where: {id: {_eq: %v}}) {\n    affected_rows\n  }",
+			i,
+			event.OriginalVideoID,
+		))
+	}
+
+	return fmt.Sprintf("\nmutation {\n%v\n}\n", strings.Join(fields, "\n")), nil
+}
+
+// DropOriginalVideos points the events (which need their ids and those of their videos) at their processed videos and
+// deletes the rows of their original ones as one mutation (which Hasura runs as one transaction, so no event is left
+// pointing at a video that's gone); the caller deals with the files
+func DropOriginalVideos(
+	application *application.Application,
+	events []model.Event,
+) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	eventModelAndClient, err := application.GetModelAndClient("event")
+	if err != nil {
+		return err
+	}
+
+	mutation, err := getDropOriginalVideosMutation(events)
+	if err != nil {
+		return err
+	}
+
+	_, err = eventModelAndClient.Client().Mutate(mutation)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getMoveMediaMutation returns a mutation that sets the file path (and, if they're set, the size and checksum) of
+// each of the videos and images, and the WebVTT file path of each of the sprite sheets
+func getMoveMediaMutation(videos []model.Video, images []model.Image, spriteSheets []model.SpriteSheet) (string, error) {
+	fields := make([]string, 0, len(videos)+len(images)+len(spriteSheets))
+
+	for i, video := range videos {
+		set, err := json.Marshal(video.FilePath)
+		if err != nil {
+			return "", err
+		}
+
+		changes := fmt.Sprintf("file_path: %v", string(set))
+
+		if video.Size != 0 {
+			changes += fmt.Sprintf(", size: %v", video.Size)
+		}
+
+		if video.Checksum != "" {
+			checksum, err := json.Marshal(video.Checksum)
+			if err != nil {
+				return "", err
+			}
+
+			changes += fmt.Sprintf(", checksum: %v", string(checksum))
+		}
+
+		fields = append(fields, fmt.Sprintf(
+			"  video_%v: update_video(where: {id: {_eq: %v}}, _set: {%v}) {\n    affected_rows\n  }",
+			i,
+			video.ID,
+			changes,
+		))
+	}
+
+	for i, image := range images {
+		set, err := json.Marshal(image.FilePath)
+		if err != nil {
+			return "", err
+		}
+
+		changes := fmt.Sprintf("file_path: %v", string(set))
+
+		if image.Size != 0 {
+			changes += fmt.Sprintf(", size: %v", image.Size)
+		}
+
+		fields = append(fields, fmt.Sprintf(
+			"  image_%v: update_image(where: {id: {_eq: %v}}, _set: {%v}) {\n    affected_rows\n  }",
+			i,
+			image.ID,
+			changes,
+		))
+	}
+
+	for i, spriteSheet := range spriteSheets {
+		set, err := json.Marshal(spriteSheet.VTTFilePath)
+		if err != nil {
+			return "", err
+		}
+
+		fields = append(fields, fmt.Sprintf(
+			"  sprite_sheet_%v: update_sprite_sheet(where: {id: {_eq: %v}}, _set: {vtt_file_path: %v}) {\n    affected_rows\n  }",
+			i,
+			spriteSheet.ID,
+			string(set),
+		))
+	}
+
+	return fmt.Sprintf("\nmutation {\n%v\n}\n", strings.Join(fields, "\n")), nil
+}
+
+// MoveMedia points the videos, images and sprite sheets (which need their ids and new file paths) at where their files
+// have been moved to as one mutation (which Hasura runs as one transaction, so either every row points at the new
+// files or none of them do)
+func MoveMedia(
+	application *application.Application,
+	videos []model.Video,
+	images []model.Image,
+	spriteSheets []model.SpriteSheet,
+) error {
+	if len(videos) == 0 && len(images) == 0 && len(spriteSheets) == 0 {
+		return nil
+	}
+
+	videoModelAndClient, err := application.GetModelAndClient("video")
+	if err != nil {
+		return err
+	}
+
+	mutation, err := getMoveMediaMutation(videos, images, spriteSheets)
+	if err != nil {
+		return err
+	}
+
+	_, err = videoModelAndClient.Client().Mutate(mutation)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func AddRecordingGap(
+	application *application.Application,
+	cameraName string,
+	startTimestamp iso8601.Time,
+	endTimestamp iso8601.Time,
+) (model.RecordingGap, error) {
+	camera, err := GetCamera(application, cameraName)
+	if err != nil {
+		return model.RecordingGap{}, err
+	}
+
+	recordingGap := model.NewRecordingGapWithID(
+		startTimestamp,
+		endTimestamp,
+		camera.ID,
+	)
+
+	recordingGapModelAndClient, err := application.GetModelAndClient("recording_gap")
+	if err != nil {
+		return model.RecordingGap{}, err
+	}
+
+	recordingGaps := make([]model.RecordingGap, 0)
+	err = recordingGapModelAndClient.Add(&recordingGap, &recordingGaps)
+	if err != nil {
+		return model.RecordingGap{}, err
+	}
+
+	if len(recordingGaps) != 1 {
+		return model.RecordingGap{}, fmt.Errorf("attempt to add RecordingGap should have returned exactly 1 RecordingGap")
+	}
+
+	return recordingGaps[0], nil
+}
+
+// GetVideosBetween returns the camera's videos that overlap the period from startTimestamp to endTimestamp
+func GetVideosBetween(
+	application *application.Application,
+	cameraName string,
+	startTimestamp time.Time,
+	endTimestamp time.Time,
+) ([]model.Video, error) {
+	videoModelAndClient, err := application.GetModelAndClient("video")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  video(
+    where: {camera: {name: {_eq: %#v}}, start_timestamp: {_lt: %#v}, end_timestamp: {_gt: %#v}},
+    order_by: {start_timestamp: asc}
+  ) {
+    id
+    start_timestamp
+    end_timestamp
+    file_path
+    camera_id
+  }
+}
+`, cameraName, endTimestamp.Format(time.RFC3339Nano), startTimestamp.Format(time.RFC3339Nano))
+
+	videos := make([]model.Video, 0)
+	err = videoModelAndClient.Client().QueryAndExtract(query, "video", &videos)
+	if err != nil {
+		return nil, err
+	}
+
+	return videos, nil
+}
+
+// GetRecordingGapsBetween returns the camera's recording gaps that overlap the period from startTimestamp to
+// endTimestamp
+func GetRecordingGapsBetween(
+	application *application.Application,
+	cameraName string,
+	startTimestamp time.Time,
+	endTimestamp time.Time,
+) ([]model.RecordingGap, error) {
+	recordingGapModelAndClient, err := application.GetModelAndClient("recording_gap")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  recording_gap(
+    where: {camera: {name: {_eq: %#v}}, start_timestamp: {_lt: %#v}, end_timestamp: {_gt: %#v}},
+    order_by: {start_timestamp: asc}
+  ) {
+    id
+    start_timestamp
+    end_timestamp
+    camera_id
+  }
+}
+`, cameraName, endTimestamp.Format(time.RFC3339Nano), startTimestamp.Format(time.RFC3339Nano))
+
+	recordingGaps := make([]model.RecordingGap, 0)
+	err = recordingGapModelAndClient.Client().QueryAndExtract(query, "recording_gap", &recordingGaps)
+	if err != nil {
+		return nil, err
+	}
+
+	return recordingGaps, nil
+}
+
+func getReplaceRecordingGapMutation(recordingGapID int64, replacements []model.RecordingGap) string {
+	objects := make([]string, 0, len(replacements))
+	for _, replacement := range replacements {
+		objects = append(objects, fmt.Sprintf(
+			"{start_timestamp: %#v, end_timestamp: %#v, camera_id: %v}",
+			replacement.StartTimestamp.Format(time.RFC3339Nano),
+			replacement.EndTimestamp.Format(time.RFC3339Nano),
+			replacement.CameraID,
+		))
+	}
+
+	return fmt.Sprintf(`
+mutation {
+  delete_recording_gap(where: {id: {_eq: %v}}) {
+    affected_rows
+  }
+  insert_recording_gap(objects: [%v]) {
+    affected_rows
+  }
+}
+`, recordingGapID, strings.Join(objects, ", "))
+}
+
+// ReplaceRecordingGap swaps a recording gap for what's left of it (none, one or more) in the one mutation, e.g. once
+// a segment that arrived late has filled in some or all of it
+func ReplaceRecordingGap(
+	application *application.Application,
+	recordingGapID int64,
+	replacements []model.RecordingGap,
+) error {
+	recordingGapModelAndClient, err := application.GetModelAndClient("recording_gap")
+	if err != nil {
+		return err
+	}
+
+	_, err = recordingGapModelAndClient.Client().Mutate(getReplaceRecordingGapMutation(recordingGapID, replacements))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddRecorderRecovery adds a recorder recovery for the camera, unless there's already one at the same timestamp (i.e.
+// it's been delivered again), in which case that's returned instead
+func AddRecorderRecovery(
+	application *application.Application,
+	cameraName string,
+	timestamp time.Time,
+	reason string,
+	detail string,
+	filePath string,
+) (model.RecorderRecovery, error) {
+	camera, err := GetCamera(application, cameraName)
+	if err != nil {
+		return model.RecorderRecovery{}, err
+	}
+
+	recorderRecoveryModelAndClient, err := application.GetModelAndClient("recorder_recovery")
+	if err != nil {
+		return model.RecorderRecovery{}, err
+	}
+
+	// as precise as Postgres keeps it, so that it matches what was recorded last time
+	timestamp = timestamp.Truncate(time.Microsecond)
+
+	query := fmt.Sprintf(`
+{
+  recorder_recovery(
+    where: {camera_id: {_eq: %v}, timestamp: {_eq: %#v}},
+    limit: 1
+  ) {
+    id
+    timestamp
+    reason
+    detail
+    file_path
+    camera_id
+  }
+}
+`, camera.ID, timestamp.Format(time.RFC3339Nano))
+
+	recorderRecoveries := make([]model.RecorderRecovery, 0)
+	err = recorderRecoveryModelAndClient.Client().QueryAndExtract(query, "recorder_recovery", &recorderRecoveries)
+	if err != nil {
+		return model.RecorderRecovery{}, err
+	}
+
+	if len(recorderRecoveries) > 0 {
+		return recorderRecoveries[0], nil
+	}
+
+	recorderRecovery := model.NewRecorderRecoveryWithID(
+		iso8601.Time{Time: timestamp},
+		reason,
+		detail,
+		filePath,
+		camera.ID,
+	)
+
+	err = recorderRecoveryModelAndClient.Add(&recorderRecovery, &recorderRecoveries)
+	if err != nil {
+		return model.RecorderRecovery{}, err
+	}
+
+	if len(recorderRecoveries) != 1 {
+		return model.RecorderRecovery{}, fmt.Errorf("attempt to add RecorderRecovery should have returned exactly 1 RecorderRecovery")
+	}
+
+	return recorderRecoveries[0], nil
+}
+
+// AddSpriteSheet adds a sprite sheet (and an image for its tiles) for the event's video with the given id (the one
+// the tiles were made from); as for AddEventWithLocations, the file paths recorded are what locate returns (nil
+// records the local paths)
+func AddSpriteSheet(
+	application *application.Application,
+	event model.Event,
+	videoID int64,
+	spriteSheet sprite_sheet.SpriteSheet,
+	locate func(path string) string,
+) (model.SpriteSheet, error) {
+	if locate == nil {
+		locate = func(path string) string {
+			return path
+		}
+	}
+
+	imageSize, err := metadata.GetFileSize(spriteSheet.ImagePath)
+	if err != nil {
+		return model.SpriteSheet{}, err
+	}
+
+	image := model.NewImageWithID(
+		event.StartTimestamp,
+		imageSize,
+		locate(spriteSheet.ImagePath),
+		event.SourceCameraID,
+	)
+
+	newSpriteSheet := model.NewSpriteSheetWithIDs(
+		spriteSheet.Interval.Seconds(),
+		int64(spriteSheet.TileWidth),
+		int64(spriteSheet.TileHeight),
+		int64(spriteSheet.Columns),
+		int64(spriteSheet.Rows),
+		int64(spriteSheet.Tiles),
+		locate(spriteSheet.VTTPath),
+		image,
+		videoID,
+		event.ID,
+		event.SourceCameraID,
+	)
+
+	spriteSheetModelAndClient, err := application.GetModelAndClient("sprite_sheet")
+	if err != nil {
+		return model.SpriteSheet{}, err
+	}
+
+	spriteSheets := make([]model.SpriteSheet, 0)
+	err = spriteSheetModelAndClient.Add(&newSpriteSheet, &spriteSheets)
+	if err != nil {
+		return model.SpriteSheet{}, err
+	}
+
+	if len(spriteSheets) != 1 {
+		return model.SpriteSheet{}, fmt.Errorf("attempt to add SpriteSheet should have returned exactly 1 SpriteSheet")
+	}
+
+	return spriteSheets[0], nil
+}
+
+// GetSpriteSheets returns the sprite sheets (with the file paths of their images) of the events
+func GetSpriteSheets(
+	application *application.Application,
+	eventIDs []int64,
+) ([]model.SpriteSheet, error) {
+	if len(eventIDs) == 0 {
+		return []model.SpriteSheet{}, nil
+	}
+
+	spriteSheetModelAndClient, err := application.GetModelAndClient("sprite_sheet")
+	if err != nil {
+		return nil, err
+	}
+
+	rawEventIDs, err := json.Marshal(eventIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+{
+  sprite_sheet(
+    where: {event_id: {_in: %v}},
+    order_by: {id: asc}
+  ) {
+    id
+    interval_seconds
+    tile_width
+    tile_height
+    columns
+    rows
+    tiles
+    vtt_file_path
+    image_id
+    image {
+      id
+      file_path
+    }
+    video_id
+    event_id
+    camera_id
+  }
+}
+`, string(rawEventIDs))
+
+	spriteSheets := make([]model.SpriteSheet, 0)
+	err = spriteSheetModelAndClient.Client().QueryAndExtract(query, "sprite_sheet", &spriteSheets)
+	if err != nil {
+		return nil, err
+	}
+
+	return spriteSheets, nil
+}
+
+// getSetThumbnailMutation returns a mutation that points the event at another thumbnail image (and moves it on to
+// status) and deletes the one it had before
+func getSetThumbnailMutation(eventID int64, imageID int64, previousImageID int64, status string) (string, error) {
+	rawStatus, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`
+mutation {
+  update_event(where: {id: {_eq: %v}}, _set: {thumbnail_image_id: %v, status: %v}) {
+    affected_rows
+  }
+  delete_image(where: {id: {_eq: %v}}) {
+    affected_rows
+  }
+}
+`, eventID, imageID, string(rawStatus), previousImageID), nil
+}
+
+// SetThumbnail adds an image for the file at imagePath (taken at timestamp) as the event's thumbnail, moves the event
+// on to status and deletes the image it had before (the caller deals with its files); as for AddEventWithLocations,
+// the file path recorded is what locate returns (nil records the local path), and if the event can't be pointed at
+// the new image it's removed again
+func SetThumbnail(
+	application *application.Application,
+	event model.Event,
+	imagePath string,
+	timestamp iso8601.Time,
+	status string,
+	locate func(path string) string,
+) (model.Image, error) {
+	if locate == nil {
+		locate = func(path string) string {
+			return path
+		}
+	}
+
+	imageSize, err := metadata.GetFileSize(imagePath)
+	if err != nil {
+		return model.Image{}, err
+	}
+
+	imageModelAndClient, err := application.GetModelAndClient("image")
+	if err != nil {
+		return model.Image{}, err
+	}
+
+	newImage := model.NewImageWithID(
+		timestamp,
+		imageSize,
+		locate(imagePath),
+		event.SourceCameraID,
+	)
+
+	images := make([]model.Image, 0)
+	err = imageModelAndClient.Add(&newImage, &images)
+	if err != nil {
+		return model.Image{}, err
+	}
+
+	if len(images) != 1 {
+		return model.Image{}, fmt.Errorf("attempt to add Image should have returned exactly 1 Image")
+	}
+
+	image := images[0]
+
+	mutation, err := getSetThumbnailMutation(event.ID, image.ID, event.ThumbnailImageID, status)
+	if err == nil {
+		_, err = imageModelAndClient.Client().Mutate(mutation)
+	}
+
+	if err != nil {
+		unused := model.Image{ID: image.ID}
+		removed := make([]model.Image, 0)
+		_ = imageModelAndClient.Remove(&unused, &removed)
+		return model.Image{}, err
+	}
+
+	return image, nil
+}
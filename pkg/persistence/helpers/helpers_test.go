@@ -0,0 +1,120 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relvacode/iso8601"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestGetDeleteEventsMutation(t *testing.T) {
+	mutation, err := getDeleteEventsMutation([]model.Event{
+		{ID: 1, OriginalVideoID: 10, ProcessedVideoID: 11, ThumbnailImageID: 20},
+		{ID: 2, OriginalVideoID: 12, ThumbnailImageID: 21},
+	}, []model.SpriteSheet{
+		{ID: 30, ImageID: 22, EventID: 1},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, mutation, `delete_detection(where: {_or: [{event_id: {_in: [1,2]}}, {object: {event_id: {_in: [1,2]}}}]})`)
+	assert.Contains(t, mutation, `delete_video(where: {id: {_in: [10,11,12]}})`)
+	assert.Contains(t, mutation, `delete_sprite_sheet(where: {event_id: {_in: [1,2]}})`)
+	assert.Contains(t, mutation, `delete_image(where: {id: {_in: [20,21,22]}})`)
+
+	// everything that refers to a row goes before it
+	order := []string{
+		"delete_detection",
+		"delete_aggregated_detection",
+		"delete_object",
+		"delete_sprite_sheet",
+		"update_video",
+		"update_image",
+		"delete_event",
+		"delete_video",
+		"delete_image",
+	}
+
+	last := -1
+	for _, field := range order {
+		index := strings.Index(mutation, field+"(")
+		require.NotEqual(t, -1, index, field)
+		assert.Greater(t, index, last, field)
+		last = index
+	}
+}
+
+func TestGetMoveMediaMutation(t *testing.T) {
+	mutation, err := getMoveMediaMutation(
+		[]model.Video{
+			{ID: 10, FilePath: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway.mp4", Size: 1.5, Checksum: "abc123"},
+			{ID: 11, FilePath: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"},
+		},
+		[]model.Image{
+			{ID: 20, FilePath: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg"},
+		},
+		[]model.SpriteSheet{
+			{ID: 30, VTTFilePath: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt"},
+		},
+	)
+	require.NoError(t, err)
+
+	assert.Contains(t, mutation, `video_0: update_video(where: {id: {_eq: 10}}, _set: {file_path: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway.mp4", size: 1.5, checksum: "abc123"})`)
+	assert.Contains(t, mutation, `video_1: update_video(where: {id: {_eq: 11}}, _set: {file_path: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"})`)
+	assert.Contains(t, mutation, `image_0: update_image(where: {id: {_eq: 20}}, _set: {file_path: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg"})`)
+	assert.Contains(t, mutation, `sprite_sheet_0: update_sprite_sheet(where: {id: {_eq: 30}}, _set: {vtt_file_path: "/srv/archive/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt"})`)
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(mutation), "mutation {"))
+}
+
+func TestGetDropOriginalVideosMutation(t *testing.T) {
+	mutation, err := getDropOriginalVideosMutation([]model.Event{
+		{ID: 1, OriginalVideoID: 10, ProcessedVideoID: 11},
+		{ID: 2, OriginalVideoID: 12, ProcessedVideoID: 13},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, mutation, `event_0: update_event(where: {id: {_eq: 1}}, _set: {original_video_id: 11, processed_video_id: null})`)
+	assert.Contains(t, mutation, `video_0: delete_video(where: {id: {_eq: 10}})`)
+	assert.Contains(t, mutation, `event_1: update_event(where: {id: {_eq: 2}}, _set: {original_video_id: 13, processed_video_id: null})`)
+	assert.Contains(t, mutation, `video_1: delete_video(where: {id: {_eq: 12}})`)
+
+	// the event has to stop pointing at the video before it can go
+	assert.Less(t, strings.Index(mutation, "event_0: update_event("), strings.Index(mutation, "video_0: delete_video("))
+
+	// there has to be something to fall back on
+	_, err = getDropOriginalVideosMutation([]model.Event{{ID: 3, OriginalVideoID: 14}})
+	assert.Error(t, err)
+}
+
+func TestGetSetThumbnailMutation(t *testing.T) {
+	mutation, err := getSetThumbnailMutation(1, 21, 20, "needs tracking")
+	require.NoError(t, err)
+
+	assert.Contains(t, mutation, `update_event(where: {id: {_eq: 1}}, _set: {thumbnail_image_id: 21, status: "needs tracking"})`)
+	assert.Contains(t, mutation, `delete_image(where: {id: {_eq: 20}})`)
+
+	// the event has to stop pointing at the image before it can go
+	assert.Less(t, strings.Index(mutation, "update_event("), strings.Index(mutation, "delete_image("))
+}
+
+func TestGetReplaceRecordingGapMutation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	mutation := getReplaceRecordingGapMutation(
+		7,
+		[]model.RecordingGap{
+			model.NewRecordingGapWithID(iso8601.Time{Time: start}, iso8601.Time{Time: start.Add(time.Minute)}, 3),
+			model.NewRecordingGapWithID(iso8601.Time{Time: start.Add(time.Minute * 2)}, iso8601.Time{Time: start.Add(time.Minute * 3)}, 3),
+		},
+	)
+
+	assert.Contains(t, mutation, `delete_recording_gap(where: {id: {_eq: 7}})`)
+	assert.Contains(t, mutation, `insert_recording_gap(objects: [{start_timestamp: "2024-01-01T03:00:00Z", end_timestamp: "2024-01-01T03:01:00Z", camera_id: 3}, {start_timestamp: "2024-01-01T03:02:00Z", end_timestamp: "2024-01-01T03:03:00Z", camera_id: 3}])`)
+
+	// filled in completely
+	assert.Contains(t, getReplaceRecordingGapMutation(7, nil), `insert_recording_gap(objects: [])`)
+}
@@ -1,9 +1,10 @@
 package model
 
 type Camera struct {
-	ID        int64  `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	StreamURL string `json:"stream_url,omitempty"`
+	ID           int64  `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	StreamURL    string `json:"stream_url,omitempty"`
+	SubStreamURL string `json:"sub_stream_url,omitempty"`
 }
 
 func NewCamera(
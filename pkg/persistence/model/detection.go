@@ -0,0 +1,19 @@
+package model
+
+import (
+	"github.com/relvacode/iso8601"
+)
+
+// Detection is a single detection of an object in a frame of an event's video; it's written by the object task
+// worker (and isn't registered, as its geometry columns are left out, bar the bounding box as Postgres writes it)
+type Detection struct {
+	ID          int64        `json:"id,omitempty"`
+	Timestamp   iso8601.Time `json:"timestamp,omitempty"`
+	ClassID     int64        `json:"class_id,omitempty"`
+	ClassName   string       `json:"class_name,omitempty"`
+	Score       float64      `json:"score,omitempty"`
+	BoundingBox string       `json:"bounding_box,omitempty"` // e.g. ((10,20),(110,20),(110,70),(10,70),(10,20))
+	CameraID    int64        `json:"camera_id,omitempty"`
+	EventID     int64        `json:"event_id,omitempty"`
+	ObjectID    int64        `json:"object_id,omitempty"`
+}
@@ -10,6 +10,8 @@ type Event struct {
 	EndTimestamp     iso8601.Time `json:"end_timestamp,omitempty"`
 	OriginalVideoID  int64        `json:"original_video_id,omitempty"`
 	OriginalVideo    Video        `json:"original_video,omitempty"`
+	ProcessedVideoID int64        `json:"processed_video_id,omitempty"`
+	ProcessedVideo   Video        `json:"processed_video,omitempty"` // the sub-stream recording of the same period, if any
 	ThumbnailImageID int64        `json:"thumbnail_image_id,omitempty"`
 	ThumbnailImage   Image        `json:"thumbnail_image,omitempty"`
 	SourceCameraID   int64        `json:"source_camera_id,omitempty"`
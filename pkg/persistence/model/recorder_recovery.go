@@ -0,0 +1,32 @@
+package model
+
+import (
+	"github.com/relvacode/iso8601"
+)
+
+// RecorderRecovery is a time that a segment generator's watchdog restarted a camera's stalled recorder
+type RecorderRecovery struct {
+	ID        int64        `json:"id,omitempty"`
+	Timestamp iso8601.Time `json:"timestamp,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Detail    string       `json:"detail,omitempty"`
+	FilePath  string       `json:"file_path,omitempty"`
+	CameraID  int64        `json:"camera_id,omitempty"`
+	Camera    Camera       `json:"camera,omitempty"`
+}
+
+func NewRecorderRecoveryWithID(
+	timestamp iso8601.Time,
+	reason string,
+	detail string,
+	filePath string,
+	cameraID int64,
+) RecorderRecovery {
+	return RecorderRecovery{
+		Timestamp: timestamp,
+		Reason:    reason,
+		Detail:    detail,
+		FilePath:  filePath,
+		CameraID:  cameraID,
+	}
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"github.com/relvacode/iso8601"
+)
+
+// RecordingGap is a period during which a camera wasn't recording
+type RecordingGap struct {
+	ID             int64        `json:"id,omitempty"`
+	StartTimestamp iso8601.Time `json:"start_timestamp,omitempty"`
+	EndTimestamp   iso8601.Time `json:"end_timestamp,omitempty"`
+	CameraID       int64        `json:"camera_id,omitempty"`
+	Camera         Camera       `json:"camera,omitempty"`
+}
+
+func NewRecordingGap(
+	startTimestamp iso8601.Time,
+	endTimestamp iso8601.Time,
+	camera Camera,
+) RecordingGap {
+	return RecordingGap{
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		Camera:         camera,
+	}
+}
+
+func NewRecordingGapWithID(
+	startTimestamp iso8601.Time,
+	endTimestamp iso8601.Time,
+	cameraID int64,
+) RecordingGap {
+	return RecordingGap{
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		CameraID:       cameraID,
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+// SpriteSheet is a grid of tiles (one every IntervalSeconds of a video, left to right and then top to bottom) for
+// scrubbing through the video; Image is the tiles and the WebVTT file at VTTFilePath says which is for when
+type SpriteSheet struct {
+	ID              int64   `json:"id,omitempty"`
+	IntervalSeconds float64 `json:"interval_seconds,omitempty"`
+	TileWidth       int64   `json:"tile_width,omitempty"`
+	TileHeight      int64   `json:"tile_height,omitempty"`
+	Columns         int64   `json:"columns,omitempty"`
+	Rows            int64   `json:"rows,omitempty"`
+	Tiles           int64   `json:"tiles,omitempty"`
+	VTTFilePath     string  `json:"vtt_file_path,omitempty"`
+	ImageID         int64   `json:"image_id,omitempty"`
+	Image           Image   `json:"image,omitempty"`
+	VideoID         int64   `json:"video_id,omitempty"`
+	EventID         int64   `json:"event_id,omitempty"`
+	CameraID        int64   `json:"camera_id,omitempty"`
+}
+
+func NewSpriteSheetWithIDs(
+	intervalSeconds float64,
+	tileWidth int64,
+	tileHeight int64,
+	columns int64,
+	rows int64,
+	tiles int64,
+	vttFilePath string,
+	image Image,
+	videoID int64,
+	eventID int64,
+	cameraID int64,
+) SpriteSheet {
+	return SpriteSheet{
+		IntervalSeconds: intervalSeconds,
+		TileWidth:       tileWidth,
+		TileHeight:      tileHeight,
+		Columns:         columns,
+		Rows:            rows,
+		Tiles:           tiles,
+		VTTFilePath:     vttFilePath,
+		Image:           image,
+		VideoID:         videoID,
+		EventID:         eventID,
+		CameraID:        cameraID,
+	}
+}
@@ -10,6 +10,7 @@ type Video struct {
 	EndTimestamp   iso8601.Time `json:"end_timestamp,omitempty"`
 	Size           float64      `json:"size,omitempty"`
 	FilePath       string       `json:"file_path,omitempty"`
+	Checksum       string       `json:"checksum,omitempty"` // hex SHA-256 of the file as it was ingested
 	CameraID       int64        `json:"camera_id,omitempty"`
 	Camera         Camera       `json:"camera,omitempty"`
 }
@@ -19,6 +20,7 @@ func NewVideo(
 	endTimestamp iso8601.Time,
 	size float64,
 	filePath string,
+	checksum string,
 	camera Camera,
 ) Video {
 	return Video{
@@ -26,6 +28,7 @@ func NewVideo(
 		EndTimestamp:   endTimestamp,
 		Size:           size,
 		FilePath:       filePath,
+		Checksum:       checksum,
 		Camera:         camera,
 	}
 }
@@ -35,6 +38,7 @@ func NewVideoWithID(
 	endTimestamp iso8601.Time,
 	size float64,
 	filePath string,
+	checksum string,
 	cameraID int64,
 ) Video {
 	return Video{
@@ -42,6 +46,7 @@ func NewVideoWithID(
 		EndTimestamp:   endTimestamp,
 		Size:           size,
 		FilePath:       filePath,
+		Checksum:       checksum,
 		CameraID:       cameraID,
 	}
 }
@@ -2,8 +2,16 @@ package process
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,103 +34,348 @@ func RunCommand(executable string, arguments ...string) (string, string, error)
 	return stdout.String(), stderr.String(), err
 }
 
+type State string
+
+const (
+	StateStarting State = "starting"
+	StateRunning  State = "running"
+	StateBackoff  State = "backoff"
+	StateStopped  State = "stopped"
+)
+
+// DefaultJitter is the Jitter for Options that don't set one
+const DefaultJitter = 0.2
+
+type Options struct {
+	Env           []string      // extra "KEY=value" entries appended to the inherited environment
+	MinBackoff    time.Duration // delay before the first restart; doubles for each consecutive failure
+	MaxBackoff    time.Duration
+	Jitter        float64       // +/- fraction of the backoff to randomise by (so a fleet doesn't restart in lockstep); negative for none
+	StableAfter   time.Duration // a run at least this long resets the backoff
+	StopTimeout   time.Duration // how long to wait after SIGINT before resorting to SIGKILL
+	StderrLines   int           // how many recent lines of stderr to keep
+	OnStateChange func(Status)  // invoked (from the supervisor goroutine) on every state change
+}
+
+func (o Options) withDefaults() Options {
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Minute
+	}
+
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+
+	if o.Jitter == 0 {
+		o.Jitter = DefaultJitter
+	} else if o.Jitter < 0 {
+		o.Jitter = 0
+	}
+
+	if o.StableAfter <= 0 {
+		o.StableAfter = o.MaxBackoff
+	}
+
+	if o.StopTimeout <= 0 {
+		o.StopTimeout = time.Second * 5
+	}
+
+	if o.StderrLines <= 0 {
+		o.StderrLines = 100
+	}
+
+	return o
+}
+
+func (o Options) backoff(consecutiveFailures int64) time.Duration {
+	backoff := o.MinBackoff
+	for i := int64(1); i < consecutiveFailures && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > o.MaxBackoff {
+		backoff = o.MaxBackoff
+	}
+
+	if o.Jitter > 0 {
+		backoff += time.Duration((rand.Float64()*2 - 1) * o.Jitter * float64(backoff))
+	}
+
+	return backoff
+}
+
+type Status struct {
+	State               State     `json:"state"`
+	PID                 int       `json:"pid,omitempty"`
+	Restarts            int64     `json:"restarts"`
+	ConsecutiveFailures int64     `json:"consecutive_failures"`
+	StartedAt           time.Time `json:"started_at,omitempty"`
+	LastExitAt          time.Time `json:"last_exit_at,omitempty"`
+	LastExitCode        int       `json:"last_exit_code"` // -1 if it was killed by a signal or never started
+	LastExitReason      string    `json:"last_exit_reason,omitempty"`
+	NextStartAt         time.Time `json:"next_start_at,omitempty"`
+	RecentStderr        []string  `json:"recent_stderr,omitempty"`
+}
+
 type BackgroundProcess struct {
-	Cmd           *exec.Cmd
-	stop, stopped bool
+	mu            sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	executable    string
+	arguments     []string
+	options       Options
+	stderr        *RingBuffer
+	status        Status
+	cmd           *exec.Cmd
+	exited        chan struct{}
+	restartReason string
+}
+
+func (b *BackgroundProcess) Status() Status {
+	b.mu.Lock()
+	status := b.status
+	b.mu.Unlock()
+
+	status.RecentStderr = b.stderr.Lines()
+
+	return status
+}
+
+func (b *BackgroundProcess) setStatus(update func(status *Status)) {
+	b.mu.Lock()
+	update(&b.status)
+	b.mu.Unlock()
+
+	if b.options.OnStateChange != nil {
+		b.options.OnStateChange(b.Status())
+	}
 }
 
 func (b *BackgroundProcess) Stop() {
-	b.stop = true
+	b.cancel()
+	<-b.done
+}
 
-	if b.Cmd != nil && b.Cmd.Process != nil {
-		_ = b.Cmd.Process.Kill()
+// Restart interrupts the current invocation (killing it if it hasn't exited after StopTimeout) so that the supervisor
+// starts another; it's a no-op unless the process is running
+func (b *BackgroundProcess) Restart(reason string) {
+	b.mu.Lock()
+	cmd := b.cmd
+	exited := b.exited
+	if cmd == nil || cmd.Process == nil || b.status.State != StateRunning {
+		b.mu.Unlock()
+		return
 	}
+	b.restartReason = reason
+	b.mu.Unlock()
 
-	for {
-		if b.stopped {
-			break
+	log.Printf("restarting %v because %v", cmd.Args, reason)
+
+	_ = cmd.Process.Signal(os.Interrupt)
+
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(b.options.StopTimeout):
+			log.Printf("warning: %v still running %v after interrupt; killing", cmd.Args, b.options.StopTimeout)
+			_ = cmd.Process.Kill()
 		}
+	}()
+}
 
-		time.Sleep(time.Millisecond * 100)
+func getExitCodeAndReason(err error) (int, string) {
+	if err == nil {
+		return 0, "exited cleanly"
 	}
+
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), exitErr.String()
+	}
+
+	return -1, err.Error()
 }
 
-func RunBackgroundProcess(executable string, arguments ...string) (process *BackgroundProcess, startErr error) {
-	log.Printf("RunBackgroundProcess; running: %v %v", executable, arguments)
+// run starts the process, restarting it (with backoff) whenever it exits until the context is cancelled; the
+// outcome of the very first start is sent to started
+func (b *BackgroundProcess) run(started chan error) {
+	defer close(b.done)
 
-	process = &BackgroundProcess{
-		stop:    false,
-		stopped: false,
-	}
+	first := true
 
-	go func() {
-		for {
-			if process.stop {
-				log.Printf("stopping %v %v", executable, arguments)
+	for {
+		cmd := exec.CommandContext(b.ctx, b.executable, b.arguments...)
 
-				_ = process.Cmd.Process.Kill()
+		if len(b.options.Env) > 0 {
+			cmd.Env = append(os.Environ(), b.options.Env...)
+		}
 
-				process.stopped = true
+		cmd.Stdout = io.Discard
+		cmd.Stderr = b.stderr
 
-				log.Printf("stopped %v %v", executable, arguments)
+		// ask nicely first (so that e.g. ffmpeg can finish writing its current file)
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+		cmd.WaitDelay = b.options.StopTimeout
 
-				break
+		b.setStatus(func(status *Status) {
+			if !first {
+				status.Restarts++
+			}
+			status.State = StateStarting
+			status.NextStartAt = time.Time{}
+		})
+
+		log.Printf("starting %v", cmd.Args)
+
+		exited := make(chan struct{})
+
+		b.mu.Lock()
+		b.cmd = cmd
+		b.exited = exited
+		b.mu.Unlock()
+
+		startedAt := time.Now()
+		err := cmd.Start()
+
+		if first {
+			first = false
+			started <- err
+			if err != nil {
+				b.setStatus(func(status *Status) {
+					status.State = StateStopped
+					status.LastExitCode, status.LastExitReason = -1, fmt.Sprintf("failed to start: %v", err)
+				})
+				return
 			}
+		}
 
-			log.Printf("creating %v %v", executable, arguments)
+		stable := false
+
+		if err != nil {
+			log.Printf("failed to Start %v because: %v", cmd.Args, err)
+
+			b.setStatus(func(status *Status) {
+				status.PID = 0
+				status.LastExitAt = time.Now()
+				status.LastExitCode, status.LastExitReason = -1, fmt.Sprintf("failed to start: %v", err)
+			})
+		} else {
+			b.setStatus(func(status *Status) {
+				status.State = StateRunning
+				status.PID = cmd.Process.Pid
+				status.StartedAt = startedAt
+			})
+
+			err = cmd.Wait()
+			close(exited)
+
+			b.mu.Lock()
+			restartReason := b.restartReason
+			b.restartReason = ""
+			b.mu.Unlock()
+
+			select {
+			case <-b.ctx.Done():
+				log.Printf("stopped %v", cmd.Args)
+				b.setStatus(func(status *Status) {
+					status.State = StateStopped
+					status.PID = 0
+					status.LastExitAt = time.Now()
+					status.LastExitCode, status.LastExitReason = getExitCodeAndReason(err)
+					status.LastExitReason = fmt.Sprintf("stopped (%v)", status.LastExitReason)
+				})
+				return
+			default:
+			}
 
-			process.Cmd = exec.Command(
-				executable,
-				arguments...,
+			exitCode, exitReason := getExitCodeAndReason(err)
+			if restartReason != "" {
+				exitReason = fmt.Sprintf("restarted because %v (%v)", restartReason, exitReason)
+			}
+
+			stable = time.Since(startedAt) >= b.options.StableAfter
+
+			log.Printf("%v %v after %v; recent stderr:\n%v",
+				cmd.Args, exitReason, time.Since(startedAt), strings.Join(b.stderr.Lines(), "\n"),
 			)
 
-			stdout := new(bytes.Buffer)
-			stderr := new(bytes.Buffer)
+			b.setStatus(func(status *Status) {
+				status.PID = 0
+				status.LastExitAt = time.Now()
+				status.LastExitCode, status.LastExitReason = exitCode, exitReason
+			})
+		}
 
-			process.Cmd.Stdout = stdout
-			process.Cmd.Stderr = stderr
+		var backoff time.Duration
 
-			log.Printf("starting %v", process.Cmd.Args)
+		b.setStatus(func(status *Status) {
+			if stable {
+				status.ConsecutiveFailures = 0
+			}
+			status.ConsecutiveFailures++
 
-			startErr = process.Cmd.Start()
-			if startErr != nil {
-				log.Printf("failed to Start because: %v; trying again...; stdout=%+v, stderr=%#+v",
-					startErr, stdout.String(), stderr.String(),
-				)
+			backoff = b.options.backoff(status.ConsecutiveFailures)
 
-				_ = process.Cmd.Process.Kill()
+			status.State = StateBackoff
+			status.NextStartAt = time.Now().Add(backoff)
+		})
 
-				time.Sleep(time.Second)
+		log.Printf("restarting %v in %v", b.executable, backoff)
 
-				continue
-			}
+		select {
+		case <-b.ctx.Done():
+			b.setStatus(func(status *Status) {
+				status.State = StateStopped
+				status.NextStartAt = time.Time{}
+			})
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
 
-			log.Printf("waiting for %+v", process.Cmd.Process)
+func RunBackgroundProcess(executable string, arguments ...string) (process *BackgroundProcess, startErr error) {
+	return RunBackgroundProcessWithOptions(Options{}, executable, arguments...)
+}
 
-			waitErr := process.Cmd.Wait()
-			if waitErr != nil {
-				if !process.stop {
-					log.Printf("failed to Wait because: %v; trying again...; stdout=%+v, stderr=%#+v",
-						waitErr, stdout.String(), stderr.String(),
-					)
-				}
-				_ = process.Cmd.Process.Kill()
+// RunBackgroundProcessWithEnv is RunBackgroundProcess with extra "KEY=value" entries appended to the inherited environment
+func RunBackgroundProcessWithEnv(env []string, executable string, arguments ...string) (process *BackgroundProcess, startErr error) {
+	return RunBackgroundProcessWithOptions(Options{Env: env}, executable, arguments...)
+}
 
-				time.Sleep(time.Second)
+// RunBackgroundProcessWithOptions starts the process and supervises it (restarting it whenever it exits) until Stop
+// is called; an error is only returned if the process couldn't be started at all the first time
+func RunBackgroundProcessWithOptions(options Options, executable string, arguments ...string) (*BackgroundProcess, error) {
+	log.Printf("RunBackgroundProcess; running: %v %v (env=%v)", executable, arguments, options.Env)
 
-				continue
-			}
-		}
-	}()
+	options = options.withDefaults()
 
-	for {
-		if process == nil || process.Cmd == nil || process.Cmd.Process == nil {
-			time.Sleep(time.Millisecond * 100)
+	process := &BackgroundProcess{
+		done:       make(chan struct{}),
+		executable: executable,
+		arguments:  arguments,
+		options:    options,
+		stderr:     NewRingBuffer(options.StderrLines),
+	}
 
-			continue
-		}
+	process.ctx, process.cancel = context.WithCancel(context.Background())
+
+	started := make(chan error, 1)
+
+	go process.run(started)
 
-		break
+	err := <-started
+	if err != nil {
+		process.cancel()
+		<-process.done
+		return nil, fmt.Errorf("failed to start %v: %v", executable, err)
 	}
 
 	return process, nil
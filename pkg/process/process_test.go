@@ -24,15 +24,16 @@ func TestRunBackgroundProcess(t *testing.T) {
 
 	assert.NotNil(t, process)
 
-	assert.NotNil(t, process.Cmd.Process)
+	pid1 := process.Status().PID
 
-	pid1 := process.Cmd.Process.Pid
+	assert.NotZero(t, pid1)
 
 	time.Sleep(time.Second * 5)
 
-	pid2 := process.Cmd.Process.Pid
+	status := process.Status()
 
-	assert.NotEqual(t, pid1, pid2)
+	assert.Greater(t, status.Restarts, int64(0))
+	assert.NotEqual(t, pid1, status.PID)
 
 	after := time.Now()
 
@@ -42,3 +43,96 @@ func TestRunBackgroundProcess(t *testing.T) {
 
 	process.Stop()
 }
+
+func TestRunBackgroundProcess_Failing(t *testing.T) {
+	statuses := make(chan Status, 1024)
+
+	process, err := RunBackgroundProcessWithOptions(
+		Options{
+			MinBackoff: time.Millisecond * 100,
+			MaxBackoff: time.Millisecond * 400,
+			OnStateChange: func(status Status) {
+				statuses <- status
+			},
+		},
+		"sh", "-c", "echo some error >&2; exit 3",
+	)
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	process.Stop()
+
+	status := process.Status()
+
+	assert.Equal(t, StateStopped, status.State)
+	assert.Greater(t, status.Restarts, int64(1))
+	assert.Equal(t, 3, status.LastExitCode)
+	assert.Contains(t, status.RecentStderr, "some error")
+
+	sawBackoff := false
+	for len(statuses) > 0 {
+		if (<-statuses).State == StateBackoff {
+			sawBackoff = true
+		}
+	}
+
+	assert.True(t, sawBackoff)
+}
+
+func TestRunBackgroundProcess_NotFound(t *testing.T) {
+	process, err := RunBackgroundProcess("some-executable-that-does-not-exist")
+	require.Error(t, err)
+	assert.Nil(t, process)
+}
+
+func TestOptions_backoff(t *testing.T) {
+	options := Options{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Second * 10,
+		Jitter:     -1,
+	}.withDefaults()
+
+	assert.Equal(t, time.Second, options.backoff(1))
+	assert.Equal(t, time.Second*2, options.backoff(2))
+	assert.Equal(t, time.Second*8, options.backoff(4))
+	assert.Equal(t, time.Second*10, options.backoff(5))
+	assert.Equal(t, time.Second*10, options.backoff(100))
+
+	options.Jitter = 0.5
+
+	for i := 0; i < 100; i++ {
+		backoff := options.backoff(1)
+		assert.GreaterOrEqual(t, backoff, time.Millisecond*500)
+		assert.LessOrEqual(t, backoff, time.Millisecond*1500)
+	}
+
+	// jittered unless it's turned off
+	assert.Equal(t, DefaultJitter, Options{}.withDefaults().Jitter)
+}
+
+func TestBackgroundProcess_Restart(t *testing.T) {
+	process, err := RunBackgroundProcessWithOptions(
+		Options{
+			MinBackoff: time.Millisecond * 100,
+		},
+		"sleep", "60",
+	)
+	require.NoError(t, err)
+	defer process.Stop()
+
+	time.Sleep(time.Millisecond * 100)
+
+	pid1 := process.Status().PID
+
+	process.Restart("testing")
+
+	time.Sleep(time.Millisecond * 500)
+
+	status := process.Status()
+
+	assert.Equal(t, StateRunning, status.State)
+	assert.Equal(t, int64(1), status.Restarts)
+	assert.NotEqual(t, pid1, status.PID)
+	assert.Contains(t, status.LastExitReason, "restarted because testing")
+}
@@ -0,0 +1,89 @@
+package process
+
+import (
+	"bytes"
+	"sync"
+)
+
+const maxLineLength = 4096
+
+// RingBuffer is an io.Writer that keeps only the most recent lines written to it
+type RingBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	if size < 1 {
+		size = 1
+	}
+
+	r := RingBuffer{
+		lines: make([]string, size),
+	}
+
+	return &r
+}
+
+func (r *RingBuffer) push(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+
+	r.lines[r.next] = string(line)
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.partial, p...)
+
+	for {
+		// ffmpeg uses \r to redraw its progress line, so treat it as a line ending too
+		i := bytes.IndexAny(data, "\r\n")
+		if i == -1 {
+			break
+		}
+
+		r.push(data[:i])
+		data = data[i+1:]
+	}
+
+	if len(data) > maxLineLength {
+		r.push(data[:maxLineLength])
+		data = data[:0]
+	}
+
+	r.partial = append([]byte{}, data...)
+
+	return len(p), nil
+}
+
+// Lines returns the buffered lines (oldest first), including any trailing partial line
+func (r *RingBuffer) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lines := make([]string, 0)
+
+	if r.full {
+		lines = append(lines, r.lines[r.next:]...)
+	}
+
+	lines = append(lines, r.lines[:r.next]...)
+
+	if len(r.partial) > 0 {
+		lines = append(lines, string(r.partial))
+	}
+
+	return lines
+}
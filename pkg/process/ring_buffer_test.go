@@ -0,0 +1,22 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer(3)
+
+	assert.Equal(t, []string{}, r.Lines())
+
+	_, _ = r.Write([]byte("one\ntwo\n"))
+	assert.Equal(t, []string{"one", "two"}, r.Lines())
+
+	_, _ = r.Write([]byte("three\r\nfour\rfi"))
+	assert.Equal(t, []string{"two", "three", "four", "fi"}, r.Lines())
+
+	_, _ = r.Write([]byte("ve\n"))
+	assert.Equal(t, []string{"three", "four", "five"}, r.Lines())
+}
@@ -1,70 +1,157 @@
 package event_receiver
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"sync"
+	"time"
 
-	"github.com/initialed85/glue/pkg/network"
-
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+	"github.com/initialed85/cameranator/pkg/segments/transport"
 )
 
+// how long to remember handled envelopes for (so that retries whose ack was lost aren't handled twice); this is only
+// to save the handler the work, as it has to cope with redeliveries anyway (e.g. after a restart)
+const dedupeWindow = time.Hour
+
+// Handler is invoked for each event received; the event is only acknowledged once it returns nil, so it mustn't do so
+// until the event is persisted, and it must be idempotent, as an event can be delivered more than once
+type Handler func(event segment_generator.Event) error
+
+// RecoveryHandler is Handler for the Recoveries that the segment generators send along with their events
+type RecoveryHandler func(recovery segment_generator.Recovery) error
+
 type EventReceiver struct {
-	mu       sync.Mutex
-	receiver *network.Receiver
-	handler  func(segment_generator.Event)
+	mu                sync.Mutex
+	consumer          transport.Consumer
+	handler           Handler
+	recoveryHandler   RecoveryHandler
+	handledAtBySource map[string]map[int64]time.Time
+	handling          map[string]struct{} // by source and sequence
+	lastPrunedAt      time.Time
+}
+
+// NewEventReceiver returns an EventReceiver listening for UDP on the given port
+func NewEventReceiver(port int64, handler Handler) (*EventReceiver, error) {
+	return NewEventReceiverWithTransport(fmt.Sprintf("udp://0.0.0.0:%v", port), handler, nil)
 }
 
-func NewEventReceiver(port int64, handler func(event segment_generator.Event)) (*EventReceiver, error) {
-	interfaceName, err := network.GetDefaultInterfaceName()
+// NewEventReceiverWithTransport returns an EventReceiver consuming from the given transport URL (see
+// transport.NewConsumer); Recoveries are passed to recoveryHandler (or just logged, if it's nil)
+func NewEventReceiverWithTransport(transportURL string, handler Handler, recoveryHandler RecoveryHandler) (*EventReceiver, error) {
+	r := EventReceiver{
+		handler:           handler,
+		recoveryHandler:   recoveryHandler,
+		handledAtBySource: make(map[string]map[int64]time.Time),
+		handling:          make(map[string]struct{}),
+	}
+
+	var err error
+
+	r.consumer, err = transport.NewConsumer(transportURL, r.handle)
 	if err != nil {
 		return nil, err
 	}
 
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("0.0.0.0:%v", port))
+	return &r, nil
+}
+
+func (r *EventReceiver) pruneHandledAt() {
+	if time.Since(r.lastPrunedAt) < time.Minute {
+		return
+	}
+
+	for source, handledAtBySequence := range r.handledAtBySource {
+		for sequence, handledAt := range handledAtBySequence {
+			if time.Since(handledAt) > dedupeWindow {
+				delete(handledAtBySequence, sequence)
+			}
+		}
 
-	r := EventReceiver{
-		receiver: network.NewReceiver(
-			addr,
-			interfaceName,
-		),
-		handler: handler,
+		if len(handledAtBySequence) == 0 {
+			delete(r.handledAtBySource, source)
+		}
 	}
 
-	return &r, nil
+	r.lastPrunedAt = time.Now()
 }
 
-func (r *EventReceiver) callback(srcAddr *net.UDPAddr, dstAddr *net.UDPAddr, data []byte) {
-	log.Printf("EventReceiver.callback; received: srcAddr=%#+v, dstAddr=%#+v, data=%#+v)", srcAddr.String(), dstAddr.String(), string(data))
+func getKey(envelope outbox.Envelope) string {
+	return fmt.Sprintf("%v/%v", envelope.Source, envelope.Sequence)
+}
+
+func (r *EventReceiver) invokeHandler(envelope outbox.Envelope) error {
+	if envelope.Recovery == nil {
+		log.Printf("EventReceiver.handle; complete, invoking handler: event=%#+v", envelope.Event)
+		return r.handler(envelope.Event)
+	}
+
+	if r.recoveryHandler == nil {
+		log.Printf("EventReceiver.handle; no recovery handler, ignoring: recovery=%#+v", *envelope.Recovery)
+		return nil
+	}
+
+	log.Printf("EventReceiver.handle; complete, invoking recovery handler: recovery=%#+v", *envelope.Recovery)
+	return r.recoveryHandler(*envelope.Recovery)
+}
+
+func (r *EventReceiver) handle(envelope outbox.Envelope) error {
+	// a bare Event from a sender that predates the outbox; nothing to deduplicate
+	if envelope.Source == "" {
+		return r.invokeHandler(envelope)
+	}
+
+	key := getKey(envelope)
+
+	r.mu.Lock()
+
+	r.pruneHandledAt()
+
+	handledAtBySequence, ok := r.handledAtBySource[envelope.Source]
+	if !ok {
+		handledAtBySequence = make(map[int64]time.Time)
+		r.handledAtBySource[envelope.Source] = handledAtBySequence
+	}
+
+	_, ok = handledAtBySequence[envelope.Sequence]
+	if ok {
+		r.mu.Unlock()
+		log.Printf("EventReceiver.handle; %v already handled, acknowledging again", key)
+		return nil
+	}
+
+	// a retry that turned up while the first attempt is still being handled; it'll be acknowledged once that's done
+	_, ok = r.handling[key]
+	if ok {
+		r.mu.Unlock()
+		return fmt.Errorf("%v is still being handled", key)
+	}
+
+	r.handling[key] = struct{}{}
+
+	r.mu.Unlock()
+
+	err := r.invokeHandler(envelope)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	event := segment_generator.Event{}
-	err := json.Unmarshal(data, &event)
+	delete(r.handling, key)
+
 	if err != nil {
-		log.Printf("warning: attempt to unmarshal %#+v raised %v", string(data), err)
-		return
+		return err
 	}
 
-	log.Printf("EventReceiver.callback; complete, invoking handler: event=%#+v", event)
-	r.handler(event)
+	handledAtBySequence[envelope.Sequence] = time.Now()
+
+	return nil
 }
 
 func (r *EventReceiver) Open() error {
-	err := r.receiver.RegisterCallback(r.callback)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return r.receiver.Open()
+	return r.consumer.Open()
 }
 
 func (r *EventReceiver) Close() {
-	_ = r.receiver.UnregisterCallback(r.callback)
-
-	r.receiver.Close()
+	r.consumer.Close()
 }
@@ -2,6 +2,7 @@ package event_receiver
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -12,17 +13,20 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+	"github.com/initialed85/cameranator/pkg/segments/transport"
 	"github.com/initialed85/cameranator/pkg/utils"
 )
 
 func TestNewEventReceiver_All(t *testing.T) {
-	events := make([]segment_generator.Event, 0)
+	events := make(chan segment_generator.Event, 16)
 
 	eventReceiver, err := NewEventReceiver(
 		6291,
-		func(event segment_generator.Event) {
-			events = append(events, event)
+		func(event segment_generator.Event) error {
+			events <- event
+			return nil
 		},
 	)
 	require.NoError(t, err)
@@ -58,11 +62,182 @@ func TestNewEventReceiver_All(t *testing.T) {
 	err = sender.Send(testEventJSON)
 	require.NoError(t, err)
 
-	time.Sleep(time.Millisecond * 1000)
+	select {
+	case event := <-events:
+		assert.Equal(t, testEvent, event)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for event")
+	}
+}
+
+func TestNewEventReceiver_Acknowledged(t *testing.T) {
+	events := make(chan segment_generator.Event, 16)
 
-	assert.Equal(
-		t,
-		testEvent,
-		events[len(events)-1],
+	eventReceiver, err := NewEventReceiver(
+		6292,
+		func(event segment_generator.Event) error {
+			events <- event
+			return nil
+		},
 	)
+	require.NoError(t, err)
+	err = eventReceiver.Open()
+	defer eventReceiver.Close()
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+
+	acks := make(chan outbox.Ack, 16)
+
+	publisher := transport.NewUDPPublisher("localhost:6292", func(ack outbox.Ack) {
+		acks <- ack
+	})
+	err = publisher.Open()
+	defer publisher.Close()
+	require.NoError(t, err)
+
+	envelope := outbox.Envelope{
+		Source:   "testing",
+		Sequence: 1,
+		Event: segment_generator.Event{
+			CameraName: "Driveway",
+			VideoPath:  "../../../test_data/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+		},
+	}
+
+	// the second is a retry (e.g. the first ack was lost) and should only be acknowledged
+	for i := 0; i < 2; i++ {
+		err = publisher.Publish(envelope)
+		require.NoError(t, err)
+
+		select {
+		case ack := <-acks:
+			assert.Equal(t, outbox.Ack{Source: "testing", Sequence: 1}, ack)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for ack")
+		}
+	}
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, envelope.Event, <-events)
+}
+
+func TestNewEventReceiver_NotAcknowledgedOnError(t *testing.T) {
+	events := make(chan segment_generator.Event, 16)
+	failures := 1
+
+	eventReceiver, err := NewEventReceiver(
+		6293,
+		func(event segment_generator.Event) error {
+			if failures > 0 {
+				failures--
+				return fmt.Errorf("failed to persist")
+			}
+
+			events <- event
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	err = eventReceiver.Open()
+	defer eventReceiver.Close()
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+
+	acks := make(chan outbox.Ack, 16)
+
+	publisher := transport.NewUDPPublisher("localhost:6293", func(ack outbox.Ack) {
+		acks <- ack
+	})
+	err = publisher.Open()
+	defer publisher.Close()
+	require.NoError(t, err)
+
+	envelope := outbox.Envelope{
+		Source:   "testing",
+		Sequence: 1,
+		Event: segment_generator.Event{
+			CameraName: "Driveway",
+			VideoPath:  "../../../test_data/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+		},
+	}
+
+	err = publisher.Publish(envelope)
+	require.NoError(t, err)
+
+	select {
+	case ack := <-acks:
+		require.Fail(t, "unexpected ack", "%#+v", ack)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	assert.Len(t, events, 0)
+
+	// the retry (as the outbox would send) is handled and acknowledged
+	err = publisher.Publish(envelope)
+	require.NoError(t, err)
+
+	select {
+	case ack := <-acks:
+		assert.Equal(t, outbox.Ack{Source: "testing", Sequence: 1}, ack)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for ack")
+	}
+
+	assert.Len(t, events, 1)
+}
+
+func TestNewEventReceiverWithTransport_Recovery(t *testing.T) {
+	events := make(chan segment_generator.Event, 16)
+	recoveries := make(chan segment_generator.Recovery, 16)
+
+	eventReceiver, err := NewEventReceiverWithTransport(
+		"udp://0.0.0.0:6294",
+		func(event segment_generator.Event) error {
+			events <- event
+			return nil
+		},
+		func(recovery segment_generator.Recovery) error {
+			recoveries <- recovery
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	err = eventReceiver.Open()
+	defer eventReceiver.Close()
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+
+	acks := make(chan outbox.Ack, 16)
+
+	publisher := transport.NewUDPPublisher("localhost:6294", func(ack outbox.Ack) {
+		acks <- ack
+	})
+	err = publisher.Open()
+	defer publisher.Close()
+	require.NoError(t, err)
+
+	err = publisher.Publish(outbox.Envelope{
+		Source:   "testing",
+		Sequence: 1,
+		Recovery: &segment_generator.Recovery{
+			CameraName: "Driveway",
+			Reason:     segment_generator.StallReasonNoNewSegment,
+			Detail:     "no new segment for 45s",
+		},
+	})
+	require.NoError(t, err)
+
+	select {
+	case ack := <-acks:
+		assert.Equal(t, outbox.Ack{Source: "testing", Sequence: 1}, ack)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for ack")
+	}
+
+	require.Len(t, recoveries, 1)
+	recovery := <-recoveries
+	assert.Equal(t, "Driveway", recovery.CameraName)
+	assert.Equal(t, "no new segment for 45s", recovery.Detail)
+
+	assert.Len(t, events, 0)
 }
@@ -0,0 +1,275 @@
+package hash_chain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GenesisHash is the PreviousHash of the first Entry in a chain
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Entry is a link in a camera's chain; Hash covers everything but the Signature (see Entry.ComputeHash) and so
+// (through PreviousHash) every entry before it, which means a Signature vouches for the entire chain up to and
+// including its entry
+type Entry struct {
+	Sequence       int64     `json:"sequence"`
+	CameraName     string    `json:"camera_name"`
+	FileName       string    `json:"file_name"` // just the base name (so the footage can be moved / archived)
+	Checksum       string    `json:"checksum"`  // hex SHA-256 of the segment
+	StartTimestamp time.Time `json:"start_timestamp"`
+	EndTimestamp   time.Time `json:"end_timestamp"`
+	PreviousHash   string    `json:"previous_hash"`
+	Hash           string    `json:"hash"`
+	Signature      string    `json:"signature,omitempty"` // hex Ed25519 signature of Hash (only on some entries)
+}
+
+// ComputeHash returns the hex SHA-256 of the entry's fields (one per line, timestamps as UTC RFC3339 with
+// nanoseconds), excluding Hash and Signature
+func (e Entry) ComputeHash() string {
+	hash := sha256.Sum256([]byte(strings.Join(
+		[]string{
+			fmt.Sprintf("%v", e.Sequence),
+			e.CameraName,
+			e.FileName,
+			e.Checksum,
+			e.StartTimestamp.UTC().Format(time.RFC3339Nano),
+			e.EndTimestamp.UTC().Format(time.RFC3339Nano),
+			e.PreviousHash,
+		},
+		"\n",
+	)))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// ReadEntries returns the entries in the chain at path (one JSON object per line); a final line that's incomplete
+// (i.e. the writer died part way through appending it) is ignored, but any other line that can't be parsed is an error
+func ReadEntries(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0)
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entry := Entry{}
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+
+			return nil, fmt.Errorf("line %v of %#+v is not an entry: %v", i+1, path, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Chain appends to a camera's chain on disk, signing an entry whenever signInterval (of footage) has passed since the
+// last signed entry
+type Chain struct {
+	mu           sync.Mutex
+	path         string
+	privateKey   ed25519.PrivateKey
+	signInterval time.Duration
+	last         *Entry
+	lastSigned   *Entry
+}
+
+// truncateIncompleteLine drops anything after the last newline (i.e. an entry that was only partly appended) so that
+// the next entry doesn't end up glued to it
+func truncateIncompleteLine(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+
+	return os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1))
+}
+
+// OpenChain opens (or starts) the chain at path; it refuses to carry on from a chain that doesn't verify (so that a
+// broken link is never papered over by signing after it)
+func OpenChain(path string, privateKey ed25519.PrivateKey, signInterval time.Duration) (*Chain, error) {
+	c := Chain{
+		path:         path,
+		privateKey:   privateKey,
+		signInterval: signInterval,
+	}
+
+	err := truncateIncompleteLine(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ReadEntries(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		result := Verify(entries, privateKey.Public().(ed25519.PublicKey))
+		if result.Break != nil {
+			return nil, fmt.Errorf("%#+v is broken: %v", path, result.Break)
+		}
+
+		c.last = &entries[len(entries)-1]
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Signature != "" {
+				c.lastSigned = &entries[i]
+				break
+			}
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *Chain) Append(
+	cameraName string,
+	filePath string,
+	checksum string,
+	startTimestamp time.Time,
+	endTimestamp time.Time,
+) (Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := Entry{
+		Sequence:       1,
+		CameraName:     cameraName,
+		FileName:       filepath.Base(filePath),
+		Checksum:       checksum,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		PreviousHash:   GenesisHash,
+	}
+
+	if c.last != nil {
+		entry.Sequence = c.last.Sequence + 1
+		entry.PreviousHash = c.last.Hash
+	}
+
+	entry.Hash = entry.ComputeHash()
+
+	if c.lastSigned == nil || entry.EndTimestamp.Sub(c.lastSigned.EndTimestamp) >= c.signInterval {
+		hash, _ := hex.DecodeString(entry.Hash)
+		entry.Signature = hex.EncodeToString(ed25519.Sign(c.privateKey, hash))
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	c.last = &entry
+	if entry.Signature != "" {
+		c.lastSigned = &entry
+	}
+
+	return entry, nil
+}
+
+// Chains keeps a chain per camera, as path/<camera name>.jsonl
+type Chains struct {
+	mu           sync.Mutex
+	path         string
+	privateKey   ed25519.PrivateKey
+	signInterval time.Duration
+	chainByName  map[string]*Chain
+}
+
+func NewChains(path string, privateKey ed25519.PrivateKey, signInterval time.Duration) *Chains {
+	c := Chains{
+		path:         path,
+		privateKey:   privateKey,
+		signInterval: signInterval,
+		chainByName:  make(map[string]*Chain),
+	}
+
+	return &c
+}
+
+func (c *Chains) getChain(cameraName string) (*Chain, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chain, ok := c.chainByName[cameraName]
+	if ok {
+		return chain, nil
+	}
+
+	chain, err := OpenChain(filepath.Join(c.path, fmt.Sprintf("%v.jsonl", cameraName)), c.privateKey, c.signInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	c.chainByName[cameraName] = chain
+
+	return chain, nil
+}
+
+func (c *Chains) Append(
+	cameraName string,
+	filePath string,
+	checksum string,
+	startTimestamp time.Time,
+	endTimestamp time.Time,
+) (Entry, error) {
+	chain, err := c.getChain(cameraName)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return chain.Append(cameraName, filePath, checksum, startTimestamp, endTimestamp)
+}
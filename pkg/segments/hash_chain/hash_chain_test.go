@@ -0,0 +1,194 @@
+package hash_chain
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAppend(t *testing.T, chain *Chain, start time.Time, count int) []Entry {
+	entries := make([]Entry, 0)
+
+	for i := 0; i < count; i++ {
+		entry, err := chain.Append(
+			"Driveway",
+			filepath.Join("/srv/segments", start.Format("Segment_2006-01-02T15:04:05_Driveway.mp4")),
+			strings.Repeat("a", 64),
+			start,
+			start.Add(time.Minute),
+		)
+		require.NoError(t, err)
+
+		entries = append(entries, entry)
+		start = start.Add(time.Minute)
+	}
+
+	return entries
+}
+
+func TestChain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Driveway.jsonl")
+
+	privateKey, err := LoadOrCreatePrivateKey(filepath.Join(dir, "chain.key"))
+	require.NoError(t, err)
+
+	publicKey, err := LoadPublicKey(filepath.Join(dir, "chain.key.pub"))
+	require.NoError(t, err)
+
+	chain, err := OpenChain(path, privateKey, time.Minute*5)
+	require.NoError(t, err)
+
+	start := time.Date(2020, 12, 25, 8, 45, 0, 0, time.UTC)
+	appended := testAppend(t, chain, start, 7)
+
+	// signed on the first entry and then every 5 minutes (of footage) after that
+	assert.NotEmpty(t, appended[0].Signature)
+	assert.Empty(t, appended[1].Signature)
+	assert.NotEmpty(t, appended[5].Signature)
+	assert.Empty(t, appended[6].Signature)
+
+	entries, err := ReadEntries(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7, len(entries))
+
+	result := Verify(entries, publicKey)
+	assert.Nil(t, result.Break)
+	assert.Equal(t, 6, result.SignedThrough)
+
+	// carries on from where it left off after being reopened
+	chain, err = OpenChain(path, privateKey, time.Minute*5)
+	require.NoError(t, err)
+
+	appended = testAppend(t, chain, start.Add(time.Minute*7), 1)
+	assert.Equal(t, int64(8), appended[0].Sequence)
+	assert.Equal(t, entries[6].Hash, appended[0].PreviousHash)
+
+	entries, err = ReadEntries(path)
+	require.NoError(t, err)
+	assert.Nil(t, Verify(entries, publicKey).Break)
+}
+
+func TestChain_IncompleteLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Driveway.jsonl")
+
+	privateKey, err := LoadOrCreatePrivateKey(filepath.Join(dir, "chain.key"))
+	require.NoError(t, err)
+
+	chain, err := OpenChain(path, privateKey, time.Minute*5)
+	require.NoError(t, err)
+
+	start := time.Date(2020, 12, 25, 8, 45, 0, 0, time.UTC)
+	testAppend(t, chain, start, 2)
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"sequence":3,"camera_na`)
+	require.NoError(t, err)
+	_ = file.Close()
+
+	entries, err := ReadEntries(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	chain, err = OpenChain(path, privateKey, time.Minute*5)
+	require.NoError(t, err)
+	testAppend(t, chain, start.Add(time.Minute*2), 1)
+
+	entries, err = ReadEntries(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Nil(t, Verify(entries, privateKey.Public().(ed25519.PublicKey)).Break)
+}
+
+func TestVerify_Broken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Driveway.jsonl")
+
+	privateKey, err := LoadOrCreatePrivateKey(filepath.Join(dir, "chain.key"))
+	require.NoError(t, err)
+
+	otherPrivateKey, err := LoadOrCreatePrivateKey(filepath.Join(dir, "other.key"))
+	require.NoError(t, err)
+
+	chain, err := OpenChain(path, privateKey, time.Minute*5)
+	require.NoError(t, err)
+
+	testAppend(t, chain, time.Date(2020, 12, 25, 8, 45, 0, 0, time.UTC), 4)
+
+	entries, err := ReadEntries(path)
+	require.NoError(t, err)
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	tampered := append([]Entry{}, entries...)
+	tampered[2].Checksum = strings.Repeat("b", 64)
+	result := Verify(tampered, publicKey)
+	require.NotNil(t, result.Break)
+	assert.Equal(t, 2, result.Break.Index)
+	assert.Contains(t, result.Break.Reason, "hash")
+
+	// re-hashing the tampered entry just moves the break along to the next one
+	tampered[2].Hash = tampered[2].ComputeHash()
+	result = Verify(tampered, publicKey)
+	require.NotNil(t, result.Break)
+	assert.Equal(t, 3, result.Break.Index)
+	assert.Contains(t, result.Break.Reason, "previous hash")
+
+	removed := append(append([]Entry{}, entries[:1]...), entries[2:]...)
+	result = Verify(removed, publicKey)
+	require.NotNil(t, result.Break)
+	assert.Equal(t, 1, result.Break.Index)
+	assert.Contains(t, result.Break.Reason, "sequence")
+
+	result = Verify(entries, otherPrivateKey.Public().(ed25519.PublicKey))
+	require.NotNil(t, result.Break)
+	assert.Equal(t, 0, result.Break.Index)
+	assert.Contains(t, result.Break.Reason, "signature")
+
+	// and nothing more gets added to a broken chain
+	err = os.WriteFile(path, nil, 0644)
+	require.NoError(t, err)
+	for _, entry := range tampered {
+		line, _ := json.Marshal(entry)
+		file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		_, _ = file.Write(append(line, '\n'))
+		_ = file.Close()
+	}
+
+	_, err = OpenChain(path, privateKey, time.Minute*5)
+	assert.Error(t, err)
+}
+
+func TestFindClip(t *testing.T) {
+	dir := t.TempDir()
+	clipPath := filepath.Join(dir, "Segment_2020-12-25T08:45:00_Driveway.mp4")
+
+	err := os.WriteFile(clipPath, []byte("hello world"), 0644)
+	require.NoError(t, err)
+
+	entries := []Entry{
+		{FileName: "Segment_2020-12-25T08:44:00_Driveway.mp4", Checksum: strings.Repeat("a", 64)},
+		{FileName: "Segment_2020-12-25T08:45:00_Driveway.mp4", Checksum: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+	}
+
+	index, ok, err := FindClip(entries, clipPath)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index)
+
+	err = os.WriteFile(clipPath, []byte("hello w0rld"), 0644)
+	require.NoError(t, err)
+
+	_, ok, err = FindClip(entries, clipPath)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
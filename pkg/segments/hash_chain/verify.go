@@ -0,0 +1,98 @@
+package hash_chain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+)
+
+// Break is the first link in a chain that doesn't hold
+type Break struct {
+	Sequence int64 // of the entry (as recorded, which may itself be what's wrong)
+	Index    int   // of the entry in the chain (0-based)
+	Reason   string
+}
+
+func (b *Break) String() string {
+	return fmt.Sprintf("entry %v (sequence %v): %v", b.Index+1, b.Sequence, b.Reason)
+}
+
+type Result struct {
+	Entries       int
+	Break         *Break // nil if the whole chain holds
+	SignedThrough int    // how many entries (from the start) are covered by a valid signature
+}
+
+// Verify checks each entry of a chain in order (sequence, link to the previous entry, hash, signature) and stops at
+// the first that doesn't hold; entries after the last signature hold together but aren't vouched for (yet)
+func Verify(entries []Entry, publicKey ed25519.PublicKey) Result {
+	result := Result{
+		Entries: len(entries),
+	}
+
+	previousHash := GenesisHash
+	expectedSequence := int64(1)
+
+	for i, entry := range entries {
+		fail := func(reason string, args ...interface{}) Result {
+			result.Break = &Break{
+				Sequence: entry.Sequence,
+				Index:    i,
+				Reason:   fmt.Sprintf(reason, args...),
+			}
+
+			return result
+		}
+
+		if entry.Sequence != expectedSequence {
+			return fail("expected sequence %v", expectedSequence)
+		}
+
+		if entry.PreviousHash != previousHash {
+			return fail("previous hash is %v but the entry before it has hash %v", entry.PreviousHash, previousHash)
+		}
+
+		hash := entry.ComputeHash()
+		if entry.Hash != hash {
+			return fail("hash is %v but its contents hash to %v", entry.Hash, hash)
+		}
+
+		if entry.Signature != "" {
+			rawHash, _ := hex.DecodeString(entry.Hash)
+
+			signature, err := hex.DecodeString(entry.Signature)
+			if err != nil || !ed25519.Verify(publicKey, rawHash, signature) {
+				return fail("signature is not valid for this key")
+			}
+
+			result.SignedThrough = i + 1
+		}
+
+		previousHash = entry.Hash
+		expectedSequence++
+	}
+
+	return result
+}
+
+// FindClip returns the index of the entry for the clip at path (matched by checksum and file name); ok is false if
+// the chain has no entry for it, which means either it isn't from this camera or it's been altered
+func FindClip(entries []Entry, path string) (index int, ok bool, err error) {
+	checksum, err := metadata.GetFileChecksum(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	fileName := filepath.Base(path)
+
+	for i, entry := range entries {
+		if entry.Checksum == checksum && entry.FileName == fileName {
+			return i, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
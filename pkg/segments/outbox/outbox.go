@@ -0,0 +1,361 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/initialed85/glue/pkg/worker"
+
+	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+)
+
+// Envelope wraps an Event (or, if Recovery is set, a Recovery instead) with enough to acknowledge it (and to discard
+// duplicates); Source identifies the outbox and Sequence increases monotonically within it (across restarts)
+type Envelope struct {
+	Source   string                      `json:"source"`
+	Sequence int64                       `json:"sequence"`
+	Event    segment_generator.Event     `json:"event"`
+	Recovery *segment_generator.Recovery `json:"recovery,omitempty"`
+}
+
+// Ack is sent back by the receiver once it has handled an Envelope
+type Ack struct {
+	Source   string `json:"source"`
+	Sequence int64  `json:"sequence"`
+}
+
+type state struct {
+	Source       string `json:"source"`
+	LastSequence int64  `json:"last_sequence"`
+}
+
+/*
+
+on disk:
+
+path/
+  state.json                  {"source": "...", "last_sequence": 1234}
+  pending/
+    00000000000000001233.json an Envelope that's yet to be acknowledged
+    00000000000000001234.json
+
+*/
+
+// Outbox persists each Event before it's published and keeps retrying it (including after a restart) until it's
+// acknowledged
+type Outbox struct {
+	mu              sync.Mutex
+	path            string
+	state           state
+	pending         map[int64]Envelope
+	lastPublishedAt map[int64]time.Time
+	publish         func(Envelope) error
+	retryInterval   time.Duration
+	scheduledWorker *worker.ScheduledWorker
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tempPath := path + ".tmp"
+
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+func newSource() (string, error) {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%v-%v", hostname, hex.EncodeToString(b)), nil
+}
+
+// NewOutbox opens (or creates) the outbox at path, loading anything still pending from a previous run; publish is
+// invoked for each Envelope (and again every retryInterval until it's acknowledged)
+func NewOutbox(
+	path string,
+	publish func(Envelope) error,
+	retryInterval time.Duration,
+) (*Outbox, error) {
+	o := Outbox{
+		path:            path,
+		pending:         make(map[int64]Envelope),
+		lastPublishedAt: make(map[int64]time.Time),
+		publish:         publish,
+		retryInterval:   retryInterval,
+	}
+
+	err := os.MkdirAll(o.pendingPath(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.load()
+	if err != nil {
+		return nil, err
+	}
+
+	o.scheduledWorker = worker.NewScheduledWorker(
+		func() {},
+		o.work,
+		func() {},
+		retryInterval,
+	)
+
+	return &o, nil
+}
+
+func (o *Outbox) statePath() string {
+	return filepath.Join(o.path, "state.json")
+}
+
+func (o *Outbox) pendingPath() string {
+	return filepath.Join(o.path, "pending")
+}
+
+func (o *Outbox) envelopePath(sequence int64) string {
+	return filepath.Join(o.pendingPath(), fmt.Sprintf("%020d.json", sequence))
+}
+
+func (o *Outbox) saveState() error {
+	b, err := json.Marshal(o.state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(o.statePath(), b)
+}
+
+func (o *Outbox) load() error {
+	b, err := os.ReadFile(o.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		o.state.Source, err = newSource()
+		if err != nil {
+			return err
+		}
+
+		err = o.saveState()
+		if err != nil {
+			return err
+		}
+	} else {
+		err = json.Unmarshal(b, &o.state)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal %#+v: %v", o.statePath(), err)
+		}
+	}
+
+	entries, err := os.ReadDir(o.pendingPath())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		sequence, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			log.Printf("warning: ignoring unexpected file %#+v in outbox", name)
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(o.pendingPath(), name))
+		if err != nil {
+			return err
+		}
+
+		envelope := Envelope{}
+		err = json.Unmarshal(b, &envelope)
+		if err != nil {
+			log.Printf("warning: discarding unreadable %#+v from outbox because %v", name, err)
+			_ = os.Remove(filepath.Join(o.pendingPath(), name))
+			continue
+		}
+
+		o.pending[sequence] = envelope
+
+		// in case the state file is behind the envelopes (e.g. it was lost)
+		if sequence > o.state.LastSequence {
+			o.state.LastSequence = sequence
+		}
+	}
+
+	if len(o.pending) > 0 {
+		log.Printf("outbox %#+v has %v unacknowledged events from a previous run", o.path, len(o.pending))
+	}
+
+	return nil
+}
+
+func (o *Outbox) Source() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.state.Source
+}
+
+// Pending returns the number of Envelopes that have yet to be acknowledged
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pending)
+}
+
+func (o *Outbox) tryPublish(envelope Envelope) {
+	o.mu.Lock()
+	o.lastPublishedAt[envelope.Sequence] = time.Now()
+	o.mu.Unlock()
+
+	err := o.publish(envelope)
+	if err != nil {
+		log.Printf("warning: failed to publish %v/%v (will retry) because %v", envelope.Source, envelope.Sequence, err)
+	}
+}
+
+// Add persists the Event (assigning it the next sequence number) and then attempts to publish it
+func (o *Outbox) Add(event segment_generator.Event) (Envelope, error) {
+	return o.add(Envelope{Event: event})
+}
+
+// AddRecovery is Add for a Recovery (so that it gets recorded as reliably as the segments are)
+func (o *Outbox) AddRecovery(recovery segment_generator.Recovery) (Envelope, error) {
+	return o.add(Envelope{Recovery: &recovery})
+}
+
+func (o *Outbox) add(envelope Envelope) (Envelope, error) {
+	o.mu.Lock()
+
+	o.state.LastSequence++
+
+	envelope.Source = o.state.Source
+	envelope.Sequence = o.state.LastSequence
+
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		o.mu.Unlock()
+		return Envelope{}, err
+	}
+
+	err = o.saveState()
+	if err != nil {
+		o.mu.Unlock()
+		return Envelope{}, err
+	}
+
+	err = writeFileAtomically(o.envelopePath(envelope.Sequence), b)
+	if err != nil {
+		o.mu.Unlock()
+		return Envelope{}, err
+	}
+
+	o.pending[envelope.Sequence] = envelope
+
+	o.mu.Unlock()
+
+	o.tryPublish(envelope)
+
+	return envelope, nil
+}
+
+// Ack removes an acknowledged Envelope; acks for another outbox or for unknown (e.g. already acknowledged)
+// sequences are ignored
+func (o *Outbox) Ack(ack Ack) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if ack.Source != o.state.Source {
+		return nil
+	}
+
+	_, ok := o.pending[ack.Sequence]
+	if !ok {
+		return nil
+	}
+
+	err := os.Remove(o.envelopePath(ack.Sequence))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(o.pending, ack.Sequence)
+	delete(o.lastPublishedAt, ack.Sequence)
+
+	return nil
+}
+
+func (o *Outbox) work() {
+	o.mu.Lock()
+
+	due := make([]Envelope, 0)
+	for sequence, envelope := range o.pending {
+		if time.Since(o.lastPublishedAt[sequence]) < o.retryInterval {
+			continue
+		}
+
+		due = append(due, envelope)
+	}
+
+	o.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Sequence < due[j].Sequence
+	})
+
+	for _, envelope := range due {
+		log.Printf("outbox; retrying unacknowledged %v/%v", envelope.Source, envelope.Sequence)
+		o.tryPublish(envelope)
+	}
+}
+
+func (o *Outbox) Start() {
+	o.scheduledWorker.Start()
+}
+
+func (o *Outbox) Stop() {
+	o.scheduledWorker.Stop()
+}
@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+)
+
+type publisher struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+}
+
+func (p *publisher) publish(envelope Envelope) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.envelopes = append(p.envelopes, envelope)
+
+	return nil
+}
+
+func (p *publisher) get() []Envelope {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Envelope{}, p.envelopes...)
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+
+	p1 := &publisher{}
+
+	o1, err := NewOutbox(dir, p1.publish, time.Hour)
+	require.NoError(t, err)
+
+	source := o1.Source()
+	assert.NotEmpty(t, source)
+
+	envelope1, err := o1.Add(segment_generator.Event{CameraName: "Driveway", VideoPath: "a.mp4"})
+	require.NoError(t, err)
+	envelope2, err := o1.Add(segment_generator.Event{CameraName: "Driveway", VideoPath: "b.mp4"})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), envelope1.Sequence)
+	assert.Equal(t, int64(2), envelope2.Sequence)
+	assert.Equal(t, []Envelope{envelope1, envelope2}, p1.get())
+	assert.Equal(t, 2, o1.Pending())
+
+	require.NoError(t, o1.Ack(Ack{Source: source, Sequence: 1}))
+	require.NoError(t, o1.Ack(Ack{Source: "someone-else", Sequence: 2}))
+	require.NoError(t, o1.Ack(Ack{Source: source, Sequence: 1234}))
+	assert.Equal(t, 1, o1.Pending())
+
+	// as if after a restart
+	p2 := &publisher{}
+
+	o2, err := NewOutbox(dir, p2.publish, time.Millisecond*100)
+	require.NoError(t, err)
+
+	assert.Equal(t, source, o2.Source())
+	assert.Equal(t, 1, o2.Pending())
+
+	o2.Start()
+	defer o2.Stop()
+
+	time.Sleep(time.Millisecond * 250)
+
+	envelopes := p2.get()
+	require.GreaterOrEqual(t, len(envelopes), 2) // retried until acknowledged
+	assert.Equal(t, envelope2, envelopes[0])
+
+	require.NoError(t, o2.Ack(Ack{Source: source, Sequence: 2}))
+	assert.Equal(t, 0, o2.Pending())
+
+	envelope3, err := o2.Add(segment_generator.Event{CameraName: "Driveway", VideoPath: "c.mp4"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), envelope3.Sequence)
+}
+
+func TestOutbox_AddRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	p1 := &publisher{}
+
+	o1, err := NewOutbox(dir, p1.publish, time.Hour)
+	require.NoError(t, err)
+
+	recovery := segment_generator.Recovery{
+		CameraName: "Driveway",
+		Reason:     segment_generator.StallReasonNotGrowing,
+		Detail:     "segment hasn't grown for 30s",
+		Path:       "a.mp4",
+		Timestamp:  time.Date(2020, 12, 25, 8, 45, 4, 0, time.UTC),
+	}
+
+	envelope, err := o1.AddRecovery(recovery)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), envelope.Sequence)
+	require.NotNil(t, envelope.Recovery)
+	assert.Equal(t, recovery, *envelope.Recovery)
+
+	// and it survives a restart like an Event does
+	p2 := &publisher{}
+
+	o2, err := NewOutbox(dir, p2.publish, time.Millisecond*100)
+	require.NoError(t, err)
+
+	o2.Start()
+	defer o2.Stop()
+
+	time.Sleep(time.Millisecond * 250)
+
+	envelopes := p2.get()
+	require.GreaterOrEqual(t, len(envelopes), 1)
+	require.NotNil(t, envelopes[0].Recovery)
+	assert.Equal(t, recovery.Reason, envelopes[0].Recovery.Reason)
+	assert.True(t, recovery.Timestamp.Equal(envelopes[0].Recovery.Timestamp))
+}
@@ -0,0 +1,104 @@
+package segment_generator
+
+import (
+	"log"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+)
+
+const (
+	settleInterval = time.Second      // a segment is finished once it's gone this long without growing
+	settleTimeout  = time.Second * 15 // give up waiting (and take it as it is) after this long
+	settlePoll     = time.Millisecond * 250
+)
+
+// waitForSettle waits until the file at path hasn't grown for settleInterval (counting from lastGrewAt, which may
+// come from write events); it returns false if it was still growing at settleTimeout
+func waitForSettle(path string, lastGrewAt time.Time) bool {
+	deadline := time.Now().Add(settleTimeout)
+
+	lastSize, _ := metadata.GetFileSize(path)
+
+	for {
+		if time.Since(lastGrewAt) >= settleInterval {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(settlePoll)
+
+		size, err := metadata.GetFileSize(path)
+		if err == nil && size != lastSize {
+			lastSize = size
+			lastGrewAt = time.Now()
+		}
+	}
+}
+
+// finaliseInFlight closes the segments that are still being written; it's for once the recorders have exited, as
+// (until they're restarted, if they are) no new segments will come along to close them the usual way
+func (s *SegmentGenerator) finaliseInFlight() {
+	// the sub-stream first, so that the main stream segment finds it waiting in the pairer
+	s.finaliseSubStreamInFlight()
+	s.finaliseMainInFlight()
+}
+
+// finaliseMainInFlight is finaliseInFlight for the main stream only (i.e. when only its recorder has exited)
+func (s *SegmentGenerator) finaliseMainInFlight() {
+	s.mu.Lock()
+	path := s.lastCreatedPath
+	createdTimestamp := s.lastFileCreatedTimestamp
+	lastWriteTimestamp := s.lastWriteTimestamp
+	// claimed, so that a segment created by a restarted recorder doesn't close it again
+	s.lastCreatedPath = ""
+	s.mu.Unlock()
+
+	if path == "" {
+		return
+	}
+
+	if !waitForSettle(path, lastWriteTimestamp) {
+		log.Printf("warning: %#+v is still growing; finalising it anyway", path)
+	}
+
+	log.Printf("finaliseInFlight; %#+v closed", path)
+
+	s.closeSegment(path, createdTimestamp)
+}
+
+// finaliseSubStreamInFlight is finaliseInFlight for the sub-stream only (i.e. when only its recorder has exited)
+func (s *SegmentGenerator) finaliseSubStreamInFlight() {
+	s.mu.Lock()
+	subStreamPath := s.lastSubStreamCreatedPath
+	s.lastSubStreamCreatedPath = ""
+	s.mu.Unlock()
+
+	if subStreamPath == "" {
+		return
+	}
+
+	if !waitForSettle(subStreamPath, time.Now()) {
+		log.Printf("warning: %#+v is still growing; finalising it anyway", subStreamPath)
+	}
+
+	log.Printf("finaliseInFlight; %#+v closed", subStreamPath)
+
+	s.closeSubStreamSegment(subStreamPath)
+}
+
+// flushUnpaired completes every segment still waiting for its counterpart (i.e. none will be coming)
+func (s *SegmentGenerator) flushUnpaired() {
+	s.mu.Lock()
+	pairer := s.pairer
+	s.mu.Unlock()
+
+	if pairer == nil {
+		return
+	}
+
+	s.completeUnpaired(pairer.flush())
+}
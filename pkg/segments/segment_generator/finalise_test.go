@@ -0,0 +1,124 @@
+package segment_generator
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/filesystem"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+)
+
+// getMPEGTS returns 1s of (timestamps only) 30 fps MPEG-TS video
+func getMPEGTS() []byte {
+	data := make([]byte, 0)
+
+	for i := uint64(0); i < 30; i++ {
+		pts := i * 3000
+
+		packet := make([]byte, 188)
+		for j := range packet {
+			packet[j] = 0xff
+		}
+
+		copy(packet, []byte{
+			0x47, 0x41, 0x00, 0x10, // sync, payload unit start + pid 0x100, payload only
+			0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05, // video PES header with a PTS
+			0x21 | byte(pts>>29&0x0e), byte(pts >> 22), byte(pts>>14) | 0x01, byte(pts >> 7), byte(pts<<1) | 0x01,
+		})
+
+		data = append(data, packet...)
+	}
+
+	return data
+}
+
+func TestSegmentGenerator_finaliseInFlight(t *testing.T) {
+	dir := t.TempDir()
+
+	template, err := segment_template.NewTemplate(
+		segment_template.WithExtension(segment_template.DefaultPattern, ".ts"),
+		"Driveway",
+		time.UTC,
+	)
+	require.NoError(t, err)
+
+	mu := sync.Mutex{}
+	events := make([]Event, 0)
+
+	s := NewSegmentGenerator(
+		Feed{CameraName: "Driveway", Duration: 2, Format: "mpegts"},
+		func(event Event) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		},
+		nil,
+	)
+	s.template = template
+
+	path := filepath.Join(dir, "Segment_2020-12-25T08:45:04_Driveway.ts")
+	require.NoError(t, os.WriteFile(path, getMPEGTS(), 0644))
+
+	s.onFileCreate(filesystem.File{Name: path, Size: 1})
+
+	before := time.Now()
+	s.finaliseInFlight()
+
+	// waited for the file to go a while without growing
+	assert.GreaterOrEqual(t, time.Since(before), settleInterval)
+
+	mu.Lock()
+	require.Len(t, events, 1)
+	assert.Equal(t, path, events[0].VideoPath)
+	assert.Equal(t, time.Second, events[0].VideoEndTimestamp.Sub(events[0].VideoStartTimestamp.Time))
+	assert.NotEmpty(t, events[0].VideoChecksum)
+	mu.Unlock()
+
+	// a segment from a restarted recorder doesn't close the finalised one again
+	nextPath := filepath.Join(dir, "Segment_2020-12-25T08:45:10_Driveway.ts")
+	require.NoError(t, os.WriteFile(nextPath, getMPEGTS(), 0644))
+
+	s.onFileCreate(filesystem.File{Name: nextPath, Size: 1})
+
+	mu.Lock()
+	assert.Len(t, events, 1)
+	mu.Unlock()
+
+	// which is itself finalised (once) when that recorder exits
+	s.finaliseInFlight()
+	s.finaliseInFlight()
+
+	mu.Lock()
+	require.Len(t, events, 2)
+	assert.Equal(t, nextPath, events[1].VideoPath)
+	mu.Unlock()
+}
+
+func TestWaitForSettle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Segment_2020-12-25T08:45:04_Driveway.ts")
+	require.NoError(t, os.WriteFile(path, []byte("some"), 0644))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 4; i++ {
+			time.Sleep(settlePoll * 2)
+			file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			_, _ = file.WriteString("more")
+			_ = file.Close()
+		}
+	}()
+
+	before := time.Now()
+	assert.True(t, waitForSettle(path, time.Now()))
+	<-done
+
+	// it kept waiting while the file grew
+	assert.GreaterOrEqual(t, time.Since(before), settlePoll*8+settleInterval)
+}
@@ -0,0 +1,144 @@
+package segment_generator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const pairerInterval = time.Second
+
+type closedSegment struct {
+	path     string
+	start    time.Time
+	closedAt time.Time
+}
+
+// segmentPair is a main stream segment and (if it turned up in time) the sub-stream segment covering the same period
+type segmentPair struct {
+	path          string
+	subStreamPath string
+}
+
+// segmentPairer matches closed main stream segments with closed sub-stream segments; each stream is recorded by its own
+// ffmpeg, and while both roll over at the same clock times, each is cut on its own stream's keyframes (and closes in
+// its own time), so they're matched by the closest start within tolerance and a segment waits up to timeout for its
+// counterpart
+type segmentPairer struct {
+	mu         sync.Mutex
+	tolerance  time.Duration
+	timeout    time.Duration
+	mains      []closedSegment
+	subStreams []closedSegment
+}
+
+func newSegmentPairer(tolerance time.Duration, timeout time.Duration) *segmentPairer {
+	p := segmentPairer{
+		tolerance:  tolerance,
+		timeout:    timeout,
+		mains:      make([]closedSegment, 0),
+		subStreams: make([]closedSegment, 0),
+	}
+
+	return &p
+}
+
+// take removes and returns the segment closest to start (if there's one within tolerance)
+func (p *segmentPairer) take(segments *[]closedSegment, start time.Time) (closedSegment, bool) {
+	closest := -1
+	closestDifference := time.Duration(0)
+
+	for i, segment := range *segments {
+		difference := segment.start.Sub(start)
+		if difference < 0 {
+			difference = -difference
+		}
+
+		if difference > p.tolerance {
+			continue
+		}
+
+		if closest == -1 || difference < closestDifference {
+			closest = i
+			closestDifference = difference
+		}
+	}
+
+	if closest == -1 {
+		return closedSegment{}, false
+	}
+
+	segment := (*segments)[closest]
+	*segments = append((*segments)[:closest], (*segments)[closest+1:]...)
+
+	return segment, true
+}
+
+func (p *segmentPairer) addMain(path string, start time.Time, now time.Time) (segmentPair, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subStream, ok := p.take(&p.subStreams, start)
+	if !ok {
+		p.mains = append(p.mains, closedSegment{path: path, start: start, closedAt: now})
+		return segmentPair{}, false
+	}
+
+	return segmentPair{path: path, subStreamPath: subStream.path}, true
+}
+
+func (p *segmentPairer) addSubStream(path string, start time.Time, now time.Time) (segmentPair, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	main, ok := p.take(&p.mains, start)
+	if !ok {
+		p.subStreams = append(p.subStreams, closedSegment{path: path, start: start, closedAt: now})
+		return segmentPair{}, false
+	}
+
+	return segmentPair{path: main.path, subStreamPath: path}, true
+}
+
+// expire gives up on counterparts that haven't turned up within timeout; main stream segments are returned unpaired
+// (in the order they started) and the paths of sub-stream segments with nothing to pair with are returned as dropped
+func (p *segmentPairer) expire(now time.Time) (pairs []segmentPair, dropped []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pairs = make([]segmentPair, 0)
+	dropped = make([]string, 0)
+
+	sort.Slice(p.mains, func(i, j int) bool {
+		return p.mains[i].start.Before(p.mains[j].start)
+	})
+
+	mains := make([]closedSegment, 0)
+	for _, main := range p.mains {
+		if now.Sub(main.closedAt) < p.timeout {
+			mains = append(mains, main)
+			continue
+		}
+
+		pairs = append(pairs, segmentPair{path: main.path})
+	}
+	p.mains = mains
+
+	subStreams := make([]closedSegment, 0)
+	for _, subStream := range p.subStreams {
+		if now.Sub(subStream.closedAt) < p.timeout {
+			subStreams = append(subStreams, subStream)
+			continue
+		}
+
+		dropped = append(dropped, subStream.path)
+	}
+	p.subStreams = subStreams
+
+	return pairs, dropped
+}
+
+// flush gives up on every counterpart that's yet to turn up (see expire)
+func (p *segmentPairer) flush() (pairs []segmentPair, dropped []string) {
+	return p.expire(time.Now().Add(p.timeout))
+}
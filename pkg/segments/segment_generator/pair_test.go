@@ -0,0 +1,50 @@
+package segment_generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentPairer(t *testing.T) {
+	p := newSegmentPairer(time.Second*30, time.Second*30)
+
+	start := time.Date(2024, 4, 1, 5, 27, 0, 0, time.UTC)
+	now := start.Add(time.Minute)
+
+	// main first
+	_, ok := p.addMain("main_1.mp4", start, now)
+	assert.False(t, ok)
+
+	pair, ok := p.addSubStream("sub_1.mp4", start.Add(time.Second*2), now)
+	assert.True(t, ok)
+	assert.Equal(t, segmentPair{path: "main_1.mp4", subStreamPath: "sub_1.mp4"}, pair)
+
+	// sub-stream first
+	_, ok = p.addSubStream("sub_2.mp4", start.Add(time.Minute-time.Second), now.Add(time.Minute))
+	assert.False(t, ok)
+
+	pair, ok = p.addMain("main_2.mp4", start.Add(time.Minute), now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, segmentPair{path: "main_2.mp4", subStreamPath: "sub_2.mp4"}, pair)
+
+	// too far apart to be a pair
+	_, ok = p.addMain("main_3.mp4", start.Add(time.Minute*2), now.Add(time.Minute*2))
+	assert.False(t, ok)
+
+	_, ok = p.addSubStream("sub_4.mp4", start.Add(time.Minute*3), now.Add(time.Minute*2))
+	assert.False(t, ok)
+
+	pairs, dropped := p.expire(now.Add(time.Minute*2 + time.Second*29))
+	assert.Len(t, pairs, 0)
+	assert.Len(t, dropped, 0)
+
+	pairs, dropped = p.expire(now.Add(time.Minute*2 + time.Second*30))
+	assert.Equal(t, []segmentPair{{path: "main_3.mp4"}}, pairs)
+	assert.Equal(t, []string{"sub_4.mp4"}, dropped)
+
+	pairs, dropped = p.expire(now.Add(time.Hour))
+	assert.Len(t, pairs, 0)
+	assert.Len(t, dropped, 0)
+}
@@ -3,24 +3,28 @@ package segment_generator
 import (
 	"fmt"
 	"log"
-	"regexp"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/initialed85/glue/pkg/worker"
 	"github.com/relvacode/iso8601"
 
 	"github.com/initialed85/cameranator/pkg/filesystem"
 	"github.com/initialed85/cameranator/pkg/media/metadata"
 	"github.com/initialed85/cameranator/pkg/media/segment_recorder"
+	"github.com/initialed85/cameranator/pkg/media/segment_repairer"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
 	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
 	"github.com/initialed85/cameranator/pkg/process"
-	"github.com/initialed85/cameranator/pkg/utils"
 )
 
 type Event struct {
 	CameraName          string
 	VideoPath           string
+	SubStreamVideoPath  string // empty unless the feed has a SubStreamURL (and the sub-stream segment turned up)
+	VideoChecksum       string // hex SHA-256 of the video as it was recorded (so the processor can tell if it changed since)
 	ImagePath           string
 	VideoStartTimestamp iso8601.Time
 	VideoEndTimestamp   iso8601.Time
@@ -29,142 +33,543 @@ type Event struct {
 
 type Feed struct {
 	NetCamURL       string
+	SubStreamURL    string // optional low-res stream, recorded alongside for detection and previews
 	DestinationPath string
 	CameraName      string
 	Duration        int
+	Timezone        string // IANA name (e.g. "Australia/Perth"); empty means the host's local timezone
+	Format          string // fmp4, mpegts or mp4 (see segment_recorder.Format); empty means fmp4
+	WatcherBackend  string // auto, inotify or polling (see filesystem.Backend); empty means auto
+}
+
+type Status struct {
+	CameraName           string          `json:"camera_name"`
+	IsLive               bool            `json:"is_live"`
+	Recoveries           int64           `json:"recoveries"`
+	RecentRecoveries     []Recovery      `json:"recent_recoveries"`
+	LastCreatedPath      string          `json:"last_created_path,omitempty"`
+	LastCreatedTimestamp time.Time       `json:"last_created_timestamp"`
+	Process              process.Status  `json:"process"`
+	SubStreamProcess     *process.Status `json:"sub_stream_process,omitempty"`
 }
 
 type SegmentGenerator struct {
-	feed                 Feed
-	completeFn           func(event Event)
-	mu                   sync.Mutex
-	backgroundProcess    *process.BackgroundProcess
-	watcher              *filesystem.Watcher
-	lastCreatedPath      string
-	lastCreatedTimestamp time.Time
+	feed                              Feed
+	completeFn                        func(event Event)
+	recoveryFn                        func(recovery Recovery)
+	mu                                sync.Mutex
+	backgroundProcess                 *process.BackgroundProcess
+	subStreamBackgroundProcess        *process.BackgroundProcess
+	watcher                           *filesystem.Watcher
+	watchdog                          *worker.ScheduledWorker
+	watchdogDone                      chan struct{}
+	template                          *segment_template.Template
+	subStreamTemplate                 *segment_template.Template
+	subStreamWatcher                  *filesystem.Watcher
+	pairer                            *segmentPairer
+	pairerWorker                      *worker.ScheduledWorker
+	lastSubStreamCreatedPath          string
+	lastSubStreamStart                time.Time
+	lastSubStreamFileCreatedTimestamp time.Time
+	lastSubStreamWriteSize            float64
+	lastSubStreamWriteTimestamp       time.Time
+	lastCreatedPath                   string
+	lastCreatedStart                  time.Time
+	lastCreatedTimestamp              time.Time
+	lastFileCreatedTimestamp          time.Time
+	lastWriteSize                     float64
+	lastWriteTimestamp                time.Time
+	lastRecoveryTimestamp             time.Time
+	recoveries                        int64
+	recentRecoveries                  []Recovery
+	statePath                         string
+	completed                         completed // including those completed before a restart
+	recordSegments                    func(
+		netCamURL, destinationPath string,
+		template *segment_template.Template,
+		duration int,
+		format segment_recorder.Format,
+		options process.Options,
+	) (*process.BackgroundProcess, error)
 }
 
+// NewSegmentGenerator returns a SegmentGenerator that calls completeFn for each finished segment and (if not nil)
+// recoveryFn each time its watchdog restarts a stalled recorder
 func NewSegmentGenerator(
 	feed Feed,
 	completeFn func(Event),
+	recoveryFn func(Recovery),
 ) *SegmentGenerator {
 	s := SegmentGenerator{
 		feed:                 feed,
 		completeFn:           completeFn,
+		recoveryFn:           recoveryFn,
 		lastCreatedTimestamp: time.Now(),
+		recentRecoveries:     make([]Recovery, 0),
+		recordSegments:       segment_recorder.RecordSegments,
 	}
 
 	return &s
 }
 
+// getStart returns the start of the segment at path (from its name); ok is false if it was completed before a restart
+// (i.e. it's been found by the Watcher's scan but was already dealt with)
+func (s *SegmentGenerator) getStart(template *segment_template.Template, path string) (start time.Time, ok bool) {
+	start, err := template.Parse(path)
+	if err != nil {
+		log.Printf("warning: attempt to get start timestamp for %#+v raised %#+v", path, err)
+		return time.Time{}, false
+	}
+
+	s.mu.Lock()
+	isCompleted := s.completed.has(start)
+	s.mu.Unlock()
+
+	if isCompleted {
+		return time.Time{}, false
+	}
+
+	return start, true
+}
+
+// onFileCreate closes the previous segment when the next one is created; a segment that's older than the current one
+// (i.e. the Watcher missed it and found it later) was closed long ago, so it's closed straight away
 func (s *SegmentGenerator) onFileCreate(file filesystem.File) {
+	s.mu.Lock()
+	template := s.template
+	s.mu.Unlock()
+
+	start, ok := s.getStart(template, file.Name)
+	if !ok {
+		return
+	}
+
 	s.mu.Lock()
 	lastCreatedPath := s.lastCreatedPath
+	lastFileCreatedTimestamp := s.lastFileCreatedTimestamp
+	missed := !s.lastCreatedStart.IsZero() && start.Before(s.lastCreatedStart)
+	if file.Name != lastCreatedPath && !missed {
+		s.lastCreatedPath = file.Name
+		s.lastCreatedStart = start
+		s.lastFileCreatedTimestamp = time.Now()
+		s.lastWriteSize = file.Size
+		s.lastWriteTimestamp = s.lastFileCreatedTimestamp
+	}
 	s.mu.Unlock()
 
 	if file.Name == lastCreatedPath {
 		return
 	}
 
+	if missed {
+		log.Printf("onFileCreate; %#+v was missed, closing it", file.Name)
+
+		// a zero created timestamp so that an empty one isn't taken as a stall of the current recorder
+		s.closeSegment(file.Name, time.Time{})
+		return
+	}
+
 	if lastCreatedPath != "" {
 		log.Printf("onFileCreate; %#+v closed, %#+v created", lastCreatedPath, file.Name)
 
-		imagePath := fmt.Sprintf("%v.jpg", strings.Split(lastCreatedPath, ".mp4")[0])
+		s.closeSegment(lastCreatedPath, lastFileCreatedTimestamp)
+	}
+}
 
-		err := thumbnail_creator.GetThumbnail(
-			lastCreatedPath,
-			imagePath,
-		)
-		if err != nil {
-			log.Printf("warning: attempt to get thumbnail for %#+v raisd %#+v", lastCreatedPath, err)
-		}
+// closeSegment completes a main stream segment that's been closed (or hands it to the pairer to wait for its
+// sub-stream segment)
+func (s *SegmentGenerator) closeSegment(path string, createdTimestamp time.Time) {
+	if !s.checkClosedSegment(path, createdTimestamp) {
+		log.Printf("warning: skipping %#+v because it's empty", path)
+		return
+	}
+
+	s.mu.Lock()
+	pairer := s.pairer
+	template := s.template
+	s.mu.Unlock()
+
+	if pairer == nil {
+		s.complete(segmentPair{path: path})
+		return
+	}
+
+	start, err := template.Parse(path)
+	if err != nil {
+		log.Printf("warning: attempt to get start timestamp for %#+v raised %#+v", path, err)
+		return
+	}
+
+	pair, ok := pairer.addMain(path, start, time.Now())
+	if ok {
+		s.complete(pair)
+	}
+}
+
+func (s *SegmentGenerator) onSubStreamFileCreate(file filesystem.File) {
+	s.mu.Lock()
+	template := s.subStreamTemplate
+	s.mu.Unlock()
+
+	start, ok := s.getStart(template, file.Name)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	lastCreatedPath := s.lastSubStreamCreatedPath
+	missed := !s.lastSubStreamStart.IsZero() && start.Before(s.lastSubStreamStart)
+	if file.Name != lastCreatedPath && !missed {
+		s.lastSubStreamCreatedPath = file.Name
+		s.lastSubStreamStart = start
+		s.lastSubStreamFileCreatedTimestamp = time.Now()
+		s.lastSubStreamWriteSize = file.Size
+		s.lastSubStreamWriteTimestamp = s.lastSubStreamFileCreatedTimestamp
+	}
+	s.mu.Unlock()
+
+	if missed {
+		log.Printf("onSubStreamFileCreate; %#+v was missed, closing it", file.Name)
+
+		s.closeSubStreamSegment(file.Name)
+		return
+	}
+
+	if file.Name == lastCreatedPath || lastCreatedPath == "" {
+		return
+	}
+
+	s.closeSubStreamSegment(lastCreatedPath)
+}
+
+func (s *SegmentGenerator) closeSubStreamSegment(path string) {
+	s.mu.Lock()
+	pairer := s.pairer
+	template := s.subStreamTemplate
+	s.mu.Unlock()
+
+	size, err := metadata.GetFileSize(path)
+	if err == nil && size == 0 {
+		log.Printf("warning: skipping %#+v because it's empty", path)
+		return
+	}
+
+	start, err := template.Parse(path)
+	if err != nil {
+		log.Printf("warning: attempt to get start timestamp for %#+v raised %#+v", path, err)
+		return
+	}
+
+	pair, ok := pairer.addSubStream(path, start, time.Now())
+	if ok {
+		s.complete(pair)
+	}
+}
+
+func (s *SegmentGenerator) expireUnpaired() {
+	s.completeUnpaired(s.pairer.expire(time.Now()))
+}
+
+func (s *SegmentGenerator) completeUnpaired(pairs []segmentPair, dropped []string) {
+	for _, path := range dropped {
+		log.Printf("warning: no main stream segment for sub-stream segment %#+v", path)
+	}
+
+	for _, pair := range pairs {
+		log.Printf("warning: no sub-stream segment for %#+v", pair.path)
+		s.complete(pair)
+	}
+}
+
+// complete builds and emits the Event for a closed segment; the thumbnail comes from the sub-stream segment if there
+// is one (as it's much cheaper to decode)
+func (s *SegmentGenerator) complete(pair segmentPair) {
+	imagePath := fmt.Sprintf("%v.jpg", strings.TrimSuffix(pair.path, filepath.Ext(pair.path)))
+
+	thumbnailSourcePath := pair.path
+	if pair.subStreamPath != "" {
+		thumbnailSourcePath = pair.subStreamPath
+	}
+
+	err := thumbnail_creator.GetThumbnail(
+		thumbnailSourcePath,
+		imagePath,
+	)
+	if err != nil {
+		log.Printf("warning: attempt to get thumbnail for %#+v raisd %#+v", thumbnailSourcePath, err)
+	}
+
+	s.mu.Lock()
+	template := s.template
+	s.mu.Unlock()
 
-		// TODO: nasty hack for local timezone
-		rawVideoStartTimestamp := fmt.Sprintf(
-			"%v+08:00",
-			strings.Split(
-				strings.Split(
-					lastCreatedPath,
-					"/Segment_",
-				)[1],
-				fmt.Sprintf("_%v", s.feed.CameraName),
-			)[0])
+	rawVideoStartTimestamp, err := template.Parse(pair.path)
+	if err != nil {
+		log.Printf("warning: attempt to get start timestamp for %#+v raised %#+v", pair.path, err)
+		return
+	}
 
-		videoStartTimestamp := utils.GetISO8601Time(rawVideoStartTimestamp)
+	videoStartTimestamp := iso8601.Time{Time: rawVideoStartTimestamp}
 
-		duration, err := metadata.GetVideoDuration(lastCreatedPath)
+	duration, err := metadata.GetVideoDuration(pair.path)
+	if err != nil {
+		// e.g. the recorder was killed part way through writing it
+		log.Printf("warning: attempt to get duration for %#+v raised %#+v; attempting to repair it...", pair.path, err)
+
+		err = segment_repairer.Repair(pair.path)
 		if err != nil {
-			log.Printf("warning: attempt to get duration for %#+v raised %#+v", lastCreatedPath, err)
+			log.Printf("warning: skipping %#+v because attempt to repair it raised %#+v", pair.path, err)
 			return
 		}
 
-		videoEndTimestamp := iso8601.Time{Time: videoStartTimestamp.Add(duration)}
-
-		imageTimestamp := videoStartTimestamp
-
-		event := Event{
-			CameraName:          s.feed.CameraName,
-			VideoPath:           lastCreatedPath,
-			ImagePath:           imagePath,
-			VideoStartTimestamp: videoStartTimestamp,
-			VideoEndTimestamp:   videoEndTimestamp,
-			ImageTimestamp:      imageTimestamp,
+		duration, err = metadata.GetVideoDuration(pair.path)
+		if err != nil {
+			log.Printf("warning: attempt to get duration for repaired %#+v raised %#+v", pair.path, err)
+			return
 		}
 
-		log.Printf("onFileCreate; complete event=%#+v", event)
+		log.Printf("repaired %#+v", pair.path)
+	}
 
-		s.completeFn(event)
+	videoEndTimestamp := iso8601.Time{Time: videoStartTimestamp.Add(duration)}
 
-		s.mu.Lock()
-		s.lastCreatedTimestamp = time.Now()
-		s.mu.Unlock()
+	videoChecksum, err := metadata.GetFileChecksum(pair.path)
+	if err != nil {
+		log.Printf("warning: attempt to get checksum for %#+v raised %#+v", pair.path, err)
+		return
 	}
 
+	imageTimestamp := videoStartTimestamp
+
+	event := Event{
+		CameraName:          s.feed.CameraName,
+		VideoPath:           pair.path,
+		SubStreamVideoPath:  pair.subStreamPath,
+		VideoChecksum:       videoChecksum,
+		ImagePath:           imagePath,
+		VideoStartTimestamp: videoStartTimestamp,
+		VideoEndTimestamp:   videoEndTimestamp,
+		ImageTimestamp:      imageTimestamp,
+	}
+
+	log.Printf("complete; event=%#+v", event)
+
+	s.completeFn(event)
+
+	s.markCompleted(rawVideoStartTimestamp)
+
 	s.mu.Lock()
-	s.lastCreatedPath = file.Name
+	s.lastCreatedTimestamp = time.Now()
 	s.mu.Unlock()
 }
 
+func (s *SegmentGenerator) onStateChange(status process.Status) {
+	switch status.State {
+	case process.StateBackoff:
+		log.Printf(
+			"warning: recorder for %#+v exited (%v) and will restart at %v (restarts=%v, consecutive failures=%v)",
+			s.feed.CameraName,
+			status.LastExitReason,
+			status.NextStartAt.Format(time.RFC3339),
+			status.Restarts,
+			status.ConsecutiveFailures,
+		)
+
+		// whatever it was writing is as finished as it's going to get (and mustn't hold up the supervisor)
+		go s.finaliseMainInFlight()
+	case process.StateRunning:
+		log.Printf("recorder for %#+v running as pid %v (restarts=%v)", s.feed.CameraName, status.PID, status.Restarts)
+	}
+}
+
+func (s *SegmentGenerator) onSubStreamStateChange(status process.Status) {
+	switch status.State {
+	case process.StateBackoff:
+		log.Printf(
+			"warning: sub-stream recorder for %#+v exited (%v) and will restart at %v (restarts=%v, consecutive failures=%v)",
+			s.feed.CameraName,
+			status.LastExitReason,
+			status.NextStartAt.Format(time.RFC3339),
+			status.Restarts,
+			status.ConsecutiveFailures,
+		)
+
+		go s.finaliseSubStreamInFlight()
+	case process.StateRunning:
+		log.Printf("sub-stream recorder for %#+v running as pid %v (restarts=%v)", s.feed.CameraName, status.PID, status.Restarts)
+	}
+}
+
 func (s *SegmentGenerator) Start() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	backgroundProcess, err := segment_recorder.RecordSegments(
-		s.feed.NetCamURL,
-		s.feed.DestinationPath,
+	location, err := segment_template.LoadLocation(s.feed.Timezone)
+	if err != nil {
+		return err
+	}
+
+	format, err := segment_recorder.ParseFormat(s.feed.Format)
+	if err != nil {
+		return err
+	}
+
+	watcherBackend, err := filesystem.ParseBackend(s.feed.WatcherBackend)
+	if err != nil {
+		return err
+	}
+
+	s.statePath = getStatePath(s.feed.DestinationPath, s.feed.CameraName)
+	s.completed = loadCompleted(s.statePath, time.Now())
+
+	pattern := segment_template.WithExtension(segment_template.DefaultPattern, format.Extension())
+
+	s.template, err = segment_template.NewTemplate(
+		pattern,
 		s.feed.CameraName,
-		s.feed.Duration,
+		location,
 	)
 	if err != nil {
 		return err
 	}
 
-	s.backgroundProcess = backgroundProcess
+	if s.feed.SubStreamURL != "" {
+		s.subStreamTemplate, err = segment_template.NewTemplate(
+			segment_template.SubStreamPattern(pattern),
+			s.feed.CameraName,
+			location,
+		)
+		if err != nil {
+			return err
+		}
+	}
 
-	// TODO: depends on not changing the filename pattern in RecordSegments
-	matcher, err := regexp.Compile(".*/Segment_.*_" + s.feed.CameraName + "\\.mp4")
+	s.backgroundProcess, err = s.recordSegments(
+		s.feed.NetCamURL,
+		s.feed.DestinationPath,
+		s.template,
+		s.feed.Duration,
+		format,
+		process.Options{
+			OnStateChange: s.onStateChange,
+		},
+	)
 	if err != nil {
 		return err
 	}
 
-	s.watcher = filesystem.NewWatcher(
+	// the sub-stream gets its own recorder (so that it failing doesn't take the main stream down with it); both roll
+	// over at the same clock times, so their segments still pair up
+	if s.subStreamTemplate != nil {
+		s.subStreamBackgroundProcess, err = s.recordSegments(
+			s.feed.SubStreamURL,
+			s.feed.DestinationPath,
+			s.subStreamTemplate,
+			s.feed.Duration,
+			format,
+			process.Options{
+				OnStateChange: s.onSubStreamStateChange,
+			},
+		)
+		if err != nil {
+			// nothing else has been started yet, and the caller won't Stop a SegmentGenerator that didn't Start
+			s.backgroundProcess.Stop()
+			return err
+		}
+	}
+
+	s.watcher = filesystem.NewWatcherWithBackend(
 		s.feed.DestinationPath,
-		matcher,
+		s.template.Matcher(),
 		s.onFileCreate,
-		func(file filesystem.File) {},
+		s.onFileWrite,
+		watcherBackend,
 	)
 
 	s.watcher.Start()
 
+	if s.subStreamTemplate != nil {
+		// segments start within a keyframe interval or so of each other, and close within one of each other
+		halfDuration := time.Second * time.Duration(s.feed.Duration) / 2
+
+		s.pairer = newSegmentPairer(halfDuration, halfDuration)
+
+		s.pairerWorker = worker.NewScheduledWorker(
+			func() {},
+			s.expireUnpaired,
+			func() {},
+			pairerInterval,
+		)
+
+		s.pairerWorker.Start()
+
+		s.subStreamWatcher = filesystem.NewWatcherWithBackend(
+			s.feed.DestinationPath,
+			s.subStreamTemplate.Matcher(),
+			s.onSubStreamFileCreate,
+			s.onSubStreamFileWrite,
+			watcherBackend,
+		)
+
+		s.subStreamWatcher.Start()
+	}
+
+	// the ScheduledWorker's Stop doesn't wait, but onStop is only called once it's finished with the last watch
+	watchdogDone := make(chan struct{})
+	s.watchdogDone = watchdogDone
+
+	s.watchdog = worker.NewScheduledWorker(
+		func() {},
+		s.watch,
+		func() {
+			close(watchdogDone)
+		},
+		watchdogInterval,
+	)
+
+	s.watchdog.Start()
+
 	return nil
 }
 
+// Stop stops the recorders and then emits the Events for the segments it was part way through (so it blocks until
+// they're finished with)
 func (s *SegmentGenerator) Stop() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	watchdog := s.watchdog
+	watchdogDone := s.watchdogDone
+	backgroundProcess := s.backgroundProcess
+	subStreamBackgroundProcess := s.subStreamBackgroundProcess
+	watcher := s.watcher
+	subStreamWatcher := s.subStreamWatcher
+	pairerWorker := s.pairerWorker
+	s.mu.Unlock()
+
+	// the watchdog takes the lock itself, and mustn't restart what's being stopped
+	watchdog.Stop()
+	<-watchdogDone
+
+	backgroundProcess.Stop()
+
+	if subStreamBackgroundProcess != nil {
+		subStreamBackgroundProcess.Stop()
+	}
+
+	if pairerWorker != nil {
+		pairerWorker.Stop()
+	}
+
+	// the recorder has exited, so the segments it was writing won't be closed by new ones; the watchers are left
+	// running until they've settled so that their last writes are counted
+	s.finaliseInFlight()
+
+	watcher.Stop()
+
+	if subStreamWatcher != nil {
+		subStreamWatcher.Stop()
+	}
 
-	s.backgroundProcess.Stop()
-	s.watcher.Stop()
+	s.flushUnpaired()
 }
 
 func (s *SegmentGenerator) IsLive() bool {
@@ -176,3 +581,30 @@ func (s *SegmentGenerator) IsLive() bool {
 
 	return time.Now().Before(expiry)
 }
+
+func (s *SegmentGenerator) Status() Status {
+	s.mu.Lock()
+	backgroundProcess := s.backgroundProcess
+	subStreamBackgroundProcess := s.subStreamBackgroundProcess
+	status := Status{
+		CameraName:           s.feed.CameraName,
+		LastCreatedPath:      s.lastCreatedPath,
+		LastCreatedTimestamp: s.lastCreatedTimestamp,
+		Recoveries:           s.recoveries,
+		RecentRecoveries:     append([]Recovery{}, s.recentRecoveries...),
+	}
+	s.mu.Unlock()
+
+	status.IsLive = s.IsLive()
+
+	if backgroundProcess != nil {
+		status.Process = backgroundProcess.Status()
+	}
+
+	if subStreamBackgroundProcess != nil {
+		subStreamStatus := subStreamBackgroundProcess.Status()
+		status.SubStreamProcess = &subStreamStatus
+	}
+
+	return status
+}
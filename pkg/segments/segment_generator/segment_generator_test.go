@@ -0,0 +1,63 @@
+package segment_generator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_recorder"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+func TestSegmentGenerator_Start_SubStreamFails(t *testing.T) {
+	s := NewSegmentGenerator(
+		Feed{
+			CameraName:      "Driveway",
+			NetCamURL:       "rtsp://192.168.137.31:554/Streaming/Channels/101",
+			SubStreamURL:    "rtsp://192.168.137.31:554/Streaming/Channels/102",
+			DestinationPath: t.TempDir(),
+			Duration:        2,
+		},
+		func(Event) {},
+		nil,
+	)
+
+	started := make([]*process.BackgroundProcess, 0)
+
+	s.recordSegments = func(
+		netCamURL, destinationPath string,
+		template *segment_template.Template,
+		duration int,
+		format segment_recorder.Format,
+		options process.Options,
+	) (*process.BackgroundProcess, error) {
+		if netCamURL == s.feed.SubStreamURL {
+			return nil, fmt.Errorf("failed to start ffmpeg")
+		}
+
+		backgroundProcess, err := process.RunBackgroundProcessWithOptions(options, "sleep", "60")
+		if err == nil {
+			started = append(started, backgroundProcess)
+		}
+
+		return backgroundProcess, err
+	}
+
+	err := s.Start()
+	assert.Error(t, err)
+
+	// the main recorder that was started before the sub-stream's failed isn't left running (and restarting)
+	require.Len(t, started, 1)
+	require.Eventually(
+		t,
+		func() bool {
+			return started[0].Status().State == process.StateStopped
+		},
+		time.Second*10,
+		time.Millisecond*100,
+	)
+}
@@ -0,0 +1,166 @@
+package segment_generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// completedRetention is how far behind the latest completed segment the completed ones are remembered; anything older
+// than that is taken to have been completed (or given up on) already
+const completedRetention = time.Hour * 24
+
+// state is persisted (next to the segments) so that a restarted SegmentGenerator knows which of the segments it finds
+// have already been completed
+type state struct {
+	CompletedBefore    time.Time   `json:"completed_before"`     // everything that started before this
+	CompletedStarts    []time.Time `json:"completed_starts"`     // and these (which started since)
+	LastCompletedStart time.Time   `json:"last_completed_start"` // a high-water mark, as older versions kept
+}
+
+// completed is the set of segments (by start, as names have a resolution of a second) that have been completed
+type completed struct {
+	before time.Time
+	starts map[int64]time.Time
+}
+
+func newCompleted(before time.Time) completed {
+	return completed{
+		before: before,
+		starts: make(map[int64]time.Time),
+	}
+}
+
+func (c completed) has(start time.Time) bool {
+	if start.Before(c.before) {
+		return true
+	}
+
+	_, ok := c.starts[start.Unix()]
+
+	return ok
+}
+
+// add adds start and forgets those more than completedRetention older than the latest
+func (c *completed) add(start time.Time) {
+	if c.starts == nil {
+		c.starts = make(map[int64]time.Time)
+	}
+
+	c.starts[start.Unix()] = start
+
+	latest := start
+	for _, other := range c.starts {
+		if other.After(latest) {
+			latest = other
+		}
+	}
+
+	horizon := latest.Add(-completedRetention).Truncate(time.Second)
+	if !horizon.After(c.before) {
+		return
+	}
+
+	c.before = horizon
+
+	for key, other := range c.starts {
+		if other.Before(horizon) {
+			delete(c.starts, key)
+		}
+	}
+}
+
+func (c completed) state() state {
+	s := state{
+		CompletedBefore: c.before,
+		CompletedStarts: make([]time.Time, 0, len(c.starts)),
+	}
+
+	for _, start := range c.starts {
+		s.CompletedStarts = append(s.CompletedStarts, start)
+	}
+
+	sort.Slice(s.CompletedStarts, func(i, j int) bool {
+		return s.CompletedStarts[i].Before(s.CompletedStarts[j])
+	})
+
+	return s
+}
+
+func getStatePath(destinationPath string, cameraName string) string {
+	return filepath.Join(destinationPath, fmt.Sprintf(".%v.state.json", cameraName))
+}
+
+// loadCompleted returns the segments that have already been completed; if there's no state (i.e. this camera has
+// never been recorded here before), nothing from before now is taken on
+func loadCompleted(statePath string, now time.Time) completed {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("warning: failed to read %#+v because %v; only taking on segments from now on", statePath, err)
+		}
+
+		return newCompleted(now.Truncate(time.Second))
+	}
+
+	s := state{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		log.Printf("warning: failed to parse %#+v because %v; only taking on segments from now on", statePath, err)
+		return newCompleted(now.Truncate(time.Second))
+	}
+
+	// kept by an older version, which only knew that much
+	if s.CompletedBefore.IsZero() {
+		if s.LastCompletedStart.IsZero() {
+			log.Printf("warning: %#+v is empty; only taking on segments from now on", statePath)
+			return newCompleted(now.Truncate(time.Second))
+		}
+
+		return newCompleted(s.LastCompletedStart.Add(time.Second))
+	}
+
+	c := newCompleted(s.CompletedBefore)
+	for _, start := range s.CompletedStarts {
+		c.starts[start.Unix()] = start
+	}
+
+	return c
+}
+
+func saveState(statePath string, s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tempPath := statePath + ".tmp"
+
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, statePath)
+}
+
+// markCompleted adds start to the persisted set of completed segments
+func (s *SegmentGenerator) markCompleted(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statePath == "" {
+		return
+	}
+
+	s.completed.add(start)
+
+	err := saveState(s.statePath, s.completed.state())
+	if err != nil {
+		log.Printf("warning: failed to save %#+v because %v", s.statePath, err)
+	}
+}
@@ -0,0 +1,115 @@
+package segment_generator
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/filesystem"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+)
+
+func TestState(t *testing.T) {
+	statePath := getStatePath(t.TempDir(), "Driveway")
+	now := time.Date(2020, 12, 25, 8, 45, 4, 500, time.UTC)
+
+	// nothing from before now, if there's no state
+	c := loadCompleted(statePath, now)
+	assert.True(t, c.has(now.Add(-time.Second)))
+	assert.False(t, c.has(now.Truncate(time.Second)))
+
+	s := NewSegmentGenerator(Feed{CameraName: "Driveway"}, func(Event) {}, nil)
+	s.statePath = statePath
+	s.completed = c
+
+	s.markCompleted(now.Add(time.Minute * 2).Truncate(time.Second))
+	s.markCompleted(now.Add(time.Minute).Truncate(time.Second)) // completed out of order
+
+	c = loadCompleted(statePath, now)
+	assert.True(t, c.has(now.Add(time.Minute).Truncate(time.Second)))
+	assert.True(t, c.has(now.Add(time.Minute*2).Truncate(time.Second)))
+
+	// missed, and older than the latest that was completed, but still to be taken on
+	assert.False(t, c.has(now.Add(time.Second*30).Truncate(time.Second)))
+	assert.False(t, c.has(now.Add(time.Minute*3).Truncate(time.Second)))
+
+	// those that are too far behind the latest are forgotten
+	s.markCompleted(now.Add(completedRetention + time.Minute*2).Truncate(time.Second))
+
+	c = loadCompleted(statePath, now)
+	assert.Len(t, c.starts, 2)
+	assert.True(t, c.has(now.Add(time.Second*30).Truncate(time.Second)))
+	assert.False(t, c.has(now.Add(completedRetention).Truncate(time.Second)))
+}
+
+func TestState_Legacy(t *testing.T) {
+	statePath := getStatePath(t.TempDir(), "Driveway")
+	now := time.Date(2020, 12, 25, 8, 45, 4, 500, time.UTC)
+
+	require.NoError(t, os.WriteFile(statePath, []byte(`{"last_completed_start":"2020-12-25T08:44:00Z"}`), 0644))
+
+	c := loadCompleted(statePath, now)
+	assert.True(t, c.has(time.Date(2020, 12, 25, 8, 44, 0, 0, time.UTC)))
+	assert.False(t, c.has(time.Date(2020, 12, 25, 8, 44, 1, 0, time.UTC)))
+}
+
+func TestSegmentGenerator_onFileCreate_Missed(t *testing.T) {
+	dir := t.TempDir()
+
+	template, err := segment_template.NewTemplate(
+		segment_template.WithExtension(segment_template.DefaultPattern, ".ts"),
+		"Driveway",
+		time.UTC,
+	)
+	require.NoError(t, err)
+
+	mu := sync.Mutex{}
+	paths := make([]string, 0)
+
+	s := NewSegmentGenerator(
+		Feed{CameraName: "Driveway", Duration: 2, Format: "mpegts"},
+		func(event Event) {
+			mu.Lock()
+			paths = append(paths, filepath.Base(event.VideoPath))
+			mu.Unlock()
+		},
+		nil,
+	)
+	s.template = template
+	s.statePath = getStatePath(dir, "Driveway")
+	s.completed = newCompleted(time.Date(2020, 12, 25, 8, 45, 0, 0, time.UTC))
+
+	create := func(name string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, getMPEGTS(), 0644))
+		s.onFileCreate(filesystem.File{Name: path, Size: 1})
+	}
+
+	create("Segment_2020-12-25T08:44:58_Driveway.ts") // completed before a restart
+	create("Segment_2020-12-25T08:45:02_Driveway.ts")
+	create("Segment_2020-12-25T08:45:04_Driveway.ts") // closes 08:45:02
+	create("Segment_2020-12-25T08:45:00_Driveway.ts") // missed; closed straight away
+	create("Segment_2020-12-25T08:45:06_Driveway.ts") // closes 08:45:04
+
+	mu.Lock()
+	assert.Equal(
+		t,
+		[]string{
+			"Segment_2020-12-25T08:45:02_Driveway.ts",
+			"Segment_2020-12-25T08:45:00_Driveway.ts",
+			"Segment_2020-12-25T08:45:04_Driveway.ts",
+		},
+		paths,
+	)
+	mu.Unlock()
+
+	c := loadCompleted(s.statePath, time.Now())
+	assert.Len(t, c.starts, 3)
+	assert.True(t, c.has(time.Date(2020, 12, 25, 8, 45, 0, 0, time.UTC)))
+	assert.False(t, c.has(time.Date(2020, 12, 25, 8, 45, 6, 0, time.UTC)))
+}
@@ -0,0 +1,258 @@
+package segment_generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/filesystem"
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+const (
+	watchdogInterval   = time.Second * 5
+	maxGrowthTimeout   = time.Second * 30
+	maxRecentRecovered = 16
+)
+
+type StallReason string
+
+const (
+	StallReasonNoNewSegment StallReason = "no_new_segment"      // the recorder hasn't rolled over to a new file
+	StallReasonNotGrowing   StallReason = "segment_not_growing" // the current file has stopped growing
+	StallReasonEmptySegment StallReason = "empty_segment"       // the recorder closed a file without writing to it
+)
+
+// Recovery records a stalled recorder that was killed and restarted
+type Recovery struct {
+	CameraName string         `json:"camera_name"`
+	Reason     StallReason    `json:"reason"`
+	Detail     string         `json:"detail"`
+	Path       string         `json:"path,omitempty"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Process    process.Status `json:"process"`              // as it was just before the restart
+	SubStream  bool           `json:"sub_stream,omitempty"` // it was the sub-stream's recorder that stalled
+}
+
+// watchedRecorder is what the watchdog goes by for one of the recorders
+type watchedRecorder struct {
+	subStream                bool
+	backgroundProcess        *process.BackgroundProcess
+	lastCreatedPath          string
+	lastFileCreatedTimestamp time.Time
+	lastWriteTimestamp       time.Time
+}
+
+func (s *SegmentGenerator) newSegmentTimeout() time.Duration {
+	return time.Second * time.Duration(float64(s.feed.Duration)*1.5)
+}
+
+func (s *SegmentGenerator) growthTimeout() time.Duration {
+	timeout := s.newSegmentTimeout()
+	if timeout > maxGrowthTimeout {
+		timeout = maxGrowthTimeout
+	}
+
+	return timeout
+}
+
+func (s *SegmentGenerator) onFileWrite(file filesystem.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file.Name != s.lastCreatedPath {
+		return
+	}
+
+	if file.Size <= s.lastWriteSize {
+		return
+	}
+
+	s.lastWriteSize = file.Size
+	s.lastWriteTimestamp = time.Now()
+}
+
+func (s *SegmentGenerator) onSubStreamFileWrite(file filesystem.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file.Name != s.lastSubStreamCreatedPath {
+		return
+	}
+
+	if file.Size <= s.lastSubStreamWriteSize {
+		return
+	}
+
+	s.lastSubStreamWriteSize = file.Size
+	s.lastSubStreamWriteTimestamp = time.Now()
+}
+
+func (s *SegmentGenerator) recover(subStream bool, reason StallReason, path string, detail string) {
+	s.mu.Lock()
+	backgroundProcess := s.backgroundProcess
+	if subStream {
+		backgroundProcess = s.subStreamBackgroundProcess
+	}
+	s.mu.Unlock()
+
+	if backgroundProcess == nil {
+		return
+	}
+
+	recovery := Recovery{
+		CameraName: s.feed.CameraName,
+		Reason:     reason,
+		Detail:     detail,
+		Path:       path,
+		Timestamp:  time.Now(),
+		Process:    backgroundProcess.Status(),
+		SubStream:  subStream,
+	}
+
+	s.mu.Lock()
+	s.recoveries++
+	s.recentRecoveries = append(s.recentRecoveries, recovery)
+	if len(s.recentRecoveries) > maxRecentRecovered {
+		s.recentRecoveries = s.recentRecoveries[len(s.recentRecoveries)-maxRecentRecovered:]
+	}
+	s.lastRecoveryTimestamp = recovery.Timestamp
+	s.mu.Unlock()
+
+	b, err := json.Marshal(recovery)
+	if err != nil {
+		log.Printf("warning: failed to marshal %#+v because %v", recovery, err)
+	} else {
+		kind := "recorder"
+		if subStream {
+			kind = "sub-stream recorder"
+		}
+
+		log.Printf("warning: %v for %#+v stalled; recovery=%s", kind, s.feed.CameraName, b)
+	}
+
+	backgroundProcess.Restart(fmt.Sprintf("%v (%v)", reason, detail))
+
+	if s.recoveryFn != nil {
+		s.recoveryFn(recovery)
+	}
+}
+
+// checkClosedSegment is called as each segment is closed; a recorder that produces empty files is stalled (unless
+// the file belonged to a run that's already been recovered)
+func (s *SegmentGenerator) checkClosedSegment(path string, createdTimestamp time.Time) bool {
+	size, err := metadata.GetFileSize(path)
+	if err != nil || size > 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	lastRecoveryTimestamp := s.lastRecoveryTimestamp
+	s.mu.Unlock()
+
+	if lastRecoveryTimestamp.Before(createdTimestamp) {
+		s.recover(false, StallReasonEmptySegment, path, "closed an empty segment")
+	}
+
+	return false
+}
+
+// pollSize reports the size of the file at path to onFileWrite, as fsnotify write events can be coalesced or missed
+func pollSize(path string, onFileWrite func(filesystem.File)) {
+	if path == "" {
+		return
+	}
+
+	size, err := metadata.GetFileSize(path)
+	if err == nil {
+		onFileWrite(filesystem.File{Name: path, Size: size})
+	}
+}
+
+func (s *SegmentGenerator) getWatchedRecorders() []watchedRecorder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recorders := []watchedRecorder{
+		{
+			backgroundProcess:        s.backgroundProcess,
+			lastCreatedPath:          s.lastCreatedPath,
+			lastFileCreatedTimestamp: s.lastFileCreatedTimestamp,
+			lastWriteTimestamp:       s.lastWriteTimestamp,
+		},
+	}
+
+	if s.subStreamBackgroundProcess != nil {
+		recorders = append(recorders, watchedRecorder{
+			subStream:                true,
+			backgroundProcess:        s.subStreamBackgroundProcess,
+			lastCreatedPath:          s.lastSubStreamCreatedPath,
+			lastFileCreatedTimestamp: s.lastSubStreamFileCreatedTimestamp,
+			lastWriteTimestamp:       s.lastSubStreamWriteTimestamp,
+		})
+	}
+
+	return recorders
+}
+
+func (s *SegmentGenerator) watch() {
+	s.mu.Lock()
+	lastCreatedPath := s.lastCreatedPath
+	lastSubStreamCreatedPath := s.lastSubStreamCreatedPath
+	s.mu.Unlock()
+
+	pollSize(lastCreatedPath, s.onFileWrite)
+	pollSize(lastSubStreamCreatedPath, s.onSubStreamFileWrite)
+
+	// the sub-stream's recorder stalling doesn't stop the main stream, but its segments would never get paired
+	for _, recorder := range s.getWatchedRecorders() {
+		s.watchRecorder(recorder)
+	}
+}
+
+func (s *SegmentGenerator) watchRecorder(recorder watchedRecorder) {
+	if recorder.backgroundProcess == nil {
+		return
+	}
+
+	status := recorder.backgroundProcess.Status()
+	if status.State != process.StateRunning {
+		return // nothing to kill; the supervisor is already dealing with it
+	}
+
+	// nothing from before the current run counts against it
+	latest := func(timestamp time.Time) time.Time {
+		if timestamp.Before(status.StartedAt) {
+			return status.StartedAt
+		}
+
+		return timestamp
+	}
+
+	sinceFileCreated := time.Since(latest(recorder.lastFileCreatedTimestamp))
+	if sinceFileCreated > s.newSegmentTimeout() {
+		s.recover(
+			recorder.subStream,
+			StallReasonNoNewSegment,
+			recorder.lastCreatedPath,
+			fmt.Sprintf("no new segment for %v", sinceFileCreated.Truncate(time.Second)),
+		)
+		return
+	}
+
+	if recorder.lastCreatedPath == "" {
+		return
+	}
+
+	sinceWrite := time.Since(latest(recorder.lastWriteTimestamp))
+	if sinceWrite > s.growthTimeout() {
+		s.recover(
+			recorder.subStream,
+			StallReasonNotGrowing,
+			recorder.lastCreatedPath,
+			fmt.Sprintf("segment hasn't grown for %v", sinceWrite.Truncate(time.Second)),
+		)
+	}
+}
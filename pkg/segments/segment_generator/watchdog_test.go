@@ -0,0 +1,143 @@
+package segment_generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/process"
+)
+
+func getSegmentGenerator(t *testing.T, recoveries chan Recovery) *SegmentGenerator {
+	backgroundProcess, err := process.RunBackgroundProcessWithOptions(
+		process.Options{MinBackoff: time.Millisecond * 100},
+		"sleep", "60",
+	)
+	require.NoError(t, err)
+	t.Cleanup(backgroundProcess.Stop)
+
+	s := NewSegmentGenerator(
+		Feed{CameraName: "Driveway", Duration: 2},
+		func(Event) {},
+		func(recovery Recovery) {
+			recoveries <- recovery
+		},
+	)
+
+	s.backgroundProcess = backgroundProcess
+
+	return s
+}
+
+func TestSegmentGenerator_watch_NoNewSegment(t *testing.T) {
+	recoveries := make(chan Recovery, 16)
+	s := getSegmentGenerator(t, recoveries)
+
+	s.watch()
+	assert.Len(t, recoveries, 0)
+
+	time.Sleep(time.Millisecond * 3500)
+
+	s.watch()
+	require.Len(t, recoveries, 1)
+
+	recovery := <-recoveries
+	assert.Equal(t, StallReasonNoNewSegment, recovery.Reason)
+	assert.Equal(t, "Driveway", recovery.CameraName)
+	assert.Equal(t, process.StateRunning, recovery.Process.State)
+
+	time.Sleep(time.Millisecond * 500)
+
+	status := s.Status()
+	assert.Equal(t, int64(1), status.Recoveries)
+	assert.Equal(t, int64(1), status.Process.Restarts)
+	assert.Contains(t, status.Process.LastExitReason, string(StallReasonNoNewSegment))
+}
+
+func TestSegmentGenerator_watch_NotGrowing(t *testing.T) {
+	recoveries := make(chan Recovery, 16)
+	s := getSegmentGenerator(t, recoveries)
+
+	path := filepath.Join(t.TempDir(), "Segment_2024-01-01T00:00:00_Driveway.mp4")
+	require.NoError(t, os.WriteFile(path, []byte("some"), 0644))
+
+	s.mu.Lock()
+	s.lastCreatedPath = path
+	s.lastFileCreatedTimestamp = time.Now().Add(time.Hour) // so only growth is in play
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond * 2000)
+
+	require.NoError(t, os.WriteFile(path, []byte("some more"), 0644))
+	s.watch()
+	assert.Len(t, recoveries, 0)
+
+	time.Sleep(time.Millisecond * 3500)
+
+	s.watch()
+	require.Len(t, recoveries, 1)
+	assert.Equal(t, StallReasonNotGrowing, (<-recoveries).Reason)
+}
+
+func TestSegmentGenerator_watch_SubStream(t *testing.T) {
+	recoveries := make(chan Recovery, 16)
+	s := getSegmentGenerator(t, recoveries)
+
+	subStreamBackgroundProcess, err := process.RunBackgroundProcessWithOptions(
+		process.Options{MinBackoff: time.Millisecond * 100},
+		"sleep", "60",
+	)
+	require.NoError(t, err)
+	t.Cleanup(subStreamBackgroundProcess.Stop)
+
+	s.mu.Lock()
+	s.subStreamBackgroundProcess = subStreamBackgroundProcess
+	s.lastFileCreatedTimestamp = time.Now().Add(time.Hour) // so only the sub-stream is in play
+	s.mu.Unlock()
+
+	s.watch()
+	assert.Len(t, recoveries, 0)
+
+	time.Sleep(time.Millisecond * 3500)
+
+	s.watch()
+	require.Len(t, recoveries, 1)
+
+	recovery := <-recoveries
+	assert.Equal(t, StallReasonNoNewSegment, recovery.Reason)
+	assert.True(t, recovery.SubStream)
+
+	time.Sleep(time.Millisecond * 500)
+
+	// it's the sub-stream's recorder that's restarted, not the main one
+	assert.Equal(t, int64(1), subStreamBackgroundProcess.Status().Restarts)
+	assert.Equal(t, int64(0), s.Status().Process.Restarts)
+}
+
+func TestSegmentGenerator_checkClosedSegment(t *testing.T) {
+	recoveries := make(chan Recovery, 16)
+	s := getSegmentGenerator(t, recoveries)
+
+	dir := t.TempDir()
+
+	nonEmptyPath := filepath.Join(dir, "Segment_2024-01-01T00:00:00_Driveway.mp4")
+	require.NoError(t, os.WriteFile(nonEmptyPath, []byte("some"), 0644))
+
+	emptyPath := filepath.Join(dir, "Segment_2024-01-01T00:01:00_Driveway.mp4")
+	require.NoError(t, os.WriteFile(emptyPath, []byte{}, 0644))
+
+	assert.True(t, s.checkClosedSegment(nonEmptyPath, time.Now()))
+	assert.Len(t, recoveries, 0)
+
+	assert.False(t, s.checkClosedSegment(emptyPath, time.Now()))
+	require.Len(t, recoveries, 1)
+	assert.Equal(t, StallReasonEmptySegment, (<-recoveries).Reason)
+
+	// an empty segment from the run that was just recovered doesn't count again
+	assert.False(t, s.checkClosedSegment(emptyPath, time.Now().Add(-time.Minute)))
+	assert.Len(t, recoveries, 0)
+}
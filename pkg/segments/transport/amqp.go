@@ -0,0 +1,278 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wagslane/go-rabbitmq"
+
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
+)
+
+const (
+	amqpConfirmTimeout = time.Second * 30
+
+	// a message that couldn't be handled is held for this long (doubling with each failure in a row, up to the max)
+	// before it's requeued, so that a handler that's failing (e.g. because the database is down) isn't handed the same
+	// message over and over as fast as the broker can redeliver it
+	amqpMinRetryBackoff = time.Second
+	amqpMaxRetryBackoff = time.Second * 30
+)
+
+func getMessageID(envelope outbox.Envelope) string {
+	return fmt.Sprintf("%v/%v", envelope.Source, envelope.Sequence)
+}
+
+// AMQPPublisher publishes each Envelope as a persistent message (via the default exchange) to a durable queue; the
+// broker's publisher confirm is the acknowledgement (from then on it's the broker's job to deliver it)
+type AMQPPublisher struct {
+	mu                 sync.Mutex
+	url                string
+	queue              string
+	onAck              func(outbox.Ack)
+	conn               *rabbitmq.Conn
+	publisher          *rabbitmq.Publisher
+	returnedMessageIDs map[string]struct{}
+}
+
+func NewAMQPPublisher(url string, queue string, onAck func(outbox.Ack)) *AMQPPublisher {
+	p := AMQPPublisher{
+		url:                url,
+		queue:              queue,
+		onAck:              onAck,
+		returnedMessageIDs: make(map[string]struct{}),
+	}
+
+	return &p
+}
+
+func (p *AMQPPublisher) Open() error {
+	var err error
+
+	p.conn, err = rabbitmq.NewConn(
+		p.url,
+		rabbitmq.WithConnectionOptionsLogging,
+		rabbitmq.WithConnectionOptionsReconnectInterval(time.Second),
+	)
+	if err != nil {
+		return err
+	}
+
+	p.publisher, err = rabbitmq.NewPublisher(
+		p.conn,
+		rabbitmq.WithPublisherOptionsLogging,
+		rabbitmq.WithPublisherOptionsConfirm,
+	)
+	if err != nil {
+		_ = p.conn.Close()
+		return err
+	}
+
+	// mandatory messages come back if there's no queue to route them to (i.e. the consumer has never declared it)
+	p.publisher.NotifyReturn(func(r rabbitmq.Return) {
+		p.mu.Lock()
+		p.returnedMessageIDs[r.MessageId] = struct{}{}
+		p.mu.Unlock()
+	})
+
+	return nil
+}
+
+func (p *AMQPPublisher) Publish(envelope outbox.Envelope) error {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	messageID := getMessageID(envelope)
+
+	ctx, cancel := context.WithTimeout(context.Background(), amqpConfirmTimeout)
+	defer cancel()
+
+	confirmations, err := p.publisher.PublishWithDeferredConfirmWithContext(
+		ctx,
+		b,
+		[]string{p.queue},
+		rabbitmq.WithPublishOptionsContentType("application/json"),
+		rabbitmq.WithPublishOptionsPersistentDelivery,
+		rabbitmq.WithPublishOptionsMandatory,
+		rabbitmq.WithPublishOptionsMessageID(messageID),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, confirmation := range confirmations {
+		if confirmation == nil {
+			continue
+		}
+
+		ok, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("broker didn't confirm %v", messageID)
+		}
+	}
+
+	p.mu.Lock()
+	_, returned := p.returnedMessageIDs[messageID]
+	delete(p.returnedMessageIDs, messageID)
+	p.mu.Unlock()
+
+	if returned {
+		return fmt.Errorf("broker returned %v; has the consumer declared %#+v?", messageID, p.queue)
+	}
+
+	p.onAck(getAck(envelope))
+
+	return nil
+}
+
+func (p *AMQPPublisher) Close() {
+	if p.publisher != nil {
+		p.publisher.Close()
+	}
+
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+}
+
+// AMQPConsumer consumes Envelopes from a durable queue, acking each message once it's been handled (and requeueing
+// it after a backoff otherwise)
+type AMQPConsumer struct {
+	mu                  sync.Mutex
+	url                 string
+	queue               string
+	handler             Handler
+	conn                *rabbitmq.Conn
+	consumer            *rabbitmq.Consumer
+	minRetryBackoff     time.Duration
+	maxRetryBackoff     time.Duration
+	consecutiveFailures int
+	closed              chan struct{}
+	closeOnce           sync.Once
+}
+
+func NewAMQPConsumer(url string, queue string, handler Handler) *AMQPConsumer {
+	c := AMQPConsumer{
+		url:             url,
+		queue:           queue,
+		handler:         handler,
+		minRetryBackoff: amqpMinRetryBackoff,
+		maxRetryBackoff: amqpMaxRetryBackoff,
+		closed:          make(chan struct{}),
+	}
+
+	return &c
+}
+
+// getRetryBackoff returns how long to hold a message after consecutiveFailures handler errors in a row (of any
+// messages, as it's the handler that's failing rather than the message, more often than not)
+func (c *AMQPConsumer) getRetryBackoff(consecutiveFailures int) time.Duration {
+	backoff := c.minRetryBackoff
+	for i := 1; i < consecutiveFailures && backoff < c.maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > c.maxRetryBackoff {
+		backoff = c.maxRetryBackoff
+	}
+
+	return backoff
+}
+
+// holdForRetry waits out the backoff for another handler error (or until the consumer is closed)
+func (c *AMQPConsumer) holdForRetry() time.Duration {
+	c.mu.Lock()
+	c.consecutiveFailures++
+	backoff := c.getRetryBackoff(c.consecutiveFailures)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-c.closed:
+	}
+
+	return backoff
+}
+
+func (c *AMQPConsumer) handle(delivery rabbitmq.Delivery) rabbitmq.Action {
+	log.Printf("AMQPConsumer.handle; received: messageID=%#+v, data=%#+v", delivery.MessageId, string(delivery.Body))
+
+	envelope, err := decodeEnvelope(delivery.Body)
+	if err != nil {
+		log.Printf("warning: discarding %#+v because attempt to unmarshal raised %v", string(delivery.Body), err)
+		return rabbitmq.NackDiscard
+	}
+
+	err = c.handler(envelope)
+	if err != nil {
+		backoff := c.holdForRetry()
+		log.Printf("warning: requeueing %v/%v (after holding it for %v) because %v", envelope.Source, envelope.Sequence, backoff, err)
+		return rabbitmq.NackRequeue
+	}
+
+	c.mu.Lock()
+	c.consecutiveFailures = 0
+	c.mu.Unlock()
+
+	return rabbitmq.Ack
+}
+
+func (c *AMQPConsumer) Open() error {
+	var err error
+
+	c.conn, err = rabbitmq.NewConn(
+		c.url,
+		rabbitmq.WithConnectionOptionsLogging,
+		rabbitmq.WithConnectionOptionsReconnectInterval(time.Second),
+	)
+	if err != nil {
+		return err
+	}
+
+	c.consumer, err = rabbitmq.NewConsumer(
+		c.conn,
+		c.queue,
+		rabbitmq.WithConsumerOptionsLogging,
+		rabbitmq.WithConsumerOptionsQueueDurable,
+	)
+	if err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+
+	go func() {
+		err := c.consumer.Run(c.handle)
+		if err != nil {
+			log.Printf("warning: AMQPConsumer for %#+v stopped because %v", c.queue, err)
+		}
+	}()
+
+	return nil
+}
+
+func (c *AMQPConsumer) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+
+	if c.consumer != nil {
+		c.consumer.Close()
+	}
+
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
+)
+
+const httpTimeout = time.Second * 30
+
+// HTTPPublisher POSTs each Envelope as JSON; a 2xx response is the acknowledgement
+type HTTPPublisher struct {
+	url        string
+	httpClient *http.Client
+	onAck      func(outbox.Ack)
+}
+
+func NewHTTPPublisher(url string, onAck func(outbox.Ack)) *HTTPPublisher {
+	p := HTTPPublisher{
+		url: url,
+		httpClient: &http.Client{
+			Timeout: httpTimeout,
+		},
+		onAck: onAck,
+	}
+
+	return &p
+}
+
+func (p *HTTPPublisher) Open() error {
+	return nil
+}
+
+func (p *HTTPPublisher) Publish(envelope outbox.Envelope) error {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.httpClient.Post(p.url, "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%v responded %v: %v", p.url, response.Status, string(body))
+	}
+
+	p.onAck(getAck(envelope))
+
+	return nil
+}
+
+func (p *HTTPPublisher) Close() {
+	p.httpClient.CloseIdleConnections()
+}
+
+// HTTPConsumer serves a single endpoint that Envelopes are POSTed to; it only responds 2xx once the Envelope has
+// been handled
+type HTTPConsumer struct {
+	address string
+	path    string
+	handler Handler
+	server  *http.Server
+	done    chan struct{}
+}
+
+func NewHTTPConsumer(address string, path string, handler Handler) *HTTPConsumer {
+	if path == "" {
+		path = "/"
+	}
+
+	c := HTTPConsumer{
+		address: address,
+		path:    path,
+		handler: handler,
+	}
+
+	return &c
+}
+
+func (c *HTTPConsumer) handle(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(request.Body, 1024*1024))
+	if err != nil {
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("HTTPConsumer.handle; received: remoteAddr=%#+v, data=%#+v", request.RemoteAddr, string(data))
+
+	envelope, err := decodeEnvelope(data)
+	if err != nil {
+		log.Printf("warning: attempt to unmarshal %#+v raised %v", string(data), err)
+		http.Error(responseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.handler(envelope)
+	if err != nil {
+		log.Printf("warning: not acknowledging %v/%v because %v", envelope.Source, envelope.Sequence, err)
+		http.Error(responseWriter, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	b, err := json.Marshal(getAck(envelope))
+	if err != nil {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	_, _ = responseWriter.Write(b)
+}
+
+func (c *HTTPConsumer) Open() error {
+	listener, err := net.Listen("tcp", c.address)
+	if err != nil {
+		return err
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc(c.path, c.handle)
+
+	c.server = &http.Server{
+		Handler: serveMux,
+	}
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		err := c.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("warning: HTTPConsumer on %v stopped because %v", c.address, err)
+		}
+	}()
+
+	return nil
+}
+
+func (c *HTTPConsumer) Close() {
+	if c.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_ = c.server.Shutdown(ctx)
+	<-c.done
+}
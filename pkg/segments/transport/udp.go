@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/initialed85/glue/pkg/network"
+
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
+)
+
+// UDPPublisher sends each Envelope as a JSON datagram (from an unconnected socket, so that the consumer can reply to
+// the source address) and passes the Acks that come back to onAck
+type UDPPublisher struct {
+	mu      sync.Mutex
+	address string
+	conn    *net.UDPConn
+	onAck   func(outbox.Ack)
+	done    chan struct{}
+}
+
+func NewUDPPublisher(address string, onAck func(outbox.Ack)) *UDPPublisher {
+	p := UDPPublisher{
+		address: address,
+		onAck:   onAck,
+	}
+
+	return &p
+}
+
+func (p *UDPPublisher) readAcks(conn *net.UDPConn, done chan struct{}) {
+	defer close(done)
+
+	buf := make([]byte, 65536)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return // closed
+		}
+
+		ack := outbox.Ack{}
+		err = json.Unmarshal(buf[:n], &ack)
+		if err != nil {
+			log.Printf("warning: attempt to unmarshal ack %#+v raised %v", string(buf[:n]), err)
+			continue
+		}
+
+		p.onAck(ack)
+	}
+}
+
+func (p *UDPPublisher) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+
+	p.conn = conn
+	p.done = make(chan struct{})
+
+	go p.readAcks(p.conn, p.done)
+
+	return nil
+}
+
+func (p *UDPPublisher) Publish(envelope outbox.Envelope) error {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	// resolved every time, as the consumer's address may change when it restarts
+	addr, err := net.ResolveUDPAddr("udp4", p.address)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("not open")
+	}
+
+	_, err = conn.WriteToUDP(b, addr)
+
+	return err
+}
+
+func (p *UDPPublisher) Close() {
+	p.mu.Lock()
+	conn := p.conn
+	done := p.done
+	p.conn = nil
+	p.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	_ = conn.Close()
+	<-done
+}
+
+// UDPConsumer receives Envelopes as JSON datagrams and replies to the sender with an Ack for each one handled
+type UDPConsumer struct {
+	receiver *network.Receiver
+	ackConn  *net.UDPConn
+	handler  Handler
+}
+
+func NewUDPConsumer(address string, handler Handler) (*UDPConsumer, error) {
+	interfaceName, err := network.GetDefaultInterfaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := UDPConsumer{
+		receiver: network.NewReceiver(
+			addr,
+			interfaceName,
+		),
+		handler: handler,
+	}
+
+	return &c, nil
+}
+
+func (c *UDPConsumer) callback(srcAddr *net.UDPAddr, dstAddr *net.UDPAddr, data []byte) {
+	log.Printf("UDPConsumer.callback; received: srcAddr=%#+v, dstAddr=%#+v, data=%#+v)", srcAddr.String(), dstAddr.String(), string(data))
+
+	envelope, err := decodeEnvelope(data)
+	if err != nil {
+		log.Printf("warning: attempt to unmarshal %#+v raised %v", string(data), err)
+		return
+	}
+
+	err = c.handler(envelope)
+	if err != nil {
+		log.Printf("warning: not acknowledging %v/%v because %v", envelope.Source, envelope.Sequence, err)
+		return
+	}
+
+	if envelope.Source == "" {
+		return
+	}
+
+	b, err := json.Marshal(getAck(envelope))
+	if err != nil {
+		log.Printf("warning: attempt to marshal ack for %v/%v raised %v", envelope.Source, envelope.Sequence, err)
+		return
+	}
+
+	_, err = c.ackConn.WriteToUDP(b, srcAddr)
+	if err != nil {
+		log.Printf("warning: attempt to ack %v/%v to %v raised %v", envelope.Source, envelope.Sequence, srcAddr, err)
+	}
+}
+
+func (c *UDPConsumer) Open() error {
+	var err error
+
+	c.ackConn, err = net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+
+	err = c.receiver.RegisterCallback(c.callback)
+	if err != nil {
+		_ = c.ackConn.Close()
+		return err
+	}
+
+	return c.receiver.Open()
+}
+
+func (c *UDPConsumer) Close() {
+	_ = c.receiver.UnregisterCallback(c.callback)
+
+	c.receiver.Close()
+
+	if c.ackConn != nil {
+		_ = c.ackConn.Close()
+	}
+}
@@ -0,0 +1,501 @@
+package archiver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/glue/pkg/worker"
+
+	"github.com/initialed85/cameranator/pkg/media/converter"
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+const (
+	pageSize     = 100
+	lowResSuffix = "__lowres.jpg"
+	tempPrefix   = ".archiving."
+)
+
+type Report struct {
+	Checked  int   // events
+	Archived int   // events (or that would be, in a dry run)
+	Failed   int   // events
+	Bytes    int64 // of the files as they were before they were moved
+	Written  int64 // bytes written to the archive (less than Bytes if videos were re-encoded)
+}
+
+func (r Report) OK() bool {
+	return r.Failed == 0
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf(
+		"checked %v events; archived %v (%.1f MB, written as %.1f MB), %v failed",
+		r.Checked,
+		r.Archived,
+		float64(r.Bytes)/1000000,
+		float64(r.Written)/1000000,
+		r.Failed,
+	)
+}
+
+type Archiver struct {
+	scheduledWorker *worker.ScheduledWorker
+	application     *application.Application
+	sourceRoot      string
+	archiveRoot     string
+	maxAge          time.Duration
+	bitrate         string
+	resolver        *path_resolver.Resolver
+	dryRun          bool
+}
+
+// NewArchiver returns an Archiver that (every interval) moves the files of events under sourceRoot that ended more
+// than maxAge ago to the same place under archiveRoot, and points their rows at them; if bitrate is set (e.g. 500k)
+// the high-res video is re-encoded at that bitrate on the way, which (as a re-encoded video no longer matches its hash
+// chain entry) has to be allowed by allowReencodeOutsideChain and isn't allowed at all if chainPath is set; if dryRun
+// is set it only logs what it would have done, and both roots are local paths, which resolver maps to and from those
+// in the database
+func NewArchiver(
+	url string,
+	timeout time.Duration,
+	interval time.Duration,
+	sourceRoot string,
+	archiveRoot string,
+	maxAge time.Duration,
+	bitrate string,
+	chainPath string,
+	allowReencodeOutsideChain bool,
+	resolver *path_resolver.Resolver,
+	dryRun bool,
+) (*Archiver, error) {
+	var err error
+
+	if bitrate != "" && chainPath != "" {
+		return nil, fmt.Errorf("may not re-encode at %v with a hash chain at %#+v; the videos would no longer be in it", bitrate, chainPath)
+	}
+
+	// there's no telling from here whether the segment processor keeps a hash chain, so it has to be ruled out
+	if bitrate != "" && !allowReencodeOutsideChain {
+		return nil, fmt.Errorf("may not re-encode at %v unless it's allowed outside of a hash chain; the videos would no longer be in one (if they are)", bitrate)
+	}
+
+	a := Archiver{
+		maxAge:   maxAge,
+		bitrate:  bitrate,
+		resolver: resolver,
+		dryRun:   dryRun,
+	}
+
+	a.sourceRoot, err = filepath.Abs(sourceRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	a.archiveRoot, err = filepath.Abs(archiveRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	if isUnder(a.archiveRoot, a.sourceRoot) || isUnder(a.sourceRoot, a.archiveRoot) {
+		return nil, fmt.Errorf("%#+v and %#+v may not be inside one another", a.sourceRoot, a.archiveRoot)
+	}
+
+	a.scheduledWorker = worker.NewScheduledWorker(
+		func() {},
+		a.work,
+		func() {},
+		interval,
+	)
+
+	a.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func isUnder(path string, root string) bool {
+	return strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// move is a file to be moved to the archive (and the row that points at it, if there is one)
+type move struct {
+	sourcePath      string
+	destinationPath string
+	video           *model.Video
+	image           *model.Image
+	spriteSheet     *model.SpriteSheet // for its WebVTT file
+	reencode        bool
+}
+
+// getMoves returns the moves for an event's files (those that exist); the full-res image that the thumbnail was made
+// from goes too (and to the same place, so that it can still be found from the thumbnail's path), as do the event's
+// sprite sheets (whose WebVTT files refer to their images by name, so the two stay side by side)
+func getMoves(
+	event model.Event,
+	spriteSheets []model.SpriteSheet,
+	sourceRoot string,
+	archiveRoot string,
+	reencode bool,
+) ([]move, error) {
+	moves := make([]move, 0, 4+len(spriteSheets)*2)
+
+	add := func(path string, m move) error {
+		if path == "" || !isUnder(path, sourceRoot) {
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(sourceRoot, path)
+		if err != nil {
+			return err
+		}
+
+		m.sourcePath = path
+		m.destinationPath = filepath.Join(archiveRoot, relativePath)
+		moves = append(moves, m)
+
+		return nil
+	}
+
+	originalVideo := event.OriginalVideo
+	err := add(originalVideo.FilePath, move{video: &originalVideo, reencode: reencode})
+	if err != nil {
+		return nil, err
+	}
+
+	processedVideo := event.ProcessedVideo
+	err = add(processedVideo.FilePath, move{video: &processedVideo})
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnailImage := event.ThumbnailImage
+	err = add(thumbnailImage.FilePath, move{image: &thumbnailImage})
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(thumbnailImage.FilePath, lowResSuffix) {
+		err = add(strings.TrimSuffix(thumbnailImage.FilePath, lowResSuffix)+".jpg", move{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range spriteSheets {
+		spriteSheet := spriteSheets[i]
+
+		image := spriteSheet.Image
+		err = add(image.FilePath, move{image: &image})
+		if err != nil {
+			return nil, err
+		}
+
+		err = add(spriteSheet.VTTFilePath, move{spriteSheet: &spriteSheet})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return moves, nil
+}
+
+func getTempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), tempPrefix+filepath.Base(path))
+}
+
+func copyFile(sourcePath string, destinationPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = source.Close()
+	}()
+
+	destination, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		_ = destination.Close()
+		return err
+	}
+
+	err = destination.Sync()
+	if err != nil {
+		_ = destination.Close()
+		return err
+	}
+
+	return destination.Close()
+}
+
+// write writes the file for a move (via a temporary file, so that an interrupted move doesn't leave a partial file
+// behind that looks like a finished one) and returns its size
+func (a *Archiver) write(m move) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(m.destinationPath), 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	tempPath := getTempPath(m.destinationPath)
+	_ = os.Remove(tempPath)
+
+	if m.reencode {
+		stdout, stderr, err := converter.ReencodeVideo(m.sourcePath, tempPath, a.bitrate)
+		if err != nil {
+			_ = os.Remove(tempPath)
+			return 0, fmt.Errorf("failed to re-encode %#+v: %v; stdout=%#+v, stderr=%#+v", m.sourcePath, err, stdout, stderr)
+		}
+	} else {
+		err = copyFile(m.sourcePath, tempPath)
+		if err != nil {
+			_ = os.Remove(tempPath)
+			return 0, fmt.Errorf("failed to copy %#+v: %v", m.sourcePath, err)
+		}
+	}
+
+	err = os.Rename(tempPath, m.destinationPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return 0, err
+	}
+
+	info, err := os.Stat(m.destinationPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// the age of a segment's files is used to tell whether it's finished with (e.g. by the importer and reconciler)
+	sourceInfo, err := os.Stat(m.sourcePath)
+	if err == nil {
+		_ = os.Chtimes(m.destinationPath, sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+
+	return info.Size(), nil
+}
+
+// archive moves an event's files to the archive; they're all written there first, then the rows are pointed at them
+// (in one transaction) and only then are the originals removed, so that the rows always point at a whole file
+func (a *Archiver) archive(moves []move, report *Report) error {
+	written := make([]string, 0, len(moves))
+
+	undo := func() {
+		for _, path := range written {
+			_ = os.Remove(path)
+		}
+	}
+
+	videos := make([]model.Video, 0)
+	images := make([]model.Image, 0)
+	spriteSheets := make([]model.SpriteSheet, 0)
+	bytes := int64(0)
+	writtenBytes := int64(0)
+
+	for _, m := range moves {
+		info, err := os.Stat(m.sourcePath)
+		if err != nil {
+			undo()
+			return err
+		}
+
+		size, err := a.write(m)
+		if err != nil {
+			undo()
+			return err
+		}
+
+		written = append(written, m.destinationPath)
+		bytes += info.Size()
+		writtenBytes += size
+
+		if m.video != nil {
+			video := model.Video{ID: m.video.ID, FilePath: a.resolver.ToCanonical(m.destinationPath)}
+
+			if m.reencode {
+				video.Size = float64(size) / 1000000
+
+				video.Checksum, err = metadata.GetFileChecksum(m.destinationPath)
+				if err != nil {
+					undo()
+					return err
+				}
+			}
+
+			videos = append(videos, video)
+		}
+
+		if m.image != nil {
+			images = append(images, model.Image{ID: m.image.ID, FilePath: a.resolver.ToCanonical(m.destinationPath)})
+		}
+
+		if m.spriteSheet != nil {
+			spriteSheets = append(spriteSheets, model.SpriteSheet{
+				ID:          m.spriteSheet.ID,
+				VTTFilePath: a.resolver.ToCanonical(m.destinationPath),
+			})
+		}
+	}
+
+	err := helpers.MoveMedia(a.application, videos, images, spriteSheets)
+	if err != nil {
+		undo()
+		return fmt.Errorf("failed to update rows (none were): %v", err)
+	}
+
+	for _, m := range moves {
+		err = os.Remove(m.sourcePath)
+		if err != nil {
+			log.Printf("warning: archived %#+v but failed to remove it because %v", m.sourcePath, err)
+		}
+	}
+
+	report.Bytes += bytes
+	report.Written += writtenBytes
+
+	return nil
+}
+
+// getSpriteSheets returns the sprite sheets of the events (with local paths) by event id
+func (a *Archiver) getSpriteSheets(events []model.Event) (map[int64][]model.SpriteSheet, error) {
+	eventIDs := make([]int64, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.ID)
+	}
+
+	spriteSheets, err := helpers.GetSpriteSheets(a.application, eventIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	spriteSheetsByEventID := make(map[int64][]model.SpriteSheet)
+	for _, spriteSheet := range spriteSheets {
+		spriteSheet.VTTFilePath = a.resolver.ToLocal(spriteSheet.VTTFilePath)
+		spriteSheet.Image.FilePath = a.resolver.ToLocal(spriteSheet.Image.FilePath)
+
+		spriteSheetsByEventID[spriteSheet.EventID] = append(spriteSheetsByEventID[spriteSheet.EventID], spriteSheet)
+	}
+
+	return spriteSheetsByEventID, nil
+}
+
+// Archive pages through the events (pageSize at a time) and archives those under the source root that are old enough
+func (a *Archiver) Archive() (Report, error) {
+	report := Report{}
+
+	now := time.Now()
+	afterID := int64(0)
+
+	for {
+		events, err := helpers.GetEventsAfter(a.application, afterID, pageSize)
+		if err != nil {
+			return report, err
+		}
+
+		if len(events) == 0 {
+			return report, nil
+		}
+
+		afterID = events[len(events)-1].ID
+		report.Checked += len(events)
+
+		spriteSheetsByEventID, err := a.getSpriteSheets(events)
+		if err != nil {
+			return report, err
+		}
+
+		for _, event := range events {
+			event.OriginalVideo.FilePath = a.resolver.ToLocal(event.OriginalVideo.FilePath)
+			event.ProcessedVideo.FilePath = a.resolver.ToLocal(event.ProcessedVideo.FilePath)
+			event.ThumbnailImage.FilePath = a.resolver.ToLocal(event.ThumbnailImage.FilePath)
+
+			if !isUnder(event.OriginalVideo.FilePath, a.sourceRoot) || now.Sub(event.EndTimestamp.Time) < a.maxAge {
+				continue
+			}
+
+			moves, err := getMoves(
+				event,
+				spriteSheetsByEventID[event.ID],
+				a.sourceRoot,
+				a.archiveRoot,
+				a.bitrate != "",
+			)
+			if err != nil {
+				log.Printf("warning: event %v; skipping because %v", event.ID, err)
+				report.Failed++
+				continue
+			}
+
+			// there's nothing left to move (the event pruner deals with these); an event whose high-res video is gone
+			// still has the rest of its files moved
+			if len(moves) == 0 {
+				continue
+			}
+
+			if a.dryRun {
+				for _, m := range moves {
+					info, err := os.Stat(m.sourcePath)
+					if err == nil {
+						report.Bytes += info.Size()
+					}
+
+					log.Printf("would move %#+v to %#+v", m.sourcePath, m.destinationPath)
+				}
+
+				report.Archived++
+				continue
+			}
+
+			err = a.archive(moves, &report)
+			if err != nil {
+				log.Printf("warning: event %v; failed to archive because %v", event.ID, err)
+				report.Failed++
+				continue
+			}
+
+			report.Archived++
+		}
+	}
+}
+
+func (a *Archiver) work() {
+	report, err := a.Archive()
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+
+	if a.dryRun {
+		log.Printf("(dry run) %v", report)
+		return
+	}
+
+	log.Printf("%v", report)
+}
+
+func (a *Archiver) Start() {
+	a.scheduledWorker.Start()
+}
+
+func (a *Archiver) Stop() {
+	a.scheduledWorker.Stop()
+}
@@ -0,0 +1,157 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestGetMoves(t *testing.T) {
+	dir := t.TempDir()
+	sourceRoot := filepath.Join(dir, "segments")
+	archiveRoot := filepath.Join(dir, "archive")
+
+	for _, name := range []string{
+		"Segment_2020-12-25T08:45:04_Driveway.mp4",
+		"Segment_2020-12-25T08:45:04_Driveway__lowres.mp4",
+		"Segment_2020-12-25T08:45:04_Driveway.jpg",
+		"Segment_2020-12-25T08:45:04_Driveway__lowres.jpg",
+		"Segment_2020-12-25T08:45:04_Driveway__sprite.jpg",
+		"Segment_2020-12-25T08:45:04_Driveway__sprite.vtt",
+	} {
+		path := filepath.Join(sourceRoot, "2020-12-25", name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(name), 0644))
+	}
+
+	event := model.Event{
+		OriginalVideo:  model.Video{ID: 10, FilePath: filepath.Join(sourceRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.mp4")},
+		ProcessedVideo: model.Video{ID: 11, FilePath: filepath.Join(sourceRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway__lowres.mp4")},
+		ThumbnailImage: model.Image{ID: 20, FilePath: filepath.Join(sourceRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway__lowres.jpg")},
+	}
+
+	spriteSheets := []model.SpriteSheet{
+		{
+			ID:          30,
+			VTTFilePath: filepath.Join(sourceRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway__sprite.vtt"),
+			Image:       model.Image{ID: 21, FilePath: filepath.Join(sourceRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway__sprite.jpg")},
+		},
+	}
+
+	moves, err := getMoves(event, spriteSheets, sourceRoot, archiveRoot, true)
+	require.NoError(t, err)
+	require.Len(t, moves, 6)
+
+	assert.Equal(t, int64(10), moves[0].video.ID)
+	assert.True(t, moves[0].reencode)
+	assert.Equal(t, int64(11), moves[1].video.ID)
+	assert.False(t, moves[1].reencode)
+	assert.Equal(t, int64(20), moves[2].image.ID)
+	assert.Nil(t, moves[3].video)
+	assert.Nil(t, moves[3].image)
+	assert.Equal(t, int64(21), moves[4].image.ID)
+	assert.Equal(t, int64(30), moves[5].spriteSheet.ID)
+
+	assert.Equal(t, filepath.Join(archiveRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.mp4"), moves[0].destinationPath)
+	assert.Equal(t, filepath.Join(archiveRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.jpg"), moves[3].destinationPath)
+
+	// files that are gone (or already archived) are left out
+	require.NoError(t, os.Remove(event.ProcessedVideo.FilePath))
+	event.ThumbnailImage.FilePath = filepath.Join(archiveRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway__lowres.jpg")
+
+	moves, err = getMoves(event, nil, sourceRoot, archiveRoot, false)
+	require.NoError(t, err)
+	require.Len(t, moves, 1)
+	assert.Equal(t, event.OriginalVideo.FilePath, moves[0].sourcePath)
+
+	// an event whose high-res video is gone still has the rest of its files moved
+	require.NoError(t, os.Remove(event.OriginalVideo.FilePath))
+
+	moves, err = getMoves(event, spriteSheets, sourceRoot, archiveRoot, false)
+	require.NoError(t, err)
+	require.Len(t, moves, 2)
+	assert.Equal(t, int64(21), moves[0].image.ID)
+	assert.Equal(t, int64(30), moves[1].spriteSheet.ID)
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	sourcePath := filepath.Join(dir, "segments", "Segment_2020-12-25T08:45:04_Driveway.mp4")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sourcePath), 0755))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("some video"), 0644))
+
+	modTime := time.Now().Add(-time.Hour * 24).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(sourcePath, modTime, modTime))
+
+	m := move{
+		sourcePath:      sourcePath,
+		destinationPath: filepath.Join(dir, "archive", "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.mp4"),
+	}
+
+	a := Archiver{}
+
+	size, err := a.write(m)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("some video")), size)
+
+	data, err := os.ReadFile(m.destinationPath)
+	require.NoError(t, err)
+	assert.Equal(t, "some video", string(data))
+
+	info, err := os.Stat(m.destinationPath)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	_, err = os.Stat(getTempPath(m.destinationPath))
+	assert.True(t, os.IsNotExist(err))
+
+	// the source is left for the caller to remove once the rows point at the archive
+	_, err = os.Stat(sourcePath)
+	assert.NoError(t, err)
+}
+
+func TestNewArchiver_BitrateWithChain(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewArchiver(
+		"http://localhost:8080/v1/graphql",
+		time.Second,
+		time.Hour,
+		filepath.Join(dir, "segments"),
+		filepath.Join(dir, "archive"),
+		time.Hour*24,
+		"500k",
+		filepath.Join(dir, "chains"),
+		true,
+		nil,
+		false,
+	)
+	assert.Error(t, err)
+}
+
+func TestNewArchiver_BitrateNotAllowed(t *testing.T) {
+	dir := t.TempDir()
+
+	// without a -chainPath there may still be a hash chain, so re-encoding has to be allowed explicitly
+	_, err := NewArchiver(
+		"http://localhost:8080/v1/graphql",
+		time.Second,
+		time.Hour,
+		filepath.Join(dir, "segments"),
+		filepath.Join(dir, "archive"),
+		time.Hour*24,
+		"500k",
+		"",
+		false,
+		nil,
+		false,
+	)
+	assert.Error(t, err)
+}
@@ -1,29 +1,70 @@
 package event_pruner
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/initialed85/glue/pkg/worker"
 
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
 	"github.com/initialed85/cameranator/pkg/persistence/model"
+	"github.com/initialed85/cameranator/pkg/storage"
+)
+
+const (
+	pageSize     = 100
+	lowResSuffix = "__lowres.jpg"
 )
 
 type EventPruner struct {
 	scheduledWorker *worker.ScheduledWorker
 	application     *application.Application
+	retention       *Retention
+	storages        []storage.Storage // that the events' files may be in (the first one they're in is used)
+	resolver        *path_resolver.Resolver
+	dryRun          bool
 }
 
+// NewEventPruner returns an EventPruner that (every interval) deletes the events whose files are all gone and, if
+// retention is set, the events and videos that it says are due to go (files and all); files are looked for in
+// mediaStorage (if it's set) and then on the local filesystem (at the paths that resolver gives), and if dryRun is set
+// it only logs what it would have done
 func NewEventPruner(
 	url string,
 	timeout time.Duration,
 	interval time.Duration,
+	retention *Retention,
+	mediaStorage storage.Storage,
+	resolver *path_resolver.Resolver,
+	dryRun bool,
 ) (*EventPruner, error) {
 	var err error
 
-	e := EventPruner{}
+	e := EventPruner{
+		retention: retention,
+		storages:  make([]storage.Storage, 0, 2),
+		resolver:  resolver,
+		dryRun:    dryRun,
+	}
+
+	if mediaStorage != nil {
+		e.storages = append(e.storages, mediaStorage)
+	}
+
+	localStorage, err := storage.NewLocal("/")
+	if err != nil {
+		return nil, err
+	}
+
+	e.storages = append(e.storages, localStorage)
 
 	e.scheduledWorker = worker.NewScheduledWorker(
 		func() {},
@@ -37,126 +78,328 @@ func NewEventPruner(
 	return &e, err
 }
 
-func (e *EventPruner) work() {
-	eventModel, err := e.application.GetModelAndClient("event")
+// stat returns what's at path (a file_path from the database) and the storage it's in
+func (e *EventPruner) stat(path string) (storage.Info, storage.Storage, error) {
+	s, key, err := storage.Find(e.storages, e.resolver.ToLocal(path))
 	if err != nil {
-		log.Printf("warning: %v", err)
-		return
+		return storage.Info{}, nil, err
 	}
 
-	videoModel, err := e.application.GetModelAndClient("video")
+	info, err := s.Stat(key)
 	if err != nil {
-		log.Printf("warning: %v", err)
-		return
+		return storage.Info{}, nil, err
 	}
 
-	imageModel, err := e.application.GetModelAndClient("image")
-	if err != nil {
-		log.Printf("warning: %v", err)
-		return
+	return info, s, nil
+}
+
+// shouldPrune returns true if the event's files are all gone (i.e. there's nothing left to look at); if it can't tell
+// (e.g. the storage they're in can't be reached) it returns false
+func (e *EventPruner) shouldPrune(event model.Event) bool {
+	for _, path := range []string{event.OriginalVideo.FilePath, event.ThumbnailImage.FilePath} {
+		if path == "" {
+			continue
+		}
+
+		_, _, err := e.stat(path)
+		if err == nil || !storage.IsNotFound(err) {
+			return false
+		}
 	}
 
-	objectModel, err := e.application.GetModelAndClient("object")
-	if err != nil {
-		log.Printf("warning: %v", err)
-		return
+	return true
+}
+
+// getNamedAfterPath returns the path of the high-res video that an event's other files are named after; once that
+// video has gone (see DropOriginalVideos) the event's original video is its sub-stream one, named the same bar the
+// suffix
+func getNamedAfterPath(videoPath string) string {
+	extension := filepath.Ext(videoPath)
+	base := strings.TrimSuffix(videoPath, extension)
+
+	if !strings.HasSuffix(base, segment_template.SubStreamSuffix) {
+		return videoPath
 	}
 
-	events := make([]model.Event, 0)
+	return strings.TrimSuffix(base, segment_template.SubStreamSuffix) + extension
+}
 
-	err = eventModel.GetAll(&events)
-	if err != nil {
-		log.Printf("warning: %v", err)
-		return
+// getMediaPaths returns the paths of all of the event's files; that includes the thumbnail that its low-res image
+// was made from, its sprite sheet and any thumbnail picked from its best frame (which are named after its high-res
+// video)
+func getMediaPaths(event model.Event) []string {
+	paths := []string{event.OriginalVideo.FilePath}
+
+	if event.ProcessedVideo.FilePath != "" {
+		paths = append(paths, event.ProcessedVideo.FilePath)
 	}
 
-	for _, event := range events {
-		paths := make([]string, 0)
-		paths = append(paths, event.OriginalVideo.FilePath)
-		paths = append(paths, event.ThumbnailImage.FilePath)
+	imagePath := event.ThumbnailImage.FilePath
+	paths = append(paths, imagePath)
 
-		remove := true
-		for _, path := range paths {
-			_, err := os.Stat(path)
+	if strings.HasSuffix(imagePath, lowResSuffix) {
+		paths = append(paths, strings.TrimSuffix(imagePath, lowResSuffix)+".jpg")
+	}
 
-			if err == nil {
-				remove = false
-				break
-			}
+	namedAfterPath := getNamedAfterPath(event.OriginalVideo.FilePath)
+
+	spriteImagePath, spriteVTTPath := sprite_sheet.GetPaths(namedAfterPath)
+	paths = append(paths, spriteImagePath, spriteVTTPath)
+
+	// unless the thumbnail is one of these already, they may have been left behind by an attempt to swap it for one
+	bestImagePath, bestLowResImagePath := thumbnail_creator.GetBestPaths(namedAfterPath)
+	if bestLowResImagePath != imagePath {
+		paths = append(paths, bestImagePath, bestLowResImagePath)
+	}
+
+	return paths
+}
+
+// getSize returns the total size of those of paths that exist
+func (e *EventPruner) getSize(paths []string) int64 {
+	size := int64(0)
+
+	for _, path := range paths {
+		info, _, err := e.stat(path)
+		if err == nil {
+			size += info.Size
 		}
+	}
+
+	return size
+}
+
+// removeFiles removes those of paths that exist, returning the bytes freed
+func (e *EventPruner) removeFiles(paths []string) int64 {
+	freed := int64(0)
 
-		if !remove {
+	for _, path := range paths {
+		info, s, err := e.stat(path)
+		if err != nil {
 			continue
 		}
 
-		//
-		// event
-		//
-
-		log.Printf("attempting to delete %#+v", event)
-		err = eventModel.Remove(event, &[]model.Event{})
+		err = s.Delete(info.Key)
 		if err != nil {
-			log.Printf("warning: %v", err)
+			log.Printf("warning: failed to remove %#+v because %v", path, err)
 			continue
 		}
 
-		//
-		// object
-		//
+		freed += info.Size
+	}
 
-		objects := make([]model.Object, 0)
-		err = objectModel.GetMany(&objects, "event_id", event.ID)
-		if err != nil {
-			log.Printf("warning: %v", err)
+	return freed
+}
+
+// getBestScores returns the best score for each class that the retention rules care about, by event id
+func (e *EventPruner) getBestScores(events []model.Event) (map[int64]map[string]float64, error) {
+	bestScores := make(map[int64]map[string]float64)
+
+	if e.retention == nil {
+		return bestScores, nil
+	}
+
+	classes, minScore := e.retention.Classes()
+	if len(classes) == 0 {
+		return bestScores, nil
+	}
+
+	eventIDs := make([]int64, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.ID)
+	}
+
+	detections, err := helpers.GetBestDetections(e.application, eventIDs, classes, minScore)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, detection := range detections {
+		_, ok := bestScores[detection.EventID]
+		if !ok {
+			bestScores[detection.EventID] = make(map[string]float64)
+		}
+
+		bestScores[detection.EventID][detection.ClassName] = detection.Score
+	}
+
+	return bestScores, nil
+}
+
+type plan struct {
+	events      []model.Event // to go entirely
+	videoEvents []model.Event // whose high-res videos are to go (they're staying, with their sub-stream videos instead)
+	bytes       int64         // freed by the above
+}
+
+// getPlan works out what's due to go from a page of events
+func (e *EventPruner) getPlan(events []model.Event, now time.Time) (plan, error) {
+	p := plan{
+		events:      make([]model.Event, 0),
+		videoEvents: make([]model.Event, 0),
+	}
+
+	bestScores, err := e.getBestScores(events)
+	if err != nil {
+		return p, err
+	}
+
+	for _, event := range events {
+		decision := Decision{}
+
+		if e.retention != nil {
+			decision = e.retention.Decide(event.SourceCamera.Name, event.EndTimestamp.Time, bestScores[event.ID], now)
+		}
+
+		if decision.Event || e.shouldPrune(event) {
+			p.events = append(p.events, event)
+			p.bytes += e.getSize(getMediaPaths(event))
 			continue
 		}
 
-		for _, object := range objects {
-			err = objectModel.Remove(object, &[]model.Object{})
-			if err != nil {
-				log.Printf("warning: %v", err)
-				continue
-			}
+		// an event without a sub-stream video keeps its high-res one (until the event goes), as it'd have no video left
+		// otherwise; one that's already lost it has its sub-stream video as its original one and no processed one
+		if decision.Video && event.ProcessedVideoID != 0 {
+			p.videoEvents = append(p.videoEvents, event)
+			p.bytes += e.getSize([]string{event.OriginalVideo.FilePath})
 		}
+	}
+
+	return p, nil
+}
 
-		//
-		// video
-		//
+// work pages through the events (pageSize at a time) and deletes what's due to go, a page at a time; an event's rows
+// go (or are pointed elsewhere) first, in one transaction, and then its files, so that nothing's left pointing at a
+// file that's gone
+func (e *EventPruner) work() {
+	total := helpers.DeletedCounts{}
+	checked := 0
+	videos := 0
+	bytes := int64(0)
 
-		videos := make([]model.Video, 0)
-		err = videoModel.GetOne(&videos, "id", event.OriginalVideoID)
+	now := time.Now()
+	afterID := int64(0)
+
+	for batch := 1; ; batch++ {
+		events, err := helpers.GetEventsAfter(e.application, afterID, pageSize)
 		if err != nil {
 			log.Printf("warning: %v", err)
-			continue
+			return
+		}
+
+		if len(events) == 0 {
+			break
 		}
-		video := videos[0]
 
-		log.Printf("attempting to delete %#+v", video)
-		err = videoModel.Remove(video, &[]model.Video{})
+		afterID = events[len(events)-1].ID
+		checked += len(events)
+
+		p, err := e.getPlan(events, now)
 		if err != nil {
-			log.Printf("warning: %v", err)
+			log.Printf("warning: batch %v; skipping because %v", batch, err)
+			continue
+		}
+
+		if len(p.events) == 0 && len(p.videoEvents) == 0 {
 			continue
 		}
 
-		//
-		// image
-		//
+		if e.dryRun {
+			for _, event := range p.events {
+				log.Printf("would delete event %v (%v) and %#+v", event.ID, event.SourceCamera.Name, getMediaPaths(event))
+			}
+
+			for _, event := range p.videoEvents {
+				log.Printf("would delete high-res video %#+v (of event %v)", event.OriginalVideo.FilePath, event.ID)
+			}
+
+			log.Printf(
+				"batch %v (%v events checked); would delete %v events and %v high-res videos, freeing %.1f MB",
+				batch,
+				len(events),
+				len(p.events),
+				len(p.videoEvents),
+				float64(p.bytes)/1000000,
+			)
+
+			total.Events += len(p.events)
+			videos += len(p.videoEvents)
+			bytes += p.bytes
 
-		images := make([]model.Image, 0)
-		err = imageModel.GetOne(&images, "id", event.ThumbnailImageID)
-		if err != nil {
-			log.Printf("warning: %v", err)
 			continue
 		}
-		image := images[0]
 
-		log.Printf("attempting to delete %#+v", image)
-		err = imageModel.Remove(image, &[]model.Image{})
+		freed := int64(0)
+
+		counts, err := helpers.DeleteEvents(e.application, p.events)
 		if err != nil {
-			log.Printf("warning: %v", err)
-			continue
+			log.Printf("warning: batch %v; failed to delete %v events (none were) because %v", batch, len(p.events), err)
+		} else {
+			for _, event := range p.events {
+				freed += e.removeFiles(getMediaPaths(event))
+			}
+
+			total = total.Add(counts)
+		}
+
+		droppedVideos := 0
+
+		err = helpers.DropOriginalVideos(e.application, p.videoEvents)
+		if err != nil {
+			log.Printf("warning: batch %v; failed to delete %v high-res videos (none were) because %v", batch, len(p.videoEvents), err)
+		} else {
+			for _, event := range p.videoEvents {
+				freed += e.removeFiles([]string{event.OriginalVideo.FilePath})
+			}
+
+			droppedVideos = len(p.videoEvents)
 		}
+
+		videos += droppedVideos
+		bytes += freed
+
+		log.Printf(
+			"batch %v (%v events checked); %v and %v high-res videos, freeing %.1f MB",
+			batch,
+			len(events),
+			describe(counts),
+			droppedVideos,
+			float64(freed)/1000000,
+		)
 	}
+
+	if e.dryRun {
+		log.Printf(
+			"checked %v events; would delete %v events and %v high-res videos, freeing %.1f MB",
+			checked,
+			total.Events,
+			videos,
+			float64(bytes)/1000000,
+		)
+
+		return
+	}
+
+	log.Printf(
+		"checked %v events; %v and %v high-res videos, freeing %.1f MB",
+		checked,
+		describe(total),
+		videos,
+		float64(bytes)/1000000,
+	)
+}
+
+func describe(counts helpers.DeletedCounts) string {
+	return fmt.Sprintf(
+		"deleted %v events, %v videos, %v images, %v sprite sheets, %v objects, %v detections and %v aggregated detections",
+		counts.Events,
+		counts.Videos,
+		counts.Images,
+		counts.SpriteSheets,
+		counts.Objects,
+		counts.Detections,
+		counts.AggregatedDetections,
+	)
 }
 
 func (e *EventPruner) RunOnce() {
@@ -0,0 +1,70 @@
+package event_pruner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestGetMediaPaths(t *testing.T) {
+	event := model.Event{
+		OriginalVideo:  model.Video{FilePath: "/srv/segments/Segment_2020-12-25T08:45:04_Driveway.mp4"},
+		ProcessedVideo: model.Video{FilePath: "/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"},
+		ThumbnailImage: model.Image{FilePath: "/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg"},
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best__lowres.jpg",
+		},
+		getMediaPaths(event),
+	)
+
+	// the thumbnail that was picked from the best frame isn't in there twice
+	event.ThumbnailImage.FilePath = "/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best__lowres.jpg"
+
+	assert.Equal(
+		t,
+		[]string{
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best__lowres.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt",
+		},
+		getMediaPaths(event),
+	)
+}
+
+func TestGetMediaPaths_WithoutHighResVideo(t *testing.T) {
+	// as left by DropOriginalVideos; the files are still named after the high-res video
+	event := model.Event{
+		OriginalVideo:  model.Video{FilePath: "/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4"},
+		ThumbnailImage: model.Image{FilePath: "/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg"},
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best.jpg",
+			"/srv/segments/Segment_2020-12-25T08:45:04_Driveway__best__lowres.jpg",
+		},
+		getMediaPaths(event),
+	)
+}
@@ -0,0 +1,296 @@
+package event_pruner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+
+e.g.
+
+default:
+  max_age: 30d       # events go (rows, thumbnails and low-res video) once they're this old
+  video_max_age: 7d  # the high-res video goes once it's this old (if there's a low-res one to take its place)
+cameras:
+  Driveway:
+    video_max_age: 14d
+keep:
+  - classes: [person, car]
+    min_score: 0.7
+    max_age: 90d
+    video_max_age: 30d
+
+*/
+
+type RetentionRule struct {
+	MaxAge      string `json:"max_age,omitempty" yaml:"max_age,omitempty"`             // e.g. 30d or 720h; empty means forever
+	VideoMaxAge string `json:"video_max_age,omitempty" yaml:"video_max_age,omitempty"` // empty means the same as max_age
+}
+
+// KeepRule keeps events with a detection of one of Classes (scoring at least MinScore) for longer
+type KeepRule struct {
+	Classes     []string `json:"classes" yaml:"classes"`
+	MinScore    float64  `json:"min_score,omitempty" yaml:"min_score,omitempty"`
+	MaxAge      string   `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	VideoMaxAge string   `json:"video_max_age,omitempty" yaml:"video_max_age,omitempty"`
+}
+
+type RetentionConfig struct {
+	Default RetentionRule            `json:"default" yaml:"default"`
+	Cameras map[string]RetentionRule `json:"cameras,omitempty" yaml:"cameras,omitempty"` // anything left empty comes from default
+	Keep    []KeepRule               `json:"keep,omitempty" yaml:"keep,omitempty"`
+}
+
+func ParseRetentionConfig(data []byte, extension string) (RetentionConfig, error) {
+	config := RetentionConfig{}
+
+	var err error
+
+	switch strings.ToLower(extension) {
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		return RetentionConfig{}, fmt.Errorf("unsupported config extension %#+v; must be .json, .yaml or .yml", extension)
+	}
+
+	if err != nil {
+		return RetentionConfig{}, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	return config, nil
+}
+
+func LoadRetentionConfig(path string) (RetentionConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RetentionConfig{}, err
+	}
+
+	config, err := ParseRetentionConfig(data, filepath.Ext(path))
+	if err != nil {
+		return RetentionConfig{}, fmt.Errorf("failed to parse %#+v: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// parseAge parses a Go duration, or a number of days (e.g. 30d); empty is 0 (i.e. forever)
+func parseAge(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+
+	if raw == "" {
+		return 0, nil
+	}
+
+	var age time.Duration
+
+	if strings.HasSuffix(raw, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(raw, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %#+v as days: %v", raw, err)
+		}
+
+		age = time.Duration(days * float64(time.Hour*24))
+	} else {
+		var err error
+
+		age, err = time.ParseDuration(raw)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if age <= 0 {
+		return 0, fmt.Errorf("%#+v must be > 0 (or left empty for forever)", raw)
+	}
+
+	return age, nil
+}
+
+type ages struct {
+	maxAge      time.Duration // 0 is forever
+	videoMaxAge time.Duration // 0 is forever
+}
+
+// longest returns the longer of each of the ages (forever being the longest)
+func (a ages) longest(other ages) ages {
+	longer := func(x, y time.Duration) time.Duration {
+		if x == 0 || y == 0 {
+			return 0
+		}
+
+		if x > y {
+			return x
+		}
+
+		return y
+	}
+
+	return ages{
+		maxAge:      longer(a.maxAge, other.maxAge),
+		videoMaxAge: longer(a.videoMaxAge, other.videoMaxAge),
+	}
+}
+
+func parseAges(maxAge string, videoMaxAge string) (ages, error) {
+	a := ages{}
+
+	var err error
+
+	a.maxAge, err = parseAge(maxAge)
+	if err != nil {
+		return ages{}, fmt.Errorf("invalid max_age: %v", err)
+	}
+
+	a.videoMaxAge, err = parseAge(videoMaxAge)
+	if err != nil {
+		return ages{}, fmt.Errorf("invalid video_max_age: %v", err)
+	}
+
+	if a.videoMaxAge == 0 || (a.maxAge != 0 && a.videoMaxAge > a.maxAge) {
+		a.videoMaxAge = a.maxAge
+	}
+
+	return a, nil
+}
+
+type keepRule struct {
+	classes  map[string]struct{}
+	minScore float64
+	ages     ages
+}
+
+// Retention decides (from a RetentionConfig) what's due to go
+type Retention struct {
+	defaultAges ages
+	cameraAges  map[string]ages
+	keepRules   []keepRule
+}
+
+func NewRetention(config RetentionConfig) (*Retention, error) {
+	var err error
+
+	r := Retention{
+		cameraAges: make(map[string]ages),
+		keepRules:  make([]keepRule, 0),
+	}
+
+	r.defaultAges, err = parseAges(config.Default.MaxAge, config.Default.VideoMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("default has %v", err)
+	}
+
+	for cameraName, rule := range config.Cameras {
+		maxAge := rule.MaxAge
+		if maxAge == "" {
+			maxAge = config.Default.MaxAge
+		}
+
+		videoMaxAge := rule.VideoMaxAge
+		if videoMaxAge == "" {
+			videoMaxAge = config.Default.VideoMaxAge
+		}
+
+		r.cameraAges[cameraName], err = parseAges(maxAge, videoMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("camera %#+v has %v", cameraName, err)
+		}
+	}
+
+	for i, rule := range config.Keep {
+		if len(rule.Classes) == 0 {
+			return nil, fmt.Errorf("keep[%v] has no classes", i)
+		}
+
+		if rule.MinScore < 0 || rule.MinScore > 1 {
+			return nil, fmt.Errorf("keep[%v] has min_score %v; must be between 0 and 1", i, rule.MinScore)
+		}
+
+		k := keepRule{
+			classes:  make(map[string]struct{}),
+			minScore: rule.MinScore,
+		}
+
+		for _, class := range rule.Classes {
+			k.classes[class] = struct{}{}
+		}
+
+		k.ages, err = parseAges(rule.MaxAge, rule.VideoMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("keep[%v] has %v", i, err)
+		}
+
+		r.keepRules = append(r.keepRules, k)
+	}
+
+	return &r, nil
+}
+
+// Classes returns every class that a KeepRule cares about, and the lowest score that any of them do
+func (r *Retention) Classes() ([]string, float64) {
+	classes := make([]string, 0)
+	minScore := 1.0
+
+	seen := make(map[string]struct{})
+
+	for _, rule := range r.keepRules {
+		for class := range rule.classes {
+			_, ok := seen[class]
+			if !ok {
+				seen[class] = struct{}{}
+				classes = append(classes, class)
+			}
+		}
+
+		if rule.minScore < minScore {
+			minScore = rule.minScore
+		}
+	}
+
+	sort.Strings(classes)
+
+	return classes, minScore
+}
+
+// Decision is what's due to go for an event
+type Decision struct {
+	Event bool // everything (the rows and all of the media)
+	Video bool // just the high-res video
+}
+
+// Decide returns what's due to go for an event from cameraName that ended at end, given the best score for each class
+// detected in it
+func (r *Retention) Decide(cameraName string, end time.Time, bestScores map[string]float64, now time.Time) Decision {
+	a, ok := r.cameraAges[cameraName]
+	if !ok {
+		a = r.defaultAges
+	}
+
+	for _, rule := range r.keepRules {
+		for class, score := range bestScores {
+			_, ok := rule.classes[class]
+			if ok && score >= rule.minScore {
+				a = a.longest(rule.ages)
+				break
+			}
+		}
+	}
+
+	age := now.Sub(end)
+
+	return Decision{
+		Event: a.maxAge != 0 && age >= a.maxAge,
+		Video: a.videoMaxAge != 0 && age >= a.videoMaxAge,
+	}
+}
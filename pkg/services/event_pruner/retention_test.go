@@ -0,0 +1,82 @@
+package event_pruner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const day = time.Hour * 24
+
+func TestRetention(t *testing.T) {
+	config, err := ParseRetentionConfig([]byte(`
+default:
+  max_age: 30d
+  video_max_age: 7d
+cameras:
+  Driveway:
+    video_max_age: 14d
+  Garage:
+    max_age: 48h
+keep:
+  - classes: [person, car]
+    min_score: 0.7
+    max_age: 90d
+    video_max_age: 30d
+`), ".yaml")
+	require.NoError(t, err)
+
+	retention, err := NewRetention(config)
+	require.NoError(t, err)
+
+	classes, minScore := retention.Classes()
+	assert.Equal(t, []string{"car", "person"}, classes)
+	assert.Equal(t, 0.7, minScore)
+
+	now := time.Date(2020, 12, 25, 8, 45, 4, 0, time.UTC)
+
+	decide := func(cameraName string, age time.Duration, bestScores map[string]float64) Decision {
+		return retention.Decide(cameraName, now.Add(-age), bestScores, now)
+	}
+
+	assert.Equal(t, Decision{}, decide("SideGate", day*6, nil))
+	assert.Equal(t, Decision{Video: true}, decide("SideGate", day*7, nil))
+	assert.Equal(t, Decision{Event: true, Video: true}, decide("SideGate", day*30, nil))
+
+	// per camera, with the rest from the default
+	assert.Equal(t, Decision{}, decide("Driveway", day*7, nil))
+	assert.Equal(t, Decision{Video: true}, decide("Driveway", day*14, nil))
+	assert.Equal(t, Decision{Event: true, Video: true}, decide("Garage", day*2, nil))
+
+	// kept for longer if there's something worth keeping
+	assert.Equal(t, Decision{}, decide("SideGate", day*29, map[string]float64{"person": 0.9}))
+	assert.Equal(t, Decision{Video: true}, decide("SideGate", day*31, map[string]float64{"car": 0.7}))
+	assert.Equal(t, Decision{Video: true}, decide("Garage", day*31, map[string]float64{"car": 0.7}))
+	assert.Equal(t, Decision{Event: true, Video: true}, decide("SideGate", day*31, map[string]float64{"dog": 0.9}))
+	assert.Equal(t, Decision{Event: true, Video: true}, decide("SideGate", day*31, map[string]float64{"person": 0.5}))
+}
+
+func TestRetention_Forever(t *testing.T) {
+	retention, err := NewRetention(RetentionConfig{Default: RetentionRule{VideoMaxAge: "7d"}})
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	assert.Equal(t, Decision{Video: true}, retention.Decide("Driveway", now.Add(-day*365), nil, now))
+}
+
+func TestNewRetention_Invalid(t *testing.T) {
+	_, err := NewRetention(RetentionConfig{Default: RetentionRule{MaxAge: "a month"}})
+	assert.Error(t, err)
+
+	_, err = NewRetention(RetentionConfig{Default: RetentionRule{MaxAge: "-1d"}})
+	assert.Error(t, err)
+
+	_, err = NewRetention(RetentionConfig{Keep: []KeepRule{{MaxAge: "90d"}}})
+	assert.Error(t, err)
+
+	_, err = NewRetention(RetentionConfig{Keep: []KeepRule{{Classes: []string{"person"}, MinScore: 70}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,281 @@
+package importer
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+)
+
+const motionTimestampLayout = "2006-01-02T15:04:05"
+
+var (
+	// e.g. Segment_2020-12-25T08:45:04_Driveway.mp4 (or with an offset after the timestamp, and / or __lowres before
+	// the extension for a sub-stream segment)
+	segmentMatcher = regexp.MustCompile(
+		`^Segment_\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}([+-]\d{4})?_(.+?)(` + segment_template.SubStreamSuffix + `)?\.(mp4|ts)$`,
+	)
+
+	// e.g. Event_2020-12-27T10:25:05__104__Testing__01.mp4 (from when motion did the recording); the image for an event
+	// has the same event number and camera but a later timestamp
+	motionMatcher = regexp.MustCompile(`^Event_(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2})__(\d+)__(.+)__(\d+)\.(mp4|jpg)$`)
+
+	// thumbnails for segments (and the low-res copies of them) and their sprite sheets; they're regenerated if
+	// missing, so they aren't needed to find anything
+	segmentImageMatcher = regexp.MustCompile(`^Segment_.*\.(jpg|vtt)$`)
+)
+
+// Segment is a video found on disk, along with what goes with it
+type Segment struct {
+	CameraName         string
+	StartTimestamp     time.Time
+	VideoPath          string
+	SubStreamVideoPath string // empty if there isn't one
+	ImagePath          string // an existing thumbnail; empty if one has to be made
+	Size               int64  // of the video (and sub-stream video)
+}
+
+type Found struct {
+	Segments     []Segment // in order of camera and then start
+	TooRecent    int       // videos modified within minAge (i.e. possibly still being recorded / processed)
+	Unrecognised int       // files that aren't named like anything we know of (or couldn't be parsed)
+}
+
+func getImagePath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".jpg"
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+type finder struct {
+	location   *time.Location
+	templates  map[string]*segment_template.Template
+	segments   map[string]*Segment // by main stream video path
+	subStreams map[string]string   // main stream video path by sub-stream video path
+	sizes      map[string]int64
+	motion     map[string]*Segment // by folder, event number, camera and sequence
+}
+
+// getTemplate returns the template a segment name was made with, so that its timestamp is parsed exactly as the
+// segment generator would
+func (f *finder) getTemplate(cameraName string, withOffset bool, extension string) (*segment_template.Template, error) {
+	pattern := segment_template.LegacyPattern
+	if withOffset {
+		pattern = segment_template.DefaultPattern
+	}
+
+	pattern = segment_template.WithExtension(pattern, extension)
+
+	key := cameraName + "\x00" + pattern
+
+	template, ok := f.templates[key]
+	if ok {
+		return template, nil
+	}
+
+	template, err := segment_template.NewTemplate(pattern, cameraName, f.location)
+	if err != nil {
+		return nil, err
+	}
+
+	f.templates[key] = template
+
+	return template, nil
+}
+
+func (f *finder) addSegment(path string, submatches []string, size int64) error {
+	withOffset := submatches[1] != ""
+	cameraName := submatches[2]
+	isSubStream := submatches[3] != ""
+	extension := "." + submatches[4]
+
+	if isSubStream {
+		mainPath := strings.TrimSuffix(path, segment_template.SubStreamSuffix+extension) + extension
+		f.subStreams[path] = mainPath
+		f.sizes[path] = size
+		return nil
+	}
+
+	template, err := f.getTemplate(cameraName, withOffset, extension)
+	if err != nil {
+		return err
+	}
+
+	start, err := template.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	segment := Segment{
+		CameraName:     cameraName,
+		StartTimestamp: start,
+		VideoPath:      path,
+		Size:           size,
+	}
+
+	imagePath := getImagePath(path)
+	if fileExists(imagePath) {
+		segment.ImagePath = imagePath
+	}
+
+	f.segments[path] = &segment
+
+	return nil
+}
+
+func (f *finder) addMotion(path string, submatches []string, size int64) error {
+	cameraName := submatches[3]
+	key := strings.Join([]string{filepath.Dir(path), submatches[2], cameraName, submatches[4]}, "\x00")
+
+	segment, ok := f.motion[key]
+	if !ok {
+		segment = &Segment{CameraName: cameraName}
+		f.motion[key] = segment
+	}
+
+	if submatches[5] == "jpg" {
+		segment.ImagePath = path
+		return nil
+	}
+
+	start, err := time.ParseInLocation(motionTimestampLayout, submatches[1], f.location)
+	if err != nil {
+		return fmt.Errorf("failed to parse %#+v from %#+v: %v", submatches[1], path, err)
+	}
+
+	segment.StartTimestamp = start
+	segment.VideoPath = path
+	segment.Size = size
+
+	return nil
+}
+
+// segmentsFound pairs up what's been found and returns it in order
+func (f *finder) segmentsFound() []Segment {
+	for subStreamPath, mainPath := range f.subStreams {
+		segment, ok := f.segments[mainPath]
+		if !ok {
+			continue // there's nothing to hang a sub-stream video off of without its main stream video
+		}
+
+		segment.SubStreamVideoPath = subStreamPath
+		segment.Size += f.sizes[subStreamPath]
+	}
+
+	segments := make([]Segment, 0, len(f.segments)+len(f.motion))
+
+	for _, segment := range f.segments {
+		segments = append(segments, *segment)
+	}
+
+	for _, segment := range f.motion {
+		if segment.VideoPath == "" {
+			continue // an image without its video
+		}
+
+		segments = append(segments, *segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].CameraName != segments[j].CameraName {
+			return segments[i].CameraName < segments[j].CameraName
+		}
+
+		if !segments[i].StartTimestamp.Equal(segments[j].StartTimestamp) {
+			return segments[i].StartTimestamp.Before(segments[j].StartTimestamp)
+		}
+
+		return segments[i].VideoPath < segments[j].VideoPath
+	})
+
+	return segments
+}
+
+// Find walks root (skipping hidden folders, e.g. the segment generator's outbox) for segments and motion-era events;
+// timestamps without an offset in their names are taken to be in location, and videos modified within minAge of now
+// are left for the segment processor
+func Find(root string, location *time.Location, minAge time.Duration, now time.Time) (Found, error) {
+	found := Found{}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return found, err
+	}
+
+	f := finder{
+		location:   location,
+		templates:  make(map[string]*segment_template.Template),
+		segments:   make(map[string]*Segment),
+		subStreams: make(map[string]string),
+		sizes:      make(map[string]int64),
+		motion:     make(map[string]*Segment),
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := entry.Name()
+
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		segmentSubmatches := segmentMatcher.FindStringSubmatch(name)
+		motionSubmatches := motionMatcher.FindStringSubmatch(name)
+
+		if segmentSubmatches == nil && motionSubmatches == nil {
+			if !segmentImageMatcher.MatchString(name) && !strings.HasPrefix(name, ".") {
+				found.Unrecognised++
+			}
+
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		isVideo := segmentSubmatches != nil || motionSubmatches[5] != "jpg"
+		if isVideo && now.Sub(info.ModTime()) < minAge {
+			found.TooRecent++
+			return nil
+		}
+
+		if segmentSubmatches != nil {
+			err = f.addSegment(path, segmentSubmatches, info.Size())
+		} else {
+			err = f.addMotion(path, motionSubmatches, info.Size())
+		}
+
+		if err != nil {
+			log.Printf("warning: skipping %#+v because %v", path, err)
+			found.Unrecognised++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return found, err
+	}
+
+	found.Segments = f.segmentsFound()
+
+	return found, nil
+}
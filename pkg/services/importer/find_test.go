@@ -0,0 +1,85 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+
+	location, err := time.LoadLocation("Australia/Perth")
+	require.NoError(t, err)
+
+	write := func(path string, age time.Duration) string {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("some data"), 0644))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		return path
+	}
+
+	hour := time.Hour
+
+	driveway2 := write("2020-12/Segment_2020-12-25T08:46:04_Driveway.mp4", hour)
+	driveway1 := write("2020-12/Segment_2020-12-25T08:45:04_Driveway.mp4", hour)
+	driveway1SubStream := write("2020-12/Segment_2020-12-25T08:45:04_Driveway__lowres.mp4", hour)
+	driveway1Image := write("2020-12/Segment_2020-12-25T08:45:04_Driveway.jpg", hour)
+	write("2020-12/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg", hour)
+	write("2020-12/Segment_2020-12-25T08:45:04_Driveway__sprite.jpg", hour)
+	write("2020-12/Segment_2020-12-25T08:45:04_Driveway__sprite.vtt", hour)
+	sideGate := write("Segment_2020-12-25T08:45:04+0000_Side_Gate.ts", hour)
+	write("Segment_2020-12-25T08:47:04_Side_Gate__lowres.ts", hour) // no main stream segment to go with
+	motion := write("events/Event_2020-12-27T10:25:05__104__Testing__01.mp4", hour)
+	motionImage := write("events/Event_2020-12-27T10:25:09__104__Testing__01.jpg", hour)
+	write("events/Event_2020-12-27T10:30:09__105__Testing__01.jpg", hour) // no video to go with
+	write("Segment_2020-12-25T08:48:04_Driveway.mp4", time.Second)        // still being recorded
+	write(".outbox/Segment_2020-12-25T08:49:04_Driveway.mp4", hour)
+	write("notes.txt", hour)
+
+	found, err := Find(root, location, time.Minute, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, found.TooRecent)
+	assert.Equal(t, 1, found.Unrecognised)
+
+	assert.Equal(
+		t,
+		[]Segment{
+			{
+				CameraName:         "Driveway",
+				StartTimestamp:     time.Date(2020, 12, 25, 8, 45, 4, 0, location),
+				VideoPath:          driveway1,
+				SubStreamVideoPath: driveway1SubStream,
+				ImagePath:          driveway1Image,
+				Size:               18,
+			},
+			{
+				CameraName:     "Driveway",
+				StartTimestamp: time.Date(2020, 12, 25, 8, 46, 4, 0, location),
+				VideoPath:      driveway2,
+				Size:           9,
+			},
+			{
+				CameraName:     "Side_Gate",
+				StartTimestamp: time.Date(2020, 12, 25, 8, 45, 4, 0, time.UTC).In(location),
+				VideoPath:      sideGate,
+				Size:           9,
+			},
+			{
+				CameraName:     "Testing",
+				StartTimestamp: time.Date(2020, 12, 27, 10, 25, 5, 0, location),
+				VideoPath:      motion,
+				ImagePath:      motionImage,
+				Size:           9,
+			},
+		},
+		found.Segments,
+	)
+}
@@ -0,0 +1,315 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/relvacode/iso8601"
+
+	"github.com/initialed85/cameranator/pkg/media/converter"
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/media/segment_repairer"
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+)
+
+const pageSize = 100
+
+type Outcome string
+
+const (
+	OutcomeImported        Outcome = "imported"
+	OutcomeWouldImport     Outcome = "would import" // in a dry run
+	OutcomeAlreadyImported Outcome = "already imported"
+	OutcomeSkipped         Outcome = "skipped" // its video isn't fit to import
+	OutcomeFailed          Outcome = "failed"
+)
+
+// ErrInvalid is returned (wrapped) by ImportSegment for a segment whose video isn't fit to import, even after trying
+// to repair it (as the segment processor would have skipped it too)
+var ErrInvalid = errors.New("not fit to import")
+
+type Result struct {
+	Segment Segment
+	Outcome Outcome
+	Err     error
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%v: %#+v (%v); %v", r.Outcome, r.Segment.VideoPath, r.Segment.CameraName, r.Err)
+	}
+
+	return fmt.Sprintf("%v: %#+v (%v)", r.Outcome, r.Segment.VideoPath, r.Segment.CameraName)
+}
+
+type Report struct {
+	Found           int
+	Imported        int // or would be, in a dry run
+	AlreadyImported int
+	Skipped         int
+	Failed          int
+	TooRecent       int
+	Unrecognised    int
+	Bytes           int64 // of the videos imported (or that would be)
+}
+
+func (r Report) OK() bool {
+	return r.Failed == 0
+}
+
+type Importer struct {
+	application *application.Application
+	location    *time.Location
+	minAge      time.Duration
+	resolver    *path_resolver.Resolver
+	dryRun      bool
+}
+
+// NewImporter returns an Importer for segments named in location's timezone (unless their names say otherwise); videos
+// modified within minAge are left alone (as the segment processor may yet get to them), paths are recorded in the
+// database as resolver makes them canonical, and if dryRun is set nothing is written (to disk or to the database)
+func NewImporter(
+	url string,
+	timeout time.Duration,
+	location *time.Location,
+	minAge time.Duration,
+	resolver *path_resolver.Resolver,
+	dryRun bool,
+) (*Importer, error) {
+	var err error
+
+	i := Importer{
+		location: location,
+		minAge:   minAge,
+		resolver: resolver,
+		dryRun:   dryRun,
+	}
+
+	i.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// makeFile runs makeFn against a temporary path and then moves the result into place, so that an interrupted import
+// doesn't leave a partial file behind to be taken as finished next time
+func makeFile(path string, makeFn func(tempPath string) error) error {
+	tempPath := strings.TrimSuffix(path, ".jpg") + ".importing.jpg"
+	_ = os.Remove(tempPath)
+
+	err := makeFn(tempPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// MakeImages makes the thumbnail at imagePath from the video (or its sub-stream video, which is much cheaper to
+// decode, if it's set) and then the low-res copy of it that events point to; either is left as it is if it's already
+// there, and the low-res image's path is returned
+func MakeImages(videoPath string, subStreamVideoPath string, imagePath string) (string, error) {
+	if !fileExists(imagePath) {
+		thumbnailSourcePath := videoPath
+		if subStreamVideoPath != "" {
+			thumbnailSourcePath = subStreamVideoPath
+		}
+
+		err := makeFile(imagePath, func(tempPath string) error {
+			return thumbnail_creator.GetThumbnail(thumbnailSourcePath, tempPath)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create thumbnail: %v", err)
+		}
+	}
+
+	// named the same way as the segment processor's
+	lowResImagePath := strings.ReplaceAll(imagePath, ".jpg", "__lowres.jpg")
+	if !fileExists(lowResImagePath) {
+		err := makeFile(lowResImagePath, func(tempPath string) error {
+			stdout, stderr, err := converter.ConvertImage(imagePath, tempPath, 640, 360)
+			if err != nil {
+				return fmt.Errorf("%v; stdout=%#+v, stderr=%#+v", err, stdout, stderr)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create low-res image: %v", err)
+		}
+	}
+
+	return lowResImagePath, nil
+}
+
+// ImportSegment validates the segment's videos as the segment processor would (see segment_repairer.ValidateOrRepair),
+// makes its images (unless they're already there) and adds an event for it; an error wrapping ErrInvalid is returned
+// if its video isn't fit to import, and an invalid sub-stream video is just left off
+func (i *Importer) ImportSegment(segment Segment) error {
+	err := segment_repairer.ValidateOrRepair(segment.VideoPath)
+	if err != nil {
+		return fmt.Errorf("%v; %w", err, ErrInvalid)
+	}
+
+	if segment.SubStreamVideoPath != "" {
+		err = segment_repairer.ValidateOrRepair(segment.SubStreamVideoPath)
+		if err != nil {
+			log.Printf("warning: leaving sub-stream video off of %#+v because %v", segment.VideoPath, err)
+			segment.SubStreamVideoPath = ""
+		}
+	}
+
+	duration, err := metadata.GetVideoDuration(segment.VideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get duration: %v", err)
+	}
+
+	imagePath := segment.ImagePath
+	if imagePath == "" {
+		imagePath = getImagePath(segment.VideoPath)
+	}
+
+	lowResImagePath, err := MakeImages(segment.VideoPath, segment.SubStreamVideoPath, imagePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = helpers.AddEventWithLocations(
+		i.application,
+		segment.CameraName,
+		iso8601.Time{Time: segment.StartTimestamp},
+		iso8601.Time{Time: segment.StartTimestamp.Add(duration)},
+		segment.VideoPath,
+		lowResImagePath,
+		segment.SubStreamVideoPath,
+		i.resolver.ToCanonical,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add event: %v", err)
+	}
+
+	return nil
+}
+
+// GetImported returns the (local) video paths of those segments that already have a video in the database
+func (i *Importer) GetImported(segments []Segment) (map[string]struct{}, error) {
+	filePaths := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		filePaths = append(filePaths, i.resolver.ToCanonical(segment.VideoPath))
+	}
+
+	videos, err := helpers.GetVideosByFilePath(i.application, filePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := make(map[string]struct{})
+	for _, video := range videos {
+		imported[i.resolver.ToLocal(video.FilePath)] = struct{}{}
+	}
+
+	return imported, nil
+}
+
+// checkCamera returns an error if there's no camera by the given name (as every event has to belong to one)
+func (i *Importer) checkCamera(cameraName string, checked map[string]error) error {
+	err, ok := checked[cameraName]
+	if ok {
+		return err
+	}
+
+	_, err = helpers.GetCamera(i.application, cameraName)
+	if err != nil {
+		err = fmt.Errorf("no usable camera named %#+v (%v); add it to the camera table first", cameraName, err)
+	}
+
+	checked[cameraName] = err
+
+	return err
+}
+
+// Import adds an event for each segment under root that isn't in the database yet; a segment is known by its video's
+// path, so it's safe to run again over the same folders (e.g. after being interrupted, in which case it carries on
+// from where it got to); onResult is invoked for each segment found
+func (i *Importer) Import(root string, onResult func(Result)) (Report, error) {
+	report := Report{}
+
+	found, err := Find(root, i.location, i.minAge, time.Now())
+	if err != nil {
+		return report, err
+	}
+
+	report.Found = len(found.Segments)
+	report.TooRecent = found.TooRecent
+	report.Unrecognised = found.Unrecognised
+
+	checkedCameras := make(map[string]error)
+
+	for start := 0; start < len(found.Segments); start += pageSize {
+		end := start + pageSize
+		if end > len(found.Segments) {
+			end = len(found.Segments)
+		}
+
+		page := found.Segments[start:end]
+
+		imported, err := i.GetImported(page)
+		if err != nil {
+			return report, err
+		}
+
+		for _, segment := range page {
+			result := Result{Segment: segment}
+
+			_, ok := imported[segment.VideoPath]
+			if ok {
+				result.Outcome = OutcomeAlreadyImported
+				report.AlreadyImported++
+				onResult(result)
+				continue
+			}
+
+			err = i.checkCamera(segment.CameraName, checkedCameras)
+			if err == nil && !i.dryRun {
+				err = i.ImportSegment(segment)
+			}
+
+			if errors.Is(err, ErrInvalid) {
+				result.Outcome = OutcomeSkipped
+				result.Err = err
+				report.Skipped++
+				onResult(result)
+				continue
+			}
+
+			if err != nil {
+				result.Outcome = OutcomeFailed
+				result.Err = err
+				report.Failed++
+				onResult(result)
+				continue
+			}
+
+			result.Outcome = OutcomeImported
+			if i.dryRun {
+				result.Outcome = OutcomeWouldImport
+			}
+
+			report.Imported++
+			report.Bytes += segment.Size
+			onResult(result)
+		}
+	}
+
+	return report, nil
+}
@@ -24,6 +24,12 @@ subscription LiveEvents {
 			start_timestamp
 			end_timestamp
 		}
+		processed_video {
+			file_path
+			camera_id
+			start_timestamp
+			end_timestamp
+		}
 	}
 }
 `
@@ -10,6 +10,7 @@ type PartialVideo struct {
 }
 
 type PartialEvent struct {
-	ID            int64        `json:"id,omitempty"`
-	OriginalVideo PartialVideo `json:"original_video,omitempty"`
+	ID             int64         `json:"id,omitempty"`
+	OriginalVideo  PartialVideo  `json:"original_video,omitempty"`
+	ProcessedVideo *PartialVideo `json:"processed_video,omitempty"` // nil unless the camera has a sub-stream
 }
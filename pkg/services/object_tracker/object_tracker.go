@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/hasura/go-graphql-client"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/persistence/application"
 	"github.com/initialed85/glue/pkg/worker"
 	"gocv.io/x/gocv"
@@ -59,18 +60,23 @@ type ObjectTracker struct {
 	application               *application.Application
 	mu                        *sync.Mutex
 	url                       string
+	resolver                  *path_resolver.Resolver
 	mats                      chan gocv.Mat
 }
 
+// NewObjectTracker returns an ObjectTracker that finds the videos of events using resolver (see
+// path_resolver.Resolver)
 func NewObjectTracker(
 	url string,
 	timeout time.Duration,
+	resolver *path_resolver.Resolver,
 	mats chan gocv.Mat,
 ) (*ObjectTracker, error) {
 	o := ObjectTracker{
-		mu:   new(sync.Mutex),
-		url:  url,
-		mats: mats,
+		mu:       new(sync.Mutex),
+		url:      url,
+		resolver: resolver,
+		mats:     mats,
 	}
 
 	var err error
@@ -135,12 +141,7 @@ func (o *ObjectTracker) handleEvent(event *PartialEvent) error {
 		event.PartialDetection[i] = detection
 	}
 
-	originalVideoFilePathParts := strings.Split(event.OriginalVideo.FilePath, "/")
-
-	filePath := fmt.Sprintf(
-		"/Users/edwardbeech/romulus-storage/cameranator-cameranator-pvc-02357f2f-139d-4963-85f7-ca2428d9fa2d/media/srv/segments/%v",
-		originalVideoFilePathParts[len(originalVideoFilePathParts)-1],
-	)
+	filePath := o.resolver.ToLocal(event.OriginalVideo.FilePath)
 
 	rawData, err := ffmpeg.Probe(filePath)
 	if err != nil {
@@ -0,0 +1,158 @@
+package quota_enforcer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseBytes parses a size like 500GB, 1.5TiB or 1048576 (i.e. bytes); 0 means no quota
+func ParseBytes(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+
+	i := strings.IndexFunc(raw, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(raw)
+	}
+
+	number, unit := raw[:i], strings.ToUpper(strings.TrimSpace(raw[i:]))
+
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit %#+v in %#+v; must be one of B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", unit, raw)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %#+v as a size: %v", raw, err)
+	}
+
+	bytes := value * multiplier
+	if bytes < 0 || bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("%#+v is out of range", raw)
+	}
+
+	return int64(bytes), nil
+}
+
+// Quotas are the byte budgets to keep under; 0 means no quota
+type Quotas struct {
+	Volume  int64            // for everything under the path
+	Cameras map[string]int64 // by camera name
+}
+
+// Value is how much a segment is worth keeping; the lowest go first
+type Value int
+
+const (
+	ValueNoEvent    Value = iota // on disk but not in the database (so it can't be found anyway)
+	ValueEvent                   // an event with nothing of note detected in it
+	ValueDetections              // an event with a detection of note
+)
+
+func (v Value) String() string {
+	switch v {
+	case ValueNoEvent:
+		return "no event"
+	case ValueEvent:
+		return "event"
+	case ValueDetections:
+		return "event with detections"
+	}
+
+	return fmt.Sprintf("Value(%d)", int(v))
+}
+
+// candidate is a segment (and everything that goes with it) that could be deleted
+type candidate struct {
+	cameraName     string
+	startTimestamp time.Time
+	paths          []string // that exist
+	size           int64
+	value          Value
+	event          *model.Event // nil if there's no event
+}
+
+// sortCandidates sorts candidates into the order they should go in; lowest value first and then oldest first
+func sortCandidates(candidates []candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].value != candidates[j].value {
+			return candidates[i].value < candidates[j].value
+		}
+
+		return candidates[i].startTimestamp.Before(candidates[j].startTimestamp)
+	})
+}
+
+// plan returns those of candidates that have to go (in the order they should go in) to bring each camera and then the
+// whole volume under quota, given what's used by each camera (including by what isn't a candidate, e.g. segments that
+// are too recent to touch)
+func plan(candidates []candidate, usage map[string]int64, quotas Quotas) []candidate {
+	sorted := make([]candidate, len(candidates))
+	copy(sorted, candidates)
+	sortCandidates(sorted)
+
+	used := make(map[string]int64)
+	total := int64(0)
+	for cameraName, size := range usage {
+		used[cameraName] = size
+		total += size
+	}
+
+	chosen := make([]bool, len(sorted))
+
+	for i, c := range sorted {
+		quota := quotas.Cameras[c.cameraName]
+		if quota <= 0 || used[c.cameraName] <= quota {
+			continue
+		}
+
+		chosen[i] = true
+		used[c.cameraName] -= c.size
+		total -= c.size
+	}
+
+	if quotas.Volume > 0 {
+		for i, c := range sorted {
+			if total <= quotas.Volume {
+				break
+			}
+
+			if chosen[i] {
+				continue
+			}
+
+			chosen[i] = true
+			total -= c.size
+		}
+	}
+
+	planned := make([]candidate, 0)
+	for i, c := range sorted {
+		if chosen[i] {
+			planned = append(planned, c)
+		}
+	}
+
+	return planned
+}
@@ -0,0 +1,395 @@
+package quota_enforcer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/glue/pkg/worker"
+
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+	"github.com/initialed85/cameranator/pkg/services/importer"
+)
+
+const pageSize = 100
+
+type Report struct {
+	Candidates int              // segments old enough to be deleted
+	Used       map[string]int64 // bytes, by camera name
+	Deleted    map[Value]int    // segments deleted (or that would be, in a dry run), by value
+	Freed      int64            // bytes
+	Failed     int              // segments that couldn't be deleted
+}
+
+func (r Report) OK() bool {
+	return r.Failed == 0
+}
+
+func (r Report) String() string {
+	total := int64(0)
+	for _, size := range r.Used {
+		total += size
+	}
+
+	return fmt.Sprintf(
+		"%v segments old enough to go; %.1f MB used; deleted %v with no event, %v with an event and %v with detections, freeing %.1f MB (%v failed)",
+		r.Candidates,
+		float64(total)/1000000,
+		r.Deleted[ValueNoEvent],
+		r.Deleted[ValueEvent],
+		r.Deleted[ValueDetections],
+		float64(r.Freed)/1000000,
+		r.Failed,
+	)
+}
+
+type QuotaEnforcer struct {
+	scheduledWorker *worker.ScheduledWorker
+	application     *application.Application
+	root            string
+	location        *time.Location
+	quotas          Quotas
+	classes         []string
+	minScore        float64
+	minAge          time.Duration
+	resolver        *path_resolver.Resolver
+	dryRun          bool
+}
+
+// NewQuotaEnforcer returns a QuotaEnforcer that (every interval) deletes segments under root (and their rows) until
+// each camera and the whole volume are under quota; segments with no event go first, then those without a detection
+// of one of classes (of any class if it's empty) scoring at least minScore, then the rest, and oldest first within
+// each of those. Segments that started within minAge are never deleted (as they may still be being recorded or
+// processed), segment names without an offset are taken to be in location, segments are matched to their rows as
+// resolver makes their paths canonical, and if dryRun is set it only logs what it would have done
+func NewQuotaEnforcer(
+	url string,
+	timeout time.Duration,
+	interval time.Duration,
+	root string,
+	location *time.Location,
+	quotas Quotas,
+	classes []string,
+	minScore float64,
+	minAge time.Duration,
+	resolver *path_resolver.Resolver,
+	dryRun bool,
+) (*QuotaEnforcer, error) {
+	var err error
+
+	q := QuotaEnforcer{
+		location: location,
+		quotas:   quotas,
+		classes:  classes,
+		minScore: minScore,
+		minAge:   minAge,
+		resolver: resolver,
+		dryRun:   dryRun,
+	}
+
+	q.root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	q.scheduledWorker = worker.NewScheduledWorker(
+		func() {},
+		q.work,
+		func() {},
+		interval,
+	)
+
+	q.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &q, nil
+}
+
+// getPaths returns those of the segment's files that exist, and their total size
+func getPaths(segment importer.Segment) ([]string, int64) {
+	imagePath := segment.ImagePath
+	if imagePath == "" {
+		imagePath = strings.TrimSuffix(segment.VideoPath, filepath.Ext(segment.VideoPath)) + ".jpg"
+	}
+
+	// named the same way as the segment processor's
+	lowResImagePath := strings.ReplaceAll(imagePath, ".jpg", "__lowres.jpg")
+	spriteImagePath, spriteVTTPath := sprite_sheet.GetPaths(segment.VideoPath)
+	bestImagePath, bestLowResImagePath := thumbnail_creator.GetBestPaths(segment.VideoPath)
+
+	paths := make([]string, 0, 8)
+	size := int64(0)
+
+	for _, path := range []string{
+		segment.VideoPath,
+		segment.SubStreamVideoPath,
+		imagePath,
+		lowResImagePath,
+		spriteImagePath,
+		spriteVTTPath,
+		bestImagePath,
+		bestLowResImagePath,
+	} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		paths = append(paths, path)
+		size += info.Size()
+	}
+
+	return paths, size
+}
+
+// getCandidates returns the segments under root that could go (valued using the database), and what's used by each
+// camera
+func (q *QuotaEnforcer) getCandidates(now time.Time) ([]candidate, map[string]int64, error) {
+	found, err := importer.Find(q.root, q.location, 0, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	candidates := make([]candidate, 0, len(found.Segments))
+	usage := make(map[string]int64)
+
+	for start := 0; start < len(found.Segments); start += pageSize {
+		end := start + pageSize
+		if end > len(found.Segments) {
+			end = len(found.Segments)
+		}
+
+		page := make([]candidate, 0, end-start)
+		videoPaths := make([]string, 0, end-start)
+
+		for _, segment := range found.Segments[start:end] {
+			paths, size := getPaths(segment)
+			usage[segment.CameraName] += size
+
+			if now.Sub(segment.StartTimestamp) < q.minAge {
+				continue
+			}
+
+			page = append(page, candidate{
+				cameraName:     segment.CameraName,
+				startTimestamp: segment.StartTimestamp,
+				paths:          paths,
+				size:           size,
+				value:          ValueNoEvent,
+			})
+
+			videoPaths = append(videoPaths, segment.VideoPath)
+		}
+
+		if len(page) == 0 {
+			continue
+		}
+
+		err = q.value(page, videoPaths)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		candidates = append(candidates, page...)
+	}
+
+	return candidates, usage, nil
+}
+
+// value sets the value of each of a page of candidates (and the event, for those that have one)
+func (q *QuotaEnforcer) value(page []candidate, videoPaths []string) error {
+	filePaths := make([]string, 0, len(videoPaths))
+	for _, videoPath := range videoPaths {
+		filePaths = append(filePaths, q.resolver.ToCanonical(videoPath))
+	}
+
+	events, err := helpers.GetEventsByVideoFilePath(q.application, filePaths)
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	eventsByVideoPath := make(map[string]model.Event)
+	eventIDs := make([]int64, 0, len(events))
+	for _, event := range events {
+		eventsByVideoPath[q.resolver.ToLocal(event.OriginalVideo.FilePath)] = event
+		eventIDs = append(eventIDs, event.ID)
+	}
+
+	var classes []string
+	if len(q.classes) > 0 {
+		classes = q.classes
+	}
+
+	detections, err := helpers.GetBestDetections(q.application, eventIDs, classes, q.minScore)
+	if err != nil {
+		return err
+	}
+
+	withDetections := make(map[int64]struct{})
+	for _, detection := range detections {
+		withDetections[detection.EventID] = struct{}{}
+	}
+
+	for i, videoPath := range videoPaths {
+		event, ok := eventsByVideoPath[videoPath]
+		if !ok {
+			continue
+		}
+
+		page[i].event = &event
+		page[i].value = ValueEvent
+
+		_, ok = withDetections[event.ID]
+		if ok {
+			page[i].value = ValueDetections
+		}
+	}
+
+	return nil
+}
+
+// removeFiles removes paths, returning the bytes freed
+func removeFiles(paths []string) (int64, error) {
+	freed := int64(0)
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return freed, err
+		}
+
+		freed += info.Size()
+	}
+
+	return freed, nil
+}
+
+// delete deletes a page of planned candidates; the rows go first (in one transaction), and then the files, so that
+// nothing's left pointing at a file that's gone
+func (q *QuotaEnforcer) delete(page []candidate, report *Report) {
+	events := make([]model.Event, 0)
+	for _, c := range page {
+		if c.event != nil {
+			events = append(events, *c.event)
+		}
+	}
+
+	if len(events) > 0 {
+		_, err := helpers.DeleteEvents(q.application, events)
+		if err != nil {
+			log.Printf("warning: failed to delete %v events (none were) because %v", len(events), err)
+			report.Failed += len(events)
+
+			// the segments without events can still go
+			remaining := make([]candidate, 0, len(page))
+			for _, c := range page {
+				if c.event == nil {
+					remaining = append(remaining, c)
+				}
+			}
+
+			page = remaining
+		}
+	}
+
+	for _, c := range page {
+		freed, err := removeFiles(c.paths)
+		report.Freed += freed
+
+		if err != nil {
+			log.Printf("warning: failed to remove %#+v because %v", c.paths, err)
+			report.Failed++
+			continue
+		}
+
+		report.Deleted[c.value]++
+	}
+}
+
+// Enforce deletes whatever has to go (see NewQuotaEnforcer) to bring each camera and then the whole volume under quota
+func (q *QuotaEnforcer) Enforce() (Report, error) {
+	report := Report{
+		Deleted: make(map[Value]int),
+	}
+
+	candidates, usage, err := q.getCandidates(time.Now())
+	if err != nil {
+		return report, err
+	}
+
+	report.Used = usage
+	report.Candidates = len(candidates)
+
+	planned := plan(candidates, usage, q.quotas)
+
+	if q.dryRun {
+		for _, c := range planned {
+			log.Printf("would delete %#+v (%v, %v)", c.paths, c.cameraName, c.value)
+			report.Deleted[c.value]++
+			report.Freed += c.size
+		}
+
+		return report, nil
+	}
+
+	for start := 0; start < len(planned); start += pageSize {
+		end := start + pageSize
+		if end > len(planned) {
+			end = len(planned)
+		}
+
+		q.delete(planned[start:end], &report)
+	}
+
+	return report, nil
+}
+
+func (q *QuotaEnforcer) work() {
+	report, err := q.Enforce()
+	if err != nil {
+		log.Printf("warning: %v", err)
+		return
+	}
+
+	if q.dryRun {
+		log.Printf("(dry run) %v", report)
+		return
+	}
+
+	log.Printf("%v", report)
+}
+
+func (q *QuotaEnforcer) RunOnce() {
+	q.work()
+}
+
+func (q *QuotaEnforcer) Start() {
+	q.scheduledWorker.Start()
+}
+
+func (q *QuotaEnforcer) Stop() {
+	q.scheduledWorker.Stop()
+}
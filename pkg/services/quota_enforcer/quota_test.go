@@ -0,0 +1,91 @@
+package quota_enforcer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBytes(t *testing.T) {
+	for raw, expected := range map[string]int64{
+		"0":       0,
+		"1048576": 1048576,
+		"500GB":   500000000000,
+		"500 gb":  500000000000,
+		"1.5TiB":  1649267441664,
+		"64MiB":   67108864,
+		"10kb":    10000,
+	} {
+		bytes, err := ParseBytes(raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, expected, bytes, raw)
+	}
+
+	for _, raw := range []string{"", "GB", "10XB", "-1GB", "1.2.3MB"} {
+		_, err := ParseBytes(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	start := time.Date(2020, 12, 25, 8, 0, 0, 0, time.UTC)
+
+	c := func(cameraName string, minutes int, value Value) candidate {
+		return candidate{
+			cameraName:     cameraName,
+			startTimestamp: start.Add(time.Minute * time.Duration(minutes)),
+			size:           100,
+			value:          value,
+		}
+	}
+
+	driveway0 := c("Driveway", 0, ValueDetections)
+	driveway5 := c("Driveway", 5, ValueEvent)
+	driveway10 := c("Driveway", 10, ValueEvent)
+	driveway15 := c("Driveway", 15, ValueNoEvent)
+	frontDoor0 := c("FrontDoor", 0, ValueEvent)
+	frontDoor5 := c("FrontDoor", 5, ValueDetections)
+
+	candidates := []candidate{driveway0, driveway5, driveway10, driveway15, frontDoor0, frontDoor5}
+
+	// 100 more each for segments too recent to be candidates
+	usage := map[string]int64{"Driveway": 500, "FrontDoor": 300}
+
+	t.Run("NoQuotas", func(t *testing.T) {
+		assert.Empty(t, plan(candidates, usage, Quotas{}))
+	})
+
+	t.Run("UnderQuota", func(t *testing.T) {
+		assert.Empty(t, plan(candidates, usage, Quotas{Volume: 800, Cameras: map[string]int64{"Driveway": 500}}))
+	})
+
+	t.Run("Camera", func(t *testing.T) {
+		assert.Equal(
+			t,
+			[]candidate{driveway15, driveway5, driveway10},
+			plan(candidates, usage, Quotas{Cameras: map[string]int64{"Driveway": 250}}),
+		)
+	})
+
+	t.Run("Volume", func(t *testing.T) {
+		assert.Equal(
+			t,
+			[]candidate{driveway15, frontDoor0, driveway5},
+			plan(candidates, usage, Quotas{Volume: 500}),
+		)
+	})
+
+	t.Run("CameraAndVolume", func(t *testing.T) {
+		assert.Equal(
+			t,
+			[]candidate{driveway15, frontDoor0, driveway5, driveway10},
+			plan(candidates, usage, Quotas{Volume: 400, Cameras: map[string]int64{"Driveway": 400}}),
+		)
+	})
+
+	t.Run("NotEnough", func(t *testing.T) {
+		assert.Len(t, plan(candidates, usage, Quotas{Volume: 1}), len(candidates))
+	})
+}
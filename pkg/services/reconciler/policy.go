@@ -0,0 +1,66 @@
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Class is a kind of disagreement between the database and what's on disk
+type Class string
+
+const (
+	ClassOrphanVideo      Class = "orphan video"             // a segment on disk with no video row
+	ClassOrphanImage      Class = "orphan image"             // an image on disk with no image row (that isn't what one was made from)
+	ClassParentlessLowRes Class = "parentless low-res image" // a __lowres.jpg with no image row and nothing left that it was made from
+	ClassMissingThumbnail Class = "missing thumbnail"        // an event whose thumbnail is gone but whose video isn't
+)
+
+// Action is what's done about a Finding
+type Action string
+
+const (
+	ActionReport     Action = "report"     // just log it
+	ActionReingest   Action = "reingest"   // add an event for it (as the importer would)
+	ActionRegenerate Action = "regenerate" // make it again from the video
+	ActionQuarantine Action = "quarantine" // move it out of the way (under the quarantine path)
+)
+
+var allowedActions = map[Class][]Action{
+	ClassOrphanVideo:      {ActionReport, ActionReingest, ActionQuarantine},
+	ClassOrphanImage:      {ActionReport, ActionQuarantine},
+	ClassParentlessLowRes: {ActionReport, ActionQuarantine},
+	ClassMissingThumbnail: {ActionReport, ActionRegenerate},
+}
+
+// Policy is the Action to take for each Class; a Class that isn't in it is reported
+type Policy map[Class]Action
+
+func (p Policy) Get(class Class) Action {
+	action, ok := p[class]
+	if !ok {
+		return ActionReport
+	}
+
+	return action
+}
+
+// Set parses action (empty means ActionReport) and sets it for class if it makes sense for it
+func (p Policy) Set(class Class, action string) error {
+	if action == "" {
+		action = string(ActionReport)
+	}
+
+	allowed := allowedActions[class]
+
+	names := make([]string, 0, len(allowed))
+	for _, a := range allowed {
+		if Action(strings.ToLower(action)) == a {
+			p[class] = a
+			return nil
+		}
+
+		names = append(names, string(a))
+	}
+
+	return fmt.Errorf("unsupported action %#+v for %v; must be one of %v", action, class, strings.Join(names, ", "))
+}
@@ -0,0 +1,438 @@
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/services/importer"
+)
+
+const (
+	pageSize     = 100
+	lowResSuffix = "__lowres.jpg"
+)
+
+// Finding is one disagreement between the database and what's on disk, and what (if anything) was done about it
+type Finding struct {
+	Class   Class
+	Paths   []string // the files involved (that exist)
+	EventID int64    // for ClassMissingThumbnail
+	Action  Action
+	Err     error
+	Skipped bool // the action wasn't taken because the segment isn't fit to reingest (see importer.ErrInvalid)
+}
+
+func (f Finding) String() string {
+	subject := strings.Join(f.Paths, ", ")
+	if f.EventID != 0 {
+		subject = fmt.Sprintf("event %v (%v)", f.EventID, subject)
+	}
+
+	if f.Skipped {
+		return fmt.Sprintf("%v: %v; skipped %v because %v", f.Class, subject, f.Action, f.Err)
+	}
+
+	if f.Err != nil {
+		return fmt.Sprintf("%v: %v; failed to %v because %v", f.Class, subject, f.Action, f.Err)
+	}
+
+	return fmt.Sprintf("%v: %v; %v", f.Class, subject, f.Action)
+}
+
+type Report struct {
+	Findings map[Class]int
+	Skipped  int // actions that weren't taken (see Finding.Skipped)
+	Failed   int // actions that didn't work out (the findings are still counted)
+}
+
+func (r Report) OK() bool {
+	return r.Failed == 0
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func getLowResPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, ".jpg") + lowResSuffix
+}
+
+// findImages returns the .jpg files under root (skipping hidden folders, and those modified within minAge of now)
+func findImages(root string, minAge time.Duration, now time.Time) ([]string, error) {
+	paths := make([]string, 0)
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := entry.Name()
+
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(name, ".jpg") || strings.HasSuffix(name, ".importing.jpg") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if now.Sub(info.ModTime()) < minAge {
+			return nil
+		}
+
+		paths = append(paths, path)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// classifyImage returns the Class of an image on disk, given the paths of the images in the database; ok is false if
+// it's accounted for
+func classifyImage(path string, referenced map[string]struct{}) (class Class, ok bool) {
+	_, isReferenced := referenced[path]
+	if isReferenced {
+		return "", false
+	}
+
+	if strings.HasSuffix(path, lowResSuffix) {
+		parent := strings.TrimSuffix(path, lowResSuffix)
+
+		for _, extension := range []string{".jpg", ".mp4", ".ts"} {
+			if fileExists(parent + extension) {
+				return ClassOrphanImage, true
+			}
+		}
+
+		return ClassParentlessLowRes, true
+	}
+
+	// what an event's low-res image was made from
+	_, isReferenced = referenced[getLowResPath(path)]
+	if isReferenced {
+		return "", false
+	}
+
+	return ClassOrphanImage, true
+}
+
+type Reconciler struct {
+	application    *application.Application
+	importer       *importer.Importer
+	location       *time.Location
+	minAge         time.Duration
+	policy         Policy
+	quarantinePath string
+	resolver       *path_resolver.Resolver
+}
+
+// NewReconciler returns a Reconciler that deals with what it finds according to policy; files modified within minAge
+// are left alone (as the segment generator and processor may yet get to them) and segment names without an offset
+// are taken to be in location; paths in the database are compared to those on disk as resolver makes them local
+func NewReconciler(
+	url string,
+	timeout time.Duration,
+	location *time.Location,
+	minAge time.Duration,
+	policy Policy,
+	quarantinePath string,
+	resolver *path_resolver.Resolver,
+) (*Reconciler, error) {
+	var err error
+
+	r := Reconciler{
+		location:       location,
+		minAge:         minAge,
+		policy:         policy,
+		quarantinePath: quarantinePath,
+		resolver:       resolver,
+	}
+
+	r.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r.importer, err = importer.NewImporter(url, timeout, location, minAge, resolver, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// quarantine moves each of paths to the same place under the quarantine path as it was under root
+func (r *Reconciler) quarantine(root string, paths []string) error {
+	for _, path := range paths {
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		destinationPath := filepath.Join(r.quarantinePath, relativePath)
+
+		err = os.MkdirAll(filepath.Dir(destinationPath), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(path, destinationPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reconciler) handle(report *Report, finding Finding, actionFn func() error, onFinding func(Finding)) {
+	report.Findings[finding.Class]++
+
+	if finding.Action != ActionReport {
+		finding.Err = actionFn()
+		if errors.Is(finding.Err, importer.ErrInvalid) {
+			finding.Skipped = true
+			report.Skipped++
+		} else if finding.Err != nil {
+			report.Failed++
+		}
+	}
+
+	onFinding(finding)
+}
+
+// reconcileVideos deals with segments that have no video row; it returns the files that belong to them (so that
+// they're not taken as orphan images as well)
+func (r *Reconciler) reconcileVideos(root string, report *Report, onFinding func(Finding)) (map[string]struct{}, error) {
+	belongsToOrphans := make(map[string]struct{})
+
+	found, err := importer.Find(root, r.location, r.minAge, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(found.Segments); start += pageSize {
+		end := start + pageSize
+		if end > len(found.Segments) {
+			end = len(found.Segments)
+		}
+
+		page := found.Segments[start:end]
+
+		imported, err := r.importer.GetImported(page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, segment := range page {
+			_, ok := imported[segment.VideoPath]
+			if ok {
+				continue
+			}
+
+			imagePath := segment.ImagePath
+			if imagePath == "" {
+				imagePath = strings.TrimSuffix(segment.VideoPath, filepath.Ext(segment.VideoPath)) + ".jpg"
+			}
+
+			spriteImagePath, spriteVTTPath := sprite_sheet.GetPaths(segment.VideoPath)
+			bestImagePath, bestLowResImagePath := thumbnail_creator.GetBestPaths(segment.VideoPath)
+
+			paths := make([]string, 0)
+			for _, path := range []string{
+				segment.VideoPath,
+				segment.SubStreamVideoPath,
+				imagePath,
+				getLowResPath(imagePath),
+				spriteImagePath,
+				spriteVTTPath,
+				bestImagePath,
+				bestLowResImagePath,
+			} {
+				if path != "" && fileExists(path) {
+					paths = append(paths, path)
+					belongsToOrphans[path] = struct{}{}
+				}
+			}
+
+			segment := segment
+			finding := Finding{Class: ClassOrphanVideo, Paths: paths, Action: r.policy.Get(ClassOrphanVideo)}
+
+			r.handle(report, finding, func() error {
+				if finding.Action == ActionReingest {
+					return r.importer.ImportSegment(segment)
+				}
+
+				return r.quarantine(root, paths)
+			}, onFinding)
+		}
+	}
+
+	return belongsToOrphans, nil
+}
+
+// reconcileImages deals with images that have no image row (and aren't what one was made from)
+func (r *Reconciler) reconcileImages(root string, belongsToOrphans map[string]struct{}, report *Report, onFinding func(Finding)) error {
+	allPaths, err := findImages(root, r.minAge, time.Now())
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(allPaths))
+	for _, path := range allPaths {
+		_, ok := belongsToOrphans[path]
+		if !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	for start := 0; start < len(paths); start += pageSize {
+		end := start + pageSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		page := paths[start:end]
+
+		filePaths := make([]string, 0, len(page)*2)
+		for _, path := range page {
+			filePaths = append(filePaths, r.resolver.ToCanonical(path))
+			if !strings.HasSuffix(path, lowResSuffix) {
+				filePaths = append(filePaths, r.resolver.ToCanonical(getLowResPath(path)))
+			}
+		}
+
+		images, err := helpers.GetImagesByFilePath(r.application, filePaths)
+		if err != nil {
+			return err
+		}
+
+		referenced := make(map[string]struct{})
+		for _, image := range images {
+			referenced[r.resolver.ToLocal(image.FilePath)] = struct{}{}
+		}
+
+		for _, path := range page {
+			class, ok := classifyImage(path, referenced)
+			if !ok {
+				continue
+			}
+
+			path := path
+			finding := Finding{Class: class, Paths: []string{path}, Action: r.policy.Get(class)}
+
+			r.handle(report, finding, func() error {
+				return r.quarantine(root, []string{path})
+			}, onFinding)
+		}
+	}
+
+	return nil
+}
+
+// reconcileThumbnails deals with events (with videos under root) whose thumbnail has gone missing
+func (r *Reconciler) reconcileThumbnails(root string, report *Report, onFinding func(Finding)) error {
+	afterID := int64(0)
+
+	for {
+		events, err := helpers.GetEventsAfter(r.application, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			afterID = event.ID
+
+			videoPath := r.resolver.ToLocal(event.OriginalVideo.FilePath)
+			imagePath := r.resolver.ToLocal(event.ThumbnailImage.FilePath)
+
+			if !strings.HasPrefix(videoPath, root+string(filepath.Separator)) {
+				continue
+			}
+
+			if imagePath == "" || fileExists(imagePath) || !fileExists(videoPath) {
+				continue
+			}
+
+			subStreamVideoPath := r.resolver.ToLocal(event.ProcessedVideo.FilePath)
+			if subStreamVideoPath != "" && !fileExists(subStreamVideoPath) {
+				subStreamVideoPath = ""
+			}
+
+			finding := Finding{
+				Class:   ClassMissingThumbnail,
+				Paths:   []string{videoPath},
+				EventID: event.ID,
+				Action:  r.policy.Get(ClassMissingThumbnail),
+			}
+
+			r.handle(report, finding, func() error {
+				if !strings.HasSuffix(imagePath, lowResSuffix) {
+					return fmt.Errorf("%#+v wasn't made by the segment processor, so can't be made again", imagePath)
+				}
+
+				_, err := importer.MakeImages(videoPath, subStreamVideoPath, strings.TrimSuffix(imagePath, lowResSuffix)+".jpg")
+
+				return err
+			}, onFinding)
+		}
+	}
+}
+
+// Reconcile compares root with the database in both directions (see Class) and takes the policy's Action for each
+// Finding; onFinding is invoked for each one
+func (r *Reconciler) Reconcile(root string, onFinding func(Finding)) (Report, error) {
+	report := Report{
+		Findings: make(map[Class]int),
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return report, err
+	}
+
+	belongsToOrphans, err := r.reconcileVideos(root, &report, onFinding)
+	if err != nil {
+		return report, err
+	}
+
+	err = r.reconcileImages(root, belongsToOrphans, &report, onFinding)
+	if err != nil {
+		return report, err
+	}
+
+	err = r.reconcileThumbnails(root, &report, onFinding)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
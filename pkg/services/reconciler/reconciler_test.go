@@ -0,0 +1,112 @@
+package reconciler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/services/importer"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{}
+
+	assert.Equal(t, ActionReport, policy.Get(ClassOrphanVideo))
+
+	require.NoError(t, policy.Set(ClassOrphanVideo, "Reingest"))
+	assert.Equal(t, ActionReingest, policy.Get(ClassOrphanVideo))
+
+	require.NoError(t, policy.Set(ClassMissingThumbnail, ""))
+	assert.Equal(t, ActionReport, policy.Get(ClassMissingThumbnail))
+
+	// an image can't be reingested on its own
+	assert.Error(t, policy.Set(ClassOrphanImage, "reingest"))
+	assert.Error(t, policy.Set(ClassMissingThumbnail, "quarantine"))
+}
+
+func TestClassifyImage(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+
+	write := func(name string, age time.Duration) string {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("some data"), 0644))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		return path
+	}
+
+	hour := time.Hour
+
+	ingestedImage := write("Segment_2020-12-25T08:45:04_Driveway.jpg", hour)
+	ingestedLowRes := write("Segment_2020-12-25T08:45:04_Driveway__lowres.jpg", hour)
+	write("Segment_2020-12-25T08:46:04_Driveway.mp4", hour)
+	orphanLowRes := write("Segment_2020-12-25T08:46:04_Driveway__lowres.jpg", hour)
+	parentlessLowRes := write("old/Segment_2020-12-25T08:47:04_Driveway__lowres.jpg", hour)
+	orphanImage := write("Segment_2020-12-25T08:48:04_Driveway.jpg", hour)
+	write("Segment_2020-12-25T08:49:04_Driveway.jpg", time.Second)              // too recent
+	write(".quarantine/Segment_2020-12-25T08:50:04_Driveway__lowres.jpg", hour) // already dealt with
+	write("Segment_2020-12-25T08:51:04_Driveway.importing.jpg", hour)           // being made
+
+	paths, err := findImages(root, time.Minute, now)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{ingestedImage, ingestedLowRes, orphanLowRes, parentlessLowRes, orphanImage}, paths)
+
+	referenced := map[string]struct{}{
+		ingestedLowRes: {},
+	}
+
+	classes := make(map[string]Class)
+	for _, path := range paths {
+		class, ok := classifyImage(path, referenced)
+		if ok {
+			classes[path] = class
+		}
+	}
+
+	assert.Equal(
+		t,
+		map[string]Class{
+			orphanLowRes:     ClassOrphanImage,
+			parentlessLowRes: ClassParentlessLowRes,
+			orphanImage:      ClassOrphanImage,
+		},
+		classes,
+	)
+}
+
+func TestReconciler_handle(t *testing.T) {
+	r := Reconciler{}
+	report := Report{Findings: make(map[Class]int)}
+	findings := make([]Finding, 0)
+
+	onFinding := func(finding Finding) {
+		findings = append(findings, finding)
+	}
+
+	finding := Finding{Class: ClassOrphanVideo, Action: ActionReingest}
+
+	r.handle(&report, finding, func() error {
+		return fmt.Errorf("%#+v is invalid: no video stream; %w", "Segment_2020-12-25T08:45:04_Driveway.mp4", importer.ErrInvalid)
+	}, onFinding)
+
+	r.handle(&report, finding, func() error {
+		return fmt.Errorf("failed to add event: some error")
+	}, onFinding)
+
+	assert.Equal(t, 2, report.Findings[ClassOrphanVideo])
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 1, report.Failed)
+
+	require.Len(t, findings, 2)
+	assert.True(t, findings[0].Skipped)
+	assert.Contains(t, findings[0].String(), "skipped reingest")
+	assert.False(t, findings[1].Skipped)
+	assert.Contains(t, findings[1].String(), "failed to reingest")
+}
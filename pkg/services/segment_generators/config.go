@@ -0,0 +1,210 @@
+package segment_generators
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/initialed85/cameranator/pkg/filesystem"
+	"github.com/initialed85/cameranator/pkg/media/segment_recorder"
+	"github.com/initialed85/cameranator/pkg/media/segment_template"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+)
+
+/*
+
+e.g.
+
+destination_path: /srv/target_dir/segments
+duration: 60
+timezone: Australia/Perth
+format: fmp4
+watcher_backend: polling
+cameras:
+  - name: Driveway
+    url: rtsp://192.168.137.31:554/Streaming/Channels/101
+    sub_stream_url: rtsp://192.168.137.31:554/Streaming/Channels/102
+  - name: SideGate
+    url: rtsp://192.168.137.33:554/Streaming/Channels/101
+    enabled: false
+
+*/
+
+type CameraConfig struct {
+	Name            string `json:"name" yaml:"name"`
+	URL             string `json:"url" yaml:"url"`
+	SubStreamURL    string `json:"sub_stream_url,omitempty" yaml:"sub_stream_url,omitempty"`
+	Duration        int    `json:"duration,omitempty" yaml:"duration,omitempty"`
+	DestinationPath string `json:"destination_path,omitempty" yaml:"destination_path,omitempty"`
+	Timezone        string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Format          string `json:"format,omitempty" yaml:"format,omitempty"`
+	WatcherBackend  string `json:"watcher_backend,omitempty" yaml:"watcher_backend,omitempty"`
+	Enabled         *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty"` // nil means enabled
+}
+
+type Config struct {
+	Duration        int            `json:"duration,omitempty" yaml:"duration,omitempty"`
+	DestinationPath string         `json:"destination_path,omitempty" yaml:"destination_path,omitempty"`
+	Timezone        string         `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Format          string         `json:"format,omitempty" yaml:"format,omitempty"`                   // fmp4 (the default), mpegts or mp4
+	WatcherBackend  string         `json:"watcher_backend,omitempty" yaml:"watcher_backend,omitempty"` // auto (the default), inotify or polling
+	Cameras         []CameraConfig `json:"cameras" yaml:"cameras"`
+}
+
+func ParseConfig(data []byte, extension string) (Config, error) {
+	config := Config{}
+
+	var err error
+
+	switch strings.ToLower(extension) {
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		return Config{}, fmt.Errorf("unsupported config extension %#+v; must be .json, .yaml or .yml", extension)
+	}
+
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	return config, nil
+}
+
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config, err := ParseConfig(data, filepath.Ext(path))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse %#+v: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Feeds validates the config and returns a Feed for each enabled camera (with the top-level defaults applied)
+func (c Config) Feeds() ([]segment_generator.Feed, error) {
+	feeds := make([]segment_generator.Feed, 0)
+	seen := make(map[string]struct{})
+
+	for i, camera := range c.Cameras {
+		if camera.Name == "" {
+			return nil, fmt.Errorf("cameras[%v] has no name", i)
+		}
+
+		_, ok := seen[camera.Name]
+		if ok {
+			return nil, fmt.Errorf("cameras[%v] has duplicate name %#+v", i, camera.Name)
+		}
+		seen[camera.Name] = struct{}{}
+
+		if camera.Enabled != nil && !*camera.Enabled {
+			continue
+		}
+
+		feed := segment_generator.Feed{
+			NetCamURL:       camera.URL,
+			SubStreamURL:    camera.SubStreamURL,
+			DestinationPath: camera.DestinationPath,
+			CameraName:      camera.Name,
+			Duration:        camera.Duration,
+			Timezone:        camera.Timezone,
+			Format:          camera.Format,
+			WatcherBackend:  camera.WatcherBackend,
+		}
+
+		if feed.DestinationPath == "" {
+			feed.DestinationPath = c.DestinationPath
+		}
+
+		if feed.Duration == 0 {
+			feed.Duration = c.Duration
+		}
+
+		if feed.Timezone == "" {
+			feed.Timezone = c.Timezone
+		}
+
+		if feed.Format == "" {
+			feed.Format = c.Format
+		}
+
+		if feed.WatcherBackend == "" {
+			feed.WatcherBackend = c.WatcherBackend
+		}
+
+		if feed.NetCamURL == "" {
+			return nil, fmt.Errorf("camera %#+v has no url", camera.Name)
+		}
+
+		if feed.DestinationPath == "" {
+			return nil, fmt.Errorf("camera %#+v has no destination_path (and there is no default)", camera.Name)
+		}
+
+		if feed.Duration <= 0 {
+			return nil, fmt.Errorf("camera %#+v has no duration > 0 (and there is no default)", camera.Name)
+		}
+
+		_, err := segment_template.LoadLocation(feed.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("camera %#+v has invalid timezone: %v", camera.Name, err)
+		}
+
+		_, err = segment_recorder.ParseFormat(feed.Format)
+		if err != nil {
+			return nil, fmt.Errorf("camera %#+v has invalid format: %v", camera.Name, err)
+		}
+
+		_, err = filesystem.ParseBackend(feed.WatcherBackend)
+		if err != nil {
+			return nil, fmt.Errorf("camera %#+v has invalid watcher_backend: %v", camera.Name, err)
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, nil
+}
+
+// FeedsFromCameras builds a Feed for each camera from the camera table (which only knows names and stream URLs;
+// the rest comes from the service's own settings)
+func FeedsFromCameras(
+	cameras []model.Camera,
+	destinationPath string,
+	duration int,
+	timezone string,
+	format string,
+	watcherBackend string,
+) []segment_generator.Feed {
+	feeds := make([]segment_generator.Feed, 0)
+
+	for _, camera := range cameras {
+		if camera.Name == "" || camera.StreamURL == "" {
+			log.Printf("warning: skipping camera %#+v; needs both a name and a stream_url", camera)
+			continue
+		}
+
+		feeds = append(feeds, segment_generator.Feed{
+			NetCamURL:       camera.StreamURL,
+			SubStreamURL:    camera.SubStreamURL,
+			DestinationPath: destinationPath,
+			CameraName:      camera.Name,
+			Duration:        duration,
+			Timezone:        timezone,
+			Format:          format,
+			WatcherBackend:  watcherBackend,
+		})
+	}
+
+	return feeds
+}
@@ -0,0 +1,168 @@
+package segment_generators
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+	"github.com/initialed85/cameranator/pkg/test_utils"
+)
+
+const testConfigYAML = `
+destination_path: /srv/target_dir/segments
+duration: 60
+timezone: Australia/Perth
+cameras:
+  - name: Driveway
+    url: rtsp://192.168.137.31:554/Streaming/Channels/101
+    sub_stream_url: rtsp://192.168.137.31:554/Streaming/Channels/102
+  - name: FrontDoor
+    url: rtsp://192.168.137.32:554/Streaming/Channels/101
+    duration: 30
+    timezone: UTC
+  - name: SideGate
+    url: rtsp://192.168.137.33:554/Streaming/Channels/101
+    enabled: false
+`
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := test_utils.GetTempDir()
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "cameras.yaml")
+	err = os.WriteFile(path, []byte(testConfigYAML), 0644)
+	require.NoError(t, err)
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	feeds, err := config.Feeds()
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]segment_generator.Feed{
+			{
+				NetCamURL:       "rtsp://192.168.137.31:554/Streaming/Channels/101",
+				SubStreamURL:    "rtsp://192.168.137.31:554/Streaming/Channels/102",
+				DestinationPath: "/srv/target_dir/segments",
+				CameraName:      "Driveway",
+				Duration:        60,
+				Timezone:        "Australia/Perth",
+			},
+			{
+				NetCamURL:       "rtsp://192.168.137.32:554/Streaming/Channels/101",
+				DestinationPath: "/srv/target_dir/segments",
+				CameraName:      "FrontDoor",
+				Duration:        30,
+				Timezone:        "UTC",
+			},
+		},
+		feeds,
+	)
+
+	config, err = ParseConfig(
+		[]byte(`{"duration": 60, "destination_path": "/tmp", "cameras": [{"name": "Driveway", "url": "rtsp://a"}]}`),
+		".json",
+	)
+	require.NoError(t, err)
+
+	feeds, err = config.Feeds()
+	require.NoError(t, err)
+	assert.Len(t, feeds, 1)
+}
+
+func TestConfig_Feeds_Invalid(t *testing.T) {
+	for _, rawConfig := range []string{
+		`{"duration": 60, "destination_path": "/tmp", "cameras": [{"name": "A", "url": "rtsp://a"}, {"name": "A", "url": "rtsp://b"}]}`,
+		`{"duration": 60, "destination_path": "/tmp", "cameras": [{"url": "rtsp://a"}]}`,
+		`{"duration": 60, "destination_path": "/tmp", "cameras": [{"name": "A"}]}`,
+		`{"duration": 60, "cameras": [{"name": "A", "url": "rtsp://a"}]}`,
+		`{"destination_path": "/tmp", "cameras": [{"name": "A", "url": "rtsp://a"}]}`,
+		`{"duration": 60, "destination_path": "/tmp", "timezone": "Nowhere/Special", "cameras": [{"name": "A", "url": "rtsp://a"}]}`,
+	} {
+		config, err := ParseConfig([]byte(rawConfig), ".json")
+		require.NoError(t, err)
+
+		_, err = config.Feeds()
+		assert.Error(t, err, rawConfig)
+	}
+
+	_, err := ParseConfig([]byte(testConfigYAML), ".toml")
+	assert.Error(t, err)
+}
+
+func TestDiffFeeds(t *testing.T) {
+	driveway := segment_generator.Feed{CameraName: "Driveway", NetCamURL: "rtsp://a", DestinationPath: "/tmp", Duration: 60}
+	frontDoor := segment_generator.Feed{CameraName: "FrontDoor", NetCamURL: "rtsp://b", DestinationPath: "/tmp", Duration: 60}
+	sideGate := segment_generator.Feed{CameraName: "SideGate", NetCamURL: "rtsp://c", DestinationPath: "/tmp", Duration: 60}
+
+	changedFrontDoor := frontDoor
+	changedFrontDoor.Duration = 30
+
+	toStop, toStart, toRestart := diffFeeds(
+		map[string]segment_generator.Feed{
+			"Driveway":  driveway,
+			"FrontDoor": frontDoor,
+			"SideGate":  sideGate,
+		},
+		map[string]segment_generator.Feed{
+			"Driveway":  driveway,
+			"FrontDoor": changedFrontDoor,
+			"BackYard":  {CameraName: "BackYard", NetCamURL: "rtsp://d", DestinationPath: "/tmp", Duration: 60},
+		},
+	)
+
+	assert.Equal(t, []string{"SideGate"}, toStop)
+	assert.Equal(t, []string{"BackYard"}, toStart)
+	assert.Equal(t, []string{"FrontDoor"}, toRestart)
+}
+
+func TestFeedsFromCameras(t *testing.T) {
+	feeds := FeedsFromCameras(
+		[]model.Camera{
+			{ID: 1, Name: "Driveway", StreamURL: "rtsp://a/101", SubStreamURL: "rtsp://a/102"},
+			{ID: 2, Name: "FrontDoor", StreamURL: "rtsp://b/101"},
+			{ID: 3, Name: "", StreamURL: "rtsp://c/101"}, // no name
+			{ID: 4, Name: "SideGate"},                    // no stream URL
+		},
+		"/srv/target_dir/segments",
+		60,
+		"Australia/Perth",
+		"mpegts",
+		"polling",
+	)
+
+	assert.Equal(
+		t,
+		[]segment_generator.Feed{
+			{
+				NetCamURL:       "rtsp://a/101",
+				SubStreamURL:    "rtsp://a/102",
+				DestinationPath: "/srv/target_dir/segments",
+				CameraName:      "Driveway",
+				Duration:        60,
+				Timezone:        "Australia/Perth",
+				Format:          "mpegts",
+				WatcherBackend:  "polling",
+			},
+			{
+				NetCamURL:       "rtsp://b/101",
+				DestinationPath: "/srv/target_dir/segments",
+				CameraName:      "FrontDoor",
+				Duration:        60,
+				Timezone:        "Australia/Perth",
+				Format:          "mpegts",
+				WatcherBackend:  "polling",
+			},
+		},
+		feeds,
+	)
+
+	assert.Empty(t, FeedsFromCameras(nil, "/srv/target_dir/segments", 60, "", "", ""))
+}
@@ -0,0 +1,116 @@
+package segment_generators
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/initialed85/cameranator/pkg/filesystem"
+)
+
+// ConfigWatcher reloads the camera config into a SegmentGenerators on SIGHUP or when the config file changes
+type ConfigWatcher struct {
+	mu                sync.Mutex
+	path              string
+	segmentGenerators *SegmentGenerators
+	lastData          []byte
+	watcher           *filesystem.Watcher
+	signals           chan os.Signal
+	done              chan struct{}
+}
+
+func NewConfigWatcher(path string, segmentGenerators *SegmentGenerators) *ConfigWatcher {
+	c := ConfigWatcher{
+		path:              path,
+		segmentGenerators: segmentGenerators,
+	}
+
+	return &c
+}
+
+// Reload reads the config file and applies it (if it has changed since the last successful reload)
+func (c *ConfigWatcher) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	if c.lastData != nil && bytes.Equal(data, c.lastData) {
+		return nil
+	}
+
+	log.Printf("ConfigWatcher.Reload; loading %#+v", c.path)
+
+	config, err := ParseConfig(data, filepath.Ext(c.path))
+	if err != nil {
+		return err
+	}
+
+	feeds, err := config.Feeds()
+	if err != nil {
+		return err
+	}
+
+	err = c.segmentGenerators.SetFeeds(feeds)
+	if err != nil {
+		return err
+	}
+
+	c.lastData = data
+
+	return nil
+}
+
+func (c *ConfigWatcher) reload() {
+	err := c.Reload()
+	if err != nil {
+		log.Printf("warning: failed to reload %#+v because %v; keeping the running config", c.path, err)
+	}
+}
+
+func (c *ConfigWatcher) Start() {
+	// the whole folder is watched (not just the file) so that editors that write-and-rename and Kubernetes
+	// ConfigMap symlink swaps are both noticed
+	c.watcher = filesystem.NewWatcher(
+		filepath.Dir(c.path),
+		nil,
+		func(file filesystem.File) {
+			c.reload()
+		},
+		func(file filesystem.File) {
+			c.reload()
+		},
+	)
+	c.watcher.Start()
+
+	c.signals = make(chan os.Signal, 1)
+	c.done = make(chan struct{})
+
+	signal.Notify(c.signals, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-c.signals:
+				log.Printf("ConfigWatcher; received SIGHUP")
+				c.reload()
+			}
+		}
+	}()
+}
+
+func (c *ConfigWatcher) Stop() {
+	signal.Stop(c.signals)
+	close(c.done)
+
+	c.watcher.Stop()
+}
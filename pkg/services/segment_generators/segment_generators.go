@@ -1,35 +1,46 @@
 package segment_generators
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/initialed85/cameranator/pkg/liveness"
-
-	"github.com/initialed85/glue/pkg/network"
-
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
+	"github.com/initialed85/cameranator/pkg/segments/transport"
 )
 
+const outboxRetryInterval = time.Second * 30
+
 type SegmentGenerators struct {
-	mu                     sync.Mutex
-	feeds                  []segment_generator.Feed
-	host                   string
-	port                   int64
-	sender                 *network.Sender
-	segmentGeneratorByFeed map[segment_generator.Feed]*segment_generator.SegmentGenerator
-	livenessAgent          *liveness.Agent
+	mu                           sync.Mutex
+	changeMu                     sync.Mutex // held through Start, Stop and SetFeeds (which can take a while) so they take turns
+	feedsMu                      sync.Mutex // held only while the maps below are read or changed
+	started                      bool
+	feedByCameraName             map[string]segment_generator.Feed
+	transportURL                 string
+	outboxPath                   string
+	publisher                    transport.Publisher
+	outbox                       *outbox.Outbox
+	segmentGeneratorByCameraName map[string]*segment_generator.SegmentGenerator
+	livenessAgent                *liveness.Agent
 }
 
-func NewSegmentGenerators(feeds []segment_generator.Feed, host string, port int64) *SegmentGenerators {
+// NewSegmentGenerators returns SegmentGenerators that publish their events to transportURL (see
+// transport.NewPublisher) via an outbox at outboxPath, so that events survive restarts until they're acknowledged
+func NewSegmentGenerators(feeds []segment_generator.Feed, transportURL string, outboxPath string) *SegmentGenerators {
 	s := SegmentGenerators{
-		feeds:                  feeds,
-		host:                   host,
-		port:                   port,
-		segmentGeneratorByFeed: make(map[segment_generator.Feed]*segment_generator.SegmentGenerator),
+		feedByCameraName:             make(map[string]segment_generator.Feed),
+		transportURL:                 transportURL,
+		outboxPath:                   outboxPath,
+		segmentGeneratorByCameraName: make(map[string]*segment_generator.SegmentGenerator),
+	}
+
+	for _, feed := range feeds {
+		s.feedByCameraName[feed.CameraName] = feed
 	}
 
 	return &s
@@ -37,70 +48,317 @@ func NewSegmentGenerators(feeds []segment_generator.Feed, host string, port int6
 
 func (s *SegmentGenerators) completeFn(event segment_generator.Event) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	eventOutbox := s.outbox
+	s.mu.Unlock()
+
+	envelope, err := eventOutbox.Add(event)
+	if err != nil {
+		log.Printf("err: failed to add %#+v to outbox because %v", event, err)
+		return
+	}
+
+	log.Printf("completeFn; published %v/%v to %v", envelope.Source, envelope.Sequence, s.transportURL)
+}
 
-	b, err := json.Marshal(event)
+// recoveryFn puts the recovery through the outbox (like an event) so that the segment processor records it durably
+func (s *SegmentGenerators) recoveryFn(recovery segment_generator.Recovery) {
+	s.mu.Lock()
+	eventOutbox := s.outbox
+	s.mu.Unlock()
+
+	envelope, err := eventOutbox.AddRecovery(recovery)
 	if err != nil {
-		log.Printf("err: failed to marshal %#+v because %v", event, err)
+		log.Printf("err: failed to add %#+v to outbox because %v", recovery, err)
+		return
+	}
+
+	log.Printf("recoveryFn; published %v/%v to %v", envelope.Source, envelope.Sequence, s.transportURL)
+}
+
+func (s *SegmentGenerators) onAck(ack outbox.Ack) {
+	s.mu.Lock()
+	eventOutbox := s.outbox
+	s.mu.Unlock()
+
+	if eventOutbox == nil {
+		return
 	}
 
-	err = s.sender.Send(b)
+	err := eventOutbox.Ack(ack)
 	if err != nil {
-		log.Printf("err: failed to send %#+v to %v:%v because %v", event, s.host, s.port, err)
+		log.Printf("warning: failed to ack %v/%v because %v", ack.Source, ack.Sequence, err)
+	}
+}
+
+// diffFeeds returns the camera names to stop, start and restart (stop then start) to get from before to after
+func diffFeeds(
+	before map[string]segment_generator.Feed,
+	after map[string]segment_generator.Feed,
+) (toStop []string, toStart []string, toRestart []string) {
+	toStop = make([]string, 0)
+	toStart = make([]string, 0)
+	toRestart = make([]string, 0)
+
+	for cameraName, beforeFeed := range before {
+		afterFeed, ok := after[cameraName]
+		if !ok {
+			toStop = append(toStop, cameraName)
+			continue
+		}
+
+		if afterFeed != beforeFeed {
+			toRestart = append(toRestart, cameraName)
+		}
+	}
+
+	for cameraName := range after {
+		_, ok := before[cameraName]
+		if !ok {
+			toStart = append(toStart, cameraName)
+		}
+	}
+
+	sort.Strings(toStop)
+	sort.Strings(toStart)
+	sort.Strings(toRestart)
+
+	return toStop, toStart, toRestart
+}
+
+// startSegmentGenerators starts a segment generator for each of the feeds (all at once, as each blocks until its
+// recorder is running) and returns those that started, by camera name
+func (s *SegmentGenerators) startSegmentGenerators(
+	feeds []segment_generator.Feed,
+) (map[string]*segment_generator.SegmentGenerator, []error) {
+	mu := sync.Mutex{}
+	segmentGeneratorByCameraName := make(map[string]*segment_generator.SegmentGenerator)
+	errs := make([]error, 0)
+
+	wg := sync.WaitGroup{}
+	for _, feed := range feeds {
+		wg.Add(1)
+		go func(feed segment_generator.Feed) {
+			defer wg.Done()
+
+			log.Printf("starting %#+v", feed.CameraName)
+
+			segmentGenerator := segment_generator.NewSegmentGenerator(
+				feed,
+				s.completeFn,
+				s.recoveryFn,
+			)
+
+			err := segmentGenerator.Start()
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to start %#+v: %v", feed.CameraName, err))
+				return
+			}
+
+			segmentGeneratorByCameraName[feed.CameraName] = segmentGenerator
+		}(feed)
+	}
+	wg.Wait()
+
+	return segmentGeneratorByCameraName, errs
+}
+
+// stopSegmentGenerators stops the segment generators (all at once, as each blocks while it finishes off its last
+// segment)
+func stopSegmentGenerators(segmentGenerators []*segment_generator.SegmentGenerator) {
+	wg := sync.WaitGroup{}
+	for _, segmentGenerator := range segmentGenerators {
+		wg.Add(1)
+		go func(segmentGenerator *segment_generator.SegmentGenerator) {
+			defer wg.Done()
+			segmentGenerator.Stop()
+		}(segmentGenerator)
+	}
+	wg.Wait()
+}
+
+// addSegmentGenerators records the segment generators that started (and forgets the feeds of those that didn't, so
+// that they're tried again by the next SetFeeds)
+func (s *SegmentGenerators) addSegmentGenerators(
+	feeds []segment_generator.Feed,
+	segmentGeneratorByCameraName map[string]*segment_generator.SegmentGenerator,
+) {
+	s.feedsMu.Lock()
+	defer s.feedsMu.Unlock()
+
+	for _, feed := range feeds {
+		segmentGenerator, ok := segmentGeneratorByCameraName[feed.CameraName]
+		if !ok {
+			delete(s.feedByCameraName, feed.CameraName)
+			continue
+		}
+
+		s.segmentGeneratorByCameraName[feed.CameraName] = segmentGenerator
+	}
+}
+
+// SetFeeds replaces the set of feeds; once started, only the feeds that were added, removed or changed are
+// started, stopped or restarted (the others keep recording undisturbed); the new feeds are swapped in straight away
+// and the segment generators are stopped and started afterwards, so IsLive and Status aren't held up meanwhile
+func (s *SegmentGenerators) SetFeeds(feeds []segment_generator.Feed) error {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	feedByCameraName := make(map[string]segment_generator.Feed)
+	for _, feed := range feeds {
+		_, ok := feedByCameraName[feed.CameraName]
+		if ok {
+			return fmt.Errorf("duplicate feed for camera %#+v", feed.CameraName)
+		}
+
+		feedByCameraName[feed.CameraName] = feed
+	}
+
+	s.feedsMu.Lock()
+
+	if !s.started {
+		s.feedByCameraName = feedByCameraName
+		s.feedsMu.Unlock()
+		return nil
+	}
+
+	toStop, toStart, toRestart := diffFeeds(s.feedByCameraName, feedByCameraName)
+
+	segmentGeneratorsToStop := make([]*segment_generator.SegmentGenerator, 0, len(toStop)+len(toRestart))
+	for _, cameraName := range append(toStop, toRestart...) {
+		segmentGenerator, ok := s.segmentGeneratorByCameraName[cameraName]
+		if ok {
+			segmentGeneratorsToStop = append(segmentGeneratorsToStop, segmentGenerator)
+			delete(s.segmentGeneratorByCameraName, cameraName)
+		}
+	}
+
+	s.feedByCameraName = feedByCameraName
+
+	s.feedsMu.Unlock()
+
+	log.Printf("SetFeeds; stopping %v, restarting %v and starting %v", toStop, toRestart, toStart)
+
+	// a restarted one's old recorder has to be finished with its files before its new one starts
+	stopSegmentGenerators(segmentGeneratorsToStop)
+
+	feedsToStart := make([]segment_generator.Feed, 0, len(toRestart)+len(toStart))
+	for _, cameraName := range append(toRestart, toStart...) {
+		feedsToStart = append(feedsToStart, feedByCameraName[cameraName])
 	}
+
+	started, errs := s.startSegmentGenerators(feedsToStart)
+
+	s.addSegmentGenerators(feedsToStart, started)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+
+	return nil
 }
 
 func (s *SegmentGenerators) Start() error {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
 
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("%v:%v", s.host, s.port))
+	var err error
+
+	s.publisher, err = transport.NewPublisher(s.transportURL, s.onAck)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	err = s.publisher.Open()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
 
-	s.sender = network.NewSender(addr)
-	err := s.sender.Open()
+	s.outbox, err = outbox.NewOutbox(s.outboxPath, s.publisher.Publish, outboxRetryInterval)
 	if err != nil {
+		s.publisher.Close()
+		s.mu.Unlock()
 		return err
 	}
 
+	s.outbox.Start()
+
 	s.livenessAgent, err = liveness.Open(
 		[]liveness.HasLiveness{s},
 		8080, // TODO
 	)
 	if err != nil {
+		s.outbox.Stop()
+		s.publisher.Close()
+		s.mu.Unlock()
 		return err
 	}
 
-	for _, feed := range s.feeds {
-		s.segmentGeneratorByFeed[feed] = segment_generator.NewSegmentGenerator(
-			feed,
-			s.completeFn,
-		)
-		err = s.segmentGeneratorByFeed[feed].Start()
-		if err != nil {
-			return err
-		}
+	s.mu.Unlock()
+
+	s.feedsMu.Lock()
+
+	s.started = true
+
+	cameraNames := make([]string, 0)
+	for cameraName := range s.feedByCameraName {
+		cameraNames = append(cameraNames, cameraName)
+	}
+	sort.Strings(cameraNames)
+
+	feeds := make([]segment_generator.Feed, 0, len(cameraNames))
+	for _, cameraName := range cameraNames {
+		feeds = append(feeds, s.feedByCameraName[cameraName])
+	}
+
+	s.feedsMu.Unlock()
+
+	started, errs := s.startSegmentGenerators(feeds)
+
+	s.addSegmentGenerators(feeds, started)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
 	}
 
 	return nil
 }
 
 func (s *SegmentGenerators) Stop() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
 
-	for _, feed := range s.feeds {
-		s.segmentGeneratorByFeed[feed].Stop()
+	s.feedsMu.Lock()
+	segmentGenerators := make([]*segment_generator.SegmentGenerator, 0, len(s.segmentGeneratorByCameraName))
+	for _, segmentGenerator := range s.segmentGeneratorByCameraName {
+		segmentGenerators = append(segmentGenerators, segmentGenerator)
 	}
+	s.segmentGeneratorByCameraName = make(map[string]*segment_generator.SegmentGenerator)
+	s.started = false
+	s.feedsMu.Unlock()
 
-	s.sender.Close()
+	stopSegmentGenerators(segmentGenerators)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox.Stop()
+	s.publisher.Close()
 	s.livenessAgent.Close()
 }
 
 func (s *SegmentGenerators) IsLive() bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.feedsMu.Lock()
+	defer s.feedsMu.Unlock()
 
-	for _, segmentGenerator := range s.segmentGeneratorByFeed {
+	for _, segmentGenerator := range s.segmentGeneratorByCameraName {
 		if !segmentGenerator.IsLive() {
 			return false
 		}
@@ -108,3 +366,18 @@ func (s *SegmentGenerators) IsLive() bool {
 
 	return true
 }
+
+// Status returns the status of each segment generator by camera name (served at /status, so that it's possible to
+// see why a camera is flapping)
+func (s *SegmentGenerators) Status() any {
+	s.feedsMu.Lock()
+	defer s.feedsMu.Unlock()
+
+	statusByCameraName := make(map[string]segment_generator.Status)
+
+	for cameraName, segmentGenerator := range s.segmentGeneratorByCameraName {
+		statusByCameraName[cameraName] = segmentGenerator.Status()
+	}
+
+	return statusByCameraName
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/initialed85/cameranator/pkg/segments/outbox"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
 	"github.com/initialed85/cameranator/pkg/test_utils"
 )
@@ -29,14 +31,14 @@ func TestNewSegmentGenerators(t *testing.T) {
 	events := make([]segment_generator.Event, 0)
 
 	err = receiver.RegisterCallback(func(srcAddr *net.UDPAddr, dstAddr *net.UDPAddr, data []byte) {
-		event := segment_generator.Event{}
+		envelope := outbox.Envelope{}
 
-		err := json.Unmarshal(data, &event)
+		err := json.Unmarshal(data, &envelope)
 		if err != nil {
 			require.NoError(t, err)
 		}
 
-		events = append(events, event)
+		events = append(events, envelope.Event)
 	})
 	require.NoError(t, err)
 
@@ -55,8 +57,8 @@ func TestNewSegmentGenerators(t *testing.T) {
 				Duration:        5,
 			},
 		},
-		"localhost",
-		6291,
+		"udp://localhost:6291",
+		filepath.Join(dir, ".outbox"),
 	)
 
 	err = segmentGenerators.Start()
@@ -73,3 +75,23 @@ func TestNewSegmentGenerators(t *testing.T) {
 
 	log.Printf("%#+v", events)
 }
+
+func TestSegmentGenerators_Start_LivenessFails(t *testing.T) {
+	dir := t.TempDir()
+
+	// the liveness agent can't listen if something else already is
+	listener, err := net.Listen("tcp", ":8080")
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	segmentGenerators := NewSegmentGenerators(nil, "udp://localhost:6291", filepath.Join(dir, ".outbox"))
+
+	err = segmentGenerators.Start()
+	require.Error(t, err)
+
+	// the publisher that was opened before that isn't left open
+	err = segmentGenerators.publisher.Publish(outbox.Envelope{Source: "some-host", Sequence: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package segment_processor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Gap is a period between two consecutive segments from the same camera during which nothing was recorded
+type Gap struct {
+	CameraName     string
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+}
+
+// Period is a period during which something was recorded (i.e. a segment)
+type Period struct {
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+}
+
+// GapDetector follows the end of the most recent segment for each camera and reports any gap (larger than the
+// tolerance) between it and the start of the next
+type GapDetector struct {
+	mu                       sync.Mutex
+	tolerance                time.Duration
+	lastEndTimestampByCamera map[string]time.Time
+}
+
+func NewGapDetector(tolerance time.Duration) *GapDetector {
+	g := GapDetector{
+		tolerance:                tolerance,
+		lastEndTimestampByCamera: make(map[string]time.Time),
+	}
+
+	return &g
+}
+
+// IsKnown returns true if a segment has already been seen (or seeded) for the camera
+func (g *GapDetector) IsKnown(cameraName string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.lastEndTimestampByCamera[cameraName]
+
+	return ok
+}
+
+// Observe records a segment and returns the gap (if any) that preceded it; segments that arrive out of order never
+// move the end backwards (and so never cause a gap)
+func (g *GapDetector) Observe(cameraName string, startTimestamp time.Time, endTimestamp time.Time) (Gap, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lastEndTimestamp, ok := g.lastEndTimestampByCamera[cameraName]
+
+	if !ok || endTimestamp.After(lastEndTimestamp) {
+		g.lastEndTimestampByCamera[cameraName] = endTimestamp
+	}
+
+	if !ok {
+		return Gap{}, false
+	}
+
+	if startTimestamp.Sub(lastEndTimestamp) <= g.tolerance {
+		return Gap{}, false
+	}
+
+	return Gap{
+		CameraName:     cameraName,
+		StartTimestamp: lastEndTimestamp,
+		EndTimestamp:   startTimestamp,
+	}, true
+}
+
+// Uncovered returns what's left of gap (in order, and only the parts larger than the tolerance) once the periods that
+// were recorded after all (e.g. segments that arrived out of order) are taken out of it
+func (g *GapDetector) Uncovered(gap Gap, recorded []Period) []Gap {
+	recorded = append([]Period{}, recorded...)
+	sort.Slice(recorded, func(i, j int) bool {
+		return recorded[i].StartTimestamp.Before(recorded[j].StartTimestamp)
+	})
+
+	gaps := make([]Gap, 0)
+
+	add := func(startTimestamp time.Time, endTimestamp time.Time) {
+		if endTimestamp.Sub(startTimestamp) <= g.tolerance {
+			return
+		}
+
+		gaps = append(gaps, Gap{
+			CameraName:     gap.CameraName,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+		})
+	}
+
+	startTimestamp := gap.StartTimestamp
+
+	for _, period := range recorded {
+		if !period.EndTimestamp.After(startTimestamp) || !period.StartTimestamp.Before(gap.EndTimestamp) {
+			continue
+		}
+
+		add(startTimestamp, period.StartTimestamp)
+
+		startTimestamp = period.EndTimestamp
+	}
+
+	add(startTimestamp, gap.EndTimestamp)
+
+	return gaps
+}
@@ -0,0 +1,87 @@
+package segment_processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGapDetector(t *testing.T) {
+	g := NewGapDetector(time.Second * 5)
+
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	assert.False(t, g.IsKnown("Driveway"))
+
+	_, ok := g.Observe("Driveway", start, start.Add(time.Minute))
+	assert.False(t, ok)
+	assert.True(t, g.IsKnown("Driveway"))
+
+	// within tolerance
+	_, ok = g.Observe("Driveway", start.Add(time.Minute+time.Second*2), start.Add(time.Minute*2))
+	assert.False(t, ok)
+
+	// cameras are independent
+	_, ok = g.Observe("SideGate", start.Add(time.Hour), start.Add(time.Hour+time.Minute))
+	assert.False(t, ok)
+
+	gap, ok := g.Observe("Driveway", start.Add(time.Minute*10), start.Add(time.Minute*11))
+	assert.True(t, ok)
+	assert.Equal(t, Gap{
+		CameraName:     "Driveway",
+		StartTimestamp: start.Add(time.Minute * 2),
+		EndTimestamp:   start.Add(time.Minute * 10),
+	}, gap)
+
+	// a late arrival doesn't cause a gap or move the end backwards
+	_, ok = g.Observe("Driveway", start.Add(time.Minute*5), start.Add(time.Minute*6))
+	assert.False(t, ok)
+
+	_, ok = g.Observe("Driveway", start.Add(time.Minute*11), start.Add(time.Minute*12))
+	assert.False(t, ok)
+}
+
+func TestGapDetector_OutOfOrder(t *testing.T) {
+	g := NewGapDetector(time.Second * 5)
+
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	_, ok := g.Observe("Driveway", start, start.Add(time.Minute))
+	assert.False(t, ok)
+
+	// the segments in between haven't arrived yet (e.g. they're being retried)
+	gap, ok := g.Observe("Driveway", start.Add(time.Minute*4), start.Add(time.Minute*5))
+	assert.True(t, ok)
+
+	// one of them turns up; what's left either side of it is still a gap
+	assert.Equal(
+		t,
+		[]Gap{
+			{CameraName: "Driveway", StartTimestamp: start.Add(time.Minute), EndTimestamp: start.Add(time.Minute * 2)},
+			{CameraName: "Driveway", StartTimestamp: start.Add(time.Minute * 3), EndTimestamp: start.Add(time.Minute * 4)},
+		},
+		g.Uncovered(gap, []Period{{StartTimestamp: start.Add(time.Minute * 2), EndTimestamp: start.Add(time.Minute * 3)}}),
+	)
+
+	// all of them do (out of order themselves, and within tolerance of one another); there's no gap left
+	assert.Equal(
+		t,
+		[]Gap{},
+		g.Uncovered(
+			gap,
+			[]Period{
+				{StartTimestamp: start.Add(time.Minute*3 + time.Second*2), EndTimestamp: start.Add(time.Minute*4 - time.Second)},
+				{StartTimestamp: start.Add(time.Minute + time.Second), EndTimestamp: start.Add(time.Minute * 2)},
+				{StartTimestamp: start.Add(time.Minute * 2), EndTimestamp: start.Add(time.Minute * 3)},
+			},
+		),
+	)
+
+	// and ones from outside it don't change it
+	assert.Equal(
+		t,
+		[]Gap{gap},
+		g.Uncovered(gap, []Period{{StartTimestamp: start, EndTimestamp: start.Add(time.Minute)}}),
+	)
+}
@@ -0,0 +1,122 @@
+package segment_processor
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+const (
+	localCleanupInterval = time.Minute
+	localCleanupPageSize = 100
+	lowResSuffix         = "__lowres.jpg"
+
+	// the last status an event goes through; nothing reads its files locally after that
+	statusDone = "done"
+)
+
+// isDoneWith returns true if nothing's going to read the event's files locally any more (i.e. it's done, or it ended
+// longer than keepLocalFor ago, in case it never gets there)
+func isDoneWith(event model.Event, now time.Time, keepLocalFor time.Duration) bool {
+	return event.Status == statusDone || now.Sub(event.EndTimestamp.Time) >= keepLocalFor
+}
+
+// getLocations returns where the event's files (and those of its sprite sheets) are recorded in the database; the
+// full-res image that the thumbnail was made from is next to it, named the same bar the suffix
+func getLocations(event model.Event, spriteSheets []model.SpriteSheet) []string {
+	locations := []string{
+		event.OriginalVideo.FilePath,
+		event.ProcessedVideo.FilePath,
+		event.ThumbnailImage.FilePath,
+	}
+
+	if strings.HasSuffix(event.ThumbnailImage.FilePath, lowResSuffix) {
+		locations = append(locations, strings.TrimSuffix(event.ThumbnailImage.FilePath, lowResSuffix)+".jpg")
+	}
+
+	for _, spriteSheet := range spriteSheets {
+		locations = append(locations, spriteSheet.Image.FilePath, spriteSheet.VTTFilePath)
+	}
+
+	return locations
+}
+
+// cleanUpLocal removes the local copies of offloaded files once their events are done with; each pass starts from the
+// first event that wasn't done with last time (so the first pass after a restart goes through all of them)
+func (s *SegmentProcessor) cleanUpLocal() error {
+	now := time.Now()
+	afterID := s.cleanedUpToID
+	firstKeptID := int64(0)
+	removed := 0
+
+	for {
+		events, err := helpers.GetEventsAfter(s.application, afterID, localCleanupPageSize)
+		if err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			break
+		}
+
+		afterID = events[len(events)-1].ID
+
+		eventIDs := make([]int64, 0, len(events))
+		for _, event := range events {
+			eventIDs = append(eventIDs, event.ID)
+		}
+
+		spriteSheets, err := helpers.GetSpriteSheets(s.application, eventIDs)
+		if err != nil {
+			return err
+		}
+
+		spriteSheetsByEventID := make(map[int64][]model.SpriteSheet)
+		for _, spriteSheet := range spriteSheets {
+			spriteSheetsByEventID[spriteSheet.EventID] = append(spriteSheetsByEventID[spriteSheet.EventID], spriteSheet)
+		}
+
+		for _, event := range events {
+			if !isDoneWith(event, now, s.offloader.keepLocalFor) {
+				if firstKeptID == 0 {
+					firstKeptID = event.ID
+				}
+
+				continue
+			}
+
+			paths := make([]string, 0)
+			for _, location := range getLocations(event, spriteSheetsByEventID[event.ID]) {
+				path, ok := s.offloader.LocalPath(location)
+				if ok {
+					paths = append(paths, path)
+				}
+			}
+
+			s.offloader.RemoveLocal(paths)
+			removed += len(paths)
+		}
+	}
+
+	if firstKeptID != 0 {
+		s.cleanedUpToID = firstKeptID - 1
+	} else {
+		s.cleanedUpToID = afterID
+	}
+
+	if removed > 0 {
+		log.Printf("cleaned up the local copies of up to %v offloaded files", removed)
+	}
+
+	return nil
+}
+
+func (s *SegmentProcessor) cleanUpLocalWork() {
+	err := s.cleanUpLocal()
+	if err != nil {
+		log.Printf("warning: failed to clean up offloaded files because %v", err)
+	}
+}
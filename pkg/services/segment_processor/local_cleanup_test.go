@@ -0,0 +1,53 @@
+package segment_processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relvacode/iso8601"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestIsDoneWith(t *testing.T) {
+	now := time.Now()
+
+	event := model.Event{
+		EndTimestamp: iso8601.Time{Time: now.Add(-time.Minute)},
+		Status:       "needs tracking",
+	}
+
+	assert.False(t, isDoneWith(event, now, time.Hour))
+	assert.True(t, isDoneWith(event, now, time.Second))
+
+	event.Status = statusDone
+	assert.True(t, isDoneWith(event, now, time.Hour))
+}
+
+func TestGetLocations(t *testing.T) {
+	event := model.Event{
+		OriginalVideo:  model.Video{FilePath: "s3://media/segments/Segment_2020-12-25T08:45:04_Driveway.mp4"},
+		ThumbnailImage: model.Image{FilePath: "s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg"},
+	}
+
+	spriteSheets := []model.SpriteSheet{
+		{
+			VTTFilePath: "s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__sprites.vtt",
+			Image:       model.Image{FilePath: "s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__sprites.jpg"},
+		},
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			"s3://media/segments/Segment_2020-12-25T08:45:04_Driveway.mp4",
+			"",
+			"s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__lowres.jpg",
+			"s3://media/segments/Segment_2020-12-25T08:45:04_Driveway.jpg",
+			"s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__sprites.jpg",
+			"s3://media/segments/Segment_2020-12-25T08:45:04_Driveway__sprites.vtt",
+		},
+		getLocations(event, spriteSheets),
+	)
+}
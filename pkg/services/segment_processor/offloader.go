@@ -0,0 +1,133 @@
+package segment_processor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/storage"
+)
+
+// Offloader copies a segment's files (once they've been processed locally) into a storage.Storage, keyed by where
+// they were under localRoot (i.e. the segment generator's destinationPath); the local copies are kept (for the things
+// that read them from there) until the event is done with, or for keepLocalFor, whichever comes first
+type Offloader struct {
+	storage      storage.Storage
+	localRoot    string
+	keepLocalFor time.Duration
+}
+
+func NewOffloader(mediaStorage storage.Storage, localRoot string, keepLocalFor time.Duration) (*Offloader, error) {
+	localRoot, err := filepath.Abs(localRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Offloader{
+		storage:      mediaStorage,
+		localRoot:    localRoot,
+		keepLocalFor: keepLocalFor,
+	}, nil
+}
+
+func (o *Offloader) getKey(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	relativePath, err := filepath.Rel(o.localRoot, path)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return "", fmt.Errorf("%#+v isn't under %#+v", path, o.localRoot)
+	}
+
+	return filepath.ToSlash(relativePath), nil
+}
+
+// Location returns where path goes in storage (whether or not it's been put there yet)
+func (o *Offloader) Location(path string) (string, error) {
+	key, err := o.getKey(path)
+	if err != nil {
+		return "", err
+	}
+
+	return o.storage.Location(key), nil
+}
+
+// LocalPath is the inverse of Location; ok is false if location isn't in storage (i.e. it was never offloaded)
+func (o *Offloader) LocalPath(location string) (string, bool) {
+	key, ok := o.storage.Key(location)
+	if !ok {
+		return "", false
+	}
+
+	return filepath.Join(o.localRoot, filepath.FromSlash(key)), true
+}
+
+// Put puts each of paths (skipping empty ones) into storage; it returns a func that gives the location of each of
+// them in storage (for the database) and the keys that were put, so that they can be deleted again with Undo if
+// need be; if any of them can't be put, those that were are deleted again
+func (o *Offloader) Put(paths []string) (func(path string) string, []string, error) {
+	locations := make(map[string]string)
+	keys := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		key, err := o.getKey(path)
+		if err != nil {
+			o.Undo(keys)
+			return nil, nil, err
+		}
+
+		err = storage.PutFile(o.storage, key, path)
+		if err != nil {
+			o.Undo(keys)
+			return nil, nil, fmt.Errorf("failed to put %#+v: %v", path, err)
+		}
+
+		locations[path] = o.storage.Location(key)
+		keys = append(keys, key)
+	}
+
+	locate := func(path string) string {
+		location, ok := locations[path]
+		if !ok {
+			return path
+		}
+
+		return location
+	}
+
+	return locate, keys, nil
+}
+
+// Undo deletes keys from storage (e.g. if they couldn't be recorded in the database)
+func (o *Offloader) Undo(keys []string) {
+	for _, key := range keys {
+		err := o.storage.Delete(key)
+		if err != nil {
+			log.Printf("warning: failed to delete %#+v from storage because %v", key, err)
+		}
+	}
+}
+
+// RemoveLocal removes the local copies of paths (skipping empty ones and those that are already gone) once they're
+// safely in storage and done with
+func (o *Offloader) RemoveLocal(paths []string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("warning: failed to remove offloaded %#+v because %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package segment_processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/storage"
+)
+
+func TestOffloader(t *testing.T) {
+	dir := t.TempDir()
+	localRoot := filepath.Join(dir, "segments")
+
+	mediaStorage, err := storage.NewLocal(filepath.Join(dir, "storage"))
+	require.NoError(t, err)
+
+	offloader, err := NewOffloader(mediaStorage, localRoot, time.Hour)
+	require.NoError(t, err)
+
+	videoPath := filepath.Join(localRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.mp4")
+	imagePath := filepath.Join(localRoot, "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.jpg")
+
+	for _, path := range []string{videoPath, imagePath} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(filepath.Base(path)), 0644))
+	}
+
+	locate, keys, err := offloader.Put([]string{videoPath, "", imagePath})
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]string{
+			"2020-12-25/Segment_2020-12-25T08:45:04_Driveway.mp4",
+			"2020-12-25/Segment_2020-12-25T08:45:04_Driveway.jpg",
+		},
+		keys,
+	)
+
+	assert.Equal(t, filepath.Join(dir, "storage", "2020-12-25", "Segment_2020-12-25T08:45:04_Driveway.mp4"), locate(videoPath))
+	assert.Equal(t, "/somewhere/else.mp4", locate("/somewhere/else.mp4"))
+
+	info, err := mediaStorage.Stat(keys[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("Segment_2020-12-25T08:45:04_Driveway.mp4")), info.Size)
+
+	localPath, ok := offloader.LocalPath(locate(videoPath))
+	assert.True(t, ok)
+	assert.Equal(t, videoPath, localPath)
+
+	_, ok = offloader.LocalPath("/somewhere/else.mp4")
+	assert.False(t, ok)
+
+	offloader.RemoveLocal([]string{videoPath, "", imagePath})
+	_, err = os.Stat(videoPath)
+	assert.True(t, os.IsNotExist(err))
+
+	offloader.Undo(keys)
+	_, err = mediaStorage.Stat(keys[0])
+	assert.True(t, storage.IsNotFound(err))
+
+	// nothing's left behind in storage if any of them can't be put
+	require.NoError(t, os.WriteFile(videoPath, []byte("a video"), 0644))
+
+	_, _, err = offloader.Put([]string{videoPath, imagePath})
+	assert.Error(t, err)
+
+	infos, err := mediaStorage.List("")
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+
+	_, _, err = offloader.Put([]string{filepath.Join(dir, "elsewhere.mp4")})
+	assert.Error(t, err)
+}
@@ -1,14 +1,24 @@
 package segment_processor
 
 import (
+	"fmt"
 	"log"
 	"strings"
 	"time"
 
+	"github.com/initialed85/glue/pkg/worker"
+	"github.com/relvacode/iso8601"
+
 	"github.com/initialed85/cameranator/pkg/media/converter"
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/media/segment_repairer"
+	"github.com/initialed85/cameranator/pkg/media/sprite_sheet"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
 	"github.com/initialed85/cameranator/pkg/persistence/application"
 	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
 	"github.com/initialed85/cameranator/pkg/segments/event_receiver"
+	"github.com/initialed85/cameranator/pkg/segments/hash_chain"
 	"github.com/initialed85/cameranator/pkg/segments/segment_generator"
 	"github.com/initialed85/cameranator/pkg/utils"
 )
@@ -19,28 +29,56 @@ type WorkAndError struct {
 }
 
 type SegmentProcessor struct {
-	correlator     *utils.Correlator
-	eventReceiver  *event_receiver.EventReceiver
-	imageConverter *converter.Converter
-	application    *application.Application
+	correlator       *utils.Correlator
+	eventReceiver    *event_receiver.EventReceiver
+	imageConverter   *converter.Converter
+	application      *application.Application
+	gapDetector      *GapDetector
+	chains           *hash_chain.Chains
+	offloader        *Offloader
+	spriteSheetMaker *sprite_sheet.Maker
+	resolver         *path_resolver.Resolver
+	localCleaner     *worker.ScheduledWorker
+	cleanedUpToID    int64
 }
 
+// NewSegmentProcessor returns a SegmentProcessor that persists each segment received from transportURL (see
+// transport.NewConsumer) as an event (and records a recording_gap whenever consecutive segments from a camera are
+// more than gapTolerance apart); if chains is set, each segment is also appended to its camera's hash chain, if
+// offloader is set, each segment's files are copied into its storage once they've been processed (and removed
+// locally once they're done with; see cleanUpLocal), and if
+// spriteSheetMaker is set, each segment gets a sprite sheet (for scrubbing through it); the paths in the events
+// received are canonical (i.e. as they're recorded in the database) and resolver gives where they are locally
 func NewSegmentProcessor(
-	port int64,
+	transportURL string,
 	url string,
 	timeout time.Duration,
+	gapTolerance time.Duration,
+	chains *hash_chain.Chains,
+	offloader *Offloader,
+	spriteSheetMaker *sprite_sheet.Maker,
+	resolver *path_resolver.Resolver,
 ) (*SegmentProcessor, error) {
 	var err error
 
 	m := SegmentProcessor{
-		correlator: utils.NewCorrelator(),
+		correlator:       utils.NewCorrelator(),
+		gapDetector:      NewGapDetector(gapTolerance),
+		chains:           chains,
+		offloader:        offloader,
+		spriteSheetMaker: spriteSheetMaker,
+		resolver:         resolver,
 		imageConverter: converter.NewImageConverter(
 			2,
 			1024,
 		),
 	}
 
-	m.eventReceiver, err = event_receiver.NewEventReceiver(port, m.eventReceiverHandler)
+	m.eventReceiver, err = event_receiver.NewEventReceiverWithTransport(
+		transportURL,
+		m.eventReceiverHandler,
+		m.recoveryHandler,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -50,11 +88,87 @@ func NewSegmentProcessor(
 		return nil, err
 	}
 
+	if offloader != nil {
+		m.localCleaner = worker.NewScheduledWorker(
+			func() {},
+			m.cleanUpLocalWork,
+			func() {},
+			localCleanupInterval,
+		)
+	}
+
 	return &m, nil
 }
 
-func (s *SegmentProcessor) eventReceiverHandler(event segment_generator.Event) {
-	correlation := s.correlator.NewCorrelation(s.reconcileEvent)
+// isIngested returns true if there's already an event for the event's video (e.g. it's been delivered again because
+// the ack for it was lost, or because we restarted before sending it)
+func (s *SegmentProcessor) isIngested(event segment_generator.Event) (bool, error) {
+	filePaths := []string{event.VideoPath}
+
+	if s.offloader != nil {
+		location, err := s.offloader.Location(s.resolver.ToLocal(event.VideoPath))
+		if err == nil {
+			filePaths = append(filePaths, location)
+		}
+	}
+
+	events, err := helpers.GetEventsByVideoFilePath(s.application, filePaths)
+	if err != nil {
+		return false, err
+	}
+
+	return len(events) > 0, nil
+}
+
+// recoveryHandler records a restart of a camera's recorder; it's acknowledged once it's persisted (and it's safe to
+// deliver again, as there's only ever one per camera per timestamp)
+func (s *SegmentProcessor) recoveryHandler(recovery segment_generator.Recovery) error {
+	detail := recovery.Detail
+	if recovery.SubStream {
+		detail = fmt.Sprintf("sub-stream: %v", detail)
+	}
+
+	recorderRecovery, err := helpers.AddRecorderRecovery(
+		s.application,
+		recovery.CameraName,
+		recovery.Timestamp,
+		string(recovery.Reason),
+		detail,
+		recovery.Path,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add recorder recovery for %#+v: %v", recovery.CameraName, err)
+	}
+
+	log.Printf("recorded recovery %v for %#+v (%v)", recorderRecovery.ID, recovery.CameraName, recovery.Reason)
+
+	return nil
+}
+
+// eventReceiverHandler only returns once the event has been persisted (or has been found to be not worth persisting),
+// as that's when it's acknowledged; an error means it should be delivered again
+func (s *SegmentProcessor) eventReceiverHandler(event segment_generator.Event) error {
+	ingested, err := s.isIngested(event)
+	if err != nil {
+		return fmt.Errorf("failed to check for an existing event for %#+v: %v", event.VideoPath, err)
+	}
+
+	if ingested {
+		log.Printf("already have an event for %#+v, skipping", event.VideoPath)
+		return nil
+	}
+
+	event.VideoPath = s.resolver.ToLocal(event.VideoPath)
+	event.ImagePath = s.resolver.ToLocal(event.ImagePath)
+	if event.SubStreamVideoPath != "" {
+		event.SubStreamVideoPath = s.resolver.ToLocal(event.SubStreamVideoPath)
+	}
+
+	result := make(chan error, 1)
+
+	correlation := s.correlator.NewCorrelation(func(correlation *utils.Correlation) {
+		result <- s.reconcileEvent(correlation)
+	})
 
 	imageWork := converter.Work{
 		SourcePath:      event.ImagePath,
@@ -80,53 +194,318 @@ func (s *SegmentProcessor) eventReceiverHandler(event segment_generator.Event) {
 		},
 	)
 
+	return <-result
 }
 
-func (s *SegmentProcessor) reconcileEvent(correlation *utils.Correlation) {
+// reconcileEvent returns an error if the event couldn't be persisted (but might be if it's tried again); an event whose
+// media is unusable is logged and dropped, as there's nothing worth persisting
+func (s *SegmentProcessor) reconcileEvent(correlation *utils.Correlation) error {
 	log.Printf("reconciling %#+v...", correlation.GetCorrelationID().String())
 
 	eventItem, err := correlation.GetItem("event")
 	if err != nil {
-		log.Printf("warning: %#+v marked as complete but failed to get event because %v", correlation, err)
-		return
+		return fmt.Errorf("%#+v marked as complete but failed to get event because %v", correlation, err)
 	}
 
 	originalEvent := eventItem.GetValue().(segment_generator.Event)
 
 	imageWorkItem, err := correlation.GetItem("image")
 	if err != nil {
-		log.Printf("warning: %#+v marked as complete but failed to get image because %v", correlation, err)
-		return
+		return fmt.Errorf("%#+v marked as complete but failed to get image because %v", correlation, err)
 	}
 
 	imageWork := imageWorkItem.GetValue().(WorkAndError)
 	if imageWork.Err != nil {
 		log.Printf("warning: %#+v marked as complete but failed to get image because %v", correlation, imageWork.Err)
-		return
+		return nil
+	}
+
+	originalEvent, ok := s.validate(originalEvent)
+	if !ok {
+		return nil
+	}
+
+	paths := []string{
+		originalEvent.VideoPath,
+		originalEvent.SubStreamVideoPath,
+		originalEvent.ImagePath,
+		imageWork.Work.DestinationPath,
+	}
+
+	spriteSheet, madeSpriteSheet := s.makeSpriteSheet(originalEvent)
+	if madeSpriteSheet {
+		paths = append(paths, spriteSheet.ImagePath, spriteSheet.VTTPath)
+	}
+
+	locate := s.resolver.ToCanonical
+	offloaded := false
+	var keys []string
+
+	if s.offloader != nil {
+		var offloadedLocate func(string) string
+
+		offloadedLocate, keys, err = s.offloader.Put(paths)
+		if err != nil {
+			log.Printf("warning: keeping %#+v local because %v", originalEvent.VideoPath, err)
+		} else {
+			locate = offloadedLocate
+			offloaded = true
+		}
 	}
 
-	event, err := helpers.AddEvent(
+	event, err := helpers.AddEventWithLocations(
 		s.application,
 		originalEvent.CameraName,
 		originalEvent.VideoStartTimestamp,
 		originalEvent.VideoEndTimestamp,
 		originalEvent.VideoPath,
 		imageWork.Work.DestinationPath,
+		originalEvent.SubStreamVideoPath,
+		locate,
+	)
+	if err != nil {
+		if offloaded {
+			s.offloader.Undo(keys)
+		}
+
+		return fmt.Errorf("could not add event for %#+v because %v", originalEvent.VideoPath, err)
+	}
+
+	log.Printf("added %#+v", event)
+
+	if madeSpriteSheet {
+		s.addSpriteSheet(originalEvent, event, spriteSheet, locate)
+	}
+
+	s.appendToChain(originalEvent, event)
+
+	s.detectGap(originalEvent)
+
+	return nil
+}
+
+// makeSpriteSheet makes the sprite sheet for the event's video (from the sub-stream video, if there is one, as it's
+// much cheaper to decode) and returns false if there isn't one
+func (s *SegmentProcessor) makeSpriteSheet(event segment_generator.Event) (sprite_sheet.SpriteSheet, bool) {
+	if s.spriteSheetMaker == nil {
+		return sprite_sheet.SpriteSheet{}, false
+	}
+
+	sourcePath := event.VideoPath
+	if event.SubStreamVideoPath != "" {
+		sourcePath = event.SubStreamVideoPath
+	}
+
+	spriteSheet, err := s.spriteSheetMaker.Make(
+		sourcePath,
+		event.VideoPath,
+		event.VideoEndTimestamp.Sub(event.VideoStartTimestamp.Time),
+	)
+	if err != nil {
+		log.Printf("warning: leaving sprite sheet off of %#+v because %v", event.VideoPath, err)
+		return sprite_sheet.SpriteSheet{}, false
+	}
+
+	return spriteSheet, true
+}
+
+func (s *SegmentProcessor) addSpriteSheet(
+	originalEvent segment_generator.Event,
+	event model.Event,
+	spriteSheet sprite_sheet.SpriteSheet,
+	locate func(path string) string,
+) {
+	videoID := event.OriginalVideo.ID
+	if originalEvent.SubStreamVideoPath != "" && event.ProcessedVideo.ID != 0 {
+		videoID = event.ProcessedVideo.ID
+	}
+
+	newSpriteSheet, err := helpers.AddSpriteSheet(s.application, event, videoID, spriteSheet, locate)
+	if err != nil {
+		log.Printf("warning: could not add sprite sheet for %#+v because %v", originalEvent.VideoPath, err)
+		return
+	}
+
+	log.Printf("added %#+v", newSpriteSheet)
+}
+
+func (s *SegmentProcessor) appendToChain(originalEvent segment_generator.Event, event model.Event) {
+	if s.chains == nil {
+		return
+	}
+
+	entry, err := s.chains.Append(
+		originalEvent.CameraName,
+		event.OriginalVideo.FilePath,
+		event.OriginalVideo.Checksum,
+		originalEvent.VideoStartTimestamp.Time,
+		originalEvent.VideoEndTimestamp.Time,
 	)
+	if err != nil {
+		log.Printf("warning: could not add %#+v to hash chain because %v", event.OriginalVideo.FilePath, err)
+		return
+	}
+
+	log.Printf("added %#+v to hash chain as %v", entry.FileName, entry.Sequence)
+}
+
+// validate returns false if the event's video has changed since it was recorded or is invalid (in which case there's nothing worth ingesting); an invalid
+// sub-stream video is just left off
+func (s *SegmentProcessor) validate(event segment_generator.Event) (segment_generator.Event, bool) {
+	if event.VideoChecksum != "" {
+		checksum, err := metadata.GetFileChecksum(event.VideoPath)
+		if err != nil {
+			log.Printf("warning: could not handle event because %v", err)
+			return event, false
+		}
+
+		if checksum != event.VideoChecksum {
+			log.Printf(
+				"warning: could not handle event because %#+v has changed since it was recorded (checksum was %v, now %v)",
+				event.VideoPath,
+				event.VideoChecksum,
+				checksum,
+			)
+			return event, false
+		}
+	}
+
+	err := segment_repairer.ValidateOrRepair(event.VideoPath)
 	if err != nil {
 		log.Printf("warning: could not handle event because %v", err)
+		return event, false
+	}
+
+	if event.SubStreamVideoPath != "" {
+		err = segment_repairer.ValidateOrRepair(event.SubStreamVideoPath)
+		if err != nil {
+			log.Printf("warning: leaving sub-stream video off of event because %v", err)
+			event.SubStreamVideoPath = ""
+		}
+	}
+
+	return event, true
+}
+
+func (s *SegmentProcessor) detectGap(event segment_generator.Event) {
+	// after a restart, pick up from the most recent video we already have for this camera
+	if !s.gapDetector.IsKnown(event.CameraName) {
+		video, ok, err := helpers.GetLatestVideoBefore(s.application, event.CameraName, event.VideoStartTimestamp)
+		if err != nil {
+			log.Printf("warning: could not get latest video for %#+v because %v", event.CameraName, err)
+		} else if ok {
+			s.gapDetector.Observe(event.CameraName, video.StartTimestamp.Time, video.EndTimestamp.Time)
+		}
+	}
+
+	// a segment that arrived late (e.g. it was retried, or missed and found by a scan) fills in whatever gap was
+	// recorded before it got here
+	s.fillGaps(event)
+
+	gap, ok := s.gapDetector.Observe(event.CameraName, event.VideoStartTimestamp.Time, event.VideoEndTimestamp.Time)
+	if !ok {
 		return
 	}
 
-	log.Printf("added %#+v", event)
+	// and the segments in the gap that arrived before this one (out of order) aren't part of it
+	videos, err := helpers.GetVideosBetween(s.application, gap.CameraName, gap.StartTimestamp, gap.EndTimestamp)
+	if err != nil {
+		log.Printf("warning: could not get videos between %v and %v because %v", gap.StartTimestamp, gap.EndTimestamp, err)
+		return
+	}
+
+	for _, remaining := range s.gapDetector.Uncovered(gap, getPeriods(videos)) {
+		log.Printf(
+			"warning: %#+v wasn't recording for %v (from %v to %v)",
+			remaining.CameraName,
+			remaining.EndTimestamp.Sub(remaining.StartTimestamp),
+			remaining.StartTimestamp.Format(time.RFC3339),
+			remaining.EndTimestamp.Format(time.RFC3339),
+		)
+
+		recordingGap, err := helpers.AddRecordingGap(
+			s.application,
+			remaining.CameraName,
+			iso8601.Time{Time: remaining.StartTimestamp},
+			iso8601.Time{Time: remaining.EndTimestamp},
+		)
+		if err != nil {
+			log.Printf("warning: could not add recording gap because %v", err)
+			continue
+		}
+
+		log.Printf("added %#+v", recordingGap)
+	}
+}
+
+func getPeriods(videos []model.Video) []Period {
+	periods := make([]Period, 0, len(videos))
+	for _, video := range videos {
+		periods = append(periods, Period{
+			StartTimestamp: video.StartTimestamp.Time,
+			EndTimestamp:   video.EndTimestamp.Time,
+		})
+	}
+
+	return periods
+}
+
+// fillGaps shrinks, splits or deletes the recording gaps that the event's segment falls in
+func (s *SegmentProcessor) fillGaps(event segment_generator.Event) {
+	recordingGaps, err := helpers.GetRecordingGapsBetween(
+		s.application,
+		event.CameraName,
+		event.VideoStartTimestamp.Time,
+		event.VideoEndTimestamp.Time,
+	)
+	if err != nil {
+		log.Printf("warning: could not get recording gaps for %#+v because %v", event.VideoPath, err)
+		return
+	}
+
+	recorded := []Period{{StartTimestamp: event.VideoStartTimestamp.Time, EndTimestamp: event.VideoEndTimestamp.Time}}
+
+	for _, recordingGap := range recordingGaps {
+		gap := Gap{
+			CameraName:     event.CameraName,
+			StartTimestamp: recordingGap.StartTimestamp.Time,
+			EndTimestamp:   recordingGap.EndTimestamp.Time,
+		}
+
+		replacements := make([]model.RecordingGap, 0)
+		for _, remaining := range s.gapDetector.Uncovered(gap, recorded) {
+			replacements = append(replacements, model.NewRecordingGapWithID(
+				iso8601.Time{Time: remaining.StartTimestamp},
+				iso8601.Time{Time: remaining.EndTimestamp},
+				recordingGap.CameraID,
+			))
+		}
+
+		err = helpers.ReplaceRecordingGap(s.application, recordingGap.ID, replacements)
+		if err != nil {
+			log.Printf("warning: could not fill in recording gap %v because %v", recordingGap.ID, err)
+			continue
+		}
+
+		log.Printf("filled in recording gap %v with %#+v (%v left of it)", recordingGap.ID, event.VideoPath, len(replacements))
+	}
 }
 
 func (s *SegmentProcessor) Start() error {
 	s.imageConverter.Start()
+
+	if s.localCleaner != nil {
+		s.localCleaner.Start()
+	}
+
 	return s.eventReceiver.Open()
 }
 
 func (s *SegmentProcessor) Stop() {
 	s.eventReceiver.Close()
 	s.imageConverter.Stop()
+
+	if s.localCleaner != nil {
+		s.localCleaner.Stop()
+	}
 }
@@ -18,9 +18,14 @@ import (
 
 func TestSegmentProcessor(t *testing.T) {
 	m, err := NewSegmentProcessor(
-		6291,
+		"udp://0.0.0.0:6291",
 		"http://localhost:8082/v1/graphql",
 		time.Second*10,
+		time.Second*5,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	require.NoError(t, err)
 
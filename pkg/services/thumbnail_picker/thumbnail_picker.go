@@ -0,0 +1,386 @@
+package thumbnail_picker
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/initialed85/glue/pkg/worker"
+
+	"github.com/initialed85/cameranator/pkg/media/converter"
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+const (
+	pageSize     = 100
+	lowResSuffix = "__lowres.jpg"
+	tempPrefix   = ".picking."
+
+	// events are moved on to statusNeedsTracking once they've been dealt with (whether they got a new thumbnail or not)
+	statusNeedsThumbnail = "needs thumbnail"
+	statusNeedsTracking  = "needs tracking"
+)
+
+type Report struct {
+	Checked int // events
+	Picked  int // events that got a new thumbnail
+	Kept    int // events that kept the one they had (nothing better was detected, or it couldn't be made)
+	Failed  int // events left to try again
+}
+
+func (r Report) OK() bool {
+	return r.Failed == 0
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf(
+		"checked %v events; picked a thumbnail for %v, kept the first frame for %v, %v failed",
+		r.Checked,
+		r.Picked,
+		r.Kept,
+		r.Failed,
+	)
+}
+
+type ThumbnailPicker struct {
+	scheduledWorker *worker.ScheduledWorker
+	application     *application.Application
+	classes         []string
+	minScore        float64
+	overlay         bool
+	resolver        *path_resolver.Resolver
+}
+
+// NewThumbnailPicker returns a ThumbnailPicker that (every interval) swaps the first-frame thumbnail of each event
+// whose detections are in for the frame with the best detection of the first of classes (in order of priority) that
+// was detected with a score of at least minScore, with its bounding box drawn on if overlay is set; the events' videos
+// are found at the paths that resolver gives
+func NewThumbnailPicker(
+	url string,
+	timeout time.Duration,
+	interval time.Duration,
+	classes []string,
+	minScore float64,
+	overlay bool,
+	resolver *path_resolver.Resolver,
+) (*ThumbnailPicker, error) {
+	var err error
+
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("classes may not be empty")
+	}
+
+	p := ThumbnailPicker{
+		classes:  classes,
+		minScore: minScore,
+		overlay:  overlay,
+		resolver: resolver,
+	}
+
+	p.scheduledWorker = worker.NewScheduledWorker(
+		func() {},
+		p.work,
+		func() {},
+		interval,
+	)
+
+	p.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// pickDetection returns the highest-scoring of the detections of the first of classes that has any
+func pickDetection(detections []model.Detection, classes []string) (model.Detection, bool) {
+	for _, class := range classes {
+		best := model.Detection{}
+		found := false
+
+		for _, detection := range detections {
+			if detection.ClassName != class {
+				continue
+			}
+
+			if !found || detection.Score > best.Score {
+				best = detection
+				found = true
+			}
+		}
+
+		if found {
+			return best, true
+		}
+	}
+
+	return model.Detection{}, false
+}
+
+// parseBoundingBox returns the box around the points of a bounding box (as Postgres writes a polygon), scaled by
+// scaleX and scaleY (for a frame of a different size to the one that the detection was made in)
+func parseBoundingBox(raw string, scaleX float64, scaleY float64) (thumbnail_creator.Box, error) {
+	raw = strings.NewReplacer("(", "", ")", "", " ", "").Replace(raw)
+
+	parts := strings.Split(raw, ",")
+	if len(parts) < 4 || len(parts)%2 != 0 {
+		return thumbnail_creator.Box{}, fmt.Errorf("%#+v isn't a list of points", raw)
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	for i := 0; i < len(parts); i += 2 {
+		x, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil {
+			return thumbnail_creator.Box{}, err
+		}
+
+		y, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return thumbnail_creator.Box{}, err
+		}
+
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+
+	return thumbnail_creator.Box{
+		X:      int(math.Round(minX * scaleX)),
+		Y:      int(math.Round(minY * scaleY)),
+		Width:  int(math.Round((maxX - minX) * scaleX)),
+		Height: int(math.Round((maxY - minY) * scaleY)),
+	}, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func getTempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), tempPrefix+filepath.Base(path))
+}
+
+// getBox returns the detection's bounding box in terms of the frames of the video at videoPath; the detections were
+// made in the frames of the processed video (or of the video itself, if there isn't one), which may be smaller
+func (p *ThumbnailPicker) getBox(event model.Event, detection model.Detection, videoPath string) (*thumbnail_creator.Box, error) {
+	scaleX, scaleY := 1.0, 1.0
+
+	detectedPath := p.resolver.ToLocal(event.ProcessedVideo.FilePath)
+	if event.ProcessedVideo.FilePath != "" && fileExists(detectedPath) {
+		width, height, err := thumbnail_creator.GetVideoSize(videoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		detectedWidth, detectedHeight, err := thumbnail_creator.GetVideoSize(detectedPath)
+		if err != nil {
+			return nil, err
+		}
+
+		scaleX = float64(width) / float64(detectedWidth)
+		scaleY = float64(height) / float64(detectedHeight)
+	}
+
+	box, err := parseBoundingBox(detection.BoundingBox, scaleX, scaleY)
+	if err != nil {
+		return nil, err
+	}
+
+	return &box, nil
+}
+
+// makeImages writes the frame of the event's video with the detection in it (and the low-res copy of it) next to the
+// video and returns their paths
+func (p *ThumbnailPicker) makeImages(event model.Event, detection model.Detection) (string, string, error) {
+	videoPath := p.resolver.ToLocal(event.OriginalVideo.FilePath)
+	if !fileExists(videoPath) {
+		return "", "", fmt.Errorf("%#+v isn't here (it may have been offloaded)", videoPath)
+	}
+
+	imagePath, lowResImagePath := thumbnail_creator.GetBestPaths(videoPath)
+
+	var box *thumbnail_creator.Box
+	if p.overlay {
+		var err error
+
+		box, err = p.getBox(event, detection, videoPath)
+		if err != nil {
+			log.Printf("warning: event %v; leaving the bounding box off because %v", event.ID, err)
+		}
+	}
+
+	tempImagePath := getTempPath(imagePath)
+	defer func() {
+		_ = os.Remove(tempImagePath)
+	}()
+
+	err := thumbnail_creator.GetThumbnailAt(videoPath, tempImagePath, detection.Timestamp.Sub(event.StartTimestamp.Time), box)
+	if err != nil {
+		return "", "", err
+	}
+
+	tempLowResImagePath := getTempPath(lowResImagePath)
+	defer func() {
+		_ = os.Remove(tempLowResImagePath)
+	}()
+
+	// the same size as the segment processor's
+	stdout, stderr, err := converter.ConvertImage(tempImagePath, tempLowResImagePath, 640, 360)
+	if err != nil {
+		return "", "", fmt.Errorf("%v; stdout=%#+v, stderr=%#+v", err, stdout, stderr)
+	}
+
+	err = os.Rename(tempImagePath, imagePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.Rename(tempLowResImagePath, lowResImagePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return imagePath, lowResImagePath, nil
+}
+
+// removePrevious removes the files of the thumbnail that the event had before (and what it was made from), bar any
+// that are still in use
+func (p *ThumbnailPicker) removePrevious(event model.Event, keep ...string) {
+	if event.ThumbnailImage.FilePath == "" {
+		return
+	}
+
+	previousPath := p.resolver.ToLocal(event.ThumbnailImage.FilePath)
+
+	paths := []string{previousPath}
+	if strings.HasSuffix(previousPath, lowResSuffix) {
+		paths = append(paths, strings.TrimSuffix(previousPath, lowResSuffix)+".jpg")
+	}
+
+	for _, path := range paths {
+		inUse := false
+		for _, keepPath := range keep {
+			if path == keepPath {
+				inUse = true
+			}
+		}
+
+		if inUse {
+			continue
+		}
+
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("warning: event %v; failed to remove previous thumbnail %#+v because %v", event.ID, path, err)
+		}
+	}
+}
+
+// Pick pages through the events whose detections are in (pageSize at a time) and gives each of them the best
+// thumbnail there is, then moves them on to tracking; those that can't be updated are left to try again
+func (p *ThumbnailPicker) Pick() (Report, error) {
+	report := Report{}
+
+	afterID := int64(0)
+
+	for {
+		events, err := helpers.GetEventsWithStatusAfter(p.application, statusNeedsThumbnail, afterID, pageSize)
+		if err != nil {
+			return report, err
+		}
+
+		if len(events) == 0 {
+			return report, nil
+		}
+
+		afterID = events[len(events)-1].ID
+		report.Checked += len(events)
+
+		eventIDs := make([]int64, 0, len(events))
+		for _, event := range events {
+			eventIDs = append(eventIDs, event.ID)
+		}
+
+		detections, err := helpers.GetBestDetections(p.application, eventIDs, p.classes, p.minScore)
+		if err != nil {
+			return report, err
+		}
+
+		detectionsByEventID := make(map[int64][]model.Detection)
+		for _, detection := range detections {
+			detectionsByEventID[detection.EventID] = append(detectionsByEventID[detection.EventID], detection)
+		}
+
+		keptEventIDs := make([]int64, 0)
+
+		for _, event := range events {
+			detection, ok := pickDetection(detectionsByEventID[event.ID], p.classes)
+			if !ok {
+				keptEventIDs = append(keptEventIDs, event.ID)
+				report.Kept++
+				continue
+			}
+
+			imagePath, lowResImagePath, err := p.makeImages(event, detection)
+			if err != nil {
+				log.Printf("warning: event %v; keeping the first frame because %v", event.ID, err)
+				keptEventIDs = append(keptEventIDs, event.ID)
+				report.Kept++
+				continue
+			}
+
+			image, err := helpers.SetThumbnail(
+				p.application,
+				event,
+				lowResImagePath,
+				detection.Timestamp,
+				statusNeedsTracking,
+				p.resolver.ToCanonical,
+			)
+			if err != nil {
+				log.Printf("warning: event %v; failed to set thumbnail because %v", event.ID, err)
+				report.Failed++
+				continue
+			}
+
+			log.Printf("event %v; picked %v @ %.2f for %#+v", event.ID, detection.ClassName, detection.Score, image.FilePath)
+
+			p.removePrevious(event, imagePath, lowResImagePath)
+
+			report.Picked++
+		}
+
+		err = helpers.SetEventsStatus(p.application, keptEventIDs, statusNeedsTracking)
+		if err != nil {
+			return report, err
+		}
+	}
+}
+
+func (p *ThumbnailPicker) work() {
+	report, err := p.Pick()
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+
+	log.Printf("%v", report)
+}
+
+func (p *ThumbnailPicker) Start() {
+	p.scheduledWorker.Start()
+}
+
+func (p *ThumbnailPicker) Stop() {
+	p.scheduledWorker.Stop()
+}
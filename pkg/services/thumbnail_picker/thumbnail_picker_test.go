@@ -0,0 +1,49 @@
+package thumbnail_picker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/media/thumbnail_creator"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestPickDetection(t *testing.T) {
+	detections := []model.Detection{
+		{ID: 1, ClassName: "car", Score: 0.95},
+		{ID: 2, ClassName: "person", Score: 0.6},
+		{ID: 3, ClassName: "person", Score: 0.8},
+		{ID: 4, ClassName: "dog", Score: 0.99},
+	}
+
+	// a person beats a better-scoring car, as it comes first
+	detection, ok := pickDetection(detections, []string{"person", "car"})
+	require.True(t, ok)
+	assert.Equal(t, int64(3), detection.ID)
+
+	detection, ok = pickDetection(detections, []string{"cat", "car"})
+	require.True(t, ok)
+	assert.Equal(t, int64(1), detection.ID)
+
+	_, ok = pickDetection(detections, []string{"cat"})
+	assert.False(t, ok)
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	box, err := parseBoundingBox("((10,20),(110,20),(110,70),(10,70),(10,20))", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, thumbnail_creator.Box{X: 10, Y: 20, Width: 100, Height: 50}, box)
+
+	// detected in a 640x360 sub-stream, drawn on a 1920x1080 frame
+	box, err = parseBoundingBox("((10,20),(110,20),(110,70),(10,70),(10,20))", 3, 3)
+	require.NoError(t, err)
+	assert.Equal(t, thumbnail_creator.Box{X: 30, Y: 60, Width: 300, Height: 150}, box)
+
+	_, err = parseBoundingBox("", 1, 1)
+	assert.Error(t, err)
+
+	_, err = parseBoundingBox("((10,a),(110,20))", 1, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package video_verifier
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/initialed85/cameranator/pkg/media/metadata"
+	"github.com/initialed85/cameranator/pkg/path_resolver"
+	"github.com/initialed85/cameranator/pkg/persistence/application"
+	"github.com/initialed85/cameranator/pkg/persistence/helpers"
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+const pageSize = 1000
+
+type ProblemKind string
+
+const (
+	ProblemMissing    ProblemKind = "missing"    // the file isn't there any more
+	ProblemMismatch   ProblemKind = "mismatch"   // the file has changed since it was ingested
+	ProblemUnreadable ProblemKind = "unreadable" // the file is there but couldn't be hashed
+)
+
+type Problem struct {
+	Kind     ProblemKind
+	Video    model.Video
+	Checksum string // of the file on disk (for a mismatch)
+	Err      error
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case ProblemMismatch:
+		return fmt.Sprintf(
+			"%v: video %v (%#+v) has checksum %v on disk but %v in the database",
+			p.Kind, p.Video.ID, p.Video.FilePath, p.Checksum, p.Video.Checksum,
+		)
+	default:
+		return fmt.Sprintf("%v: video %v (%#+v); %v", p.Kind, p.Video.ID, p.Video.FilePath, p.Err)
+	}
+}
+
+type Report struct {
+	Verified  int // checksum matched
+	Unchecked int // ingested before checksums were recorded
+	Problems  int
+}
+
+func (r Report) OK() bool {
+	return r.Problems == 0
+}
+
+// verifyVideo re-hashes the video's file (at path); ok is false (with nothing to report) if there's no checksum to
+// compare to
+func verifyVideo(video model.Video, path string) (problem *Problem, ok bool) {
+	if video.Checksum == "" {
+		return nil, false
+	}
+
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &Problem{Kind: ProblemMissing, Video: video, Err: err}, true
+	}
+
+	checksum, err := metadata.GetFileChecksum(path)
+	if err != nil {
+		return &Problem{Kind: ProblemUnreadable, Video: video, Err: err}, true
+	}
+
+	if checksum != video.Checksum {
+		return &Problem{Kind: ProblemMismatch, Video: video, Checksum: checksum}, true
+	}
+
+	return nil, true
+}
+
+type VideoVerifier struct {
+	application *application.Application
+	resolver    *path_resolver.Resolver
+}
+
+func NewVideoVerifier(
+	url string,
+	timeout time.Duration,
+	resolver *path_resolver.Resolver,
+) (*VideoVerifier, error) {
+	var err error
+
+	v := VideoVerifier{
+		resolver: resolver,
+	}
+
+	v.application, err = application.NewApplication(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// Verify re-hashes the file of every video (just those for cameraName, if it's set) and compares it against the
+// checksum recorded when the video was ingested; onProblem is invoked for each one that doesn't line up
+func (v *VideoVerifier) Verify(cameraName string, onProblem func(Problem)) (Report, error) {
+	report := Report{}
+
+	afterID := int64(0)
+
+	for {
+		videos, err := helpers.GetVideosAfter(v.application, cameraName, afterID, pageSize)
+		if err != nil {
+			return report, err
+		}
+
+		if len(videos) == 0 {
+			return report, nil
+		}
+
+		for _, video := range videos {
+			afterID = video.ID
+
+			problem, ok := verifyVideo(video, v.resolver.ToLocal(video.FilePath))
+			if !ok {
+				report.Unchecked++
+				continue
+			}
+
+			if problem != nil {
+				report.Problems++
+				onProblem(*problem)
+				continue
+			}
+
+			report.Verified++
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package video_verifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/initialed85/cameranator/pkg/persistence/model"
+)
+
+func TestVerifyVideo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Segment_2020-12-25T08:45:04_Driveway.mp4")
+
+	err := os.WriteFile(path, []byte("hello world"), 0644)
+	require.NoError(t, err)
+
+	checksum := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	problem, ok := verifyVideo(model.Video{FilePath: path}, path)
+	assert.False(t, ok)
+	assert.Nil(t, problem)
+
+	problem, ok = verifyVideo(model.Video{FilePath: path, Checksum: checksum}, path)
+	assert.True(t, ok)
+	assert.Nil(t, problem)
+
+	err = os.WriteFile(path, []byte("hello w0rld"), 0644)
+	require.NoError(t, err)
+
+	problem, ok = verifyVideo(model.Video{FilePath: path, Checksum: checksum}, path)
+	assert.True(t, ok)
+	require.NotNil(t, problem)
+	assert.Equal(t, ProblemMismatch, problem.Kind)
+	assert.NotEqual(t, checksum, problem.Checksum)
+
+	err = os.Remove(path)
+	require.NoError(t, err)
+
+	problem, ok = verifyVideo(model.Video{FilePath: path, Checksum: checksum}, path)
+	assert.True(t, ok)
+	require.NotNil(t, problem)
+	assert.Equal(t, ProblemMissing, problem.Kind)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local is a Storage in a folder; a key's Location is its absolute path
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root}, nil
+}
+
+func (l *Local) getPath(key string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func wrapNotFound(err error, key string) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%#+v: %w", key, ErrNotFound)
+	}
+
+	return err
+}
+
+// Put writes to a temporary file and then moves it into place, so that nothing sees a partial file at key
+func (l *Local) Put(key string, reader io.Reader) error {
+	path, err := l.getPath(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".put.*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, reader)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	return l.GetRange(key, 0, -1)
+}
+
+type sectionReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (l *Local) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	path, err := l.getPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, wrapNotFound(err, key)
+	}
+
+	if offset == 0 && length < 0 {
+		return file, nil
+	}
+
+	if length < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+
+		length = info.Size() - offset
+	}
+
+	return sectionReadCloser{Reader: io.NewSectionReader(file, offset, length), Closer: file}, nil
+}
+
+func (l *Local) Stat(key string) (Info, error) {
+	path, err := l.getPath(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, wrapNotFound(err, key)
+	}
+
+	if info.IsDir() {
+		return Info{}, fmt.Errorf("%#+v is a folder: %w", key, ErrNotFound)
+	}
+
+	return Info{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.getPath(key)
+	if err != nil {
+		return err
+	}
+
+	return wrapNotFound(os.Remove(path), key)
+}
+
+func (l *Local) List(prefix string) ([]Info, error) {
+	infos := make([]Info, 0)
+
+	// only walk the folder that the prefix is in
+	walkRoot := l.root
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		walkRoot = filepath.Join(l.root, filepath.FromSlash(prefix[:i]))
+	}
+
+	err := filepath.WalkDir(walkRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == walkRoot {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".put.") {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		infos = append(infos, Info{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos, nil
+}
+
+func (l *Local) Location(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+func (l *Local) Key(location string) (string, bool) {
+	if !filepath.IsAbs(location) {
+		return "", false
+	}
+
+	relativePath, err := filepath.Rel(l.root, filepath.Clean(location))
+	if err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(relativePath), true
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3 is a Storage in an S3-compatible bucket (e.g. MinIO), under an optional prefix; a key's Location is
+// s3://bucket/prefix/key
+type S3 struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+	prefix   string // empty or ending in /
+}
+
+// NewS3 returns an S3 for bucket (under prefix) at endpoint (empty for AWS itself); credentials come from the usual
+// places (e.g. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY), and path-style addressing is used if endpoint is set (as
+// that's what most S3-compatible servers expect)
+func NewS3(endpoint string, region string, bucket string, prefix string) (*S3, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket may not be empty")
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	s, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{
+		client:   s3.New(s),
+		uploader: s3manager.NewUploader(s),
+		bucket:   bucket,
+		prefix:   prefix,
+	}, nil
+}
+
+// NewS3FromURL returns an S3 for e.g. s3://bucket/prefix?endpoint=http://minio:9000&region=us-east-1
+func NewS3FromURL(parsedURL *url.URL) (*S3, error) {
+	query := parsedURL.Query()
+
+	return NewS3(query.Get("endpoint"), query.Get("region"), parsedURL.Host, parsedURL.Path)
+}
+
+func (s *S3) getObjectKey(key string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return s.prefix + key, nil
+}
+
+func wrapS3Error(err error, key string) error {
+	if err == nil {
+		return nil
+	}
+
+	requestFailure, ok := err.(awserr.RequestFailure)
+	if ok && requestFailure.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%#+v: %w", key, ErrNotFound)
+	}
+
+	return err
+}
+
+// Put uploads in parts if need be, so reader needn't be seekable
+func (s *S3) Put(key string, reader io.Reader) error {
+	objectKey, err := s.getObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+		Body:   reader,
+	})
+
+	return err
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+func (s *S3) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	objectKey, err := s.getObjectKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+	}
+
+	if length == 0 {
+		// a range can't be empty, but there's still the question of whether the object's there
+		_, err = s.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	if offset != 0 || length > 0 {
+		if length > 0 {
+			input.Range = aws.String(fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+		} else {
+			input.Range = aws.String(fmt.Sprintf("bytes=%v-", offset))
+		}
+	}
+
+	output, err := s.client.GetObject(input)
+	if err != nil {
+		return nil, wrapS3Error(err, key)
+	}
+
+	return output.Body, nil
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	objectKey, err := s.getObjectKey(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	output, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return Info{}, wrapS3Error(err, key)
+	}
+
+	return Info{
+		Key:     key,
+		Size:    aws.Int64Value(output.ContentLength),
+		ModTime: aws.TimeValue(output.LastModified),
+	}, nil
+}
+
+// Delete returns ErrNotFound if there's nothing at key (S3 itself doesn't say)
+func (s *S3) Delete(key string) error {
+	_, err := s.Stat(key)
+	if err != nil {
+		return err
+	}
+
+	objectKey, err := s.getObjectKey(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+	})
+
+	return wrapS3Error(err, key)
+}
+
+func (s *S3) List(prefix string) ([]Info, error) {
+	infos := make([]Info, 0)
+
+	err := s.client.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(s.prefix + prefix),
+		},
+		func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range output.Contents {
+				infos = append(infos, Info{
+					Key:     strings.TrimPrefix(aws.StringValue(object.Key), s.prefix),
+					Size:    aws.Int64Value(object.Size),
+					ModTime: aws.TimeValue(object.LastModified),
+				})
+			}
+
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+func (s *S3) Location(key string) string {
+	return fmt.Sprintf("s3://%v/%v", s.bucket, path.Join(s.prefix, key))
+}
+
+func (s *S3) Key(location string) (string, bool) {
+	objectKey, ok := strings.CutPrefix(location, fmt.Sprintf("s3://%v/", s.bucket))
+	if !ok {
+		return "", false
+	}
+
+	key, ok := strings.CutPrefix(objectKey, s.prefix)
+	if !ok || key == "" {
+		return "", false
+	}
+
+	return key, true
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned (wrapped) when there's nothing at a key
+var ErrNotFound = errors.New("not found")
+
+// Info describes what's at a key
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is somewhere media can be kept; keys are slash-separated and relative (e.g. 2020-12-25/Segment_....mp4)
+type Storage interface {
+	// Put writes everything from reader to key (replacing anything that's there)
+	Put(key string, reader io.Reader) error
+
+	// Get returns a reader for what's at key; it must be closed
+	Get(key string) (io.ReadCloser, error)
+
+	// GetRange returns a reader for length bytes from offset of what's at key (or to the end if length is < 0); it
+	// must be closed
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+
+	Stat(key string) (Info, error)
+
+	Delete(key string) error
+
+	// List returns everything with a key starting with prefix (in order of key)
+	List(prefix string) ([]Info, error)
+
+	// Location returns what a key is known by elsewhere (e.g. as a file_path in the database)
+	Location(key string) string
+
+	// Key is the inverse of Location; ok is false if location isn't in this Storage
+	Key(location string) (key string, ok bool)
+}
+
+// Open returns the Storage for rawURL; a path or file:// URL is a Local rooted there, and s3://bucket/prefix is an S3
+// (see NewS3FromURL)
+func Open(rawURL string) (Storage, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("storage URL may not be empty")
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		return NewLocal(rawURL)
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsedURL.Scheme {
+	case "file":
+		return NewLocal(parsedURL.Path)
+	case "s3":
+		return NewS3FromURL(parsedURL)
+	}
+
+	return nil, fmt.Errorf("unsupported storage URL %#+v; must be a path, file:// or s3://", rawURL)
+}
+
+// Find returns the first of storages that location is in, along with its key
+func Find(storages []Storage, location string) (Storage, string, error) {
+	for _, s := range storages {
+		key, ok := s.Key(location)
+		if ok {
+			return s, key, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%#+v isn't in any storage", location)
+}
+
+// PutFile writes the file at path to key
+func PutFile(s Storage, key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return s.Put(key, file)
+}
+
+// IsNotFound returns true if err is (or wraps) ErrNotFound
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid key %#+v; must be relative and not empty", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return fmt.Errorf("invalid key %#+v; may not contain ..", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is just enough of an S3-compatible server (path-style, one bucket) to test S3 against
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+type fakeS3Contents struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+type fakeS3ListBucketResult struct {
+	XMLName     xml.Name         `xml:"ListBucketResult"`
+	Name        string           `xml:"Name"`
+	Prefix      string           `xml:"Prefix"`
+	KeyCount    int              `xml:"KeyCount"`
+	IsTruncated bool             `xml:"IsTruncated"`
+	Contents    []fakeS3Contents `xml:"Contents"`
+}
+
+var fakeS3LastModified = time.Date(2020, 12, 25, 8, 45, 4, 0, time.UTC)
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		prefix := r.URL.Query().Get("prefix")
+
+		result := fakeS3ListBucketResult{Name: f.bucket, Prefix: prefix}
+		for objectKey, data := range f.objects {
+			if strings.HasPrefix(objectKey, prefix) {
+				result.Contents = append(result.Contents, fakeS3Contents{
+					Key:          objectKey,
+					Size:         int64(len(data)),
+					LastModified: fakeS3LastModified.Format(time.RFC3339),
+				})
+			}
+		}
+
+		sort.Slice(result.Contents, func(i, j int) bool {
+			return result.Contents[i].Key < result.Contents[j].Key
+		})
+		result.KeyCount = len(result.Contents)
+
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		f.objects[key] = data
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			}
+			return
+		}
+
+		status := http.StatusOK
+
+		rawRange := r.Header.Get("Range")
+		if rawRange != "" {
+			rawStart, rawEnd, _ := strings.Cut(strings.TrimPrefix(rawRange, "bytes="), "-")
+
+			start, _ := strconv.Atoi(rawStart)
+			end := len(data) - 1
+			if rawEnd != "" {
+				end, _ = strconv.Atoi(rawEnd)
+			}
+
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", fakeS3LastModified.Format(http.TimeFormat))
+		w.WriteHeader(status)
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// reader returns a func that reads everything from what Get / GetRange return
+func reader(t *testing.T) func(io.ReadCloser, error) string {
+	return func(r io.ReadCloser, err error) string {
+		require.NoError(t, err)
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		return string(data)
+	}
+}
+
+// testStorage exercises everything a Storage has to do (against an empty one)
+func testStorage(t *testing.T, s Storage) {
+	key := "2020-12-25/Segment_2020-12-25T08:45:04_Driveway.mp4"
+	read := reader(t)
+
+	_, err := s.Stat(key)
+	assert.True(t, IsNotFound(err), err)
+
+	_, err = s.Get(key)
+	assert.True(t, IsNotFound(err), err)
+
+	assert.True(t, IsNotFound(s.Delete(key)))
+
+	require.NoError(t, s.Put(key, strings.NewReader("0123456789")))
+	require.NoError(t, s.Put("2020-12-25/Segment_2020-12-25T08:45:04_Driveway.jpg", strings.NewReader("an image")))
+	require.NoError(t, s.Put("2020-12-26/Segment_2020-12-26T08:45:04_Driveway.mp4", strings.NewReader("another video")))
+
+	info, err := s.Stat(key)
+	require.NoError(t, err)
+	assert.Equal(t, key, info.Key)
+	assert.Equal(t, int64(10), info.Size)
+	assert.False(t, info.ModTime.IsZero())
+
+	assert.Equal(t, "0123456789", read(s.Get(key)))
+	assert.Equal(t, "234", read(s.GetRange(key, 2, 3)))
+	assert.Equal(t, "789", read(s.GetRange(key, 7, -1)))
+	assert.Equal(t, "", read(s.GetRange(key, 7, 0)))
+
+	// replacing
+	require.NoError(t, s.Put(key, strings.NewReader("abc")))
+	assert.Equal(t, "abc", read(s.Get(key)))
+
+	infos, err := s.List("2020-12-25/")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "2020-12-25/Segment_2020-12-25T08:45:04_Driveway.jpg", infos[0].Key)
+	assert.Equal(t, key, infos[1].Key)
+	assert.Equal(t, int64(3), infos[1].Size)
+
+	infos, err = s.List("")
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+
+	infos, err = s.List("2020-12-2")
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+
+	infos, err = s.List("nothing/")
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+
+	location := s.Location(key)
+	roundTrip, ok := s.Key(location)
+	assert.True(t, ok)
+	assert.Equal(t, key, roundTrip)
+
+	require.NoError(t, s.Delete(key))
+	_, err = s.Stat(key)
+	assert.True(t, IsNotFound(err), err)
+
+	for _, badKey := range []string{"", "/etc/passwd", "../escape", "a/../../escape"} {
+		assert.Error(t, s.Put(badKey, strings.NewReader("")), badKey)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewLocal(dir)
+	require.NoError(t, err)
+
+	testStorage(t, s)
+
+	assert.Equal(t, dir+"/some/file.mp4", s.Location("some/file.mp4"))
+
+	_, ok := s.Key("/somewhere/else/file.mp4")
+	assert.False(t, ok)
+
+	_, ok = s.Key(dir + "/../file.mp4")
+	assert.False(t, ok)
+
+	root, err := NewLocal("/")
+	require.NoError(t, err)
+
+	key, ok := root.Key("/srv/target_dir/segments/file.mp4")
+	assert.True(t, ok)
+	assert.Equal(t, "srv/target_dir/segments/file.mp4", key)
+}
+
+// TestS3 runs against a fake S3 unless STORAGE_TEST_S3_URL is set (e.g. to
+// s3://some-bucket/some-prefix?endpoint=http://localhost:9000 for a MinIO with that bucket); it's expected to be empty
+func TestS3(t *testing.T) {
+	rawURL := os.Getenv("STORAGE_TEST_S3_URL")
+
+	if rawURL == "" {
+		server := httptest.NewServer(&fakeS3{bucket: "cameranator", objects: make(map[string][]byte)})
+		defer server.Close()
+
+		t.Setenv("AWS_ACCESS_KEY_ID", "some-access-key")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "some-secret-key")
+
+		rawURL = fmt.Sprintf("s3://cameranator/media?endpoint=%v", server.URL)
+	}
+
+	s, err := Open(rawURL)
+	require.NoError(t, err)
+
+	testStorage(t, s)
+
+	if os.Getenv("STORAGE_TEST_S3_URL") == "" {
+		assert.Equal(t, "s3://cameranator/media/some/file.mp4", s.Location("some/file.mp4"))
+
+		_, ok := s.Key("s3://cameranator/other/file.mp4")
+		assert.False(t, ok)
+
+		_, ok = s.Key("/srv/target_dir/segments/file.mp4")
+		assert.False(t, ok)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, err := Open("/srv/target_dir")
+	require.NoError(t, err)
+	assert.IsType(t, &Local{}, s)
+	assert.Equal(t, "/srv/target_dir/a.mp4", s.Location("a.mp4"))
+
+	s, err = Open("file:///srv/target_dir")
+	require.NoError(t, err)
+	assert.IsType(t, &Local{}, s)
+
+	_, err = Open("ftp://somewhere")
+	assert.Error(t, err)
+
+	_, err = Open("")
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	archive, err := NewLocal("/srv/archive")
+	require.NoError(t, err)
+
+	root, err := NewLocal("/")
+	require.NoError(t, err)
+
+	s, key, err := Find([]Storage{archive, root}, "/srv/archive/a.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, archive, s)
+	assert.Equal(t, "a.mp4", key)
+
+	s, key, err = Find([]Storage{archive, root}, "/srv/segments/a.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, root, s)
+	assert.Equal(t, "srv/segments/a.mp4", key)
+
+	_, _, err = Find([]Storage{archive}, "/srv/segments/a.mp4")
+	assert.Error(t, err)
+}